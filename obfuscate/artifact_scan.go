@@ -0,0 +1,95 @@
+package obfuscate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Scanners to run against every built binary, from the
+// -yara and -scancmd flags.
+var (
+	yaraRules    listFlag
+	scanCommands listFlag
+)
+
+// A ScanDetection is a scanner which matched a binary.
+type ScanDetection struct {
+	Scanner string
+	Output  string
+}
+
+func (s ScanDetection) String() string {
+	if s.Output == "" {
+		return s.Scanner
+	}
+	return s.Scanner + ": " + s.Output
+}
+
+// artifactScanners gets the YARA rule files and scanner
+// commands from the config file and the command line.
+func artifactScanners() (rules, commands []string) {
+	if config != nil {
+		rules = append(rules, config.ArtifactScan.Yara...)
+		commands = append(commands, config.ArtifactScan.Commands...)
+	}
+	return append(rules, yaraRules...), append(commands, scanCommands...)
+}
+
+func checkScanCommand(command string) error {
+	if len(strings.Fields(command)) == 0 {
+		return errors.New("empty command")
+	}
+	return nil
+}
+
+// ScanArtifact runs every scanner against a binary and
+// returns the ones which matched it.
+//
+// YARA rules match if yara prints any match. Commands
+// are split on spaces, run with the binary's path as their
+// last argument, and match if they exit with a non-zero
+// status.
+func ScanArtifact(path string) ([]ScanDetection, error) {
+	rules, commands := artifactScanners()
+	var res []ScanDetection
+	for _, rule := range rules {
+		output, err := runScanner([]string{"yara", "-w", rule, path})
+		if err != nil {
+			if output != "" {
+				return nil, fmt.Errorf("yara %s: %s", rule, output)
+			}
+			return nil, fmt.Errorf("yara %s: %s", rule, err)
+		}
+		if output != "" {
+			res = append(res, ScanDetection{Scanner: "yara " + rule, Output: output})
+		}
+	}
+	for _, command := range commands {
+		if err := checkScanCommand(command); err != nil {
+			return nil, err
+		}
+		output, err := runScanner(append(strings.Fields(command), path))
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if output == "" {
+				output = exitErr.Error()
+			}
+			res = append(res, ScanDetection{Scanner: command, Output: output})
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %s", command, err)
+		}
+	}
+	return res, nil
+}
+
+// runScanner runs a scanner and gets its combined output.
+func runScanner(args []string) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	return strings.TrimSpace(output.String()), err
+}
@@ -0,0 +1,153 @@
+package obfuscate
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// blocklistPath is a file of byte patterns which must not
+// appear in built binaries.
+var blocklistPath string
+
+// blocklist holds the patterns from the blocklist file and
+// the config file.
+var blocklist [][]byte
+
+// setupBlocklist loads the blocklist patterns.
+func setupBlocklist() error {
+	var lines []string
+	if config != nil {
+		lines = append(lines, config.Blocklist...)
+	}
+	if blocklistPath != "" {
+		data, err := ioutil.ReadFile(blocklistPath)
+		if err != nil {
+			return stepError("load blocklist", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimRight(line, "\r")
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+	}
+	for _, line := range lines {
+		pattern, err := parseBlockPattern(line)
+		if err != nil {
+			return stepError("load blocklist", err)
+		}
+		blocklist = append(blocklist, pattern)
+	}
+	return nil
+}
+
+// parseBlockPattern parses a blocklist pattern, which is
+// either text, or bytes written in hex after "hex:".
+func parseBlockPattern(line string) ([]byte, error) {
+	if strings.HasPrefix(line, "hex:") {
+		pattern, err := hex.DecodeString(strings.TrimPrefix(line, "hex:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", line, err)
+		} else if len(pattern) == 0 {
+			return nil, errors.New("empty pattern")
+		}
+		return pattern, nil
+	} else if line == "" {
+		return nil, errors.New("empty pattern")
+	}
+	return []byte(line), nil
+}
+
+func checkBlockPattern(line string) error {
+	_, err := parseBlockPattern(line)
+	return err
+}
+
+// blockedLiteral checks if a string contains any blocklist
+// pattern.
+func blockedLiteral(value string) bool {
+	for _, pattern := range blocklist {
+		if strings.Contains(value, string(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocklistMatches finds the blocklist patterns which
+// appear in a binary.
+func blocklistMatches(binPath string) ([][]byte, error) {
+	var maxLen int
+	for _, pattern := range blocklist {
+		if len(pattern) > maxLen {
+			maxLen = len(pattern)
+		}
+	}
+	found := make([]bool, len(blocklist))
+	err := scanChunks(binPath, maxLen, func(chunk []byte, atEOF bool) {
+		for i, pattern := range blocklist {
+			if !found[i] && bytes.Contains(chunk, pattern) {
+				found[i] = true
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res [][]byte
+	for i, pattern := range blocklist {
+		if found[i] {
+			res = append(res, pattern)
+		}
+	}
+	return res, nil
+}
+
+// checkBlocklist verifies that no blocklist pattern
+// appears in a binary, reporting each one which does along
+// with the literals which may have put it there.
+func checkBlocklist(binPath string, coverage *StringCoverage) error {
+	if len(blocklist) == 0 {
+		return nil
+	}
+	matches, err := blocklistMatches(binPath)
+	if err != nil {
+		return stepError("check blocklist", err)
+	}
+	var problems []string
+	for _, pattern := range matches {
+		problem := displayPattern(pattern)
+		var sources int
+		if coverage != nil {
+			for _, plain := range coverage.Plaintext {
+				if strings.Contains(plain.Value, string(pattern)) {
+					problem += fmt.Sprintf("\n  %s/%s:%d: %s literal which cannot be obfuscated",
+						plain.Package, plain.File, plain.Line, plain.Reason)
+					sources++
+				}
+			}
+		}
+		if sources == 0 {
+			problem += "\n  not from a literal in the obfuscated code " +
+				"(it may come from the standard library, a symbol name, or a file path)"
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) > 0 {
+		return &CheckError{Kind: "Blocked pattern found in binary", Problems: problems}
+	}
+	return nil
+}
+
+func displayPattern(pattern []byte) string {
+	if utf8.Valid(pattern) {
+		return strconv.Quote(string(pattern))
+	}
+	return "hex:" + hex.EncodeToString(pattern)
+}
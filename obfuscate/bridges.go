@@ -0,0 +1,307 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Bridge is a reference between a symbol which kept its
+// original name and one which was renamed. An analyst who
+// recognizes the kept symbol can follow the reference to
+// guess what the renamed one does.
+type Bridge struct {
+	// Kind is "kept-to-renamed" if the code of a kept
+	// symbol refers to a renamed one, or "renamed-to-kept"
+	// for the opposite.
+	Kind string `json:"kind"`
+
+	From BridgeSymbol `json:"from"`
+	To   BridgeSymbol `json:"to"`
+
+	// References counts the references from From to To.
+	References int `json:"references"`
+}
+
+// A BridgeSymbol is one end of a Bridge.
+type BridgeSymbol struct {
+	// Package is the original import path of the symbol's
+	// package.
+	Package string `json:"package"`
+
+	// Name is the name of the symbol in the obfuscated
+	// code, as "Name" or "Type.Member".
+	Name string `json:"name"`
+
+	// Original is the original name of a renamed symbol.
+	Original string `json:"original,omitempty"`
+
+	// Kept tells why a symbol kept its name: "excluded"
+	// for the symbols of -exclude packages, "keep" for
+	// those of -keep patterns, "reflectnames" for those of
+	// the -reflectnames list or looked up by reflection, or
+	// "directive" for those marked with a gobfuscate
+	// directive.
+	Kept string `json:"kept,omitempty"`
+}
+
+// bridgeSymbol is a declared symbol of the workspace.
+type bridgeSymbol struct {
+	BridgeSymbol
+	Renamed bool
+}
+
+// findBridges lists the references between kept and
+// renamed symbols in the packages of a GOPATH, other than
+// the string helper.
+// Packages are type-checked without their imports, so
+// references to the methods and fields of other packages'
+// types cannot be followed; only their top-level symbols
+// can.
+func findBridges(gopath, helperPath string, moves PackageMoves, keep *KeepList, n NameHasher,
+	record *renameRecord) ([]Bridge, error) {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	originals := originalNames(record)
+
+	type bridgeKey struct {
+		From, To *bridgeSymbol
+	}
+	counts := map[bridgeKey]int{}
+	var order []bridgeKey
+
+	// The symbols of every package are found first, so
+	// that references to other packages can be resolved.
+	type checkedPackage struct {
+		Path    string
+		Files   []*ast.File
+		Info    *types.Info
+		Symbols map[types.Object]*bridgeSymbol
+		Decls   map[ast.Decl][]*bridgeSymbol
+	}
+	topLevel := map[string]map[string]*bridgeSymbol{}
+	var checked []*checkedPackage
+	for _, pkg := range pkgs {
+		if pkg == helperPath {
+			continue
+		}
+		set := token.NewFileSet()
+		files, err := parsePackageFiles(set, filepath.Join(srcDir, filepath.FromSlash(pkg)))
+		if err != nil {
+			return nil, err
+		}
+		info := &types.Info{
+			Defs: map[*ast.Ident]types.Object{},
+			Uses: map[*ast.Ident]types.Object{},
+		}
+		conf := types.Config{Importer: noImporter{}, Error: func(error) {}}
+		conf.Check(pkg, set, files, info)
+
+		c := &checkedPackage{
+			Path:    pkg,
+			Files:   files,
+			Info:    info,
+			Symbols: map[types.Object]*bridgeSymbol{},
+			Decls:   map[ast.Decl][]*bridgeSymbol{},
+		}
+		topLevel[pkg] = map[string]*bridgeSymbol{}
+		original := moves.Original(pkg)
+		excluded := isExcluded(original)
+		declare := func(decl ast.Decl, ident *ast.Ident, typeName string, directive bool) {
+			obj := info.Defs[ident]
+			if obj == nil || ident.Name == "_" {
+				return
+			}
+			sym := &bridgeSymbol{BridgeSymbol: BridgeSymbol{Package: original, Name: ident.Name}}
+			reason := keep.Reason(pkg, ident.Name, "", n)
+			if typeName != "" {
+				sym.Name = typeName + "." + ident.Name
+				reason = keep.Reason(pkg, typeName, ident.Name, n)
+			}
+			if orig, ok := originals[original][ident.Name]; ok {
+				sym.Renamed = true
+				sym.Original = orig
+				if typeName != "" {
+					if origType, ok := originals[original][typeName]; ok {
+						typeName = origType
+					}
+					sym.Original = typeName + "." + orig
+				}
+			}
+			switch {
+			case excluded:
+				sym.Kept = "excluded"
+			case sym.Renamed:
+			case reason != "":
+				sym.Kept = reason
+			case directive:
+				sym.Kept = "directive"
+			}
+			c.Symbols[obj] = sym
+			c.Decls[decl] = append(c.Decls[decl], sym)
+			if typeName == "" {
+				topLevel[pkg][ident.Name] = sym
+			}
+		}
+		for _, file := range files {
+			for _, decl := range file.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					var typeName string
+					if decl.Recv != nil && len(decl.Recv.List) == 1 {
+						typeName = receiverTypeName(decl.Recv.List[0])
+					}
+					declare(decl, decl.Name, typeName, keepsName(file, decl.Doc))
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							directive := keepsName(file, decl.Doc, spec.Doc, spec.Comment)
+							declare(decl, spec.Name, "", directive)
+							if st, ok := spec.Type.(*ast.StructType); ok {
+								for _, field := range st.Fields.List {
+									for _, name := range field.Names {
+										declare(decl, name, spec.Name.Name, directive)
+									}
+								}
+							}
+						case *ast.ValueSpec:
+							directive := keepsName(file, decl.Doc, spec.Doc, spec.Comment)
+							for _, name := range spec.Names {
+								declare(decl, name, "", directive)
+							}
+						}
+					}
+				}
+			}
+		}
+		checked = append(checked, c)
+	}
+
+	for _, c := range checked {
+		for _, file := range c.Files {
+			for _, decl := range file.Decls {
+				declSyms := c.Decls[decl]
+				if len(declSyms) == 0 {
+					continue
+				}
+				// A declaration of several names is
+				// attributed to the first one.
+				from := declSyms[0]
+				if from.Kept == "" && !from.Renamed {
+					continue
+				}
+				ast.Inspect(decl, func(node ast.Node) bool {
+					var to *bridgeSymbol
+					switch node := node.(type) {
+					case *ast.SelectorExpr:
+						x, ok := node.X.(*ast.Ident)
+						if !ok {
+							return true
+						}
+						if pkgName, ok := c.Info.Uses[x].(*types.PkgName); ok {
+							to = topLevel[pkgName.Imported().Path()][node.Sel.Name]
+						}
+					case *ast.Ident:
+						to = c.Symbols[originObject(c.Info.Uses[node])]
+					}
+					if to == nil || to == from {
+						return true
+					}
+					if (from.Kept != "" && to.Renamed) || (from.Renamed && to.Kept != "") {
+						key := bridgeKey{from, to}
+						if counts[key] == 0 {
+							order = append(order, key)
+						}
+						counts[key]++
+					}
+					return true
+				})
+			}
+		}
+	}
+
+	var res []Bridge
+	for _, key := range order {
+		kind := "kept-to-renamed"
+		if key.From.Renamed {
+			kind = "renamed-to-kept"
+		}
+		res = append(res, Bridge{
+			Kind:       kind,
+			From:       key.From.BridgeSymbol,
+			To:         key.To.BridgeSymbol,
+			References: counts[key],
+		})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		b1, b2 := res[i], res[j]
+		if b1.Kind != b2.Kind {
+			return b1.Kind < b2.Kind
+		} else if b1.From.Package != b2.From.Package {
+			return b1.From.Package < b2.From.Package
+		}
+		return b1.From.Name < b2.From.Name
+	})
+	return res, nil
+}
+
+// originObject finds the generic declaration of an object
+// used with type arguments.
+func originObject(obj types.Object) types.Object {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return obj.Origin()
+	case *types.Var:
+		return obj.Origin()
+	}
+	return obj
+}
+
+// originalNames maps the new names of renamed symbols,
+// methods, and fields to their original names, by the
+// original import paths of their packages.
+func originalNames(record *renameRecord) map[string]map[string]string {
+	res := map[string]map[string]string{}
+	for symbol, newName := range record.Symbols {
+		slash := strings.LastIndex(symbol, "/")
+		dot := slash + 1 + strings.Index(symbol[slash+1:], ".")
+		pkg, rest := symbol[:dot], symbol[dot+1:]
+		if res[pkg] == nil {
+			res[pkg] = map[string]string{}
+		}
+		res[pkg][newName] = rest[strings.LastIndex(rest, ".")+1:]
+	}
+	return res
+}
+
+// parsePackageFiles parses the Go files of a package
+// directory, other than tests, with their comments.
+func parsePackageFiles(set *token.FileSet, dir string) ([]*ast.File, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []*ast.File
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) || strings.HasSuffix(item.Name(), "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(set, filepath.Join(dir, item.Name()), nil, parser.ParseComments)
+		if err != nil {
+			// Files which cannot be parsed are not built
+			// either.
+			continue
+		}
+		res = append(res, file)
+	}
+	return res, nil
+}
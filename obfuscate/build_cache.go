@@ -0,0 +1,458 @@
+package obfuscate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildCacheFormat is part of every build fingerprint, and
+// must be changed whenever the layout of the entries
+// changes.
+const buildCacheFormat = "1"
+
+// buildCacheDir, if set, is where the binaries of builds
+// are kept by their fingerprint, so that a run which would
+// build the same binaries again copies them instead.
+var buildCacheDir string
+
+// buildCacheKey is the fingerprint of the run's build, once
+// it missed the -buildcache, under which it is stored.
+var buildCacheKey string
+
+// buildCacheIgnoredFlags are the flags, on top of the
+// manifestOutputFlags, which do not change what a run
+// builds, and are left out of its fingerprint.
+var buildCacheIgnoredFlags = map[string]bool{
+	"buildcache":  true,
+	"jobs":        true,
+	"buildjobs":   true,
+	"verbose":     true,
+	"keepwork":    true,
+	"nodiskcheck": true,
+}
+
+// A buildCacheEntry describes the files of a cached build.
+// They are stored in the entry's directory under names of
+// their own.
+type buildCacheEntry struct {
+	// Packages are the original import paths of the main
+	// packages.
+	Packages  []string         `json:"packages"`
+	Artifacts []cachedArtifact `json:"artifacts"`
+
+	// Mapping and Report are the names of the mapping file
+	// and the report, if the build wrote them.
+	Mapping string `json:"mapping,omitempty"`
+	Report  string `json:"report,omitempty"`
+}
+
+// A cachedArtifact is a binary of a cached build, and the
+// name of its copy in the entry.
+type cachedArtifact struct {
+	Artifact
+	File string `json:"file"`
+}
+
+// checkBuildCacheFlags reports flags which cannot be
+// combined with -buildcache.
+func checkBuildCacheFlags() error {
+	if !cmdLineFlags["seed"] {
+		return flagError("The -buildcache flag requires -seed, since builds are only reproducible with one.")
+	} else if outputGopath || numVariants > 1 || incrementalDir != "" {
+		return flagError("The -buildcache flag cannot be combined with -outdir, -variants, or -incremental.")
+	}
+	return nil
+}
+
+// useBuildCache fingerprints the build of a package, and
+// copies its files from the -buildcache if it has them.
+// It returns true if the run is done.
+// Otherwise, the build is stored once it is made.
+func useBuildCache(pkgName, outPath string) (bool, error) {
+	if err := checkBuildCacheFlags(); err != nil {
+		return false, err
+	}
+	log.Println("Fingerprinting the build...")
+	key, err := buildFingerprint(pkgName)
+	if err != nil {
+		return false, stepError("fingerprint build", err)
+	}
+	entry, err := loadBuildCacheEntry(key)
+	if err != nil {
+		log.Println("Warning: ignoring build cache entry:", err)
+	}
+	hit := entry != nil && entry.usable(outPath)
+	runMetrics.Cache("build", hit)
+	if !hit {
+		buildCacheKey = key
+		return false, nil
+	}
+	log.Println("Copying the binaries of build", key[:16], "from the build cache")
+	artifacts, err := entry.Restore(filepath.Join(buildCacheDir, key), outPath)
+	if err != nil {
+		return true, err
+	}
+	ws := &Workspace{PkgName: entry.Packages[0], ExtraPkgs: entry.Packages[1:], Artifacts: artifacts}
+	// SBOMs are not cached, but listed again from the
+	// sources, which are those of the cached build.
+	if sbomFormat != "" {
+		if err := ws.WriteSBOMs(); err != nil {
+			return true, err
+		}
+	}
+	if releasePath != "" {
+		if err := ws.WriteRelease(mappingPath(outPath)); err != nil {
+			return true, err
+		}
+	}
+	if provenancePath != "" {
+		if err := ws.WriteProvenance(mappingPath(outPath)); err != nil {
+			return true, err
+		}
+	}
+	if manifestPath != "" {
+		return true, ws.WriteManifest(outPath)
+	}
+	return true, nil
+}
+
+// buildFingerprint hashes everything which decides what a
+// run builds: the sources of every package, the flags
+// (including the seed) and the files they name, and the Go
+// toolchain and its settings.
+// Where the files are written is left out, so that a build
+// is restored to whichever output path a run asks for.
+func buildFingerprint(pkgName string) (string, error) {
+	sources, err := buildSources(pkgName)
+	if err != nil {
+		return "", err
+	}
+	goVersion, err := goVersion()
+	if err != nil {
+		return "", err
+	}
+	env, err := manifestEnv()
+	if err != nil {
+		return "", err
+	}
+	toolData, err := json.Marshal(currentTool())
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintln(hash, buildCacheFormat, string(toolData), goVersion)
+	fmt.Fprintln(hash, pkgName, strings.Join(extraPackages, " "))
+	var lines []string
+	for name, fingerprint := range sources {
+		lines = append(lines, name+" "+fingerprint)
+	}
+	for name, value := range env {
+		lines = append(lines, name+"="+strconv.Quote(value))
+	}
+	sort.Strings(lines)
+	fmt.Fprintln(hash, strings.Join(lines, "\n"))
+	fmt.Fprintf(hash, "%s=%q\n", mapPassphraseEnv, os.Getenv(mapPassphraseEnv))
+	for _, target := range buildTargets() {
+		if targetCGO(target) != "1" {
+			continue
+		}
+		cc, _ := targetCompilers(target)
+		if cc == "" {
+			cc = env["CC"]
+		}
+		fmt.Fprintln(hash, target, compilerVersion(cc))
+	}
+	if packer != "" {
+		fmt.Fprintln(hash, compilerVersion(packer))
+	}
+
+	fmt.Fprintf(hash, "-seed=%q\n", seed)
+	files := map[string]bool{}
+	if configPath != "" {
+		files[configPath] = true
+	}
+	runFlags.Visit(func(f *flag.Flag) {
+		if manifestOutputFlags[f.Name] || buildCacheIgnoredFlags[f.Name] || f.Name == "seed" {
+			return
+		}
+		values := []string{f.Value.String()}
+		if list, ok := f.Value.(*listFlag); ok {
+			values = *list
+		}
+		for _, value := range values {
+			fmt.Fprintf(hash, "-%s=%q\n", f.Name, value)
+			if pathFlags[f.Name] {
+				files[value] = true
+			}
+		}
+	})
+	for _, path := range sortedKeys(files) {
+		// Directories, like the -depcache, are not hashed.
+		if info, err := os.Stat(path); err == nil && !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(hash, path, len(data))
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// buildSources fingerprints the sources of the packages to
+// build and their dependencies, as listed by the go command
+// with the settings of -prepenv. With -modules, the go.mod
+// and go.sum files are included, and dependencies are
+// listed from the go command's own module cache.
+func buildSources(pkgName string) (map[string]string, error) {
+	prepEnv, err := prepareEnv()
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]string{}
+	err = withEnv(prepEnv, func() error {
+		env, err := originalListEnv("")
+		if err != nil {
+			return err
+		}
+		if useModules {
+			if err := moduleFiles(env, res); err != nil {
+				return err
+			}
+		}
+		for _, pattern := range append([]string{pkgName}, extraPackages...) {
+			pkgs, err := listPackages(pattern, env, false)
+			if err != nil {
+				return err
+			}
+			sources, err := sourceFingerprints(pkgs, "")
+			if err != nil {
+				return err
+			}
+			for name, fingerprint := range sources {
+				res[name] = fingerprint
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// originalListEnv is the environment in which the go
+// command lists the original packages of the run, with
+// goflags added to GOFLAGS. It is called with the -prepenv
+// variables set.
+func originalListEnv(goflags string) ([]string, error) {
+	goflags = strings.TrimSpace(os.Getenv("GOFLAGS") + " " + goflags)
+	if !useModules {
+		return append(os.Environ(), "GO111MODULE=off", "GOPATH="+sourceContext().GOPATH, "GOFLAGS="+goflags), nil
+	}
+	env := append(os.Environ(), "GO111MODULE=on")
+	vendored, err := moduleVendored(env)
+	if err != nil {
+		return nil, err
+	} else if vendored {
+		goflags = strings.TrimSpace(goflags + " -mod=vendor")
+	}
+	return append(env, "GOFLAGS="+goflags), nil
+}
+
+// moduleFiles fingerprints the go.mod and go.sum files of
+// the main module.
+func moduleFiles(env []string, res map[string]string) error {
+	cmd := sourceCommand("go", "env", "GOMOD")
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go env: %s", err)
+	}
+	goMod := strings.TrimSpace(string(output))
+	if goMod == "" || goMod == os.DevNull {
+		return nil
+	}
+	for _, path := range []string{goMod, filepath.Join(filepath.Dir(goMod), "go.sum")} {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		res[filepath.Base(path)] = hex.EncodeToString(hash[:])
+	}
+	return nil
+}
+
+// loadBuildCacheEntry loads the entry of a fingerprint, or
+// returns nil if there is none.
+func loadBuildCacheEntry(key string) (*buildCacheEntry, error) {
+	dir := filepath.Join(buildCacheDir, key)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "entry.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entry buildCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if len(entry.Packages) == 0 {
+		return nil, fmt.Errorf("no packages in %s", dir)
+	}
+	// The clean subcommand removes the entries which have
+	// not been used for the longest.
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return &entry, nil
+}
+
+// usable checks if an entry has every file which the run
+// writes.
+func (e *buildCacheEntry) usable(outPath string) bool {
+	if mappingPath(outPath) != "" && e.Mapping == "" {
+		log.Println("The cached build has no mapping file")
+		return false
+	} else if reportPath != "" && e.Report == "" {
+		log.Println("The cached build has no report")
+		return false
+	}
+	return true
+}
+
+// Restore copies the files of an entry to where the run
+// writes them, naming the binaries after outPath as a build
+// would. It returns the binaries by their new paths, which
+// the restored report lists as well.
+func (e *buildCacheEntry) Restore(dir, outPath string) ([]Artifact, error) {
+	ws := &Workspace{PkgName: e.Packages[0], ExtraPkgs: e.Packages[1:]}
+	binaries, err := ws.binaries(outPath)
+	if err != nil {
+		return nil, stepError("create output directory", err)
+	}
+	paths, err := outputPaths(binaries)
+	if err != nil {
+		return nil, stepError("name binaries", err)
+	}
+	binByPkg := map[string]mainBinary{}
+	for _, bin := range binaries {
+		binByPkg[bin.PkgName] = bin
+	}
+
+	var artifacts []Artifact
+	for _, cached := range e.Artifacts {
+		artifact := cached.Artifact
+		target := buildTarget{GOOS: artifact.GOOS, GOARCH: artifact.GOARCH}
+		path, ok := paths[binByPkg[artifact.Package]][target]
+		if !ok {
+			return nil, fmt.Errorf("cached binary of %s for %s is not built by this run", artifact.Package, target)
+		}
+		artifact.Path = path
+		if err := os.MkdirAll(filepath.Dir(artifact.Path), 0755); err != nil {
+			return nil, stepError("create output directory", err)
+		}
+		if err := copyCachedFile(filepath.Join(dir, cached.File), artifact.Path, 0755); err != nil {
+			return nil, stepError("copy binary", err)
+		}
+		runMetrics.Artifact(artifact.GOOS, artifact.GOARCH, artifact.Path)
+		artifacts = append(artifacts, artifact)
+	}
+	if mapFile := mappingPath(outPath); mapFile != "" {
+		if err := copyCachedFile(filepath.Join(dir, e.Mapping), mapFile, 0600); err != nil {
+			return nil, stepError("copy mapping file", err)
+		}
+	}
+	if reportPath != "" {
+		data, err := ioutil.ReadFile(filepath.Join(dir, e.Report))
+		if err != nil {
+			return nil, stepError("copy report", err)
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, stepError("copy report", err)
+		}
+		report.Artifacts = artifacts
+		if err := report.Write(reportPath); err != nil {
+			return nil, stepError("copy report", err)
+		}
+	}
+	return artifacts, nil
+}
+
+func copyCachedFile(src, dest string, mode os.FileMode) error {
+	if err := copyFile(src, dest); err != nil {
+		return err
+	}
+	return os.Chmod(dest, mode)
+}
+
+// storeBuild saves the files written by a build in the
+// -buildcache, under the fingerprint of the run, unless
+// an entry already exists.
+func storeBuild(w *Workspace, mapFile string) error {
+	entryDir := filepath.Join(buildCacheDir, buildCacheKey)
+	if err := os.MkdirAll(buildCacheDir, 0755); err != nil {
+		return err
+	}
+	// Another run may have stored the same build while this
+	// one was building.
+	unlock, err := lockFile(filepath.Join(buildCacheDir, "lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(entryDir); err == nil {
+		return nil
+	}
+	tmpDir, err := ioutil.TempDir(buildCacheDir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	entry := &buildCacheEntry{Packages: append([]string{w.PkgName}, w.ExtraPkgs...)}
+	for i, artifact := range w.Artifacts {
+		name := "bin-" + strconv.Itoa(i)
+		if err := copyCachedFile(artifact.Path, filepath.Join(tmpDir, name), 0755); err != nil {
+			return err
+		}
+		entry.Artifacts = append(entry.Artifacts, cachedArtifact{Artifact: artifact, File: name})
+	}
+	if mapFile != "" {
+		entry.Mapping = "map"
+		if err := copyCachedFile(mapFile, filepath.Join(tmpDir, entry.Mapping), 0600); err != nil {
+			return err
+		}
+	}
+	if reportPath != "" {
+		entry.Report = "report.json"
+		if err := copyCachedFile(reportPath, filepath.Join(tmpDir, entry.Report), 0644); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "entry.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, entryDir); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package obfuscate
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildCacheOutPath(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/app/main.go": "package main\n\nimport \"fmt\"\n\n" +
+			"func main() {\n\tfmt.Println(\"cached\")\n}\n",
+	})
+	defer func(old string) {
+		build.Default.GOPATH = old
+	}(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+	t.Setenv("GOPATH", gopath)
+	t.Setenv("GO111MODULE", "off")
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	runTo := func(dir string) string {
+		out := filepath.Join(dir, "app")
+		err := Run(Options{PkgName: "example.com/app", OutPath: out, Seed: "cache", BuildCache: cacheDir,
+			ErrorCodes: true, ReportPath: filepath.Join(dir, "report.json")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	first := runTo(t.TempDir())
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	dir := t.TempDir()
+	second := runTo(dir)
+	if !strings.Contains(logs.String(), "from the build cache") {
+		t.Fatalf("the build is not restored from the cache:\n%s", logs.String())
+	}
+
+	for _, suffix := range []string{"", ".map.json"} {
+		expected, err := ioutil.ReadFile(first + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ioutil.ReadFile(second + suffix)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(actual, expected) {
+			t.Errorf("restored %s differs from the build", filepath.Base(second+suffix))
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Artifacts) != 1 || report.Artifacts[0].Path != second {
+		t.Errorf("the restored report lists %v instead of %s", report.Artifacts, second)
+	}
+}
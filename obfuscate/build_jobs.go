@@ -0,0 +1,94 @@
+package obfuscate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// numBuildJobs is the number of targets which are built at
+// the same time.
+var numBuildJobs = runtime.NumCPU()
+
+// errBuildFailed stops the builds of other targets once one
+// fails. The failure itself has already been reported.
+var errBuildFailed = errors.New("build failed")
+
+// outputLock keeps lines written by concurrent builds
+// whole.
+var outputLock sync.Mutex
+
+// A targetOutput is where the messages about building one
+// target go. When several targets are built at the same
+// time, every line starts with the target, like
+// "[linux/arm64] ", and is written at once, so that lines
+// from different builds are not mixed up.
+type targetOutput struct {
+	Log    *log.Logger
+	Stdout io.Writer
+	Stderr io.Writer
+
+	writers []*prefixWriter
+}
+
+func newTargetOutput(target buildTarget, parallel bool) *targetOutput {
+	if !parallel {
+		return &targetOutput{
+			Log:    log.New(log.Writer(), log.Prefix(), log.Flags()),
+			Stdout: os.Stdout,
+			Stderr: stderr,
+		}
+	}
+	prefix := "[" + target.String() + "] "
+	stdout := &prefixWriter{w: os.Stdout, prefix: prefix}
+	errOut := &prefixWriter{w: stderr, prefix: prefix}
+	return &targetOutput{
+		Log:     log.New(log.Writer(), log.Prefix()+prefix, log.Flags()|log.Lmsgprefix),
+		Stdout:  stdout,
+		Stderr:  errOut,
+		writers: []*prefixWriter{stdout, errOut},
+	}
+}
+
+// Flush writes the last line of the output, if it was not
+// finished.
+func (t *targetOutput) Flush() {
+	for _, w := range t.writers {
+		w.Flush()
+	}
+}
+
+// A prefixWriter writes whole lines, starting with a
+// prefix, to another writer.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	p.buf = append(p.buf, data...)
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			return len(data), nil
+		}
+		line := append([]byte(p.prefix), p.buf[:idx+1]...)
+		p.buf = p.buf[idx+1:]
+		if _, err := p.w.Write(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.Write([]byte{'\n'})
+	}
+}
@@ -0,0 +1,61 @@
+package obfuscate
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A localPath is a directory of the build machine which
+// should not show up in binaries.
+type localPath struct {
+	Kind string
+	Path string
+}
+
+// localPaths lists the directories whose paths the go
+// command could embed in the binaries of a workspace: the
+// workspace itself, GOROOT, the GOPATH and module cache of
+// the original sources, the working directory, and the
+// home directory.
+func localPaths(gopath string) []localPath {
+	res := []localPath{{"workspace", gopath}, {"GOROOT", build.Default.GOROOT}}
+	for _, dir := range filepath.SplitList(sourceContext().GOPATH) {
+		res = append(res, localPath{"GOPATH", dir})
+	}
+	if dir, err := currentDir(); err == nil {
+		res = append(res, localPath{"working directory", dir})
+	}
+	if dir, err := os.UserHomeDir(); err == nil {
+		res = append(res, localPath{"home directory", dir})
+	}
+	return res
+}
+
+// pathLeaks finds the local paths which are embedded in a
+// binary, in either slash style.
+// Paths of a single component, like "/root", are too
+// likely to match by chance, and are not looked for.
+func pathLeaks(path string, paths []localPath) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		dir := filepath.ToSlash(filepath.Clean(p.Path))
+		if p.Path == "" || seen[dir] || strings.Count(strings.Trim(dir, "/"), "/") < 1 {
+			continue
+		}
+		seen[dir] = true
+		if bytes.Contains(data, []byte(dir)) ||
+			bytes.Contains(data, []byte(strings.Replace(dir, "/", `\`, -1))) {
+			res = append(res, p.Kind+" "+p.Path)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package obfuscate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+)
+
+// These sentinels surround the module information which
+// the go command embeds in every binary.
+// They are defined in cmd/go/internal/modload.
+var (
+	modInfoStart = []byte("\x30\x77\xaf\x0c\x92\x74\x08\x02\x41\xe1\xc1\x07\xe6\xd6\x18\xe6")
+	modInfoEnd   = []byte("\xf9\x32\x43\x31\x86\x18\x20\x72\x00\x82\x42\x10\x41\x16\xd8\xf2")
+)
+
+// stripBuildInfo blanks out the embedded module info of a
+// binary so that `go version -m` and debug.ReadBuildInfo
+// reveal nothing about module paths, dependencies, or
+// build settings.
+//
+// The data is zeroed in place rather than removed, so the
+// layout of the binary is unchanged. Both the runtime and
+// debug/buildinfo treat the zeroed blob as empty.
+// The ad-hoc code signature of a Mach-O binary covers the
+// blob, so it is rehashed to match, since darwin/arm64
+// refuses to run binaries with broken signatures.
+func stripBuildInfo(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !zeroModInfo(data) {
+		return nil
+	}
+	if err := rehashCodeSignature(data); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, info.Mode())
+}
+
+// zeroModInfo zeroes every module info blob in data,
+// leaving the sentinels in place.
+// It reports whether anything was changed.
+func zeroModInfo(data []byte) bool {
+	var changed bool
+	for offset := 0; offset < len(data); {
+		start := bytes.Index(data[offset:], modInfoStart)
+		if start < 0 {
+			break
+		}
+		start += offset + len(modInfoStart)
+		end := bytes.Index(data[start:], modInfoEnd)
+		if end < 0 {
+			break
+		}
+		end += start
+		for i := start; i < end; i++ {
+			data[i] = 0
+		}
+		changed = true
+		offset = end + len(modInfoEnd)
+	}
+	return changed
+}
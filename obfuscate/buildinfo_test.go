@@ -0,0 +1,92 @@
+package obfuscate
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// buildDarwinBinary cross-compiles a small program for
+// darwin/arm64, which the linker signs ad-hoc.
+func buildDarwinBinary(t *testing.T) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(src, []byte("package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "main")
+	cmd := exec.Command("go", "build", "-o", out, src)
+	cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=0", "GO111MODULE=off", "GOFLAGS=")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s\n%s", err, output)
+	}
+	return out
+}
+
+func TestRehashCodeSignature(t *testing.T) {
+	data, err := ioutil.ReadFile(buildDarwinBinary(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hashes of an unchanged binary match the linker's.
+	rehashed := append([]byte{}, data...)
+	if err := rehashCodeSignature(rehashed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rehashed, data) {
+		t.Error("rehashing an unchanged binary changed its signature")
+	}
+
+	if !zeroModInfo(rehashed) {
+		t.Fatal("no module info found")
+	}
+	zeroed := append([]byte{}, rehashed...)
+	if err := rehashCodeSignature(rehashed); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rehashed, zeroed) {
+		t.Error("signature was not updated")
+	}
+}
+
+func TestStripBuildInfo(t *testing.T) {
+	path := buildDarwinBinary(t)
+	if err := stripBuildInfo(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Path != "" || len(info.Settings) > 0 {
+		t.Errorf("build info was not stripped: %v", info)
+	}
+
+	// Stripping is idempotent, and the signature of a
+	// stripped binary stays valid.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rehashed := append([]byte{}, data...)
+	if err := rehashCodeSignature(rehashed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rehashed, data) {
+		t.Error("stripped binary has stale signature hashes")
+	}
+}
+
+func TestRehashCodeSignatureNotMachO(t *testing.T) {
+	data := []byte("\x7fELF not a Mach-O binary")
+	if err := rehashCodeSignature(data); err != nil {
+		t.Fatal(err)
+	}
+}
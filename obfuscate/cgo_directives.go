@@ -0,0 +1,368 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cgoDirPrefix starts the names of the directories which
+// localizeCgoPaths copies files referenced by #cgo
+// directives into. Like every directory starting with "_",
+// they are not packages, and package moves leave them be.
+const cgoDirPrefix = "_cgo"
+
+func isCgoDir(name string) bool {
+	return strings.HasPrefix(name, cgoDirPrefix)
+}
+
+// A cgoDirective is a "#cgo" line of a cgo preamble, like
+// "#cgo linux,amd64 LDFLAGS: -L${SRCDIR}/lib -lfoo".
+type cgoDirective struct {
+	Constraints []string
+	Verb        string
+	Args        []string
+}
+
+// parseCgoDirective parses a line of a cgo preamble, which
+// may still start with "//".
+func parseCgoDirective(line string) (cgoDirective, bool) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSpace(strings.TrimPrefix(line, "//"))
+	if !strings.HasPrefix(line, "#cgo ") && !strings.HasPrefix(line, "#cgo\t") {
+		return cgoDirective{}, false
+	}
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return cgoDirective{}, false
+	}
+	fields := strings.Fields(line[len("#cgo"):colon])
+	if len(fields) == 0 {
+		return cgoDirective{}, false
+	}
+	return cgoDirective{
+		Constraints: fields[:len(fields)-1],
+		Verb:        fields[len(fields)-1],
+		Args:        strings.Fields(line[colon+1:]),
+	}, true
+}
+
+// Matches checks if a directive applies to a build, where
+// its constraints are OR'd options of AND'd terms, as in
+// "linux,amd64 darwin,!cgo".
+func (c cgoDirective) Matches(ctx *build.Context) bool {
+	if len(c.Constraints) == 0 {
+		return true
+	}
+	for _, option := range c.Constraints {
+		matches := true
+		for _, term := range strings.Split(option, ",") {
+			if strings.HasPrefix(term, "!") {
+				matches = matches && !matchCgoTerm(ctx, term[1:])
+			} else {
+				matches = matches && matchCgoTerm(ctx, term)
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func matchCgoTerm(ctx *build.Context, term string) bool {
+	switch term {
+	case ctx.GOOS, ctx.GOARCH:
+		return true
+	case "cgo":
+		return ctx.CgoEnabled
+	case "unix":
+		return knownOS[ctx.GOOS] && ctx.GOOS != "js" && ctx.GOOS != "nacl" && ctx.GOOS != "plan9" &&
+			ctx.GOOS != "wasip1" && ctx.GOOS != "windows" && ctx.GOOS != "zos"
+	}
+	for _, tag := range ctx.BuildTags {
+		if tag == term {
+			return true
+		}
+	}
+	return false
+}
+
+// srcdirPaths finds the paths after each ${SRCDIR} in an
+// argument of a directive, like "/lib" in
+// "-Wl,-rpath,${SRCDIR}/lib".
+func srcdirPaths(arg string) []string {
+	var res []string
+	for {
+		idx := strings.Index(arg, "${SRCDIR}")
+		if idx < 0 {
+			return res
+		}
+		arg = arg[idx+len("${SRCDIR}"):]
+		end := strings.IndexAny(arg, `,"'`)
+		if end < 0 {
+			end = len(arg)
+		}
+		res = append(res, arg[:end])
+	}
+}
+
+// readCgoPreamble reads a cgo file, and finds the offsets
+// of its preamble, the comment before `import "C"`.
+// The offsets are zero if there is none.
+func readCgoPreamble(path string) (contents []byte, start, end int, err error) {
+	contents, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, contents, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if doc := cgoPreamble(file); doc != nil {
+		start, end = set.Position(doc.Pos()).Offset, set.Position(doc.End()).Offset
+	}
+	return contents, start, end, nil
+}
+
+// readCgoDirectives lists the #cgo directives of a file.
+func readCgoDirectives(path string) ([]cgoDirective, error) {
+	contents, start, end, err := readCgoPreamble(path)
+	if err != nil {
+		return nil, err
+	}
+	var res []cgoDirective
+	for _, line := range strings.Split(string(contents[start:end]), "\n") {
+		if directive, ok := parseCgoDirective(line); ok {
+			res = append(res, directive)
+		}
+	}
+	return res, nil
+}
+
+// rewriteCgoDirectives applies f to every argument of the
+// #cgo directives of a file, replacing the argument with
+// the result of f.
+func rewriteCgoDirectives(path string, f func(d cgoDirective, arg string) (string, error)) error {
+	contents, start, end, err := readCgoPreamble(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(contents[start:end]), "\n")
+	var changed bool
+	for i, line := range lines {
+		directive, ok := parseCgoDirective(line)
+		if !ok {
+			continue
+		}
+		for _, arg := range directive.Args {
+			newArg, err := f(directive, arg)
+			if err != nil {
+				return err
+			}
+			if newArg != arg {
+				line = strings.Replace(line, arg, newArg, 1)
+				changed = true
+			}
+		}
+		lines[i] = line
+	}
+	if !changed {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.Write(contents[:start])
+	buf.WriteString(strings.Join(lines, "\n"))
+	buf.Write(contents[end:])
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// cgoPreamble finds the comment before `import "C"`.
+func cgoPreamble(file *ast.File) *ast.CommentGroup {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			if imp.Path.Value != `"C"` {
+				continue
+			}
+			if imp.Doc != nil {
+				return imp.Doc
+			} else if len(gen.Specs) == 1 {
+				return gen.Doc
+			}
+		}
+	}
+	return nil
+}
+
+// localizeCgoPaths makes the ${SRCDIR} paths of the #cgo
+// directives in the copy of a package at dstDir keep
+// working when the package or its parents are moved.
+//
+// The package's own directory and the files copied with it
+// are left alone. Anything else, such as a header directory
+// next to the package, is copied from srcDir into a _cgo
+// directory inside the copy, and the path is changed to
+// point there. Paths which only matter at run time, like
+// those of -Wl,-rpath, point at srcDir instead, as they
+// would in the original binary.
+func localizeCgoPaths(srcDir, dstDir string, cgoFiles []string) error {
+	copied := map[string]string{}
+	localize := func(directive cgoDirective, arg string) (string, error) {
+		for _, rest := range srcdirPaths(arg) {
+			if rest == "" || !strings.HasPrefix(rest, "/") {
+				continue
+			}
+			target := filepath.Join(srcDir, filepath.FromSlash(rest))
+			if strings.HasPrefix(arg, "-Wl,") {
+				arg = strings.Replace(arg, "${SRCDIR}"+rest, filepath.ToSlash(target), 1)
+				continue
+			}
+			rel, err := filepath.Rel(srcDir, target)
+			if err != nil {
+				return "", err
+			}
+			if rel == "." {
+				continue
+			} else if !strings.ContainsRune(rel, filepath.Separator) {
+				if info, err := os.Stat(filepath.Join(dstDir, rel)); err == nil && !info.IsDir() {
+					continue
+				}
+			}
+			info, err := os.Stat(target)
+			if err != nil {
+				// Left for checkCgoDirectives to report.
+				continue
+			}
+			local, ok := copied[target]
+			if !ok {
+				local = cgoDirPrefix + strconv.Itoa(len(copied)) + "/" + filepath.Base(target)
+				copied[target] = local
+				dst := filepath.Join(dstDir, filepath.FromSlash(local))
+				if info.IsDir() {
+					err = copyTree(target, dst)
+				} else if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+					err = copyFile(target, dst)
+				}
+				if err != nil {
+					return "", fmt.Errorf("copy %s: %s", target, err)
+				}
+			}
+			arg = strings.Replace(arg, "${SRCDIR}"+rest, "${SRCDIR}/"+local, 1)
+		}
+		return arg, nil
+	}
+	for _, name := range cgoFiles {
+		if err := rewriteCgoDirectives(filepath.Join(dstDir, name), localize); err != nil {
+			return fmt.Errorf("cgo directives of %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// checkCgoDirectives checks that the #cgo directives which
+// apply to a build of a GOPATH can be resolved: every
+// ${SRCDIR} path must exist, and every pkg-config package
+// must be known to pkg-config.
+// It returns a description of each problem.
+func checkCgoDirectives(gopath string, target buildTarget, tags string) ([]string, error) {
+	ctx := gopathContext(gopath)
+	ctx.GOOS = target.GOOS
+	ctx.GOARCH = target.GOARCH
+	ctx.CgoEnabled = true
+	ctx.BuildTags = strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == ' ' })
+
+	var problems []string
+	srcDir := filepath.Join(gopath, "src")
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if isCgoDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isGoFile(path) || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		dir, name := filepath.Split(path)
+		if ok, err := ctx.MatchFile(dir, name); err != nil || !ok {
+			return err
+		}
+		pkg, err := filepath.Rel(srcDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		pkg = filepath.ToSlash(pkg)
+		directives, err := readCgoDirectives(path)
+		if err != nil {
+			return fmt.Errorf("cgo directives of %s: %s", path, err)
+		}
+		var pkgConfig []string
+		for _, directive := range directives {
+			if !directive.Matches(&ctx) {
+				continue
+			}
+			for _, arg := range directive.Args {
+				if directive.Verb == "pkg-config" {
+					if !strings.HasPrefix(arg, "-") {
+						pkgConfig = append(pkgConfig, arg)
+					}
+					continue
+				}
+				if strings.HasPrefix(arg, "-Wl,") {
+					continue
+				}
+				for _, rest := range srcdirPaths(arg) {
+					if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rest))); err != nil {
+						problems = append(problems, fmt.Sprintf("%s (%s): %s %s: ${SRCDIR}%s does not exist",
+							pkg, name, directive.Verb, arg, rest))
+					}
+				}
+			}
+		}
+		if len(pkgConfig) > 0 {
+			if err := runPkgConfig(pkgConfig); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (%s): pkg-config %s: %s",
+					pkg, name, strings.Join(pkgConfig, " "), err))
+			}
+		}
+		return nil
+	})
+	sort.Strings(problems)
+	return problems, err
+}
+
+// runPkgConfig checks that pkg-config, or the $PKG_CONFIG
+// command like the go tool uses, knows some packages.
+func runPkgConfig(pkgs []string) error {
+	command := os.Getenv("PKG_CONFIG")
+	if command == "" {
+		command = "pkg-config"
+	}
+	cmd := exec.Command(command, append([]string{"--cflags", "--libs", "--"}, pkgs...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%s: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,494 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// linknameDirective starts the comments which refer to a
+// symbol by name, which keeps its name.
+const linknameDirective = "//go:linkname "
+
+// ObfuscateCgoSymbols renames the top-level names and the
+// unexported methods of the packages which use cgo, which
+// the refactoring API cannot load, in the packages of a
+// GOPATH for which include returns true.
+//
+// Each package is type-checked on its own, so that only the
+// identifiers which refer to its Go declarations are
+// renamed: references to C, the cgo preamble and its #cgo
+// directives, and the //export functions, whose names C
+// code calls, are left alone. A name which is used where
+// the package cannot be type-checked, such as a member of
+// a C value, keeps its name, and so do exported methods,
+// which may implement interfaces of other packages.
+// Exported top-level names are renamed in the packages
+// which import them too, unless one of them is not
+// obfuscated, imports the package with a dot, or embeds
+// the type.
+// It returns the renames, like ObfuscateSymbols does.
+func ObfuscateCgoSymbols(gopath string, n NameHasher, keep *KeepList,
+	include func(string) bool) ([]symbolRenameReq, error) {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	var cgoPkgs []string
+	for _, pkg := range pkgs {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		if include(pkg) && containsCGO(dir) && !containsAssembly(dir) && !containsSwig(dir) {
+			cgoPkgs = append(cgoPkgs, pkg)
+		}
+	}
+	if len(cgoPkgs) == 0 {
+		return nil, nil
+	}
+	importers, err := findImporters(gopath, cgoPkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every package is planned before any file changes,
+	// since a package may refer to the exported names of
+	// another, so the edits of several packages may apply
+	// to the same file.
+	var resLock sync.Mutex
+	var res []symbolRenameReq
+	edits := map[string][]fileEdit{}
+	err = forEachPackage(cgoPkgs, func(pkg string) error {
+		renames, pkgEdits, err := planCgoPackage(srcDir, pkg, importers[pkg], n, keep, include)
+		if err != nil {
+			return err
+		}
+		resLock.Lock()
+		defer resLock.Unlock()
+		res = append(res, renames...)
+		for path, fileEdits := range pkgEdits {
+			edits[path] = append(edits[path], fileEdits...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for path := range edits {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	err = runJobs(len(paths), func(i int) error {
+		return applyFileEdits(paths[i], edits[paths[i]])
+	})
+	return res, err
+}
+
+// findImporters lists the Go files of a GOPATH which
+// import each of some packages.
+func findImporters(gopath string, pkgs []string) (map[string][]sourceFile, error) {
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, pkg := range pkgs {
+		wanted[pkg] = true
+	}
+	var resLock sync.Mutex
+	res := map[string][]sourceFile{}
+	err = forEachFile(files, func(f sourceFile) error {
+		file, err := parser.ParseFile(token.NewFileSet(), f.Path, nil, parser.ImportsOnly)
+		if err != nil {
+			return nil
+		}
+		for _, spec := range file.Imports {
+			if path, _ := strconv.Unquote(spec.Path.Value); wanted[path] {
+				resLock.Lock()
+				res[path] = append(res[path], f)
+				resLock.Unlock()
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+func containsSwig(dir string) bool {
+	listing, _ := ioutil.ReadDir(dir)
+	for _, item := range listing {
+		if isSwigFile(item.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// A cgoPackage is a package which uses cgo, parsed and
+// type-checked for ObfuscateCgoSymbols.
+type cgoPackage struct {
+	Path  string
+	Name  string
+	Set   *token.FileSet
+	Files []*ast.File
+	Paths []string
+	Info  *types.Info
+	Types *types.Package
+}
+
+// planCgoPackage finds the renames of one package which
+// uses cgo, and the edits which make them in its files and
+// in the files which import it.
+func planCgoPackage(srcDir, pkgPath string, importers []sourceFile, n NameHasher, keep *KeepList,
+	include func(string) bool) ([]symbolRenameReq, map[string][]fileEdit, error) {
+	pkg, err := loadCgoPackage(srcDir, pkgPath)
+	if err != nil || pkg == nil {
+		return nil, nil, err
+	}
+	blocked := pkg.blockedNames()
+	renamed := map[types.Object]string{}
+	var res []symbolRenameReq
+	prefix := strconv.Quote(pkgPath) + "."
+
+	// Names declared more than once belong to files for
+	// different platforms, like in singleRenames.
+	declared := map[string]int{}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && d.Recv == nil {
+				declared[d.Name.Name]++
+			} else if d, ok := decl.(*ast.GenDecl); ok {
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						declared[spec.Name.Name]++
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							declared[name.Name]++
+						}
+					}
+				}
+			}
+		}
+	}
+	var exported []string
+	addTopLevel := func(file *ast.File, name *ast.Ident, comments ...*ast.CommentGroup) {
+		if name.Name == "_" || declared[name.Name] != 1 || blocked[name.Name] || IgnoreMethods[name.Name] ||
+			keep.KeepsName(pkgPath, name.Name) || keepsName(file, comments...) {
+			return
+		}
+		if obj := pkg.Info.Defs[name]; obj != nil {
+			renamed[obj] = n.Hash(name.Name)
+			if name.IsExported() {
+				exported = append(exported, name.Name)
+			}
+		}
+	}
+	interfaceMethods := pkg.interfaceMethods()
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					if !isExportedToC(d) {
+						addTopLevel(file, d.Name, d.Doc)
+					}
+					continue
+				}
+				if len(d.Recv.List) != 1 || d.Name.IsExported() || d.Name.Name == "_" ||
+					interfaceMethods[d.Name.Name] || blocked[d.Name.Name] || keepsName(file, d.Doc) ||
+					keep.KeepsMember(pkgPath, receiverTypeName(d.Recv.List[0]), d.Name.Name, n) {
+					continue
+				}
+				if obj := pkg.Info.Defs[d.Name]; obj != nil {
+					renamed[obj] = n.Hash(d.Name.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						addTopLevel(file, spec.Name, d.Doc, spec.Doc, spec.Comment)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							addTopLevel(file, name, d.Doc, spec.Doc, spec.Comment)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	importerEdits, kept := cgoImporterEdits(pkg, importers, exported, include)
+	for obj := range renamed {
+		if kept[obj.Name()] && obj.Parent() == pkg.Types.Scope() {
+			delete(renamed, obj)
+		}
+	}
+	if len(renamed) == 0 {
+		return nil, nil, nil
+	}
+
+	edits := map[string][]fileEdit{}
+	for i, file := range pkg.Files {
+		path := pkg.Paths[i]
+		ast.Inspect(file, func(node ast.Node) bool {
+			ident, ok := node.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			obj := pkg.Info.Defs[ident]
+			if obj == nil {
+				obj = pkg.Info.Uses[ident]
+			}
+			newName, ok := renamed[obj]
+			if !ok {
+				// The names of embedded fields are those of
+				// their types.
+				if v, isVar := obj.(*types.Var); isVar && v.Embedded() {
+					newName, ok = renamed[embeddedTypeName(v.Type())]
+				}
+			}
+			if ok {
+				edits[path] = append(edits[path], pkg.edit(ident, newName))
+			}
+			return true
+		})
+	}
+	for path, fileEdits := range importerEdits {
+		for _, e := range fileEdits {
+			if e.Text = renamedName(renamed, pkg.Types, e.Text); e.Text != "" {
+				edits[path] = append(edits[path], e)
+			}
+		}
+	}
+
+	for obj, newName := range renamed {
+		oldName := prefix + obj.Name()
+		if fn, ok := obj.(*types.Func); ok && fn.Type().(*types.Signature).Recv() != nil {
+			oldName = cgoMethodName(prefix, fn)
+		}
+		res = append(res, symbolRenameReq{OldName: oldName, NewName: newName})
+	}
+	return res, edits, nil
+}
+
+// loadCgoPackage parses the files of a package directory,
+// with its internal tests, and type-checks them without
+// their imports, which leaves the references to C
+// unresolved.
+func loadCgoPackage(srcDir, pkgPath string) (*cgoPackage, error) {
+	dir := filepath.Join(srcDir, filepath.FromSlash(pkgPath))
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &cgoPackage{Path: pkgPath, Set: token.NewFileSet()}
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, item.Name())
+		file, err := parser.ParseFile(pkg.Set, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		// External tests are importers of the package.
+		if strings.HasSuffix(file.Name.Name, "_test") && strings.HasSuffix(item.Name(), "_test.go") {
+			continue
+		}
+		pkg.Name = file.Name.Name
+		pkg.Files = append(pkg.Files, file)
+		pkg.Paths = append(pkg.Paths, path)
+	}
+	if len(pkg.Files) == 0 {
+		return nil, nil
+	}
+	pkg.Info = &types.Info{
+		Defs: map[*ast.Ident]types.Object{},
+		Uses: map[*ast.Ident]types.Object{},
+	}
+	conf := types.Config{Importer: noImporter{}, Error: func(error) {}}
+	pkg.Types, _ = conf.Check(pkgPath, pkg.Set, pkg.Files, pkg.Info)
+	return pkg, nil
+}
+
+// blockedNames lists the names which keep their names
+// because some identifier with the name is not resolved,
+// other than the members of imported packages, or because
+// a //go:linkname directive names them.
+func (c *cgoPackage) blockedNames() map[string]bool {
+	res := map[string]bool{}
+	for _, file := range c.Files {
+		qualified := map[*ast.Ident]bool{file.Name: true}
+		ast.Inspect(file, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					if _, ok := c.Info.Uses[x].(*types.PkgName); ok {
+						qualified[sel.Sel] = true
+					}
+				}
+			}
+			return true
+		})
+		ast.Inspect(file, func(node ast.Node) bool {
+			if ident, ok := node.(*ast.Ident); ok && !qualified[ident] &&
+				c.Info.Defs[ident] == nil && c.Info.Uses[ident] == nil {
+				res[ident.Name] = true
+			}
+			return true
+		})
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if strings.HasPrefix(comment.Text, linknameDirective) {
+					for _, field := range strings.Fields(comment.Text[len(linknameDirective):]) {
+						res[field[strings.LastIndex(field, ".")+1:]] = true
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+// interfaceMethods lists the names of the methods of the
+// interfaces which the package declares.
+func (c *cgoPackage) interfaceMethods() map[string]bool {
+	res := map[string]bool{}
+	for _, file := range c.Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if t, ok := node.(*ast.InterfaceType); ok {
+				for _, field := range t.Methods.List {
+					for _, name := range field.Names {
+						res[name.Name] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	return res
+}
+
+func (c *cgoPackage) edit(ident *ast.Ident, text string) fileEdit {
+	return fileEdit{
+		Start: c.Set.Position(ident.Pos()).Offset,
+		End:   c.Set.Position(ident.End()).Offset,
+		Text:  text,
+	}
+}
+
+// isExportedToC checks if a function has an //export
+// comment, which makes C code call it by name.
+func isExportedToC(fn *ast.FuncDecl) bool {
+	if fn.Doc == nil {
+		return false
+	}
+	for _, comment := range fn.Doc.List {
+		if m := exportDirective.FindStringSubmatch(comment.Text); m != nil && m[1] == fn.Name.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func cgoMethodName(prefix string, fn *types.Func) string {
+	recv := fn.Type().(*types.Signature).Recv().Type()
+	typeName := embeddedTypeName(recv).Name()
+	if _, ok := recv.(*types.Pointer); ok {
+		return "(*" + prefix + typeName + ")." + fn.Name()
+	}
+	return prefix + typeName + "." + fn.Name()
+}
+
+// cgoImporterEdits finds the references to the exported
+// names of a package in the files which import it, which
+// findImporters found. The
+// edits hold the old names, which are replaced once the
+// names to rename are known.
+// It also returns the names which must be kept, since
+// their references cannot all be renamed.
+func cgoImporterEdits(pkg *cgoPackage, importers []sourceFile, exported []string,
+	include func(string) bool) (map[string][]fileEdit, map[string]bool) {
+	kept := map[string]bool{}
+	edits := map[string][]fileEdit{}
+	if len(exported) == 0 {
+		return edits, kept
+	}
+	keepAll := func() {
+		for _, name := range exported {
+			kept[name] = true
+		}
+	}
+	isExported := map[string]bool{}
+	for _, name := range exported {
+		isExported[name] = true
+	}
+	for _, f := range importers {
+		if f.PkgPath == pkg.Path && !strings.HasSuffix(f.Path, "_test.go") {
+			continue
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, 0)
+		if err != nil {
+			continue
+		}
+		var local string
+		for _, spec := range file.Imports {
+			if path, _ := strconv.Unquote(spec.Path.Value); path == pkg.Path {
+				local = pkg.Name
+				if spec.Name != nil {
+					local = spec.Name.Name
+				}
+			}
+		}
+		if local == "" || local == "_" {
+			continue
+		}
+		if local == "." || !include(f.PkgPath) {
+			keepAll()
+			continue
+		}
+		ast.Inspect(file, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.Field:
+				// Embedded types name fields.
+				t := node.Type
+				if star, ok := t.(*ast.StarExpr); ok {
+					t = star.X
+				}
+				if sel, ok := t.(*ast.SelectorExpr); ok && len(node.Names) == 0 {
+					if x, ok := sel.X.(*ast.Ident); ok && x.Name == local {
+						kept[sel.Sel.Name] = true
+					}
+				}
+			case *ast.SelectorExpr:
+				x, ok := node.X.(*ast.Ident)
+				if ok && x.Name == local && x.Obj == nil && isExported[node.Sel.Name] {
+					edits[f.Path] = append(edits[f.Path], fileEdit{
+						Start: set.Position(node.Sel.Pos()).Offset,
+						End:   set.Position(node.Sel.End()).Offset,
+						Text:  node.Sel.Name,
+					})
+				}
+			}
+			return true
+		})
+	}
+	return edits, kept
+}
+
+// renamedName gets the new name of a top-level name of a
+// package, or "" if it is not renamed.
+func renamedName(renamed map[types.Object]string, pkg *types.Package, name string) string {
+	if obj := pkg.Scope().Lookup(name); obj != nil {
+		return renamed[obj]
+	}
+	return ""
+}
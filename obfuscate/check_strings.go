@@ -0,0 +1,244 @@
+package obfuscate
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// checkStrings enables the check that the string helper of
+// a build decodes every kind of literal correctly.
+var checkStrings bool
+
+// stringCheckCorpus lists strings which are easy to get
+// wrong when they are written, parsed, or encoded.
+var stringCheckCorpus = []string{
+	"", "a", "\x00", "a\x00b\x00", "\xff", "\xff\xfe\xfd", "\xc3", "\xed\xa0\x80", "\xf4\x90\x80\x80",
+	"\ufeffbom", "é", "日本語", "\U0001F600", "\u2028\u2029", "`", "``", "\"", "'", "\\", "\\x41",
+	"\r", "\r\n", "a\rb", "\t\v\f\a\b", "%s %% %q", "$GOPATH", "//gobfuscate:encrypt",
+}
+
+// stringCheckRandom is the number of random strings which
+// are checked, along with the corpus.
+const stringCheckRandom = 200
+
+// stringCheckSealedSize is the size of the longest string
+// which is checked encrypted, since encrypted strings are
+// built by a statement for every byte.
+const stringCheckSealedSize = 1024
+
+// stringCheckCases lists the strings to check: the corpus,
+// every byte value, long strings, and random strings drawn
+// from r.
+func stringCheckCases(r *rand.Rand) []string {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	cases := append([]string{string(all), strings.Repeat("long\x00", 1<<14)}, stringCheckCorpus...)
+	for i := 0; i < stringCheckRandom; i++ {
+		data := make([]byte, r.Intn(100))
+		for j := range data {
+			if i%2 == 0 {
+				data[j] = byte(r.Intn(256))
+			} else {
+				data[j] = byte(r.Intn(128))
+			}
+		}
+		str := string(data)
+		if i%4 == 1 {
+			// Valid UTF-8 with multi-byte characters.
+			str = strings.ToValidUTF8(str, "\u00e9\u65e5")
+		}
+		cases = append(cases, str)
+	}
+
+	// Switch cases must be unique.
+	seen := map[string]bool{}
+	var res []string
+	for _, c := range cases {
+		if !seen[c] {
+			seen[c] = true
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// CheckStringHelper checks that the string helper of a
+// build decodes strings correctly, by running the string
+// pass on a program which compares the strings it decodes
+// to their bytes, and running it.
+//
+// Every string is written as an interpreted literal, as an
+// ASCII-only literal, and as a raw literal (if it can be
+// one), and is also used in a switch case and encrypted.
+//
+// The random strings are derived from the -seed, or from a
+// random seed without one. Either way, a failure names the
+// seed, so that the same strings can be checked again.
+func CheckStringHelper(helper *stringHelper) error {
+	corpusSeed := seed
+	if corpusSeed == "" {
+		var seedBytes [8]byte
+		cryptorand.Read(seedBytes[:])
+		corpusSeed = hex.EncodeToString(seedBytes[:])
+	}
+	if err := checkStringCases(helper, stringCheckCases(newCorpusRand(corpusSeed))); err != nil {
+		return fmt.Errorf("%s (strings generated with seed %s)", err, corpusSeed)
+	}
+	return nil
+}
+
+// newCorpusRand creates the source of the random strings
+// which are checked with a seed.
+func newCorpusRand(corpusSeed string) *rand.Rand {
+	hash := sha256.Sum256([]byte(corpusSeed + "\x00checkstrings"))
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(hash[:8]))))
+}
+
+// checkStringCases runs the check program over the given
+// strings with a copy of the helper.
+func checkStringCases(helper *stringHelper, cases []string) error {
+	gopath, err := newTempDir("strcheck")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(gopath)
+
+	// The check uses a copy, so that it does not make the
+	// build's helper encrypt strings.
+	data, err := json.Marshal(helper)
+	if err != nil {
+		return err
+	}
+	check := &stringHelper{}
+	if err := json.Unmarshal(data, check); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(gopath, "src", "strcheck")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(path, stringCheckProgram(cases), 0644); err != nil {
+		return err
+	}
+	if _, err := obfuscateFileStrings(path, check); err != nil {
+		return err
+	}
+	if err := check.Write(gopath); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", "run", "strcheck")
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=", "CGO_ENABLED=0",
+		"GOOS=", "GOARCH=")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		var kind, idx int
+		if _, err := fmt.Sscan(line, &kind, &idx); err == nil && kind < len(stringCheckKinds) && idx < len(cases) {
+			return fmt.Errorf("%s %q is decoded incorrectly", stringCheckKinds[kind], cases[idx])
+		}
+	}
+	return fmt.Errorf("check program failed: %s\n%s", err, output)
+}
+
+// stringCheckKinds describe the ways in which the check
+// program uses strings, by the numbers it prints.
+var stringCheckKinds = []string{"literal", "encrypted literal", "switch case"}
+
+// stringCheckProgram generates the source of the program
+// which checks the strings.
+func stringCheckProgram(cases []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\n")
+
+	buf.WriteString("var want = [][]byte{\n")
+	for _, c := range cases {
+		buf.WriteString("\t{")
+		for i := 0; i < len(c); i++ {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(strconv.Itoa(int(c[i])))
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("var forms = [][]string{\n")
+	for _, c := range cases {
+		fmt.Fprintf(&buf, "\t{%s, %s", strconv.Quote(c), strconv.QuoteToASCII(c))
+		if canBeRaw(c) {
+			buf.WriteString(", `" + c + "`")
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("//gobfuscate:encrypt\nvar sealed = map[int]string{\n")
+	for i, c := range cases {
+		if len(c) <= stringCheckSealedSize {
+			fmt.Fprintf(&buf, "\t%d: %s,\n", i, strconv.Quote(c))
+		}
+	}
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("func hoisted(s string) int {\n\tswitch s {\n")
+	for i, c := range cases {
+		fmt.Fprintf(&buf, "\tcase %s:\n\t\treturn %d\n", strconv.Quote(c), i)
+	}
+	buf.WriteString("\t}\n\treturn -1\n}\n\n")
+
+	// The program only prints numbers, since it cannot
+	// trust its own strings.
+	buf.WriteString(`func main() {
+	failed := false
+	for i, w := range want {
+		for _, got := range forms[i] {
+			if got != string(w) {
+				fmt.Println(0, i)
+				failed = true
+			}
+		}
+		if got, ok := sealed[i]; ok && got != string(w) {
+			fmt.Println(1, i)
+			failed = true
+		}
+		if hoisted(string(w)) != i {
+			fmt.Println(2, i)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+`)
+	return buf.Bytes()
+}
+
+// canBeRaw checks if a string can be written as a raw
+// string literal, which cannot contain backquotes or
+// carriage returns (which the compiler drops), or anything
+// the compiler rejects in source code.
+func canBeRaw(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsAny(s, "`\r\x00\ufeff")
+}
@@ -0,0 +1,65 @@
+package obfuscate
+
+import (
+	"os/exec"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// schemeHelpers creates a string helper for every scheme.
+func schemeHelpers(tb testing.TB) []*stringHelper {
+	helpers := make([]*stringHelper, numSchemes)
+	found := 0
+	for i := 0; found < numSchemes; i++ {
+		helper := newStringHelper(tb.TempDir(), NameHasher("scheme"+strconv.Itoa(i)))
+		if helpers[helper.Scheme] == nil {
+			helpers[helper.Scheme] = helper
+			found++
+		}
+	}
+	return helpers
+}
+
+func TestCheckStringHelper(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	// The schemes are checked in parallel, after this
+	// function returns, so the seed is restored by a
+	// cleanup.
+	oldSeed := seed
+	t.Cleanup(func() {
+		seed = oldSeed
+	})
+	seed = "checkstrings"
+
+	if !reflect.DeepEqual(stringCheckCases(newCorpusRand(seed)), stringCheckCases(newCorpusRand(seed))) {
+		t.Error("the strings to check are not derived from the seed")
+	}
+	for _, helper := range schemeHelpers(t) {
+		helper := helper
+		t.Run("scheme"+strconv.Itoa(helper.Scheme), func(t *testing.T) {
+			t.Parallel()
+			if err := CheckStringHelper(helper); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func FuzzStringRoundTrip(f *testing.F) {
+	if _, err := exec.LookPath("go"); err != nil {
+		f.Skip("go is not installed")
+	}
+	helpers := schemeHelpers(f)
+	for i, data := range []string{"", "\x00\xff", "\r\n`\ufeff", "\xed\xa0\x80日本語"} {
+		f.Add([]byte(data), uint8(i))
+	}
+	f.Fuzz(func(t *testing.T, data []byte, scheme uint8) {
+		helper := helpers[int(scheme)%len(helpers)]
+		if err := checkStringCases(helper, []string{string(data)}); err != nil {
+			t.Errorf("scheme %d: %s", helper.Scheme, err)
+		}
+	})
+}
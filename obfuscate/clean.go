@@ -0,0 +1,217 @@
+package obfuscate
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The clean subcommand's policies for caches and
+// artifacts.
+var (
+	cleanMaxAge  time.Duration
+	cleanMaxSize string
+	artifactsDir string
+)
+
+// A cleanItem is a cache entry or artifact which the clean
+// subcommand may remove.
+type cleanItem struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// clean removes the temporary directories of crashed runs,
+// and then applies the age and size policies to the
+// dependency cache, the build cache, the incremental
+// directory, and the artifacts directory, if they are
+// given.
+func clean() bool {
+	maxSize, err := parseSize(cleanMaxSize)
+	if err != nil {
+		fmt.Fprintln(stderr, "Invalid -maxsize:", err)
+		return false
+	}
+	if !cleanTempDirs() {
+		return false
+	}
+	if depCacheDir != "" && !cleanCache(depCacheDir, "dependency cache", maxSize) {
+		return false
+	}
+	if buildCacheDir != "" && !cleanCache(buildCacheDir, "build cache", maxSize) {
+		return false
+	}
+	if incrementalDir != "" && !cleanIncremental() {
+		return false
+	}
+	if artifactsDir != "" {
+		items, err := listCleanItems(artifactsDir, nil)
+		if err != nil {
+			fmt.Fprintln(stderr, "Failed to list artifacts:", err)
+			return false
+		}
+		if !removeCleanItems(staleItems(items, maxSize)) {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanCache removes the leftovers of crashed runs from
+// a cache directory, like the dependency cache, along with
+// the entries which the policies select.
+// Entries are dated by their last use.
+func cleanCache(dir, desc string, maxSize int64) bool {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return true
+	}
+	unlock, err := lockFile(filepath.Join(dir, "lock"))
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to lock "+desc+":", err)
+		return false
+	}
+	defer unlock()
+
+	// Entries are only written with the lock held, so
+	// any partial entry was left by a crash.
+	partial, err := filepath.Glob(filepath.Join(dir, "tmp-*"))
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to list "+desc+":", err)
+		return false
+	}
+	var items []*cleanItem
+	for _, path := range partial {
+		items = append(items, &cleanItem{Path: path, Size: dirSize(path)})
+	}
+	entries, err := listCleanItems(dir, func(name string) bool {
+		return name != "lock" && !strings.HasPrefix(name, "tmp-")
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to list "+desc+":", err)
+		return false
+	}
+	return removeCleanItems(append(items, staleItems(entries, maxSize)...))
+}
+
+// cleanIncremental removes the incremental directory's
+// workspace and caches if it was last used longer ago than
+// -maxage.
+func cleanIncremental() bool {
+	statePath := filepath.Join(incrementalDir, "state.json")
+	info, err := os.Stat(statePath)
+	if err != nil || cleanMaxAge == 0 || time.Since(info.ModTime()) <= cleanMaxAge {
+		return true
+	}
+	unlock, err := lockFile(filepath.Join(incrementalDir, "lock"))
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to lock incremental directory:", err)
+		return false
+	}
+	defer unlock()
+	var items []*cleanItem
+	for _, name := range []string{"state.json", "workspace", "gocache", "modcache"} {
+		path := filepath.Join(incrementalDir, name)
+		if _, err := os.Stat(path); err == nil {
+			items = append(items, &cleanItem{Path: path, Size: dirSize(path)})
+		}
+	}
+	return removeCleanItems(items)
+}
+
+// listCleanItems lists the files and directories in a
+// directory, optionally filtered by name.
+func listCleanItems(dir string, include func(name string) bool) ([]*cleanItem, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []*cleanItem
+	for _, info := range listing {
+		if include != nil && !include(info.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		size := info.Size()
+		if info.IsDir() {
+			size = dirSize(path)
+		}
+		res = append(res, &cleanItem{Path: path, Size: size, ModTime: info.ModTime()})
+	}
+	return res, nil
+}
+
+// staleItems selects the items older than -maxage, and
+// then the oldest of the remaining items until the rest
+// fit in maxSize (if it is not zero).
+func staleItems(items []*cleanItem, maxSize int64) []*cleanItem {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ModTime.Before(items[j].ModTime)
+	})
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	var res []*cleanItem
+	for _, item := range items {
+		tooOld := cleanMaxAge != 0 && time.Since(item.ModTime) > cleanMaxAge
+		tooBig := maxSize != 0 && total > maxSize
+		if !tooOld && !tooBig {
+			break
+		}
+		res = append(res, item)
+		total -= item.Size
+	}
+	return res
+}
+
+func removeCleanItems(items []*cleanItem) bool {
+	var freed int64
+	for _, item := range items {
+		if err := removeTempDir(item.Path); err != nil {
+			fmt.Fprintln(stderr, "Failed to remove:", err)
+			return false
+		}
+		log.Printf("Removed %s (%s)", item.Path, formatSize(item.Size))
+		freed += item.Size
+	}
+	if len(items) > 0 {
+		log.Println("Freed", formatSize(freed))
+	}
+	return true
+}
+
+// parseSize parses a size like "500MB" or "10GiB".
+// An empty size is zero.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	units := []struct {
+		Suffix string
+		Size   int64
+	}{
+		{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	number, unit := s, int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.Suffix)) {
+			number, unit = strings.TrimSpace(s[:len(s)-len(u.Suffix)]), u.Size
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, errors.New("invalid size: " + s)
+	}
+	return int64(value * float64(unit)), nil
+}
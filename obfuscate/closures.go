@@ -0,0 +1,197 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HoistClosures moves the bodies of functions which keep
+// their names into new functions with hashed names, if they
+// contain closures or go or defer statements.
+//
+// The compiler names closures and the wrappers of go and
+// defer statements after the function they are in (like
+// main.main.func1), so a function whose name is kept, like
+// main, init, or a method of an interface, would otherwise
+// leak its name into the names of everything in it.
+//
+// Functions whose names are in renamed were renamed
+// already, and are left alone.
+//
+// It returns the hoists, from each function which kept its
+// name to the function its body was moved into, with the
+// function named like the renames of ObfuscateSymbols.
+func HoistClosures(gopath string, n NameHasher, renamed map[string]bool,
+	include func(string) bool) ([]symbolRenameReq, error) {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return nil, err
+	}
+	var resLock sync.Mutex
+	var res []symbolRenameReq
+	err = forEachFile(files, func(f sourceFile) error {
+		hoists, err := hoistFileClosures(f.Path, f.PkgPath, n, renamed)
+		if err != nil {
+			return err
+		}
+		resLock.Lock()
+		defer resLock.Unlock()
+		res = append(res, hoists...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].OldName < res[j].OldName
+	})
+	return res, nil
+}
+
+// renamedNames gets the new names of renamed symbols.
+func renamedNames(renames []symbolRenameReq) map[string]bool {
+	res := map[string]bool{}
+	for _, r := range renames {
+		res[r.NewName] = true
+	}
+	return res
+}
+
+func hoistFileClosures(path, pkgPath string, n NameHasher,
+	renamed map[string]bool) ([]symbolRenameReq, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, contents, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	offset := func(pos token.Pos) int {
+		return set.Position(pos).Offset
+	}
+	source := func(node ast.Node) string {
+		return string(contents[offset(node.Pos()):offset(node.End())])
+	}
+
+	skipped := findSkippedCode(file)
+	var edits []fileEdit
+	var hoists []symbolRenameReq
+	for i, decl := range file.Decls {
+		d, ok := decl.(*ast.FuncDecl)
+		if !ok || d.Body == nil || d.Type.TypeParams != nil || renamed[d.Name.Name] || !containsClosures(d.Body) ||
+			skipped.Contains(d) {
+			continue
+		}
+		newName := n.Hash("closures#" + filepath.Base(path) + "#" + strconv.Itoa(i))
+		paramToken := "closures#" + filepath.Base(path) + "#" + strconv.Itoa(i) + "#"
+
+		// The forwarding function needs names for every
+		// parameter and for its receiver.
+		call := newName
+		oldName := strconv.Quote(pkgPath) + "." + d.Name.Name
+		if d.Recv != nil && len(d.Recv.List) == 1 {
+			recv := d.Recv.List[0]
+			oldName = strconv.Quote(pkgPath) + "." + genericReceiverName(recv) + "." + d.Name.Name
+			if len(recv.Names) == 0 || recv.Names[0].Name == "_" {
+				name := n.Hash(paramToken + "recv")
+				edits = append(edits, fileEdit{
+					Start: offset(d.Recv.Pos()),
+					End:   offset(d.Recv.End()),
+					Text:  "(" + name + " " + source(recv.Type) + ")",
+				})
+				call = name + "." + newName
+			} else {
+				call = recv.Names[0].Name + "." + newName
+			}
+		}
+		var args, params []string
+		var variadic, renameParams bool
+		for _, field := range d.Type.Params.List {
+			var names []string
+			for _, name := range field.Names {
+				names = append(names, name.Name)
+			}
+			if len(names) == 0 {
+				names = append(names, "_")
+			}
+			for j, name := range names {
+				if name == "_" {
+					names[j] = n.Hash(paramToken + strconv.Itoa(len(args)+j))
+					renameParams = true
+				}
+			}
+			args = append(args, names...)
+			params = append(params, strings.Join(names, ", ")+" "+source(field.Type))
+			_, variadic = field.Type.(*ast.Ellipsis)
+		}
+		if renameParams {
+			edits = append(edits, fileEdit{
+				Start: offset(d.Type.Params.Opening) + 1,
+				End:   offset(d.Type.Params.Closing),
+				Text:  strings.Join(params, ", "),
+			})
+		}
+		call += "(" + strings.Join(args, ", ")
+		if variadic {
+			call += "..."
+		}
+		call += ")"
+		if d.Type.Results != nil && len(d.Type.Results.List) > 0 {
+			call = "return " + call
+		}
+
+		hoisted := source(d)
+		nameStart := offset(d.Name.Pos()) - offset(d.Pos())
+		hoisted = hoisted[:nameStart] + newName + hoisted[nameStart+len(d.Name.Name):]
+		edits = append(edits, fileEdit{
+			Start: offset(d.Body.Pos()),
+			End:   offset(d.Body.End()),
+			Text:  "{\n\t" + call + "\n}\n\n" + hoisted,
+		})
+		hoists = append(hoists, symbolRenameReq{OldName: oldName, NewName: newName})
+	}
+	if len(edits) == 0 {
+		return nil, nil
+	}
+	return hoists, applyFileEdits(path, edits)
+}
+
+// genericReceiverName is like receiverTypeName, but also
+// gets the names of generic types, without their type
+// parameters.
+func genericReceiverName(rec *ast.Field) string {
+	t := rec.Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch index := t.(type) {
+	case *ast.IndexExpr:
+		t = index.X
+	case *ast.IndexListExpr:
+		t = index.X
+	}
+	return receiverTypeName(&ast.Field{Type: t})
+}
+
+// containsClosures checks if a function body contains
+// anything the compiler names after the function.
+func containsClosures(body *ast.BlockStmt) bool {
+	var res bool
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.FuncLit, *ast.GoStmt, *ast.DeferStmt:
+			res = true
+		}
+		return !res
+	})
+	return res
+}
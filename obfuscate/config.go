@@ -0,0 +1,253 @@
+package obfuscate
+
+import (
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configFileNames are the config files which are used if
+// -config is not passed, in the root of the project.
+var configFileNames = []string{"gobfuscate.yaml", "gobfuscate.yml", "gobfuscate.json"}
+
+// A Config is read from a JSON or YAML file passed with
+// -config, or found in the root of the project.
+type Config struct {
+	// Profile is used if -profile is not passed.
+	Profile string `json:"profile"`
+
+	// Padding is used if -padding is not passed.
+	Padding string `json:"padding"`
+
+	// Exclude lists packages to copy without obfuscating
+	// them, like -exclude.
+	Exclude []string `json:"exclude"`
+
+	// Platforms lists the "goos/goarch" targets to build,
+	// if neither -goos nor -goarch is passed.
+	Platforms []string `json:"platforms"`
+
+	// LDFlags are added to the flags which are passed to
+	// go build with -ldflags.
+	LDFlags string `json:"ldflags"`
+
+	// Tags are build tags added to those of -tags.
+	Tags string `json:"tags"`
+
+	// Packages overrides settings for packages, by import
+	// path. A package also matches the settings of its
+	// closest parent which has any.
+	Packages map[string]PackageConfig `json:"packages"`
+
+	// PrepareEnv sets Go environment variables (such as
+	// GOPRIVATE or GOFLAGS) while the workspace is prepared,
+	// but not while building.
+	PrepareEnv map[string]string `json:"prepare_env"`
+
+	// ArtifactScan lists scanners to run against every
+	// built binary.
+	ArtifactScan ArtifactScanConfig `json:"artifact_scan"`
+
+	// Blocklist lists patterns which must not appear in
+	// built binaries, like the lines of -blocklist.
+	Blocklist []string `json:"blocklist"`
+
+	// Targets overrides build settings for target
+	// platforms, by "goos/goarch" or just "goos".
+	Targets map[string]TargetConfig `json:"targets"`
+
+	// Verify lists the projects which the verify subcommand
+	// obfuscates and checks, by name.
+	Verify map[string]VerifyProject `json:"verify"`
+}
+
+// A VerifyProject is a project which the verify subcommand
+// obfuscates, along with the command which checks the
+// obfuscated binary.
+type VerifyProject struct {
+	// Dir is the directory to obfuscate the project in,
+	// relative to the config file. With -modules, it must be
+	// inside the module.
+	Dir string `json:"dir"`
+
+	// Package is the package to obfuscate, like pkg_name.
+	Package string `json:"package"`
+
+	// Flags are added to the flags of the obfuscation.
+	Flags []string `json:"flags"`
+
+	// Command is split on spaces and run in Dir with the
+	// path of the binary as its last argument. It must exit
+	// with a zero status.
+	Command string `json:"command"`
+}
+
+// A TargetConfig overrides build settings for a target
+// platform.
+type TargetConfig struct {
+	// Env sets toolchain environment variables like
+	// GOEXPERIMENT or GOAMD64 while building.
+	Env map[string]string `json:"env"`
+
+	// GCFlags are passed to go build with -gcflags.
+	GCFlags string `json:"gcflags"`
+
+	// LDFlags are added to the -ldflags of the config.
+	LDFlags string `json:"ldflags"`
+
+	// Tags are build tags added to those of -tags.
+	Tags string `json:"tags"`
+}
+
+// An ArtifactScanConfig lists scanners which must not
+// match a built binary.
+type ArtifactScanConfig struct {
+	// Yara lists YARA rule files.
+	Yara []string `json:"yara"`
+
+	// Commands lists commands which are run with the path
+	// of the binary as their last argument, and which exit
+	// with a non-zero status if they detect it.
+	Commands []string `json:"commands"`
+}
+
+// A PackageConfig overrides settings for a package.
+type PackageConfig struct {
+	// Profile decides which per-package passes are applied
+	// to the package.
+	Profile string `json:"profile"`
+}
+
+// LoadConfig reads a config file, which is YAML if its
+// name ends in .yaml or .yml and JSON otherwise.
+// The file is validated first, so that mistakes are
+// reported with their positions.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var positions []yamlPosition
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		data, positions, err = yamlToJSON(path, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := validateConfig(path, data, positions); err != nil {
+		return nil, err
+	}
+	var res Config
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// findConfigFile looks for a config file in the root of
+// the project which contains a package: the closest
+// directory with a go.mod file, or the package's own
+// directory outside of modules.
+// With -modules, the search starts in sourceDir. It returns "" if there is no config file.
+func findConfigFile(pkgName string) (string, error) {
+	var dir string
+	if useModules {
+		var err error
+		dir, err = currentDir()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		ctx := sourceContext()
+		pkg, err := ctx.Import(pkgName, "", build.FindOnly)
+		if err != nil {
+			// The copy reports missing packages.
+			return "", nil
+		}
+		dir = pkg.Dir
+	}
+	root := dir
+	for search := dir; ; {
+		if _, err := os.Stat(filepath.Join(search, "go.mod")); err == nil {
+			root = search
+			break
+		}
+		parent := filepath.Dir(search)
+		if parent == search {
+			break
+		}
+		search = parent
+	}
+	for _, name := range configFileNames {
+		path := filepath.Join(root, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// PackageProfile finds the profile which applies to a
+// package, or "" if the package has no override.
+func (c *Config) PackageProfile(importPath string) string {
+	if c == nil {
+		return ""
+	}
+	bestLen := -1
+	var res string
+	for prefix, pkgConfig := range c.Packages {
+		if pkgConfig.Profile == "" || len(prefix) <= bestLen {
+			continue
+		}
+		if importPath == prefix || strings.HasPrefix(importPath, prefix+"/") {
+			bestLen = len(prefix)
+			res = pkgConfig.Profile
+		}
+	}
+	return res
+}
+
+// AnyPackageEnables checks if any package override turns
+// on the pass controlled by a flag.
+func (c *Config) AnyPackageEnables(flagName string) bool {
+	if c == nil {
+		return false
+	}
+	for _, pkgConfig := range c.Packages {
+		if pkgConfig.Profile != "" && profileEnables(pkgConfig.Profile, flagName) {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetConfig gets the build settings for a target.
+// Settings for "goos/goarch" take precedence over those
+// for "goos", except for tags and ldflags, which are
+// combined.
+func (c *Config) TargetConfig(target buildTarget) TargetConfig {
+	res := TargetConfig{Env: map[string]string{}}
+	if c == nil {
+		return res
+	}
+	for _, key := range []string{target.GOOS, target.String()} {
+		targetConfig, ok := c.Targets[key]
+		if !ok {
+			continue
+		}
+		for name, value := range targetConfig.Env {
+			res.Env[name] = value
+		}
+		if targetConfig.GCFlags != "" {
+			res.GCFlags = targetConfig.GCFlags
+		}
+		res.LDFlags = strings.TrimSpace(res.LDFlags + " " + targetConfig.LDFlags)
+		res.Tags = mergeTags(res.Tags, targetConfig.Tags)
+	}
+	return res
+}
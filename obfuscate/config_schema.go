@@ -0,0 +1,377 @@
+package obfuscate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A configSchema describes the JSON value expected at some
+// point of a config file.
+type configSchema struct {
+	// Kind is "object" for objects with known fields,
+	// "map" for objects with arbitrary keys, "array", or
+	// "string".
+	Kind string
+
+	Fields map[string]*configSchema
+	Elem   *configSchema
+
+	// Check validates a string value, and CheckKey
+	// validates the keys of a map.
+	Check    func(value string) error
+	CheckKey func(key string) error
+}
+
+var configFileSchema = &configSchema{
+	Kind: "object",
+	Fields: map[string]*configSchema{
+		"profile":   {Kind: "string", Check: checkProfileName},
+		"padding":   {Kind: "string"},
+		"exclude":   {Kind: "array", Elem: &configSchema{Kind: "string", Check: checkExcludePath}},
+		"platforms": {Kind: "array", Elem: &configSchema{Kind: "string", Check: checkPlatform}},
+		"ldflags":   {Kind: "string"},
+		"tags":      {Kind: "string", Check: checkTags},
+		"packages": {
+			Kind: "map",
+			Elem: &configSchema{
+				Kind: "object",
+				Fields: map[string]*configSchema{
+					"profile": {Kind: "string", Check: checkProfileName},
+				},
+			},
+		},
+		"prepare_env": {
+			Kind:     "map",
+			Elem:     &configSchema{Kind: "string"},
+			CheckKey: checkPrepareEnvKey,
+		},
+		"blocklist": {Kind: "array", Elem: &configSchema{Kind: "string", Check: checkBlockPattern}},
+		"artifact_scan": {
+			Kind: "object",
+			Fields: map[string]*configSchema{
+				"yara":     {Kind: "array", Elem: &configSchema{Kind: "string"}},
+				"commands": {Kind: "array", Elem: &configSchema{Kind: "string", Check: checkScanCommand}},
+			},
+		},
+		"targets": {
+			Kind: "map",
+			Elem: &configSchema{
+				Kind: "object",
+				Fields: map[string]*configSchema{
+					"env": {
+						Kind:     "map",
+						Elem:     &configSchema{Kind: "string"},
+						CheckKey: checkTargetEnvKey,
+					},
+					"gcflags": {Kind: "string"},
+					"ldflags": {Kind: "string"},
+					"tags":    {Kind: "string", Check: checkTags},
+				},
+			},
+			CheckKey: checkTargetKey,
+		},
+		"verify": {
+			Kind: "map",
+			Elem: &configSchema{
+				Kind: "object",
+				Fields: map[string]*configSchema{
+					"dir":     {Kind: "string"},
+					"package": {Kind: "string", Check: checkVerifyPackage},
+					"flags":   {Kind: "array", Elem: &configSchema{Kind: "string", Check: checkVerifyFlag}},
+					"command": {Kind: "string", Check: checkScanCommand},
+				},
+			},
+		},
+	},
+}
+
+func checkProfileName(name string) error {
+	_, err := profileValues(name)
+	return err
+}
+
+func checkPrepareEnvKey(key string) error {
+	if !strings.HasPrefix(key, "GO") {
+		return fmt.Errorf("not a Go environment variable: %s", key)
+	}
+	return nil
+}
+
+func checkVerifyPackage(value string) error {
+	if value == "" {
+		return fmt.Errorf("empty package")
+	}
+	return nil
+}
+
+func checkVerifyFlag(value string) error {
+	if !strings.HasPrefix(value, "-") {
+		return fmt.Errorf("not a flag: %s", value)
+	}
+	return nil
+}
+
+// checkTargetKey checks that a key of "targets" is a
+// "goos/goarch" pair or a GOOS.
+func checkTargetKey(key string) error {
+	parts := strings.Split(key, "/")
+	if !knownOS[parts[0]] || len(parts) > 2 || (len(parts) == 2 && !knownArch[parts[1]]) {
+		return fmt.Errorf("not a goos/goarch pair or a goos: %s", key)
+	}
+	return nil
+}
+
+// checkPlatform checks that a platform is a
+// "goos/goarch" pair.
+func checkPlatform(value string) error {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 || !knownOS[parts[0]] || !knownArch[parts[1]] {
+		return fmt.Errorf("not a goos/goarch pair: %s", value)
+	}
+	return nil
+}
+
+func checkExcludePath(value string) error {
+	if value == "" || strings.ContainsAny(value, " \t,") || strings.HasPrefix(value, "/") {
+		return fmt.Errorf("not an import path: %s", value)
+	}
+	return nil
+}
+
+func checkTags(value string) error {
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		for _, ch := range tag {
+			if !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && ch != '_' && ch != '.' {
+				return fmt.Errorf("invalid build tag: %s", tag)
+			}
+		}
+	}
+	return nil
+}
+
+// checkTargetEnvKey checks that a variable can be set for
+// a target. Variables which gobfuscate sets itself cannot.
+func checkTargetEnvKey(key string) error {
+	if !strings.HasPrefix(key, "GO") && !strings.HasPrefix(key, "CGO_") {
+		return fmt.Errorf("not a Go environment variable: %s", key)
+	}
+	for _, name := range buildEnvVars {
+		if key == name {
+			return fmt.Errorf("%s is set by gobfuscate", key)
+		}
+	}
+	return nil
+}
+
+// A ConfigError lists the problems found in a config file,
+// each with its position.
+type ConfigError struct {
+	Problems []string
+}
+
+func (c *ConfigError) Error() string {
+	return strings.Join(c.Problems, "\n")
+}
+
+// validateConfig checks a config file against the schema,
+// reporting unknown keys, values of the wrong type, and
+// invalid values with their line and column.
+// Positions, if any, map offsets in data to the lines of
+// a YAML file which was translated to JSON.
+func validateConfig(path string, data []byte, positions []yamlPosition) error {
+	v := &configValidator{path: path, data: data, positions: positions,
+		dec: json.NewDecoder(bytes.NewReader(data))}
+	if err := v.value(configFileSchema, ""); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			v.problem(syntaxErr.Offset, "%s", syntaxErr)
+		} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+			v.problem(int64(len(data)), "unexpected end of file")
+		} else {
+			v.problem(v.dec.InputOffset(), "%s", err)
+		}
+	} else if v.dec.More() {
+		v.problem(v.dec.InputOffset(), "unexpected data after the config")
+	}
+	if len(v.problems) > 0 {
+		return &ConfigError{Problems: v.problems}
+	}
+	return nil
+}
+
+type configValidator struct {
+	path      string
+	data      []byte
+	positions []yamlPosition
+	dec       *json.Decoder
+	problems  []string
+}
+
+// problem records a problem at a byte offset.
+func (c *configValidator) problem(offset int64, format string, args ...interface{}) {
+	if offset > int64(len(c.data)) {
+		offset = int64(len(c.data))
+	}
+	var line, column int
+	if c.positions != nil {
+		line, column = yamlLocation(c.positions, offset)
+	} else {
+		before := c.data[:offset]
+		line = bytes.Count(before, []byte("\n")) + 1
+		column = int(offset) - bytes.LastIndexByte(before, '\n')
+	}
+	c.problems = append(c.problems, fmt.Sprintf("%s:%d:%d: %s", c.path, line, column,
+		fmt.Sprintf(format, args...)))
+}
+
+// value validates the next value in the file.
+// It only returns an error if the file cannot be read any
+// further.
+func (c *configValidator) value(schema *configSchema, name string) error {
+	start := c.dec.InputOffset()
+	token, err := c.dec.Token()
+	if err != nil {
+		return err
+	}
+	// The offset before a token may point at the
+	// whitespace and separators in front of it.
+	for int(start) < len(c.data) && strings.IndexByte(" \t\r\n:,", c.data[start]) >= 0 {
+		start++
+	}
+	display := name
+	if display == "" {
+		display = "config"
+	}
+
+	switch schema.Kind {
+	case "string":
+		str, ok := token.(string)
+		if !ok {
+			c.problem(start, "%s should be a string, not %s", display, jsonKind(token))
+			return c.skip(token)
+		}
+		if schema.Check != nil {
+			if err := schema.Check(str); err != nil {
+				c.problem(start, "invalid %s: %s", display, err)
+			}
+		}
+		return nil
+	case "array":
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			c.problem(start, "%s should be an array, not %s", display, jsonKind(token))
+			return c.skip(token)
+		}
+		for i := 0; c.dec.More(); i++ {
+			if err := c.value(schema.Elem, fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return err
+			}
+		}
+		_, err := c.dec.Token()
+		return err
+	case "object", "map":
+		if delim, ok := token.(json.Delim); !ok || delim != '{' {
+			c.problem(start, "%s should be an object, not %s", display, jsonKind(token))
+			return c.skip(token)
+		}
+		for c.dec.More() {
+			keyStart := c.dec.InputOffset()
+			keyToken, err := c.dec.Token()
+			if err != nil {
+				return err
+			}
+			for int(keyStart) < len(c.data) && strings.IndexByte(" \t\r\n,", c.data[keyStart]) >= 0 {
+				keyStart++
+			}
+			key := keyToken.(string)
+			fieldName := key
+			if name != "" {
+				fieldName = name + "." + key
+			}
+			fieldSchema := schema.Elem
+			if schema.CheckKey != nil {
+				if err := schema.CheckKey(key); err != nil {
+					c.problem(keyStart, "invalid key in %s: %s", display, err)
+				}
+			}
+			if schema.Kind == "object" {
+				fieldSchema = schema.Fields[key]
+				if fieldSchema == nil {
+					c.problem(keyStart, "unknown key %q in %s (expected one of: %s)", key, display,
+						strings.Join(schemaFields(schema), ", "))
+					if err := c.skip(nil); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := c.value(fieldSchema, fieldName); err != nil {
+				return err
+			}
+		}
+		_, err := c.dec.Token()
+		return err
+	}
+	return nil
+}
+
+// skip skips the rest of a value whose first token has
+// been read, or the whole next value if token is nil.
+func (c *configValidator) skip(token json.Token) error {
+	if token == nil {
+		var err error
+		token, err = c.dec.Token()
+		if err != nil {
+			return err
+		}
+	}
+	delim, ok := token.(json.Delim)
+	if !ok || (delim != '{' && delim != '[') {
+		return nil
+	}
+	depth := 1
+	for depth > 0 {
+		token, err := c.dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+	}
+	return nil
+}
+
+func schemaFields(schema *configSchema) []string {
+	var res []string
+	for name := range schema.Fields {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// jsonKind describes the kind of value a token starts.
+func jsonKind(token json.Token) string {
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '{' {
+			return "an object"
+		}
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
@@ -0,0 +1,410 @@
+package obfuscate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yamlNumber matches the plain YAML scalars which are
+// also JSON numbers. Other numbers are read as strings.
+var yamlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// A yamlPosition maps an offset in the JSON translation
+// of a YAML file to the line and column of the YAML value.
+type yamlPosition struct {
+	Offset int64
+	Line   int
+	Column int
+}
+
+// yamlToJSON translates a config file written in YAML to
+// JSON, so that it is validated and decoded like a JSON
+// config file.
+// Only the subset of YAML which config files need is
+// supported: block mappings and sequences (including
+// sequences of mappings), flow mappings
+// and sequences on a single line, plain and quoted
+// scalars, and comments.
+// The positions map offsets in the JSON back to the YAML.
+func yamlToJSON(path string, data []byte) ([]byte, []yamlPosition, error) {
+	t := &yamlTranslator{path: path}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(text, " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || (i == 0 && text == "---") {
+			continue
+		}
+		indent := len(text) - len(trimmed)
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, nil, t.errorf(i+1, indent+1, "tabs cannot indent YAML")
+		}
+		t.lines = append(t.lines, yamlLine{Num: i + 1, Indent: indent, Text: text})
+	}
+	if len(t.lines) == 0 {
+		t.mark(1, 1)
+		t.out.WriteString("{}")
+		return t.out.Bytes(), t.positions, nil
+	}
+	if err := t.block(t.lines[0].Indent); err != nil {
+		return nil, nil, err
+	}
+	if t.index < len(t.lines) {
+		line := t.lines[t.index]
+		return nil, nil, t.errorf(line.Num, line.Indent+1, "unexpected indentation")
+	}
+	return t.out.Bytes(), t.positions, nil
+}
+
+type yamlLine struct {
+	Num    int
+	Indent int
+	Text   string
+}
+
+type yamlTranslator struct {
+	path      string
+	lines     []yamlLine
+	index     int
+	out       bytes.Buffer
+	positions []yamlPosition
+}
+
+func (y *yamlTranslator) errorf(line, column int, format string, args ...interface{}) error {
+	return &ConfigError{Problems: []string{fmt.Sprintf("%s:%d:%d: %s", y.path, line, column,
+		fmt.Sprintf(format, args...))}}
+}
+
+// mark records the position of the next JSON token.
+func (y *yamlTranslator) mark(line, column int) {
+	y.positions = append(y.positions, yamlPosition{Offset: int64(y.out.Len()), Line: line, Column: column})
+}
+
+// block translates the mapping or sequence whose entries
+// start at an indentation.
+func (y *yamlTranslator) block(indent int) error {
+	first := y.lines[y.index]
+	y.mark(first.Num, first.Indent+1)
+	if isSequenceEntry(first.Text[indent:]) {
+		y.out.WriteByte('[')
+		for i := 0; y.index < len(y.lines); i++ {
+			line := y.lines[y.index]
+			if line.Indent != indent || !isSequenceEntry(line.Text[indent:]) {
+				break
+			}
+			if i > 0 {
+				y.out.WriteByte(',')
+			}
+			y.index++
+			s := &yamlScanner{y: y, line: line.Num, text: line.Text, pos: indent + 1}
+			s.skipSpaces()
+			if s.atEnd() {
+				if err := y.nested(indent, false, s); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := s.key(); err == nil {
+				// The entry is a mapping whose first key is on the
+				// dash's line, and whose other keys line up with it.
+				// The dash is blanked out, so that the line reads as
+				// the first line of the mapping.
+				y.index--
+				y.lines[y.index] = yamlLine{
+					Num:    line.Num,
+					Indent: s.keyStart,
+					Text:   line.Text[:indent] + " " + line.Text[indent+1:],
+				}
+				if err := y.block(s.keyStart); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.value(false); err != nil {
+				return err
+			}
+			if err := s.end(); err != nil {
+				return err
+			}
+		}
+		y.out.WriteByte(']')
+		return nil
+	}
+
+	y.out.WriteByte('{')
+	for i := 0; y.index < len(y.lines); i++ {
+		line := y.lines[y.index]
+		if line.Indent != indent || isSequenceEntry(line.Text[indent:]) {
+			break
+		}
+		if i > 0 {
+			y.out.WriteByte(',')
+		}
+		y.index++
+		s := &yamlScanner{y: y, line: line.Num, text: line.Text, pos: indent}
+		key, err := s.key()
+		if err != nil {
+			return err
+		}
+		s.mark(s.keyStart)
+		encoded, _ := json.Marshal(key)
+		y.out.Write(encoded)
+		y.out.WriteByte(':')
+		s.skipSpaces()
+		if s.atEnd() {
+			if err := y.nested(indent, true, s); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.value(false); err != nil {
+			return err
+		}
+		if err := s.end(); err != nil {
+			return err
+		}
+	}
+	y.out.WriteByte('}')
+	return nil
+}
+
+// nested translates the value of an entry whose line ends
+// after the key or dash: the block indented under it, or
+// null. The entries of a sequence under a key may have
+// the same indentation as the key.
+func (y *yamlTranslator) nested(indent int, inMapping bool, s *yamlScanner) error {
+	if y.index < len(y.lines) {
+		next := y.lines[y.index]
+		if next.Indent > indent ||
+			(inMapping && next.Indent == indent && isSequenceEntry(next.Text[indent:])) {
+			return y.block(next.Indent)
+		}
+	}
+	s.mark(s.pos)
+	y.out.WriteString("null")
+	return nil
+}
+
+func isSequenceEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// A yamlScanner reads the values on a line.
+type yamlScanner struct {
+	y        *yamlTranslator
+	line     int
+	text     string
+	pos      int
+	keyStart int
+}
+
+func (s *yamlScanner) errorf(format string, args ...interface{}) error {
+	return s.y.errorf(s.line, s.pos+1, format, args...)
+}
+
+func (s *yamlScanner) mark(pos int) {
+	s.y.mark(s.line, pos+1)
+}
+
+func (s *yamlScanner) skipSpaces() {
+	for s.pos < len(s.text) && s.text[s.pos] == ' ' {
+		s.pos++
+	}
+}
+
+// atEnd checks if the rest of the line is empty or a
+// comment.
+func (s *yamlScanner) atEnd() bool {
+	return s.pos == len(s.text) || (s.text[s.pos] == '#' && (s.pos == 0 || s.text[s.pos-1] == ' '))
+}
+
+// end checks that nothing but a comment follows a value.
+func (s *yamlScanner) end() error {
+	s.skipSpaces()
+	if !s.atEnd() {
+		return s.errorf("unexpected %q after value", s.text[s.pos:])
+	}
+	return nil
+}
+
+// key reads a mapping key and the colon after it.
+// If there is no key, the position is left unchanged.
+func (s *yamlScanner) key() (string, error) {
+	start := s.pos
+	s.keyStart = start
+	var key string
+	if s.pos < len(s.text) && (s.text[s.pos] == '"' || s.text[s.pos] == '\'') {
+		var err error
+		key, err = s.quoted()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		idx := strings.Index(s.text[s.pos:]+" ", ": ")
+		if idx <= 0 {
+			return "", s.errorf("expected a key")
+		}
+		key = s.text[s.pos : s.pos+idx]
+		s.pos += idx
+	}
+	if s.pos >= len(s.text) || s.text[s.pos] != ':' || (s.pos+1 < len(s.text) && s.text[s.pos+1] != ' ') {
+		s.pos = start
+		return "", s.errorf("expected a key")
+	}
+	s.pos++
+	return key, nil
+}
+
+// value translates a scalar or a flow collection.
+func (s *yamlScanner) value(inFlow bool) error {
+	if s.pos == len(s.text) {
+		return s.errorf("unexpected end of line")
+	}
+	s.mark(s.pos)
+	switch s.text[s.pos] {
+	case '[':
+		s.y.out.WriteByte('[')
+		s.pos++
+		return s.flow(']', func() error {
+			return s.value(true)
+		})
+	case '{':
+		s.y.out.WriteByte('{')
+		s.pos++
+		return s.flow('}', func() error {
+			start := s.pos
+			key, err := s.flowKey()
+			if err != nil {
+				return err
+			}
+			s.mark(start)
+			encoded, _ := json.Marshal(key)
+			s.y.out.Write(encoded)
+			s.y.out.WriteByte(':')
+			s.skipSpaces()
+			return s.value(true)
+		})
+	case '"', '\'':
+		str, err := s.quoted()
+		if err != nil {
+			return err
+		}
+		encoded, _ := json.Marshal(str)
+		s.y.out.Write(encoded)
+		return nil
+	case '&', '*', '!', '|', '>', '%', '@', '`':
+		return s.errorf("unsupported YAML syntax %q", s.text[s.pos])
+	}
+	start := s.pos
+	for s.pos < len(s.text) && !s.atEnd() && !(inFlow && strings.IndexByte(",]}", s.text[s.pos]) >= 0) {
+		s.pos++
+	}
+	plain := strings.TrimRight(s.text[start:s.pos], " ")
+	switch {
+	case plain == "" && inFlow:
+		return s.errorf("missing value")
+	case plain == "null" || plain == "Null" || plain == "NULL" || plain == "~":
+		s.y.out.WriteString("null")
+	case plain == "true" || plain == "True" || plain == "TRUE":
+		s.y.out.WriteString("true")
+	case plain == "false" || plain == "False" || plain == "FALSE":
+		s.y.out.WriteString("false")
+	case yamlNumber.MatchString(plain):
+		s.y.out.WriteString(plain)
+	default:
+		encoded, _ := json.Marshal(plain)
+		s.y.out.Write(encoded)
+	}
+	return nil
+}
+
+// flow translates the entries of a flow collection up to
+// its closing bracket, which must be on the same line.
+func (s *yamlScanner) flow(closing byte, entry func() error) error {
+	for i := 0; ; i++ {
+		s.skipSpaces()
+		if s.pos == len(s.text) || s.atEnd() {
+			return s.errorf("missing %q (flow collections must fit on one line)", closing)
+		} else if s.text[s.pos] == closing {
+			s.pos++
+			s.y.out.WriteByte(closing)
+			return nil
+		}
+		if i > 0 {
+			if s.text[s.pos] != ',' {
+				return s.errorf("expected ',' or %q", closing)
+			}
+			s.pos++
+			s.skipSpaces()
+			s.y.out.WriteByte(',')
+		}
+		if err := entry(); err != nil {
+			return err
+		}
+	}
+}
+
+// flowKey reads a key in a flow mapping and the colon
+// after it.
+func (s *yamlScanner) flowKey() (string, error) {
+	if s.pos < len(s.text) && (s.text[s.pos] == '"' || s.text[s.pos] == '\'') {
+		key, err := s.quoted()
+		if err != nil {
+			return "", err
+		}
+		if s.pos >= len(s.text) || s.text[s.pos] != ':' {
+			return "", s.errorf("expected ':'")
+		}
+		s.pos++
+		return key, nil
+	}
+	idx := strings.Index(s.text[s.pos:], ": ")
+	if idx <= 0 || strings.ContainsAny(s.text[s.pos:s.pos+idx], ",]}") {
+		return "", s.errorf("expected a key")
+	}
+	key := s.text[s.pos : s.pos+idx]
+	s.pos += idx + 1
+	return key, nil
+}
+
+// quoted reads a single or double quoted scalar.
+func (s *yamlScanner) quoted() (string, error) {
+	quote := s.text[s.pos]
+	start := s.pos
+	for i := s.pos + 1; i < len(s.text); i++ {
+		if quote == '\'' && s.text[i] == '\'' {
+			if i+1 < len(s.text) && s.text[i+1] == '\'' {
+				i++
+				continue
+			}
+			s.pos = i + 1
+			return strings.Replace(s.text[start+1:i], "''", "'", -1), nil
+		} else if quote == '"' && s.text[i] == '\\' {
+			i++
+		} else if quote == '"' && s.text[i] == '"' {
+			str, err := strconv.Unquote(s.text[start : i+1])
+			if err != nil {
+				return "", s.errorf("invalid quoted string: %s", err)
+			}
+			s.pos = i + 1
+			return str, nil
+		}
+	}
+	return "", s.errorf("unterminated quoted string")
+}
+
+// yamlLocation finds the line and column in a YAML file of
+// an offset in its JSON translation.
+func yamlLocation(positions []yamlPosition, offset int64) (int, int) {
+	line, column := 1, 1
+	for _, pos := range positions {
+		if pos.Offset > offset {
+			break
+		}
+		line, column = pos.Line, pos.Column
+	}
+	return line, column
+}
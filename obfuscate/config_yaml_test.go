@@ -0,0 +1,62 @@
+package obfuscate
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLSequencesOfMappings(t *testing.T) {
+	data := "targets:\n" +
+		"  - platform: linux/amd64 # the default\n" +
+		"    env:\n" +
+		"      GOAMD64: v3\n" +
+		"    tags: [netgo, osusergo]\n" +
+		"  - platform: windows/arm64\n" +
+		"    ldflags: \"-H windowsgui\"\n" +
+		"    steps:\n" +
+		"    - name: sign\n" +
+		"      args:\n" +
+		"        - -v\n" +
+		"    -\n" +
+		"      name: pack\n" +
+		"  - darwin/arm64\n" +
+		"profile: light\n"
+	translated, positions, err := yamlToJSON("gobfuscate.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{}
+	if err := json.Unmarshal(translated, &actual); err != nil {
+		t.Fatalf("invalid JSON %s: %s", translated, err)
+	}
+	var expected interface{}
+	json.Unmarshal([]byte(`{
+		"targets": [
+			{"platform": "linux/amd64", "env": {"GOAMD64": "v3"}, "tags": ["netgo", "osusergo"]},
+			{"platform": "windows/arm64", "ldflags": "-H windowsgui",
+				"steps": [{"name": "sign", "args": ["-v"]}, {"name": "pack"}]},
+			"darwin/arm64"
+		],
+		"profile": "light"
+	}`), &expected)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+
+	// Values in the mappings map back to their lines.
+	offset := strings.Index(string(translated), `"windows/arm64"`)
+	if line, column := yamlLocation(positions, int64(offset)); line != 6 || column != 15 {
+		t.Errorf("expected windows/arm64 at 6:15 but got %d:%d", line, column)
+	}
+
+	for _, bad := range []string{
+		"targets:\n  - platform: linux\n   env: {}\n",
+		"targets:\n  - platform: linux\n      env: {}\n",
+	} {
+		if _, _, err := yamlToJSON("gobfuscate.yaml", []byte(bad)); err == nil {
+			t.Errorf("misaligned mapping is accepted: %q", bad)
+		}
+	}
+}
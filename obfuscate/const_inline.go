@@ -0,0 +1,470 @@
+package obfuscate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InlineConstants replaces references to the exported
+// constants of packages with the constants' values, and
+// then removes the constant declarations which are no
+// longer referenced.
+//
+// Only constants of packages for which inModule returns
+// true are inlined. Constants are skipped if they are
+// declared in files with build constraints, or if their
+// type comes from another package.
+func InlineConstants(gopath string, inModule func(string) bool) error {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return err
+	}
+
+	inlined := map[string]map[string]*inlineConst{}
+	edits := map[string][]fileEdit{}
+	for _, pkg := range pkgs {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		if !inModule(pkg) || containsUnsupportedCode(dir) {
+			continue
+		}
+		consts, err := inlinableConstants(pkg, dir, edits)
+		if err != nil {
+			return fmt.Errorf("check %s: %s", pkg, err)
+		}
+		if len(consts) > 0 {
+			inlined[pkg] = consts
+		}
+	}
+	if len(inlined) == 0 {
+		return nil
+	}
+	pkgNames := map[string]string{}
+	for pkg := range inlined {
+		pkgNames[pkg], err = packageName(filepath.Join(srcDir, filepath.FromSlash(pkg)))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pkg := range pkgs {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		err := forEachGoFile(dir, func(path string, file *ast.File, set *token.FileSet) error {
+			for alias, constPkg := range importAliases(file, pkgNames) {
+				ast.Inspect(file, func(n ast.Node) bool {
+					sel, ok := n.(*ast.SelectorExpr)
+					if !ok {
+						return true
+					}
+					x, ok := sel.X.(*ast.Ident)
+					if !ok || x.Obj != nil || x.Name != alias {
+						return true
+					}
+					if c, ok := inlined[constPkg][sel.Sel.Name]; ok {
+						edits[path] = append(edits[path], fileEdit{
+							Start: set.Position(sel.Pos()).Offset,
+							End:   set.Position(sel.End()).Offset,
+							Text:  c.Expr(alias + "."),
+						})
+					}
+					return true
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for path, fileEdits := range edits {
+		if err := applyFileEdits(path, fileEdits); err != nil {
+			return err
+		}
+	}
+
+	return dropInlinedConstants(srcDir, pkgs, inlined, pkgNames)
+}
+
+// An inlineConst is a constant which can be replaced with
+// its value.
+type inlineConst struct {
+	// Literal is the constant's value as Go code.
+	Literal string
+
+	// Type is the name of the constant's type, or "" if it
+	// is untyped.
+	Type string
+
+	// LocalType is true if Type is declared in the same
+	// package as the constant.
+	LocalType bool
+}
+
+// Expr generates an expression for the constant.
+// The qualifier is prepended to local types.
+func (i *inlineConst) Expr(qualifier string) string {
+	if i.Type == "" {
+		return i.Literal
+	} else if i.LocalType {
+		return qualifier + i.Type + "(" + i.Literal + ")"
+	}
+	return i.Type + "(" + i.Literal + ")"
+}
+
+// A fileEdit replaces a range of bytes in a file.
+type fileEdit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// inlinableConstants type-checks the files of a package
+// which have no build constraints, and finds its exported
+// constants which can be inlined.
+// Edits to inline the constants within the package are
+// added to edits.
+func inlinableConstants(pkg, dir string, edits map[string][]fileEdit) (map[string]*inlineConst, error) {
+	set := token.NewFileSet()
+	var files []*ast.File
+	var paths []string
+	kept := map[*ast.Ident]bool{}
+	err := forEachGoFile(dir, func(path string, file *ast.File, fileSet *token.FileSet) error {
+		if hasBuildConstraints(path, file) {
+			return nil
+		}
+		parsed, err := parser.ParseFile(set, path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		// Constants which keep their names stay declared
+		// and referenced.
+		for _, decl := range parsed.Decls {
+			if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.CONST {
+				for _, spec := range d.Specs {
+					spec := spec.(*ast.ValueSpec)
+					if keepsName(parsed, d.Doc, spec.Doc, spec.Comment) {
+						for _, name := range spec.Names {
+							kept[name] = true
+						}
+					}
+				}
+			}
+		}
+		files = append(files, parsed)
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	conf := types.Config{
+		Importer: noImporter{},
+		Error:    func(error) {},
+	}
+	info := &types.Info{
+		Defs: map[*ast.Ident]types.Object{},
+		Uses: map[*ast.Ident]types.Object{},
+	}
+	typesPkg, _ := conf.Check(pkg, set, files, info)
+	if typesPkg == nil {
+		return nil, nil
+	}
+
+	res := map[string]*inlineConst{}
+	objs := map[types.Object]*inlineConst{}
+	for ident, obj := range info.Defs {
+		c, ok := obj.(*types.Const)
+		if !ok || !ident.IsExported() || c.Parent() != typesPkg.Scope() || kept[ident] {
+			continue
+		}
+		if inline := newInlineConst(c, typesPkg); inline != nil {
+			res[ident.Name] = inline
+			objs[obj] = inline
+		}
+	}
+	for ident, obj := range info.Uses {
+		if inline, ok := objs[obj]; ok {
+			path := set.Position(ident.Pos()).Filename
+			edits[path] = append(edits[path], fileEdit{
+				Start: set.Position(ident.Pos()).Offset,
+				End:   set.Position(ident.End()).Offset,
+				Text:  inline.Expr(""),
+			})
+		}
+	}
+	return res, nil
+}
+
+func newInlineConst(c *types.Const, pkg *types.Package) *inlineConst {
+	basic, ok := c.Type().Underlying().(*types.Basic)
+	if !ok || basic.Kind() == types.Invalid || basic.Kind() == types.UntypedRune {
+		return nil
+	}
+	literal, ok := constLiteral(c.Val())
+	if !ok {
+		return nil
+	}
+	res := &inlineConst{Literal: literal}
+	switch t := c.Type().(type) {
+	case *types.Basic:
+		if t.Info()&types.IsUntyped == 0 {
+			res.Type = t.Name()
+		}
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != pkg || obj.Parent() != pkg.Scope() || !obj.Exported() {
+			return nil
+		}
+		res.Type = obj.Name()
+		res.LocalType = true
+	default:
+		return nil
+	}
+	return res
+}
+
+// constLiteral formats a constant value as Go code which
+// evaluates to the exact same untyped constant.
+func constLiteral(val constant.Value) (string, bool) {
+	switch val.Kind() {
+	case constant.Bool:
+		return val.String(), true
+	case constant.String:
+		return strconv.Quote(constant.StringVal(val)), true
+	case constant.Int:
+		s := val.ExactString()
+		if strings.HasPrefix(s, "-") {
+			s = "(" + s + ")"
+		}
+		return s, true
+	case constant.Float:
+		num, denom := constant.Num(val), constant.Denom(val)
+		if num.Kind() != constant.Int || denom.Kind() != constant.Int {
+			return "", false
+		}
+		return "(" + num.ExactString() + ".0/" + denom.ExactString() + ")", true
+	}
+	return "", false
+}
+
+// dropInlinedConstants removes the declarations of inlined
+// constants which are not referenced anymore.
+// A declaration is only removed if every constant in it
+// was inlined.
+func dropInlinedConstants(srcDir string, pkgs []string, inlined map[string]map[string]*inlineConst,
+	pkgNames map[string]string) error {
+	// Count the references left in other packages.
+	remaining := map[string]map[string]bool{}
+	for pkg := range inlined {
+		remaining[pkg] = map[string]bool{}
+	}
+	for _, pkg := range pkgs {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		err := forEachGoFile(dir, func(path string, file *ast.File, set *token.FileSet) error {
+			for _, spec := range file.Imports {
+				importPath, _ := strconv.Unquote(spec.Path.Value)
+				if _, ok := inlined[importPath]; ok && spec.Name != nil && spec.Name.Name == "." {
+					// We cannot tell which names come from a
+					// dot import, so keep all of them.
+					delete(inlined, importPath)
+				}
+			}
+			for alias, constPkg := range importAliases(file, pkgNames) {
+				ast.Inspect(file, func(n ast.Node) bool {
+					if sel, ok := n.(*ast.SelectorExpr); ok {
+						if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil && x.Name == alias {
+							remaining[constPkg][sel.Sel.Name] = true
+						}
+					}
+					return true
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for pkg, consts := range inlined {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+
+		// Count the references left in the package itself.
+		var decls []*ast.GenDecl
+		declPaths := map[*ast.GenDecl]string{}
+		declSets := map[*ast.GenDecl]*token.FileSet{}
+		err := forEachGoFile(dir, func(path string, file *ast.File, set *token.FileSet) error {
+			declared := map[*ast.Ident]bool{}
+			for _, decl := range file.Decls {
+				d, ok := decl.(*ast.GenDecl)
+				if !ok || d.Tok != token.CONST {
+					continue
+				}
+				for _, spec := range d.Specs {
+					for _, name := range spec.(*ast.ValueSpec).Names {
+						declared[name] = true
+					}
+				}
+				decls = append(decls, d)
+				declPaths[d] = path
+				declSets[d] = set
+			}
+			ast.Inspect(file, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.SelectorExpr:
+					ast.Inspect(n.X, func(n ast.Node) bool {
+						if ident, ok := n.(*ast.Ident); ok && !declared[ident] {
+							remaining[pkg][ident.Name] = true
+						}
+						return true
+					})
+					return false
+				case *ast.Ident:
+					if !declared[n] {
+						remaining[pkg][n.Name] = true
+					}
+				}
+				return true
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		edits := map[string][]fileEdit{}
+	DeclLoop:
+		for _, d := range decls {
+			for _, spec := range d.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if _, ok := consts[name.Name]; !ok || remaining[pkg][name.Name] {
+						continue DeclLoop
+					}
+				}
+			}
+			path, set := declPaths[d], declSets[d]
+			start := d.Pos()
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			edits[path] = append(edits[path], fileEdit{
+				Start: set.Position(start).Offset,
+				End:   set.Position(d.End()).Offset,
+			})
+		}
+		for path, fileEdits := range edits {
+			if err := applyFileEdits(path, fileEdits); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importAliases maps the local names of a file's imports
+// to their import paths, for the imports of packages in
+// pkgNames (which maps import paths to package names).
+func importAliases(file *ast.File, pkgNames map[string]string) map[string]string {
+	res := map[string]string{}
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name, ok := pkgNames[importPath]
+		if !ok {
+			continue
+		}
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name != "_" && name != "." {
+			res[name] = importPath
+		}
+	}
+	return res
+}
+
+// hasBuildConstraints checks if a file is only built for
+// some configurations.
+func hasBuildConstraints(path string, file *ast.File) bool {
+	if goFileSuffix(filepath.Base(path)) != ".go" {
+		return true
+	}
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "//go:build") || strings.HasPrefix(comment.Text, "// +build") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forEachGoFile parses every Go file in a directory, with
+// comments.
+func forEachGoFile(dir string, f func(path string, file *ast.File, set *token.FileSet) error) error {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, item.Name())
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		if err := f(path, file, set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyFileEdits applies non-overlapping edits to a file.
+func applyFileEdits(path string, edits []fileEdit) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].Start < edits[j].Start
+	})
+	return rewriteFile(path, func(w *bufio.Writer) error {
+		var lastIdx int
+		for _, e := range edits {
+			if e.Start < lastIdx {
+				return errors.New("overlapping edits in " + path)
+			}
+			w.Write(contents[lastIdx:e.Start])
+			w.WriteString(e.Text)
+			lastIdx = e.End
+		}
+		_, err := w.Write(contents[lastIdx:])
+		return err
+	})
+}
+
+// noImporter fails to import every package, which lets a
+// package be type-checked on its own.
+type noImporter struct{}
+
+func (noImporter) Import(path string) (*types.Package, error) {
+	return nil, errors.New("imports are not resolved")
+}
@@ -0,0 +1,273 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// stringConstsToVar turns the string constant
+// declarations in a file's contents into variables,
+// writing the new contents to out.
+//
+// Sensitive strings (see sensitiveKind) and strings with
+// blocklist patterns in top-level blocks which also
+// declare other constants are moved to variable
+// declarations of their own, as long as nothing needs
+// them to be constant.
+func stringConstsToVar(path string, contents []byte, out *bytes.Buffer) {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, contents, parser.ParseComments)
+	if err != nil {
+		// If the file is invalid, we do nothing.
+		out.Write(contents)
+		return
+	}
+
+	skipped := findSkippedCode(file)
+	ctv := &constToVar{}
+	for _, decl := range file.Decls {
+		if !skipped.Contains(decl) {
+			ast.Walk(ctv, decl)
+		}
+	}
+	extracted := sensitiveConstSpecs(path, file)
+	for _, decl := range file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || len(extracted[d]) == 0 || skipped.Contains(d) {
+			continue
+		}
+		ctv.Decls = append(ctv.Decls, d)
+	}
+	sort.Sort(ctv)
+
+	var lastIdx int
+	for _, decl := range ctv.Decls {
+		start := int(decl.Pos() - 1)
+		end := int(decl.End() - 1)
+		out.Write(contents[lastIdx:start])
+		lastIdx = end
+		if specs := extracted[decl]; len(specs) > 0 {
+			writeExtractedSpecs(out, contents, decl, specs)
+			continue
+		}
+		declData := contents[start:end]
+		idx := bytes.Index(declData, []byte("const"))
+		out.Write(declData[:idx])
+		out.WriteString("var")
+		out.Write(declData[idx+len("const"):])
+	}
+	out.Write(contents[lastIdx:])
+}
+
+// writeExtractedSpecs writes a constant declaration,
+// splitting it around some of its specs, which become
+// variable declarations.
+// Each spec stays where it was, so that positions in the
+// rest of the file do not change.
+func writeExtractedSpecs(out *bytes.Buffer, contents []byte, decl *ast.GenDecl, specs []*ast.ValueSpec) {
+	lastIdx := int(decl.Pos() - 1)
+	for _, spec := range specs {
+		start, end := int(spec.Pos()-1), int(spec.End()-1)
+		out.Write(contents[lastIdx:start])
+		out.WriteString("); var (")
+		out.Write(contents[start:end])
+		out.WriteString("); const (")
+		lastIdx = end
+	}
+	out.Write(contents[lastIdx:int(decl.End()-1)])
+}
+
+// sensitiveConstSpecs finds the specs to move out of the
+// mixed top-level constant blocks of a file.
+//
+// A spec is only moved if it declares a single string
+// constant which contains a sensitive or blocked literal, no later
+// spec in its block depends on its position (by using
+// iota or repeating an expression implicitly), and no
+// constant declaration in the package refers to it.
+func sensitiveConstSpecs(path string, file *ast.File) map[*ast.GenDecl][]*ast.ValueSpec {
+	res := map[*ast.GenDecl][]*ast.ValueSpec{}
+	var constRefs map[string]bool
+	for _, decl := range file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.CONST || !d.Lparen.IsValid() || constOnlyHasStrings(d) {
+			continue
+		}
+		for i, spec := range d.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if len(vs.Names) != 1 || len(vs.Values) != 1 || !specIsString(vs) ||
+				!containsForcedLiteral(vs.Values[0]) {
+				continue
+			}
+			if dependsOnPosition(d.Specs[i+1:]) {
+				continue
+			}
+			if constRefs == nil {
+				constRefs = constReferences(filepath.Dir(path))
+			}
+			if constRefs[vs.Names[0].Name] {
+				continue
+			}
+			res[d] = append(res[d], vs)
+		}
+	}
+	return res
+}
+
+func dependsOnPosition(specs []ast.Spec) bool {
+	for _, spec := range specs {
+		vs := spec.(*ast.ValueSpec)
+		if len(vs.Values) == 0 {
+			return true
+		}
+		for _, value := range vs.Values {
+			var usesIota bool
+			ast.Inspect(value, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok && ident.Name == "iota" {
+					usesIota = true
+				}
+				return !usesIota
+			})
+			if usesIota {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsForcedLiteral(e ast.Expr) bool {
+	var found bool
+	ast.Inspect(e, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			value, err := strconv.Unquote(lit.Value)
+			if err == nil && (sensitiveKind(value) != "" || blockedLiteral(value)) {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// constReferences finds the identifiers which are used in
+// the values of constant declarations (other than those
+// made only of strings, which become variables) in the Go
+// files of a directory.
+func constReferences(dir string) map[string]bool {
+	res := map[string]bool{}
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return res
+	}
+	for _, item := range listing {
+		if !isGoFile(item.Name()) {
+			continue
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, filepath.Join(dir, item.Name()), nil, 0)
+		if err != nil {
+			continue
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if d, ok := n.(*ast.GenDecl); ok && d.Tok == token.CONST && !constOnlyHasStrings(d) {
+				for _, spec := range d.Specs {
+					for _, value := range spec.(*ast.ValueSpec).Values {
+						ast.Inspect(value, func(n ast.Node) bool {
+							if ident, ok := n.(*ast.Ident); ok {
+								res[ident.Name] = true
+							}
+							return true
+						})
+					}
+				}
+				return false
+			} else if arr, ok := n.(*ast.ArrayType); ok && arr.Len != nil {
+				// Array lengths must be constant too.
+				ast.Inspect(arr.Len, func(n ast.Node) bool {
+					if ident, ok := n.(*ast.Ident); ok {
+						res[ident.Name] = true
+					}
+					return true
+				})
+			}
+			return true
+		})
+	}
+	return res
+}
+
+type constToVar struct {
+	Decls []*ast.GenDecl
+}
+
+func (c *constToVar) Visit(n ast.Node) ast.Visitor {
+	if decl, ok := n.(*ast.GenDecl); ok {
+		if decl.Tok == token.CONST {
+			if constOnlyHasStrings(decl) {
+				c.Decls = append(c.Decls, decl)
+			}
+		}
+	}
+	return c
+}
+
+func (c *constToVar) Len() int {
+	return len(c.Decls)
+}
+
+func (c *constToVar) Swap(i, j int) {
+	c.Decls[i], c.Decls[j] = c.Decls[j], c.Decls[i]
+}
+
+func (c *constToVar) Less(i, j int) bool {
+	return c.Decls[i].Pos() < c.Decls[j].Pos()
+}
+
+func constOnlyHasStrings(decl *ast.GenDecl) bool {
+	for _, spec := range decl.Specs {
+		if cs, ok := spec.(*ast.ValueSpec); ok {
+			if !specIsString(cs) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func specIsString(v *ast.ValueSpec) bool {
+	if v.Type != nil {
+		s, ok := v.Type.(fmt.Stringer)
+		if ok && s.String() == "string" {
+			return true
+		}
+	}
+	if len(v.Values) != 1 {
+		return false
+	}
+	return exprIsString(v.Values[0])
+}
+
+func exprIsString(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			return true
+		}
+	case *ast.BinaryExpr:
+		if e.Op == token.ADD {
+			return exprIsString(e.X) || exprIsString(e.Y)
+		}
+		return false
+	case *ast.ParenExpr:
+		return exprIsString(e.X)
+	}
+	return false
+}
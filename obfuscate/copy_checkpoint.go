@@ -0,0 +1,225 @@
+package obfuscate
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// resumeDir, if set, is where the copy of the sources is
+	// made and checkpointed, so that a run which is
+	// interrupted while copying picks up where it stopped.
+	resumeDir string
+
+	// copyTimeout, if set, stops the copy phase after this
+	// long.
+	copyTimeout time.Duration
+)
+
+// copyProgress tracks the copy phase while copyWorkspace
+// runs. Its methods do nothing on a nil checkpoint.
+var copyProgress *copyCheckpoint
+
+// A copyCheckpoint stops the copy phase cleanly between
+// packages when the run is interrupted or runs out of
+// time, and, with -resume, records the packages which were
+// copied, so that the next run skips them.
+type copyCheckpoint struct {
+	// Workspace is where the run obfuscates and builds the
+	// sources, and Dest is where they are copied to first.
+	Workspace string
+	Dest      string
+
+	deadline time.Time
+	signals  chan os.Signal
+	release  func()
+	stopped  error
+
+	// previous maps the import paths of packages copied by
+	// earlier runs to fingerprints of their sources.
+	previous map[string]string
+	journal  *os.File
+	copied   int
+}
+
+// resumeKey fingerprints the settings which decide what is
+// copied. A checkpoint made with other settings is
+// discarded.
+func resumeKey(pkgName string) string {
+	wd, _ := currentDir()
+	hash := sha256.New()
+	fmt.Fprintln(hash, pkgName, strings.Join(extraPackages, " "), wd, sourceContext().GOPATH)
+	fmt.Fprintln(hash, useModules, keepTests, tags, os.Getenv("GOFLAGS"), strings.Join(prepEnvFlags, " "))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// startCopy starts watching for interrupts and the
+// -copytimeout, and opens the -resume checkpoint, if any.
+func startCopy(pkgName, workspace, dest string) (*copyCheckpoint, error) {
+	res := &copyCheckpoint{Workspace: workspace, Dest: dest, signals: make(chan os.Signal, 1)}
+	if copyTimeout > 0 {
+		res.deadline = time.Now().Add(copyTimeout)
+	}
+	if resumeDir != "" {
+		if err := res.openJournal(resumeKey(pkgName)); err != nil {
+			return nil, err
+		}
+	}
+	res.release = catchInterrupts(res.signals)
+	return res, nil
+}
+
+// openJournal reads the packages recorded in the -resume
+// directory's journal, and opens it to record more. The
+// journal starts with the settings key, followed by a line
+// for every package copied.
+func (c *copyCheckpoint) openJournal(key string) error {
+	path := filepath.Join(resumeDir, "copied")
+	c.previous = map[string]string{}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		if !scanner.Scan() || scanner.Text() != "key "+key {
+			log.Println("Starting the copy over, since the settings changed")
+		} else {
+			for scanner.Scan() {
+				// A line cut short by a crash has no package.
+				fields := strings.Fields(scanner.Text())
+				if len(fields) == 2 {
+					c.previous[fields[1]] = fields[0]
+				}
+			}
+		}
+		f.Close()
+	}
+	if len(c.previous) > 0 {
+		log.Println("Resuming the copy:", len(c.previous), "packages were already copied")
+	} else {
+		if err := removeTempDir(filepath.Join(resumeDir, "gopath")); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte("key "+key+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	var err error
+	c.journal, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// Close stops watching for interrupts and closes the
+// journal.
+func (c *copyCheckpoint) Close() {
+	if c == nil {
+		return
+	}
+	c.release()
+	if c.journal != nil {
+		c.journal.Close()
+	}
+}
+
+// Check returns an error once the copy phase should stop.
+// After the first interrupt, interrupts are no longer
+// caught, so that a second one stops the run right away.
+func (c *copyCheckpoint) Check() error {
+	if c == nil || c.stopped != nil {
+		return c.stopErr()
+	}
+	select {
+	case sig := <-c.signals:
+		c.release()
+		log.Println("Stopping the copy after", sig, "(repeat to stop right away)")
+		c.stopped = fmt.Errorf("stopped by %s", sig)
+	default:
+		if !c.deadline.IsZero() && time.Now().After(c.deadline) {
+			c.stopped = fmt.Errorf("stopped after the -copytimeout of %s", copyTimeout)
+		}
+	}
+	return c.stopErr()
+}
+
+func (c *copyCheckpoint) stopErr() error {
+	if c == nil || c.stopped == nil {
+		return nil
+	} else if c.journal == nil {
+		return c.stopped
+	}
+	return fmt.Errorf("%s, with %d packages copied; run again with -resume %s to continue",
+		c.stopped, c.copied, resumeDir)
+}
+
+// Copied checks if an earlier run copied a package, and
+// its sources have not changed since. Packages which were
+// copied, but removed as unused, are copied again.
+func (c *copyCheckpoint) Copied(pkg *build.Package) bool {
+	if c == nil || c.previous[pkg.ImportPath] == "" {
+		return false
+	}
+	if c.previous[pkg.ImportPath] != copyFingerprint(pkg) {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(resumeDir, "gopath", "src", pkg.ImportPath)); err != nil {
+		return false
+	}
+	c.copied++
+	return true
+}
+
+// Record adds a package which was copied to the journal.
+func (c *copyCheckpoint) Record(pkg *build.Package) error {
+	if c == nil {
+		return nil
+	}
+	c.copied++
+	if c.journal == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(c.journal, copyFingerprint(pkg), pkg.ImportPath)
+	return err
+}
+
+// copyFingerprint fingerprints the names, sizes and
+// modification times of the files of a package, which is
+// much faster than reading them.
+func copyFingerprint(pkg *build.Package) string {
+	hash := sha256.New()
+	for _, list := range depFiles(pkg, true) {
+		for _, name := range list {
+			info, err := os.Stat(filepath.Join(pkg.Dir, name))
+			if err != nil {
+				fmt.Fprintln(hash, name, "missing")
+				continue
+			}
+			fmt.Fprintln(hash, name, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// openResumeDir locks the -resume directory for the run.
+// The returned function releases it, and removes it if the
+// run succeeded, since it is only needed to finish a run.
+func openResumeDir() (func(success bool), error) {
+	if err := os.MkdirAll(resumeDir, 0755); err != nil {
+		return nil, stepError("create resume directory", err)
+	}
+	unlock, err := lockFile(filepath.Join(resumeDir, "lock"))
+	if err != nil {
+		return nil, stepError("lock resume directory", err)
+	}
+	return func(success bool) {
+		unlock()
+		if success {
+			removeTempDir(resumeDir)
+		}
+	}, nil
+}
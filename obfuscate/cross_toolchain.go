@@ -0,0 +1,83 @@
+package obfuscate
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// crossCompilers lists the usual names of C cross
+// compilers for each target, in order of preference.
+// Names with a "*" are matched against the files in PATH,
+// since osxcross puts the SDK version in its compiler names.
+var crossCompilers = map[buildTarget][]string{
+	{"linux", "amd64"}:    {"x86_64-linux-gnu-gcc", "x86_64-linux-musl-gcc"},
+	{"linux", "386"}:      {"i686-linux-gnu-gcc", "i686-linux-musl-gcc"},
+	{"linux", "arm64"}:    {"aarch64-linux-gnu-gcc", "aarch64-linux-musl-gcc"},
+	{"linux", "arm"}:      {"arm-linux-gnueabihf-gcc", "arm-linux-gnueabi-gcc", "arm-linux-musleabihf-gcc"},
+	{"linux", "riscv64"}:  {"riscv64-linux-gnu-gcc", "riscv64-linux-musl-gcc"},
+	{"linux", "ppc64le"}:  {"powerpc64le-linux-gnu-gcc"},
+	{"linux", "s390x"}:    {"s390x-linux-gnu-gcc"},
+	{"linux", "mips"}:     {"mips-linux-gnu-gcc"},
+	{"linux", "mipsle"}:   {"mipsel-linux-gnu-gcc"},
+	{"linux", "mips64"}:   {"mips64-linux-gnuabi64-gcc"},
+	{"linux", "mips64le"}: {"mips64el-linux-gnuabi64-gcc"},
+	{"linux", "loong64"}:  {"loongarch64-linux-gnu-gcc"},
+	{"windows", "amd64"}:  {"x86_64-w64-mingw32-gcc", "x86_64-w64-mingw32-clang"},
+	{"windows", "386"}:    {"i686-w64-mingw32-gcc", "i686-w64-mingw32-clang"},
+	{"windows", "arm64"}:  {"aarch64-w64-mingw32-clang", "aarch64-w64-mingw32-gcc"},
+	{"darwin", "amd64"}:   {"o64-clang", "x86_64-apple-darwin*-clang"},
+	{"darwin", "arm64"}:   {"oa64-clang", "aarch64-apple-darwin*-clang", "arm64-apple-darwin*-clang"},
+}
+
+// targetCompilers finds the C and C++ compilers for a
+// target which uses cgo.
+//
+// The CC_goos_goarch and CXX_goos_goarch environment
+// variables take precedence. Otherwise, a cross compiler
+// is looked up in PATH, unless the target is the host
+// platform, in which case the go tool's default is used.
+func targetCompilers(target buildTarget) (cc, cxx string) {
+	suffix := "_" + target.GOOS + "_" + target.GOARCH
+	cc = os.Getenv("CC" + suffix)
+	cxx = os.Getenv("CXX" + suffix)
+	if cc != "" || target == (buildTarget{build.Default.GOOS, build.Default.GOARCH}) {
+		return cc, cxx
+	}
+	cc = findCompiler(crossCompilers[target])
+	if cc != "" && cxx == "" {
+		cxx = findCompiler([]string{cxxCompilerName(cc)})
+	}
+	return cc, cxx
+}
+
+// findCompiler finds the first compiler in PATH, returning
+// its name, or "" if there is none.
+func findCompiler(names []string) string {
+	for _, name := range names {
+		if !strings.Contains(name, "*") {
+			if _, err := exec.LookPath(name); err == nil {
+				return name
+			}
+			continue
+		}
+		for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+			matches, _ := filepath.Glob(filepath.Join(dir, name))
+			if len(matches) > 0 {
+				return filepath.Base(matches[len(matches)-1])
+			}
+		}
+	}
+	return ""
+}
+
+// cxxCompilerName gets the usual name of the C++ compiler
+// which comes with a C compiler.
+func cxxCompilerName(cc string) string {
+	if strings.HasSuffix(cc, "gcc") {
+		return strings.TrimSuffix(cc, "gcc") + "g++"
+	}
+	return cc + "++"
+}
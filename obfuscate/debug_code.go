@@ -0,0 +1,108 @@
+package obfuscate
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+)
+
+// StripDebugCode removes the code marked with
+// debugDirective from the packages of a GOPATH for which
+// include returns true.
+//
+// A file is removed if the directive is in a comment
+// before its package clause, and a top-level declaration
+// or a statement is removed if the directive is on the line
+// right above it.
+func StripDebugCode(gopath string, include func(string) bool) error {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return err
+	}
+	names := newImportNames(gopath)
+	return forEachFile(files, func(f sourceFile) error {
+		return stripFileDebugCode(f.Path, names)
+	})
+}
+
+func stripFileDebugCode(path string, names *importNames) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	} else if !bytes.Contains(contents, []byte(debugDirective)) {
+		return nil
+	}
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, contents, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	marked := map[int]bool{}
+	for _, group := range file.Comments {
+		if !hasDirective(group, debugDirective) || !startsLine(contents, set.Position(group.Pos()).Offset) {
+			// Comments after code on the same line mark
+			// nothing.
+			continue
+		}
+		if group.End() < file.Package {
+			return os.Remove(path)
+		}
+		marked[set.Position(group.End()).Line+1] = true
+	}
+	isMarked := func(n ast.Node) bool {
+		return marked[set.Position(n.Pos()).Line]
+	}
+
+	var edits []fileEdit
+	removed := map[ast.Node]bool{}
+	remove := func(n ast.Node, text string) {
+		removed[n] = true
+		edits = append(edits, fileEdit{
+			Start: set.Position(n.Pos()).Offset,
+			End:   set.Position(n.End()).Offset,
+			Text:  text,
+		})
+	}
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+		if isMarked(decl) {
+			remove(decl, "")
+		}
+	}
+	removeStmts := func(stmts []ast.Stmt) {
+		for _, stmt := range stmts {
+			switch stmt.(type) {
+			case *ast.CaseClause, *ast.CommClause:
+				// Nothing but clauses may be in their place.
+				continue
+			}
+			if isMarked(stmt) {
+				remove(stmt, keepUsedText(stmt))
+			}
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if removed[n] {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			removeStmts(n.List)
+		case *ast.CaseClause:
+			removeStmts(n.Body)
+		case *ast.CommClause:
+			removeStmts(n.Body)
+		}
+		return true
+	})
+	if len(edits) == 0 {
+		return nil
+	}
+	edits = append(edits, blankUnusedImports(set, file, fileImports(file, names), removed)...)
+	return applyFileEdits(path, edits)
+}
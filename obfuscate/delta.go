@@ -0,0 +1,427 @@
+package obfuscate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"hash"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// funcListPath, if set, is where the fingerprints of the
+// obfuscated functions of a build are written, which the
+// delta subcommand compares.
+var funcListPath string
+
+const deltaFormat = "gobfuscate-delta-1"
+
+// A funcList fingerprints the functions of an obfuscated
+// workspace.
+type funcList struct {
+	Functions []funcEntry `json:"functions"`
+}
+
+// A funcEntry is a function or method, by its obfuscated
+// name, as "import/path.Func" or "import/path.Type.Method",
+// and its original name, with the SHA-256 of its
+// obfuscated code.
+type funcEntry struct {
+	Name     string `json:"name"`
+	Original string `json:"original"`
+	SHA256   string `json:"sha256"`
+}
+
+// A DeltaReport lists the functions which differ between
+// the obfuscated builds of two revisions.
+type DeltaReport struct {
+	Format string        `json:"format"`
+	Old    DeltaRevision `json:"old"`
+	New    DeltaRevision `json:"new"`
+
+	// Changed, Added, and Removed are the functions whose
+	// obfuscated code differs, which only the new revision
+	// has, and which only the old one has, and Unchanged
+	// counts the others.
+	Changed   []DeltaFunc `json:"changed"`
+	Added     []DeltaFunc `json:"added"`
+	Removed   []DeltaFunc `json:"removed"`
+	Unchanged int         `json:"unchanged"`
+}
+
+// A DeltaRevision is a revision which was built, with the
+// binaries and the mapping file of its build.
+type DeltaRevision struct {
+	Revision  string        `json:"revision"`
+	Commit    string        `json:"commit"`
+	Binaries  []ReleaseFile `json:"binaries"`
+	Mapping   string        `json:"mapping"`
+	Functions int           `json:"functions"`
+}
+
+// A DeltaFunc is a function of a DeltaReport, by its
+// obfuscated and original names.
+type DeltaFunc struct {
+	Name     string `json:"name"`
+	Original string `json:"original"`
+}
+
+// WriteFuncList writes the fingerprints of the functions
+// of the workspace to path.
+func (w *Workspace) WriteFuncList(path string) error {
+	origPkgs, err := w.originalPackages()
+	if err != nil {
+		return stepError("list packages", err)
+	}
+	list, err := workspaceFuncs(w.Gopath, w.Moves, w.Mapping(origPkgs))
+	if err != nil {
+		return stepError("fingerprint functions", err)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return stepError("encode function list", err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return stepError("write function list", err)
+	}
+	return nil
+}
+
+// workspaceFuncs hashes the code of every function in a
+// workspace, without comments, so that functions which
+// only moved within their files keep their hashes. The
+// declarations of a name in several files, for different
+// build constraints, and the init functions of a package,
+// are hashed together.
+func workspaceFuncs(gopath string, moves PackageMoves, record *renameRecord) (*funcList, error) {
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	codes := make([][]string, len(files))
+	names := make([][]string, len(files))
+	err = runJobs(len(files), func(i int) error {
+		if strings.HasSuffix(files[i].Path, "_test.go") {
+			return nil
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, files[i].Path, nil, 0)
+		if err != nil {
+			// Files which cannot be parsed are not
+			// built either.
+			return nil
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := printer.Fprint(&buf, set, fn); err != nil {
+				return err
+			}
+			name := fn.Name.Name
+			if fn.Recv != nil && len(fn.Recv.List) > 0 {
+				typeName := receiverTypeName(fn.Recv.List[0])
+				if typeName == "" {
+					// Generic receivers keep their type
+					// parameters.
+					typeName = types.ExprString(fn.Recv.List[0].Type)
+				}
+				name = typeName + "." + name
+			}
+			names[i] = append(names[i], name)
+			codes[i] = append(codes[i], buf.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]hash.Hash{}
+	for i, f := range files {
+		for j, name := range names[i] {
+			qualified := f.PkgPath + "." + name
+			if hashes[qualified] == nil {
+				hashes[qualified] = sha256.New()
+			}
+			fmt.Fprintln(hashes[qualified], codes[i][j])
+		}
+	}
+	originals := originalFuncNames(record)
+	res := &funcList{Functions: []funcEntry{}}
+	var sorted []string
+	for name := range hashes {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		pkg, rest := splitFuncSymbol(name)
+		original := originals[moves.Original(pkg)+"."+rest]
+		if original == "" {
+			original = moves.Original(pkg) + "." + rest
+		}
+		res.Functions = append(res.Functions, funcEntry{
+			Name:     name,
+			Original: original,
+			SHA256:   hex.EncodeToString(hashes[name].Sum(nil)),
+		})
+	}
+	return res, nil
+}
+
+// originalFuncNames maps the obfuscated names of renamed
+// functions and methods, qualified by the original import
+// paths of their packages, to their original names.
+func originalFuncNames(record *renameRecord) map[string]string {
+	res := map[string]string{}
+	for original, newName := range record.Symbols {
+		pkg, rest := splitFuncSymbol(original)
+		newRest := newName
+		if dot := strings.Index(rest, "."); dot >= 0 {
+			typeName := rest[:dot]
+			if newType, ok := record.Symbols[pkg+"."+typeName]; ok {
+				typeName = newType
+			}
+			newRest = typeName + "." + newName
+		}
+		res[pkg+"."+newRest] = original
+	}
+	return res
+}
+
+// delta builds two revisions of a package, which must be
+// in the module of the working directory, into the "old"
+// and "new" directories of outDir, and reports which of
+// their functions differ.
+//
+// Both builds use the same -seed, so that every symbol
+// they share gets the same name, and every file they share
+// the same keys: the mapping of one build is also that of
+// the other, and only functions which were changed, or
+// whose files were, differ, which keeps binary patches
+// between them small.
+func delta(oldRev, newRev, pkgName, outDir string) bool {
+	if seed == "" {
+		fmt.Fprintln(stderr, "The delta subcommand requires -seed, so that both builds get the same names and keys.")
+		return false
+	} else if sourceArchive != "" || funcListPath != "" {
+		fmt.Fprintln(stderr, "The delta subcommand cannot be combined with -src or -funcs.")
+		return false
+	} else if isRemoteRef(pkgName) || strings.Contains(pkgName, ",") {
+		fmt.Fprintln(stderr, "The delta subcommand builds a single package of the working directory's module.")
+		return false
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to find executable:", err)
+		return false
+	}
+	if outDir, err = filepath.Abs(outDir); err != nil {
+		fmt.Fprintln(stderr, "Failed to resolve output path:", err)
+		return false
+	}
+	repoDir, treePrefix, err := moduleTreePrefix()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to find module in git repository:", err)
+		return false
+	}
+	tmpDir, err := newTempDir("delta")
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to create temp dir:", err)
+		return false
+	}
+	defer os.RemoveAll(tmpDir)
+
+	name := path.Base(pkgName)
+	if name == "." || name == "/" {
+		name = "out"
+	}
+	report := &DeltaReport{Format: deltaFormat, Changed: []DeltaFunc{}, Added: []DeltaFunc{}, Removed: []DeltaFunc{}}
+	lists := make([]*funcList, 2)
+	flags := forwardedFlags()
+	for i, rev := range []string{oldRev, newRev} {
+		label := [...]string{"old", "new"}[i]
+		revision := &report.Old
+		if i == 1 {
+			revision = &report.New
+		}
+		revision.Revision = rev
+		commit, err := exec.Command("git", "rev-parse", "--verify", rev+"^{commit}").Output()
+		if err != nil {
+			fmt.Fprintln(stderr, "Failed to resolve revision", rev+":", err)
+			return false
+		}
+		revision.Commit = strings.TrimSpace(string(commit))
+
+		archive := filepath.Join(tmpDir, label+".tar")
+		treeish := revision.Commit
+		if treePrefix != "" {
+			treeish += ":" + treePrefix
+		}
+		cmd := exec.Command("git", "archive", "--format=tar", "-o", archive, treeish)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			fmt.Fprintln(stderr, "Failed to archive revision", rev+":", strings.TrimSpace(string(output)))
+			return false
+		}
+
+		binDir := filepath.Join(outDir, label)
+		if err := os.MkdirAll(binDir, 0755); err != nil {
+			fmt.Fprintln(stderr, "Failed to create output directory:", err)
+			return false
+		}
+		revision.Mapping = filepath.Join(binDir, name+".map.json")
+		funcsPath := filepath.Join(tmpDir, label+".funcs.json")
+		args := append(append([]string{}, flags...), "-src="+archive, "-map="+revision.Mapping,
+			"-funcs="+funcsPath, pkgName, filepath.Join(binDir, name))
+		log.Println("Building revision", rev, "("+revision.Commit[:12]+")...")
+		cmd = exec.Command(self, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintln(stderr, "Failed to build revision", rev+":", err)
+			return false
+		}
+
+		if lists[i], err = readFuncList(funcsPath); err != nil {
+			fmt.Fprintln(stderr, "Failed to read function list:", err)
+			return false
+		}
+		revision.Functions = len(lists[i].Functions)
+		if revision.Binaries, err = deltaBinaries(binDir, revision.Mapping); err != nil {
+			fmt.Fprintln(stderr, "Failed to hash binaries:", err)
+			return false
+		}
+	}
+
+	report.compare(lists[0], lists[1])
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to encode delta report:", err)
+		return false
+	}
+	reportFile := filepath.Join(outDir, "delta.json")
+	if err := ioutil.WriteFile(reportFile, append(data, '\n'), 0600); err != nil {
+		fmt.Fprintln(stderr, "Failed to write delta report:", err)
+		return false
+	}
+	log.Println(len(report.Changed), "function(s) changed,", len(report.Added), "added,",
+		len(report.Removed), "removed, and", report.Unchanged, "unchanged (see", reportFile+")")
+	return true
+}
+
+// moduleTreePrefix finds the root of the git repository
+// of the working directory, and the path of its module in
+// the repository, as a git archive of a commit needs it.
+func moduleTreePrefix() (string, string, error) {
+	goMod, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		return "", "", err
+	}
+	modFile := strings.TrimSpace(string(goMod))
+	if modFile == "" || modFile == os.DevNull {
+		return "", "", fmt.Errorf("no go.mod in the working directory")
+	}
+	top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("not in a git repository")
+	}
+	topDir, err := filepath.EvalSymlinks(strings.TrimSpace(string(top)))
+	if err != nil {
+		return "", "", err
+	}
+	modDir, err := filepath.EvalSymlinks(filepath.Dir(modFile))
+	if err != nil {
+		return "", "", err
+	}
+	rel, err := filepath.Rel(topDir, modDir)
+	if err != nil {
+		return "", "", err
+	}
+	if rel == "." {
+		return topDir, "", nil
+	} else if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("the module at %s is outside of the repository", modDir)
+	}
+	return topDir, filepath.ToSlash(rel), nil
+}
+
+func readFuncList(path string) (*funcList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res funcList
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// deltaBinaries hashes the binaries of a revision, which
+// are all the files of its directory other than the
+// mapping file.
+func deltaBinaries(dir, mapFile string) ([]ReleaseFile, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	res := []ReleaseFile{}
+	for _, item := range listing {
+		path := filepath.Join(dir, item.Name())
+		if item.IsDir() || path == mapFile {
+			continue
+		}
+		file, err := releaseFile(path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *file)
+	}
+	return res, nil
+}
+
+// compare fills in the differences between the functions
+// of the old and the new build.
+func (d *DeltaReport) compare(oldList, newList *funcList) {
+	oldFuncs := map[string]funcEntry{}
+	for _, fn := range oldList.Functions {
+		oldFuncs[fn.Name] = fn
+	}
+	seen := map[string]bool{}
+	for _, fn := range newList.Functions {
+		seen[fn.Name] = true
+		old, ok := oldFuncs[fn.Name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, DeltaFunc{fn.Name, fn.Original})
+		case old.SHA256 != fn.SHA256:
+			d.Changed = append(d.Changed, DeltaFunc{fn.Name, fn.Original})
+		default:
+			d.Unchanged++
+		}
+	}
+	for _, fn := range oldList.Functions {
+		if !seen[fn.Name] {
+			d.Removed = append(d.Removed, DeltaFunc{fn.Name, fn.Original})
+		}
+	}
+}
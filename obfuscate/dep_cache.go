@@ -0,0 +1,770 @@
+package obfuscate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// depCacheFormat is part of every cache key, and must be
+// changed whenever the cache layout or the way that
+// dependencies are transformed changes.
+const depCacheFormat = "7"
+
+// A DepCache stores obfuscated dependency modules, so that
+// builds which share dependencies (and a padding) only need
+// to obfuscate their own code.
+//
+// An entry holds the obfuscated packages of every module
+// that a build depends on, other than the main module, and
+// a record of how they were renamed. Builds using an entry
+// rewrite their references to the dependencies according
+// to the record.
+type DepCache struct {
+	Dir string
+	Key string
+
+	// Hit is set if the dependencies were restored from
+	// the cache, in which case Moves holds the package
+	// moves which they went through, and Record describes
+	// how their symbols were renamed.
+	Hit    bool
+	Moves  PackageMoves
+	Record *renameRecord
+
+	deps map[string]bool
+}
+
+// A renameRecord describes how a set of packages, such as
+// the dependencies in a cache entry, were renamed.
+type renameRecord struct {
+	// Packages maps the original import paths of the
+	// packages to their obfuscated paths.
+	Packages map[string]string `json:"packages"`
+
+	// Symbols maps the original names of renamed symbols,
+	// as "import/path.Name" or "import/path.Type.Method",
+	// to their new names.
+	Symbols map[string]string `json:"symbols"`
+
+	// Hoisted maps the original names of functions which
+	// kept their names, named like Symbols, to the new
+	// functions which HoistClosures moved their bodies
+	// into.
+	Hoisted map[string]string `json:"hoisted,omitempty"`
+
+	// Errors maps the codes which replaced error messages
+	// to the messages, in mapping files.
+	Errors map[string]string `json:"errors,omitempty"`
+
+	// Strings lists the obfuscated string literals, in
+	// mapping files.
+	Strings []ObfuscatedString `json:"strings,omitempty"`
+
+	// Files maps the original paths of files renamed with
+	// -renamefiles, as "import/path/name.go", to their new
+	// names, in mapping files.
+	Files map[string]string `json:"files,omitempty"`
+
+	// Tool is the gobfuscate which wrote a mapping file.
+	Tool *ToolInfo `json:"gobfuscate,omitempty"`
+}
+
+// CopyModulesCached is like CopyModules, but restores the
+// obfuscated dependencies from a cache if it can, in which
+// case only the main module's packages are copied.
+//
+// It returns the import path of the package.
+func CopyModulesCached(pattern, workspace, modCache string, keepTests bool,
+	cacheDir string, n NameHasher) (string, *DepCache, error) {
+	pkgs, err := ListModulePackages(pattern, modCache, true)
+	if err != nil {
+		return "", nil, err
+	}
+	cache, err := NewDepCache(cacheDir, pkgs, n)
+	if err != nil {
+		return "", nil, err
+	}
+	record, err := cache.lookup()
+	if err != nil {
+		return "", nil, err
+	}
+	moves := componentMoves(pkgs, n)
+	if record != nil && !record.usable(pkgs, moves) {
+		log.Println("Cached dependencies cannot be used by this module.")
+		record = nil
+	}
+	if record == nil {
+		if err := copyListedPackages(pkgs, workspace, keepTests); err != nil {
+			return "", nil, err
+		}
+		return rootPackage(pkgs), cache, nil
+	}
+
+	var mainPkgs []*listedPackage
+	for _, pkg := range pkgs {
+		if pkg.Module != nil && pkg.Module.Main {
+			moved := *pkg
+			moved.ImportPath = moves.Obfuscated(pkg.ImportPath)
+			mainPkgs = append(mainPkgs, &moved)
+		}
+	}
+	if err := copyListedPackages(mainPkgs, workspace, keepTests); err != nil {
+		return "", nil, err
+	}
+	if err := rewriteDepReferences(workspace, pkgs, moves, record); err != nil {
+		return "", nil, err
+	}
+	if err := copyTree(filepath.Join(cache.entryDir(), "src"), filepath.Join(workspace, "src")); err != nil {
+		return "", nil, err
+	}
+	cache.Hit = true
+	cache.Moves = moves
+	cache.Record = record
+	return rootPackage(pkgs), cache, nil
+}
+
+// NewDepCache computes the cache key for a build from the
+// versions of its dependency modules, the padding, and the
+// options which affect how dependencies are obfuscated.
+func NewDepCache(dir string, pkgs []*listedPackage, n NameHasher) (*DepCache, error) {
+	goVersion, err := goLanguageVersion()
+	if err != nil {
+		return nil, err
+	}
+	deps := map[string]bool{}
+	modules := map[string]bool{}
+	for _, pkg := range pkgs {
+		if !pkg.Standard && pkg.Module != nil && !pkg.Module.Main {
+			deps[pkg.ImportPath] = true
+			modules[pkg.Module.Path+"@"+pkg.Module.Version] = true
+		}
+	}
+	var configData []byte
+	if config != nil {
+		configData, err = json.Marshal(config.Packages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hash := sha256.New()
+	fmt.Fprintln(hash, depCacheFormat)
+	fmt.Fprintln(hash, goVersion, build.Default.GOOS, build.Default.GOARCH)
+	fmt.Fprintln(hash, hex.EncodeToString(n))
+	fmt.Fprintln(hash, keepTests, passRuns("prunetypes", pruneTypes), reflectNames, reflectSafety, keepFlags.String(),
+		numericLiterals, lazyStrings, stringsMode, encryptEmbeds, httpStrings)
+	fmt.Fprintln(hash, string(configData))
+	fmt.Fprintf(hash, "%x\n", blocklist)
+	fmt.Fprintf(hash, "%q\n", logPatterns)
+	fmt.Fprintf(hash, "%q\n", excludedPaths)
+	fmt.Fprintln(hash, namingMode, pathStyle, strings.Join(dictionary, " "), internalMode)
+	for _, module := range sortedKeys(modules) {
+		fmt.Fprintln(hash, module)
+	}
+	return &DepCache{
+		Dir:  dir,
+		Key:  hex.EncodeToString(hash.Sum(nil)),
+		deps: deps,
+	}, nil
+}
+
+// IsDep checks if a package in the obfuscated workspace
+// belongs to a dependency module.
+func (d *DepCache) IsDep(moves PackageMoves, pkg string) bool {
+	return d.deps[moves.Original(pkg)]
+}
+
+func (d *DepCache) entryDir() string {
+	return filepath.Join(d.Dir, d.Key)
+}
+
+// lookup loads the record of the cache entry, or returns
+// nil if there is no entry.
+func (d *DepCache) lookup() (*renameRecord, error) {
+	data, err := readMapFile(filepath.Join(d.entryDir(), "record.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var record renameRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	// The clean subcommand removes the entries which have
+	// not been used for the longest.
+	now := time.Now()
+	os.Chtimes(d.entryDir(), now, now)
+	return &record, nil
+}
+
+// usable checks if an entry can be used by the main module
+// packages in a listing.
+// It cannot be used if the main module uses cgo (which we
+// cannot type-check), has tests which are kept, does not
+// type-check, or has interfaces which a type of the
+// dependencies implements with methods that were renamed,
+// or if the dependencies were moved differently than
+// expected.
+func (r *renameRecord) usable(pkgs []*listedPackage, moves PackageMoves) bool {
+	set := token.NewFileSet()
+	imp := exportImporter(set, pkgs)
+	methods := r.renamedMethods()
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.Module == nil {
+			continue
+		}
+		if !pkg.Module.Main {
+			if r.Packages[pkg.ImportPath] != moves.Obfuscated(pkg.ImportPath) {
+				return false
+			}
+			continue
+		}
+		if pkg.usesCgo() {
+			return false
+		}
+		if keepTests && len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) > 0 {
+			return false
+		}
+		var files []*ast.File
+		for _, name := range pkg.GoFiles {
+			file, err := parser.ParseFile(set, filepath.Join(pkg.Dir, name), nil, 0)
+			if err != nil {
+				return false
+			}
+			files = append(files, file)
+		}
+		info := &types.Info{
+			Types: map[ast.Expr]types.TypeAndValue{},
+			Defs:  map[*ast.Ident]types.Object{},
+		}
+		conf := types.Config{Importer: imp}
+		if _, err := conf.Check(pkg.ImportPath, set, files, info); err != nil {
+			return false
+		}
+		if implementsRenamed(imp, info, methods) {
+			return false
+		}
+	}
+	return true
+}
+
+// renamedMethods lists the types of the record's packages
+// which have renamed methods, by the original names of the
+// methods, as "import/path.Type".
+func (r *renameRecord) renamedMethods() map[string][]string {
+	res := map[string][]string{}
+	for name := range r.Symbols {
+		for pkg := range r.Packages {
+			if !strings.HasPrefix(name, pkg+".") {
+				continue
+			}
+			parts := strings.Split(name[len(pkg)+1:], ".")
+			if len(parts) == 2 {
+				res[parts[1]] = append(res[parts[1]], pkg+"."+parts[0])
+			}
+		}
+	}
+	return res
+}
+
+// implementsRenamed checks if a type-checked package
+// declares or uses an interface which a type of the record's
+// packages implements with renamed methods, or declares a
+// type which implements an interface of those packages with
+// renamed methods. Either would have to be renamed along
+// with the methods.
+func implementsRenamed(imp types.Importer, info *types.Info, methods map[string][]string) bool {
+	// conflicts checks if a type of the package and one
+	// which has a renamed method implement each other.
+	conflicts := func(t types.Type, method string) bool {
+		tIface, isIface := t.Underlying().(*types.Interface)
+		for _, owner := range methods[method] {
+			dot := strings.LastIndex(owner, ".")
+			pkg, err := imp.Import(owner[:dot])
+			if err != nil {
+				return true
+			}
+			obj, ok := pkg.Scope().Lookup(owner[dot+1:]).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			ownerIface, ownerIsIface := obj.Type().Underlying().(*types.Interface)
+			if isIface && !ownerIsIface && types.Implements(types.NewPointer(obj.Type()), tIface) {
+				return true
+			} else if !isIface && ownerIsIface && types.Implements(types.NewPointer(t), ownerIface) {
+				return true
+			}
+		}
+		return false
+	}
+	check := func(t types.Type) bool {
+		if iface, ok := t.Underlying().(*types.Interface); ok {
+			for i := 0; i < iface.NumMethods(); i++ {
+				if conflicts(t, iface.Method(i).Name()) {
+					return true
+				}
+			}
+			return false
+		}
+		mset := types.NewMethodSet(types.NewPointer(t))
+		for i := 0; i < mset.Len(); i++ {
+			if conflicts(t, mset.At(i).Obj().Name()) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, obj := range info.Defs {
+		if obj, ok := obj.(*types.TypeName); ok && check(obj.Type()) {
+			return true
+		}
+	}
+	for _, tv := range info.Types {
+		if tv.IsType() && check(tv.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// componentMoves computes the moves that
+// ObfuscatePackageNames makes for the listed packages,
+// which hash every component of a package's path, level
+// by level, except for directories with cgo code.
+func componentMoves(pkgs []*listedPackage, n NameHasher) PackageMoves {
+	cgoDirs := map[string]bool{}
+	dirs := map[string]bool{}
+	for _, pkg := range pkgs {
+		if pkg.Standard {
+			continue
+		}
+		if pkg.usesCgo() {
+			cgoDirs[pkg.ImportPath] = true
+		}
+		parts := strings.Split(pkg.ImportPath, "/")
+		for i := range parts {
+			dirs[strings.Join(parts[:i+1], "/")] = true
+		}
+	}
+
+	// The new path of every directory moved so far.
+	newPaths := map[string]string{}
+	var moves PackageMoves
+	for level := 1; len(dirs) > 0; level++ {
+		for _, dir := range sortedKeys(dirs) {
+			if strings.Count(dir, "/") != level-1 {
+				continue
+			}
+			delete(dirs, dir)
+			parent, base := path.Split(dir)
+			newParent := strings.TrimSuffix(parent, "/")
+			if moved, ok := newPaths[newParent]; ok {
+				newParent = moved
+			}
+			from := path.Join(newParent, base)
+			if cgoDirs[dir] {
+				newPaths[dir] = from
+				continue
+			}
+			to := path.Join(newParent, n.Hash(base))
+			newPaths[dir] = to
+			moves = append(moves, packageMove{from, to})
+		}
+	}
+	return moves
+}
+
+// Store saves the obfuscated dependencies of a workspace
+// as a cache entry, unless an entry already exists.
+// The renames and hoists are those of ObfuscateSymbols.
+func (d *DepCache) Store(workspace string, moves PackageMoves, renames, hoists []symbolRenameReq) error {
+	if _, err := os.Stat(d.entryDir()); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+
+	// Another run may have stored the same entry while this
+	// one was obfuscating.
+	unlock, err := lockFile(filepath.Join(d.Dir, "lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(d.entryDir()); err == nil {
+		return nil
+	}
+	tmpDir, err := ioutil.TempDir(d.Dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	deps := sortedKeys(d.deps)
+	for _, pkg := range deps {
+		obfPath := moves.Obfuscated(pkg)
+		src := filepath.Join(workspace, "src", filepath.FromSlash(obfPath))
+		dst := filepath.Join(tmpDir, "src", filepath.FromSlash(obfPath))
+		if err := copyPackageFiles(src, dst); err != nil {
+			return err
+		}
+	}
+	isDep := func(pkg string) bool {
+		return d.IsDep(moves, pkg)
+	}
+	record := newRenameRecord(deps, moves, renames, isDep)
+	record.addHoists(moves, hoists, isDep)
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeMapFile(filepath.Join(tmpDir, "record.json"), data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, d.entryDir()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// newRenameRecord records how packages were moved and
+// which of the renames applied by ObfuscateSymbols belong
+// to them.
+// Packages are given by their original import paths, and
+// include checks an obfuscated import path.
+func newRenameRecord(pkgs []string, moves PackageMoves, renames []symbolRenameReq,
+	include func(string) bool) *renameRecord {
+	record := &renameRecord{
+		Packages: map[string]string{},
+		Symbols:  map[string]string{},
+	}
+	for _, pkg := range pkgs {
+		record.Packages[pkg] = moves.Obfuscated(pkg)
+	}
+
+	// Method renames refer to their receivers by the
+	// receivers' new names, so top-level renames are
+	// needed to find the original names.
+	origNames := map[string]string{}
+	for _, r := range renames {
+		pkg, typeName, name := parseRenameQuery(r.OldName)
+		if typeName == "" && include(pkg) {
+			origNames[pkg+"."+r.NewName] = name
+			record.Symbols[moves.Original(pkg)+"."+name] = r.NewName
+		}
+	}
+	for _, r := range renames {
+		pkg, typeName, name := parseRenameQuery(r.OldName)
+		if typeName == "" || !include(pkg) {
+			continue
+		}
+		if orig, ok := origNames[pkg+"."+typeName]; ok {
+			typeName = orig
+		}
+		record.Symbols[moves.Original(pkg)+"."+typeName+"."+name] = r.NewName
+	}
+	return record
+}
+
+// addHoists records the hoists of HoistClosures which
+// belong to the packages for which include returns true,
+// after the renames, which give the original names of the
+// methods' receivers.
+func (r *renameRecord) addHoists(moves PackageMoves, hoists []symbolRenameReq, include func(string) bool) {
+	for _, h := range hoists {
+		pkg, typeName, name := parseRenameQuery(h.OldName)
+		if !include(pkg) {
+			continue
+		}
+		origPkg := moves.Original(pkg)
+		key := origPkg + "." + name
+		if typeName != "" {
+			key = origPkg + "." + r.originalName(origPkg, typeName) + "." + name
+		}
+		if r.Hoisted == nil {
+			r.Hoisted = map[string]string{}
+		}
+		r.Hoisted[key] = h.NewName
+	}
+}
+
+// originalName finds the original name of a top-level
+// symbol of a package, given by its original import path,
+// which may have been renamed.
+func (r *renameRecord) originalName(pkg, name string) string {
+	for key, newName := range r.Symbols {
+		if newName == name && strings.HasPrefix(key, pkg+".") && !strings.Contains(key[len(pkg)+1:], ".") {
+			return key[len(pkg)+1:]
+		}
+	}
+	return name
+}
+
+// merge adds the packages, symbols, and hoists of another
+// record.
+func (r *renameRecord) merge(other *renameRecord) {
+	for pkg, newPath := range other.Packages {
+		r.Packages[pkg] = newPath
+	}
+	for name, newName := range other.Symbols {
+		r.Symbols[name] = newName
+	}
+	for name, newName := range other.Hoisted {
+		if r.Hoisted == nil {
+			r.Hoisted = map[string]string{}
+		}
+		r.Hoisted[name] = newName
+	}
+}
+
+// parseRenameQuery splits a query like "pkg".Name,
+// "pkg".Type.Method, or (*"pkg".Type).Method.
+// The type is empty for top-level symbols.
+func parseRenameQuery(query string) (pkg, typeName, name string) {
+	query = strings.TrimPrefix(query, "(*")
+	query = strings.Replace(query, ").", ".", 1)
+	end := strings.Index(query[1:], "\"") + 1
+	pkg = query[1:end]
+	parts := strings.Split(query[end+2:], ".")
+	if len(parts) == 1 {
+		return pkg, "", parts[0]
+	}
+	return pkg, parts[0], parts[1]
+}
+
+// rewriteDepReferences updates the main module packages in
+// a workspace, which have already been moved, to refer to
+// packages by their new paths and to the dependencies'
+// symbols by their obfuscated names.
+//
+// The packages are type-checked against the export data
+// of the original dependencies, so pkgs must have been
+// listed with export data.
+func rewriteDepReferences(workspace string, pkgs []*listedPackage, moves PackageMoves,
+	record *renameRecord) error {
+	set := token.NewFileSet()
+	imp := exportImporter(set, pkgs)
+	for _, pkg := range pkgs {
+		if pkg.Module == nil || !pkg.Module.Main {
+			continue
+		}
+		newPath := moves.Obfuscated(pkg.ImportPath)
+		dir := filepath.Join(workspace, "src", filepath.FromSlash(newPath))
+		var files []*ast.File
+		for _, name := range pkg.GoFiles {
+			file, err := parser.ParseFile(set, filepath.Join(dir, name), nil, 0)
+			if err != nil {
+				return err
+			}
+			files = append(files, file)
+		}
+		edits, err := renameEdits(set, pkg.ImportPath, files, imp, moves, record, false)
+		if err != nil {
+			return err
+		}
+		for path, fileEdits := range edits {
+			if err := applyFileEdits(path, fileEdits); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportImporter creates an importer which reads the
+// export data of listed packages.
+func exportImporter(set *token.FileSet, pkgs []*listedPackage) types.Importer {
+	exports := map[string]string{}
+	for _, pkg := range pkgs {
+		exports[pkg.ImportPath] = pkg.Export
+	}
+	return importer.ForCompiler(set, "gc", func(path string) (io.ReadCloser, error) {
+		export, ok := exports[path]
+		if !ok || export == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(export)
+	})
+}
+
+// renameEdits type-checks the files of a package, and
+// finds the edits which make them refer to packages by
+// their new paths and to symbols by their new names.
+// If defs is set, the package's own declarations of
+// renamed symbols are renamed as well.
+// The edits are grouped by file name.
+func renameEdits(set *token.FileSet, importPath string, files []*ast.File, imp types.Importer,
+	moves PackageMoves, record *renameRecord, defs bool) (map[string][]fileEdit, error) {
+	info := &types.Info{
+		Uses:      map[*ast.Ident]types.Object{},
+		Defs:      map[*ast.Ident]types.Object{},
+		Implicits: map[ast.Node]types.Object{},
+	}
+	conf := types.Config{Importer: imp}
+	if _, err := conf.Check(importPath, set, files, info); err != nil {
+		return nil, fmt.Errorf("type-check %s: %s", importPath, err)
+	}
+
+	// The name of an embedded field is both a use of its
+	// type and a definition of the field, so edits are
+	// recorded once per position.
+	edits := map[string][]fileEdit{}
+	edited := map[token.Pos]bool{}
+	addEdit := func(start, end token.Pos, text string) {
+		if edited[start] {
+			return
+		}
+		edited[start] = true
+		pos := set.Position(start)
+		edits[pos.Filename] = append(edits[pos.Filename], fileEdit{
+			Start: pos.Offset,
+			End:   set.Position(end).Offset,
+			Text:  text,
+		})
+	}
+	for _, file := range files {
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			obfPath := moves.Obfuscated(path)
+			if obfPath == path {
+				continue
+			}
+			text := strconv.Quote(obfPath)
+			if spec.Name == nil {
+				if pkgName, ok := info.Implicits[spec].(*types.PkgName); ok {
+					text = pkgName.Imported().Name() + " " + text
+				}
+			}
+			addEdit(spec.Path.Pos(), spec.Path.End(), text)
+		}
+	}
+	for ident, obj := range info.Uses {
+		if newName, ok := record.newName(obj); ok {
+			addEdit(ident.Pos(), ident.End(), newName)
+		}
+	}
+	for ident, obj := range info.Defs {
+		// Embedded fields are named after their types.
+		if v, ok := obj.(*types.Var); ok && v.Embedded() {
+			if newName, ok := record.newName(embeddedTypeName(v.Type())); ok {
+				addEdit(ident.Pos(), ident.End(), newName)
+			}
+		} else if defs {
+			if newName, ok := record.newName(obj); ok {
+				addEdit(ident.Pos(), ident.End(), newName)
+			}
+		}
+	}
+	return edits, nil
+}
+
+// newName finds the new name of an object from one of the
+// record's packages, if it was renamed.
+func (r *renameRecord) newName(obj types.Object) (string, bool) {
+	if obj == nil {
+		return "", false
+	}
+	if v, ok := obj.(*types.Var); ok && v.Embedded() {
+		return r.newName(embeddedTypeName(v.Type()))
+	}
+	if obj.Pkg() == nil {
+		return "", false
+	}
+	if _, ok := r.Packages[obj.Pkg().Path()]; !ok {
+		return "", false
+	}
+	key := obj.Pkg().Path() + "." + obj.Name()
+	if fn, ok := obj.(*types.Func); ok {
+		if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+			named := embeddedTypeName(recv.Type())
+			if named == nil {
+				return "", false
+			}
+			key = obj.Pkg().Path() + "." + named.Name() + "." + obj.Name()
+		}
+	} else if obj.Parent() != obj.Pkg().Scope() {
+		return "", false
+	}
+	newName, ok := r.Symbols[key]
+	return newName, ok
+}
+
+// embeddedTypeName finds the named type of an embedded
+// field or a method receiver, if it has one.
+func embeddedTypeName(t types.Type) types.Object {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	switch t := t.(type) {
+	case *types.Alias:
+		return t.Obj()
+	case *types.Named:
+		return t.Obj()
+	}
+	return nil
+}
+
+// copyPackageFiles copies the files of a package directory,
+// and the _cgo directories of localizeCgoPaths, but not the
+// other sub-directories.
+func copyPackageFiles(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	listing, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, item := range listing {
+		if item.IsDir() {
+			if isCgoDir(item.Name()) {
+				if err := copyTree(filepath.Join(src, item.Name()), filepath.Join(dst, item.Name())); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := copyFile(filepath.Join(src, item.Name()), filepath.Join(dst, item.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies a directory tree, merging it with any
+// existing directories at the destination.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target)
+	})
+}
@@ -0,0 +1,183 @@
+package obfuscate
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// Directives are comments which mark code for the passes,
+// written on the line above the code (or, for a whole
+// file, before its package clause).
+const (
+	// debugDirective marks code which is removed.
+	debugDirective = "//gobfuscate:debug"
+
+	// keepDirective marks declarations which keep their
+	// names.
+	keepDirective = "//gobfuscate:keep"
+
+	// skipDirective marks declarations which keep their
+	// names, and whose strings, local names, labels, and
+	// closures are left alone.
+	skipDirective = "//gobfuscate:skip"
+
+	// encryptDirective marks declarations and lines whose
+	// strings are encrypted with AES.
+	encryptDirective = "//gobfuscate:encrypt"
+)
+
+// hasDirective checks if a comment group contains a
+// directive, which may be followed by an explanation.
+func hasDirective(group *ast.CommentGroup, directive string) bool {
+	if group == nil {
+		return false
+	}
+	for _, comment := range group.List {
+		rest := strings.TrimPrefix(comment.Text, directive)
+		if rest != comment.Text && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return true
+		}
+	}
+	return false
+}
+
+// fileHasDirective checks if a directive is in a comment
+// before the package clause of a file, which must have
+// been parsed with comments.
+func fileHasDirective(file *ast.File, directive string) bool {
+	for _, group := range file.Comments {
+		if group.End() < file.Package && hasDirective(group, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepsName checks if a declaration keeps its name, given
+// its comments, because it (or its file) is marked with
+// keepDirective or skipDirective.
+func keepsName(file *ast.File, comments ...*ast.CommentGroup) bool {
+	for _, directive := range []string{keepDirective, skipDirective} {
+		if fileHasDirective(file, directive) {
+			return true
+		}
+		for _, group := range comments {
+			if hasDirective(group, directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skippedCode lists the declarations of a file which are
+// marked with skipDirective.
+type skippedCode []ast.Node
+
+// findSkippedCode finds the skipped declarations of a
+// file, which must have been parsed with comments.
+func findSkippedCode(file *ast.File) skippedCode {
+	if fileHasDirective(file, skipDirective) {
+		return skippedCode{file}
+	}
+	var res skippedCode
+	for _, decl := range file.Decls {
+		var doc *ast.CommentGroup
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			doc = decl.Doc
+		case *ast.GenDecl:
+			doc = decl.Doc
+		}
+		if hasDirective(doc, skipDirective) {
+			res = append(res, decl)
+		}
+	}
+	return res
+}
+
+// Contains checks if a node is part of skipped code.
+func (s skippedCode) Contains(n ast.Node) bool {
+	for _, node := range s {
+		if n.Pos() >= node.Pos() && n.Pos() < node.End() {
+			return true
+		}
+	}
+	return false
+}
+
+// startsLine checks if only whitespace comes before an
+// offset on its line.
+func startsLine(contents []byte, offset int) bool {
+	lineStart := bytes.LastIndexByte(contents[:offset], '\n') + 1
+	return len(bytes.TrimSpace(contents[lineStart:offset])) == 0
+}
+
+// encryptedCode finds the string literals of a file which
+// are marked with encryptDirective.
+type encryptedCode struct {
+	set *token.FileSet
+
+	// nodes are the marked declarations (or the file), and
+	// lines are the marked lines.
+	nodes []ast.Node
+	lines map[int]bool
+}
+
+// findEncryptedCode finds the encrypted code of a file,
+// which must have been parsed with comments.
+//
+// The directive marks a whole declaration in its doc
+// comment, the next line when it is on a line of its own,
+// and its own line when it follows code.
+func findEncryptedCode(set *token.FileSet, file *ast.File, contents []byte) *encryptedCode {
+	res := &encryptedCode{set: set, lines: map[int]bool{}}
+	if fileHasDirective(file, encryptDirective) {
+		res.nodes = append(res.nodes, file)
+		return res
+	}
+	for _, group := range file.Comments {
+		if group.Pos() < file.Package || !hasDirective(group, encryptDirective) {
+			continue
+		}
+		line := set.Position(group.End()).Line
+		if startsLine(contents, set.Position(group.Pos()).Offset) {
+			line++
+		}
+		res.lines[line] = true
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		var doc *ast.CommentGroup
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			doc = n.Doc
+		case *ast.GenDecl:
+			doc = n.Doc
+		case *ast.ValueSpec:
+			doc = n.Doc
+		case *ast.TypeSpec:
+			doc = n.Doc
+		}
+		if hasDirective(doc, encryptDirective) {
+			res.nodes = append(res.nodes, n)
+			return false
+		}
+		return true
+	})
+	return res
+}
+
+// Contains checks if a node is part of encrypted code.
+func (e *encryptedCode) Contains(n ast.Node) bool {
+	if e.lines[e.set.Position(n.Pos()).Line] {
+		return true
+	}
+	for _, node := range e.nodes {
+		if n.Pos() >= node.Pos() && n.Pos() < node.End() {
+			return true
+		}
+	}
+	return false
+}
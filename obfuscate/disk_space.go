@@ -0,0 +1,100 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// noDiskCheck skips the check that there is enough disk
+// space for a run.
+var noDiskCheck bool
+
+const (
+	// stdlibCacheSize is about how much compiling the
+	// standard library adds to a build cache, per target.
+	stdlibCacheSize = 400 << 20
+
+	// obfuscatedGrowth is how many times larger than its
+	// sources a workspace may grow as it is obfuscated, with
+	// rewritten files and encoded strings.
+	obfuscatedGrowth = 3
+)
+
+// Preflight checks, before packages are copied, that there
+// is about enough disk space for the copy and for the rest
+// of the run, so that a run fails right away rather than
+// running out of space while it builds.
+//
+// The workspace needs room for the obfuscated sources, and
+// for a build cache holding the standard library and the
+// packages for every target. Packages which a -resume
+// directory already holds need no more room there.
+func (c *copyCheckpoint) Preflight(pkgs []*build.Package) error {
+	if c == nil || noDiskCheck {
+		return nil
+	}
+	var size, pending int64
+	for _, pkg := range pkgs {
+		var pkgSize int64
+		for _, list := range depFiles(pkg, keepTests) {
+			for _, name := range list {
+				if info, err := os.Stat(filepath.Join(pkg.Dir, name)); err == nil {
+					pkgSize += info.Size()
+				}
+			}
+		}
+		size += pkgSize
+		if c.previous[pkg.ImportPath] == "" {
+			pending += pkgSize
+		}
+	}
+
+	need := size * obfuscatedGrowth
+	if numVariants > 1 {
+		// Each variant is obfuscated in a copy of the base
+		// workspace.
+		need *= 2
+	}
+	if !outputGopath {
+		need += int64(len(buildTargets())) * (stdlibCacheSize + size)
+	}
+	if c.Dest != c.Workspace {
+		if err := checkDiskSpace(c.Dest, pending); err != nil {
+			return err
+		}
+	}
+	return checkDiskSpace(c.Workspace, need)
+}
+
+// checkDiskSpace checks that the filesystem holding dir,
+// which may not exist yet, has need bytes free.
+// Filesystems whose free space cannot be found are assumed
+// to have enough.
+func checkDiskSpace(dir string, need int64) error {
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		if verbose {
+			log.Println("Skipping the disk space check:", err)
+		}
+		return nil
+	}
+	if free >= need {
+		return nil
+	}
+	hint := "free some space"
+	if strings.HasPrefix(dir, filepath.Clean(tempRoot())) {
+		hint = "use -workdir to pick a directory on a bigger filesystem"
+	}
+	return fmt.Errorf("not enough disk space in %s: the run needs about %s, but %s is free; %s, or use -nodiskcheck",
+		dir, formatSize(need), formatSize(free), hint)
+}
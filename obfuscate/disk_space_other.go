@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!dragonfly,!windows
+
+package obfuscate
+
+import (
+	"errors"
+	"runtime"
+)
+
+func freeDiskSpace(dir string) (int64, error) {
+	return 0, errors.New("free disk space is unknown on " + runtime.GOOS)
+}
@@ -0,0 +1,475 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// dryRun makes the obfuscation stop before anything is
+// built, and print a summary of what it changed instead.
+var dryRun bool
+
+// diffPath, if set, is where a dry run writes a unified
+// diff of the sources before and after obfuscation.
+var diffPath string
+
+// checkDryRunFlags reports flags which cannot be combined
+// with -dryrun or -diff.
+func checkDryRunFlags() error {
+	if diffPath != "" && !dryRun {
+		return flagError("The -diff flag requires -dryrun.")
+	}
+	if dryRun && (numVariants > 1 || incrementalDir != "" || outputGopath || buildCacheDir != "" || resumeDir != "") {
+		return flagError("The -dryrun flag cannot be combined with -variants, -incremental, -outdir, " +
+			"-buildcache, or -resume.")
+	}
+	return nil
+}
+
+// obfuscateDryRun copies and obfuscates a package like a
+// build, keeping a copy of the sources from before the
+// obfuscation, and prints what changed without building
+// anything.
+func obfuscateDryRun(pkgName string) error {
+	newGopath, err := newTempDir("gopath")
+	if err != nil {
+		return stepError("create temp dir", err)
+	}
+	defer os.RemoveAll(newGopath)
+	var modCache string
+	if useModules {
+		modCache, err = newTempDir("modcache")
+		if err != nil {
+			return stepError("create temp dir", err)
+		}
+		defer cleanModCache(modCache)
+	}
+
+	n := newNameHasher(customPadding)
+	pkgName, depCache, err := copyWorkspace(pkgName, newGopath, modCache, n)
+	if err != nil {
+		return err
+	}
+	origGopath, err := newTempDir("original")
+	if err != nil {
+		return stepError("create temp dir", err)
+	}
+	defer os.RemoveAll(origGopath)
+	if err := copyTree(filepath.Join(newGopath, "src"), filepath.Join(origGopath, "src")); err != nil {
+		return stepError("copy the original sources", err)
+	}
+
+	ws, err := obfuscateWorkspace(pkgName, newGopath, n, depCache)
+	if err != nil {
+		return err
+	}
+	log.Println("Comparing sources...")
+	runMetrics.Phase("diff")
+	changes, err := diffWorkspace(filepath.Join(origGopath, "src"), ws)
+	if err != nil {
+		return stepError("compare sources", err)
+	}
+	printDryRunSummary(ws, changes)
+	if diffPath != "" {
+		var buf bytes.Buffer
+		for _, change := range changes {
+			buf.WriteString(change.Diff)
+		}
+		if err := ioutil.WriteFile(diffPath, buf.Bytes(), 0644); err != nil {
+			return stepError("write diff", err)
+		}
+		log.Println("Wrote the diff of", len(changes), "file(s) to", diffPath)
+	}
+	return nil
+}
+
+// A fileChange is a file which a dry run changed, added,
+// or removed, by its paths relative to the src directory
+// before and after obfuscation. Added files have no Old
+// path, and removed files have no New one.
+type fileChange struct {
+	Old string
+	New string
+
+	// Package is the original import path of the package of
+	// the file.
+	Package string
+
+	Added   int
+	Removed int
+	Diff    string
+}
+
+// diffWorkspace compares the sources of an obfuscated
+// workspace with those in origSrc. A file is matched with
+// its original through the moves of its package and the
+// new names of renamed files; files which cannot be
+// matched, like those of the helper package or of merged
+// packages, are listed as added and removed.
+func diffWorkspace(origSrc string, ws *Workspace) ([]fileChange, error) {
+	newSrc := filepath.Join(ws.Gopath, "src")
+	origFiles, err := listTreeFiles(origSrc)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := listTreeFiles(newSrc)
+	if err != nil {
+		return nil, err
+	}
+	renamed := map[string]string{}
+	for oldPath, newName := range ws.FileNames {
+		renamed[path.Dir(oldPath)+"/"+newName] = oldPath
+	}
+
+	var res []fileChange
+	matched := map[string]bool{}
+	for _, newPath := range newFiles {
+		origPkg := ws.Moves.Original(path.Dir(newPath))
+		oldPath := origPkg + "/" + path.Base(newPath)
+		if p, ok := renamed[oldPath]; ok {
+			oldPath = p
+		}
+		if _, err := os.Stat(filepath.Join(origSrc, filepath.FromSlash(oldPath))); err != nil {
+			oldPath = ""
+		} else {
+			matched[oldPath] = true
+		}
+		change, err := diffFile(origSrc, newSrc, oldPath, newPath)
+		if err != nil {
+			return nil, err
+		} else if change != nil {
+			change.Package = origPkg
+			res = append(res, *change)
+		}
+	}
+	for _, oldPath := range origFiles {
+		if matched[oldPath] {
+			continue
+		}
+		change, err := diffFile(origSrc, newSrc, oldPath, "")
+		if err != nil {
+			return nil, err
+		}
+		change.Package = path.Dir(oldPath)
+		res = append(res, *change)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Package != res[j].Package {
+			return res[i].Package < res[j].Package
+		}
+		return res[i].Old+res[i].New < res[j].Old+res[j].New
+	})
+	return res, nil
+}
+
+// listTreeFiles lists the regular files under a directory,
+// by their slash-separated paths relative to it.
+func listTreeFiles(dir string) ([]string, error) {
+	var res []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		res = append(res, filepath.ToSlash(rel))
+		return nil
+	})
+	return res, err
+}
+
+// diffFile compares a file before and after obfuscation,
+// either of which may be missing, and returns nil if it
+// did not change.
+func diffFile(origSrc, newSrc, oldPath, newPath string) (*fileChange, error) {
+	var oldData, newData []byte
+	oldName, newName := "/dev/null", "/dev/null"
+	if oldPath != "" {
+		var err error
+		if oldData, err = ioutil.ReadFile(filepath.Join(origSrc, filepath.FromSlash(oldPath))); err != nil {
+			return nil, err
+		}
+		oldName = "a/" + oldPath
+	}
+	if newPath != "" {
+		var err error
+		if newData, err = ioutil.ReadFile(filepath.Join(newSrc, filepath.FromSlash(newPath))); err != nil {
+			return nil, err
+		}
+		newName = "b/" + newPath
+	}
+	if oldPath != "" && newPath != "" && oldPath == newPath && bytes.Equal(oldData, newData) {
+		return nil, nil
+	}
+	change := &fileChange{Old: oldPath, New: newPath}
+	if isBinaryData(oldData) || isBinaryData(newData) {
+		change.Diff = fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+		return change, nil
+	}
+	change.Diff, change.Added, change.Removed = unifiedDiff(oldName, newName, splitLines(oldData),
+		splitLines(newData))
+	return change, nil
+}
+
+// isBinaryData checks if the contents of a file are not
+// text, like those of encrypted embedded files.
+func isBinaryData(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
+
+// splitLines splits text into lines, each with its line
+// break, if it has one.
+func splitLines(data []byte) []string {
+	var res []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		res = append(res, string(data[:i]))
+		data = data[i:]
+	}
+	return res
+}
+
+// diffContext is the number of unchanged lines shown around
+// the changes of a unified diff.
+const diffContext = 3
+
+// maxDiffCells limits the size of the table which is used
+// to compare the changed parts of two files.
+const maxDiffCells = 1 << 22
+
+// A diffOp is a line of a diff, with its kind: ' ' for a
+// line which was kept, '-' for one which was removed, and
+// '+' for one which was added.
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// unifiedDiff makes a unified diff of two files, given as
+// their lines, and counts the lines which were added and
+// removed.
+func unifiedDiff(oldName, newName string, a, b []string) (string, int, int) {
+	ops := diffLines(a, b)
+	// oldLines and newLines count the lines of each file
+	// before each op.
+	oldLines := make([]int, len(ops)+1)
+	newLines := make([]int, len(ops)+1)
+	var added, removed int
+	for i, op := range ops {
+		oldLines[i+1], newLines[i+1] = oldLines[i], newLines[i]
+		if op.Kind != '+' {
+			oldLines[i+1]++
+		}
+		if op.Kind != '-' {
+			newLines[i+1]++
+		}
+		if op.Kind == '+' {
+			added++
+		} else if op.Kind == '-' {
+			removed++
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(ops); {
+		for start < len(ops) && ops[start].Kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Changes which are separated by no more than twice the
+		// context share a hunk.
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].Kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		last := end + diffContext
+		if last > len(ops) {
+			last = len(ops)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(oldLines[first], oldLines[last]),
+			hunkRange(newLines[first], newLines[last]))
+		for _, op := range ops[first:last] {
+			buf.WriteByte(op.Kind)
+			buf.WriteString(op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = end
+	}
+	return buf.String(), added, removed
+}
+
+// hunkRange formats the lines of a file which a hunk
+// covers, from the number of lines before it to the number
+// of lines up to its end.
+func hunkRange(before, after int) string {
+	if after == before {
+		return fmt.Sprintf("%d,0", before)
+	} else if after == before+1 {
+		return fmt.Sprint(after)
+	}
+	return fmt.Sprintf("%d,%d", before+1, after-before)
+}
+
+// diffLines finds the lines which are kept, removed, and
+// added to turn a into b, by the longest common
+// subsequence of their lines once their common prefix and
+// suffix are trimmed. Changed parts which are too long to
+// compare are replaced as a whole.
+func diffLines(a, b []string) []diffOp {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var res []diffOp
+	for _, line := range a[:prefix] {
+		res = append(res, diffOp{' ', line})
+	}
+	changedA, changedB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(changedA)*len(changedB) > maxDiffCells {
+		for _, line := range changedA {
+			res = append(res, diffOp{'-', line})
+		}
+		for _, line := range changedB {
+			res = append(res, diffOp{'+', line})
+		}
+	} else {
+		res = append(res, diffCommon(changedA, changedB)...)
+	}
+	for _, line := range a[len(a)-suffix:] {
+		res = append(res, diffOp{' ', line})
+	}
+	return res
+}
+
+// diffCommon diffs two lists of lines by their longest
+// common subsequence.
+func diffCommon(a, b []string) []diffOp {
+	// lengths[i*width+j] is the length of the longest common
+	// subsequence of a[i:] and b[j:].
+	width := len(b) + 1
+	lengths := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			} else if lengths[(i+1)*width+j] >= lengths[i*width+j+1] {
+				lengths[i*width+j] = lengths[(i+1)*width+j]
+			} else {
+				lengths[i*width+j] = lengths[i*width+j+1]
+			}
+		}
+	}
+	var res []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			res = append(res, diffOp{' ', a[i]})
+			i++
+			j++
+		} else if lengths[(i+1)*width+j] >= lengths[i*width+j+1] {
+			res = append(res, diffOp{'-', a[i]})
+			i++
+		} else {
+			res = append(res, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		res = append(res, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		res = append(res, diffOp{'+', b[j]})
+	}
+	return res
+}
+
+// printDryRunSummary prints what the obfuscation of a
+// workspace changed: the numbers of renamed symbols,
+// encrypted strings, and so on, and the changes to the
+// files of each package.
+func printDryRunSummary(ws *Workspace, changes []fileChange) {
+	fmt.Println("Dry run of", ws.PkgName, "(nothing was built)")
+	moved := map[string]bool{}
+	for _, change := range changes {
+		if change.New != "" && path.Dir(change.New) != change.Package {
+			moved[change.Package] = true
+		}
+	}
+	fmt.Println("  renamed symbols:", len(ws.Renames))
+	fmt.Println("  moved packages:", len(moved))
+	if len(ws.FileNames) > 0 {
+		fmt.Println("  renamed files:", len(ws.FileNames))
+	}
+	if ws.Strings != nil {
+		fmt.Println("  encrypted strings:", ws.Strings.Obfuscated, "("+fmt.Sprint(len(ws.Strings.Plaintext)),
+			"left in plaintext)")
+		if ws.Strings.Numbers > 0 {
+			fmt.Println("  replaced numbers:", ws.Strings.Numbers)
+		}
+	}
+	if len(ws.ErrorMessages) > 0 {
+		fmt.Println("  replaced error messages:", len(ws.ErrorMessages))
+	}
+
+	fmt.Println("Changed packages:")
+	var totalAdded, totalRemoved, numPkgs int
+	for i := 0; i < len(changes); {
+		pkg := changes[i].Package
+		var newPkg string
+		var changed, added, removed, addedLines, removedLines int
+		for ; i < len(changes) && changes[i].Package == pkg; i++ {
+			change := changes[i]
+			if change.Old == "" {
+				added++
+			} else if change.New == "" {
+				removed++
+			} else {
+				changed++
+			}
+			if change.New != "" && newPkg == "" {
+				newPkg = path.Dir(change.New)
+			}
+			addedLines += change.Added
+			removedLines += change.Removed
+		}
+		name := pkg
+		if newPkg != "" && newPkg != pkg {
+			name += " -> " + newPkg
+		}
+		fmt.Printf("  %s: %d file(s) changed, %d added, %d removed (+%d -%d)\n", name, changed, added, removed,
+			addedLines, removedLines)
+		totalAdded += addedLines
+		totalRemoved += removedLines
+		numPkgs++
+	}
+	fmt.Printf("%d file(s) in %d package(s) (+%d -%d)\n", len(changes), numPkgs, totalAdded, totalRemoved)
+}
@@ -0,0 +1,68 @@
+package obfuscate
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// stripELFNotes removes compiler and linker fingerprints
+// (.note.* and .comment sections) from an ELF binary.
+// If gnuVersion is set, .gnu.version* sections are removed
+// as well; these are only meaningful for dynamic linking.
+//
+// The removal itself is done by objcopy, which can be
+// overridden per target with OBJCOPY_goos_goarch.
+func stripELFNotes(path, goos, goarch string, gnuVersion bool) error {
+	sections, err := elfFingerprintSections(path, gnuVersion)
+	if err != nil || len(sections) == 0 {
+		return err
+	}
+	objcopy := os.Getenv("OBJCOPY_" + goos + "_" + goarch)
+	if objcopy == "" {
+		objcopy = "objcopy"
+	}
+	var args []string
+	for _, name := range sections {
+		args = append(args, "--remove-section", name)
+	}
+	args = append(args, path)
+	cmd := exec.Command(objcopy, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// elfFingerprintSections lists the sections of an ELF
+// binary which stripELFNotes should remove.
+func elfFingerprintSections(path string, gnuVersion bool) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dynamic := false
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP || prog.Type == elf.PT_DYNAMIC {
+			dynamic = true
+		}
+	}
+
+	var res []string
+	for _, sec := range f.Sections {
+		switch {
+		case sec.Name == ".comment":
+			res = append(res, sec.Name)
+		case sec.Name == ".note.gnu.property":
+			// The kernel parses this note to enable
+			// CPU features like IBT and BTI.
+		case strings.HasPrefix(sec.Name, ".note."):
+			res = append(res, sec.Name)
+		case gnuVersion && !dynamic && strings.HasPrefix(sec.Name, ".gnu.version"):
+			res = append(res, sec.Name)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,545 @@
+package obfuscate
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// encryptEmbeds enables EncryptEmbeds.
+var encryptEmbeds bool
+
+// An embedKind is the type of a variable which a //go:embed
+// directive initializes.
+type embedKind int
+
+const (
+	embedString embedKind = iota
+	embedBytes
+	embedFS
+)
+
+// An embedVar is a package-level variable with a //go:embed
+// directive.
+type embedVar struct {
+	Path    string
+	PkgPath string
+	Name    string
+	Kind    embedKind
+	Test    bool
+
+	// NameStart and NameEnd are the offsets of the name in
+	// the file, and the declaration which decrypts the
+	// variable is inserted at Insert, in the var block of
+	// the variable if Grouped.
+	NameStart int
+	NameEnd   int
+	Insert    int
+	Grouped   bool
+
+	// Files are the embedded files, relative to the
+	// directory of the package, with slashes, which is what
+	// an embed.FS names them.
+	Files []string
+}
+
+// An embedFile is a Go file which declares variables with
+// //go:embed directives.
+type embedFile struct {
+	Vars []*embedVar
+
+	// UsesFS is set if the file mentions embed.FS other
+	// than as the type of an embedded variable.
+	UsesFS  bool
+	Imports []string
+
+	// PackageEnd is the offset of the end of the package
+	// clause, where the helper can be imported.
+	PackageEnd int
+}
+
+// EncryptEmbeds encrypts the files which the packages of a
+// GOPATH embed with //go:embed directives, and makes the
+// variables they are embedded in decrypt them at runtime,
+// with the helper package. Packages for which include
+// returns false are skipped.
+//
+// The files are encrypted in place with AES-CTR, with a key
+// for every package, and an IV derived from the name of the
+// file. A string or []byte variable gets a hidden name, and
+// the original variable is initialized with the decrypted
+// contents. An embed.FS variable becomes a helper type
+// with the same methods, which decrypts files as they are
+// read, and which can only be used where an fs.FS can, so
+// packages which mention embed.FS elsewhere, or import a
+// package which does, keep their file systems in plaintext.
+// It returns the number of files which were encrypted.
+func EncryptEmbeds(gopath string, moves PackageMoves, include func(string) bool,
+	helper *stringHelper) (int, error) {
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every package is scanned, so that files which packages
+	// that are not obfuscated embed as well stay readable.
+	parsed := map[string]*embedFile{}
+	fsUsers := map[string]bool{}
+	pkgImports := map[string][]string{}
+	claims := map[string]map[string]bool{}
+	for _, f := range files {
+		contents, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Contains(contents, []byte("embed")) {
+			continue
+		}
+		file, err := scanEmbedFile(f, contents)
+		if err != nil || file == nil {
+			// Files which cannot be parsed are left for the
+			// build to report.
+			continue
+		}
+		parsed[f.Path] = file
+		if file.UsesFS {
+			fsUsers[f.PkgPath] = true
+		}
+		pkgImports[f.PkgPath] = append(pkgImports[f.PkgPath], file.Imports...)
+		if !include(f.PkgPath) && len(file.Vars) > 0 && file.importsHelper(helper) {
+			// Dependencies from the -depcache were encrypted
+			// in an earlier run.
+			helper.lock.Lock()
+			helper.Embeds = true
+			helper.lock.Unlock()
+		}
+		for _, v := range file.Vars {
+			owner := v.PkgPath
+			if v.Test {
+				owner += " test"
+			}
+			for _, name := range v.Files {
+				abs := filepath.Join(filepath.Dir(v.Path), filepath.FromSlash(name))
+				if claims[abs] == nil {
+					claims[abs] = map[string]bool{}
+				}
+				claims[abs][owner] = true
+			}
+		}
+	}
+
+	var count int
+	encrypted := map[string]bool{}
+	keys := map[string][]byte{}
+	pkgNames := map[string]map[string]bool{}
+	for _, f := range files {
+		file := parsed[f.Path]
+		if file == nil || !include(f.PkgPath) {
+			continue
+		}
+		var edits []fileEdit
+		for _, v := range file.Vars {
+			if v.Test || len(v.Files) == 0 {
+				continue
+			}
+			if reason := embedSkipReason(v, claims, fsUsers, pkgImports[v.PkgPath]); reason != "" {
+				log.Println("Warning: leaving the files of", moves.Original(v.PkgPath)+"."+v.Name,
+					"in plaintext:", reason)
+				continue
+			}
+			key := keys[v.PkgPath]
+			if key == nil {
+				key = randomBytes(newKeyRand("embed", []byte(v.PkgPath)), 32)
+				keys[v.PkgPath] = key
+			}
+			dir := filepath.Dir(v.Path)
+			for _, name := range v.Files {
+				abs := filepath.Join(dir, filepath.FromSlash(name))
+				if encrypted[abs] {
+					continue
+				}
+				if err := encryptEmbeddedFile(abs, name, key); err != nil {
+					return 0, err
+				}
+				encrypted[abs] = true
+				count++
+			}
+
+			if pkgNames[v.PkgPath] == nil {
+				if pkgNames[v.PkgPath], err = packageNames(filepath.Dir(v.Path)); err != nil {
+					return 0, err
+				}
+			}
+			hidden := newEmbedName(pkgNames[v.PkgPath], v.Name)
+			expr := helper.EmbedExpr(v.Kind, hidden, key, v.Files[0])
+			decl := "\nvar " + v.Name + " = " + expr
+			if v.Grouped {
+				decl = "\n" + v.Name + " = " + expr
+			}
+			edits = append(edits,
+				fileEdit{Start: v.NameStart, End: v.NameEnd, Text: hidden},
+				fileEdit{Start: v.Insert, End: v.Insert, Text: decl})
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		if !file.importsHelper(helper) {
+			edits = append(edits, fileEdit{Start: file.PackageEnd, End: file.PackageEnd, Text: "\n" + helper.ImportDecl()})
+		}
+		if err := applyFileEdits(f.Path, edits); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// scanEmbedFile finds the variables with //go:embed
+// directives in a Go file, and the files they embed.
+// It returns nil if the file does not import "embed".
+func scanEmbedFile(f sourceFile, contents []byte) (*embedFile, error) {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, f.Path, contents, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var embedName string
+	res := &embedFile{PackageEnd: set.Position(file.Name.End()).Offset}
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		res.Imports = append(res.Imports, importPath)
+		if importPath == "embed" {
+			embedName = "embed"
+			if spec.Name != nil {
+				embedName = spec.Name.Name
+			}
+		}
+	}
+	if embedName == "" {
+		return nil, nil
+	}
+
+	dir := filepath.Dir(f.Path)
+	types := map[ast.Expr]bool{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			doc := spec.Doc
+			if gen.Lparen == token.NoPos {
+				doc = gen.Doc
+			}
+			patterns, ok := embedPatterns(doc)
+			if !ok || gen.Tok != token.VAR || len(spec.Names) != 1 || len(spec.Values) > 0 {
+				continue
+			}
+			kind, ok := embedVarKind(spec.Type, embedName)
+			if !ok {
+				continue
+			}
+			types[spec.Type] = true
+			v := &embedVar{
+				Path:      f.Path,
+				PkgPath:   f.PkgPath,
+				Name:      spec.Names[0].Name,
+				Kind:      kind,
+				Test:      strings.HasSuffix(f.Path, "_test.go"),
+				NameStart: set.Position(spec.Names[0].Pos()).Offset,
+				NameEnd:   set.Position(spec.Names[0].End()).Offset,
+				Insert:    set.Position(gen.End()).Offset,
+				Grouped:   gen.Lparen != token.NoPos,
+			}
+			if v.Grouped {
+				v.Insert = set.Position(spec.End()).Offset
+			}
+			if v.Files, err = resolveEmbedPatterns(dir, patterns); err != nil {
+				return nil, err
+			}
+			if kind != embedFS && len(v.Files) != 1 {
+				continue
+			}
+			res.Vars = append(res.Vars, v)
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if expr, ok := n.(ast.Expr); ok && types[expr] {
+			return false
+		}
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == "FS" {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == embedName {
+				res.UsesFS = true
+			}
+		}
+		return true
+	})
+	return res, nil
+}
+
+func (e *embedFile) importsHelper(helper *stringHelper) bool {
+	for _, importPath := range e.Imports {
+		if importPath == helper.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// embedPatterns gets the patterns of the //go:embed
+// directives in the doc comment of a variable.
+func embedPatterns(doc *ast.CommentGroup) ([]string, bool) {
+	if doc == nil {
+		return nil, false
+	}
+	var res []string
+	var found bool
+	for _, comment := range doc.List {
+		if !strings.HasPrefix(comment.Text, "//go:embed ") && !strings.HasPrefix(comment.Text, "//go:embed\t") {
+			continue
+		}
+		found = true
+		patterns, err := splitEmbedPatterns(comment.Text[len("//go:embed "):])
+		if err != nil {
+			return nil, false
+		}
+		res = append(res, patterns...)
+	}
+	return res, found
+}
+
+// splitEmbedPatterns splits the arguments of a //go:embed
+// directive, which are separated by spaces, and may be
+// quoted like Go strings.
+func splitEmbedPatterns(args string) ([]string, error) {
+	var res []string
+	for {
+		args = strings.TrimLeftFunc(args, unicode.IsSpace)
+		if args == "" {
+			return res, nil
+		}
+		if args[0] != '"' && args[0] != '`' {
+			i := strings.IndexFunc(args, unicode.IsSpace)
+			if i < 0 {
+				i = len(args)
+			}
+			res = append(res, args[:i])
+			args = args[i:]
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+		}
+		pattern, _ := strconv.Unquote(quoted)
+		res = append(res, pattern)
+		args = args[len(quoted):]
+	}
+}
+
+// embedVarKind checks if a variable type can be embedded,
+// and encrypted.
+func embedVarKind(expr ast.Expr, embedName string) (embedKind, bool) {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if expr.Name == "string" {
+			return embedString, true
+		}
+	case *ast.ArrayType:
+		if elem, ok := expr.Elt.(*ast.Ident); ok && expr.Len == nil && elem.Name == "byte" {
+			return embedBytes, true
+		}
+	case *ast.SelectorExpr:
+		if x, ok := expr.X.(*ast.Ident); ok && x.Name == embedName && expr.Sel.Name == "FS" {
+			return embedFS, true
+		}
+	}
+	return 0, false
+}
+
+// resolveEmbedPatterns lists the files which patterns match
+// in a package directory, the way the go command does:
+// directories are embedded with the files under them,
+// other than those whose names begin with "." or "_",
+// unless the pattern has an "all:" prefix, and other than
+// those in nested modules.
+func resolveEmbedPatterns(dir string, patterns []string) ([]string, error) {
+	var res []string
+	seen := map[string]bool{}
+	add := func(file string) error {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); !seen[rel] {
+			seen[rel] = true
+			res = append(res, rel)
+		}
+		return nil
+	}
+	for _, pattern := range patterns {
+		all := strings.HasPrefix(pattern, "all:")
+		pattern = strings.TrimPrefix(pattern, "all:")
+		if _, err := path.Match(pattern, ""); err != nil || strings.HasPrefix(pattern, "/") {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if err := add(match); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			err = filepath.Walk(match, func(file string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if file == match {
+					return nil
+				}
+				name := info.Name()
+				hidden := strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+				if info.IsDir() {
+					if hidden && !all {
+						return filepath.SkipDir
+					}
+					if _, err := os.Stat(filepath.Join(file, "go.mod")); err == nil {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if (hidden && !all) || !info.Mode().IsRegular() {
+					return nil
+				}
+				return add(file)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// embedSkipReason is why the files of a variable cannot be
+// encrypted, or "" if they can.
+func embedSkipReason(v *embedVar, claims map[string]map[string]bool, fsUsers map[string]bool,
+	imports []string) string {
+	dir := filepath.Dir(v.Path)
+	for _, name := range v.Files {
+		if len(claims[filepath.Join(dir, filepath.FromSlash(name))]) > 1 {
+			return name + " is also embedded by tests or another package"
+		}
+	}
+	if v.Kind != embedFS {
+		return ""
+	}
+	if fsUsers[v.PkgPath] {
+		return "the package uses embed.FS as a type"
+	}
+	for _, importPath := range imports {
+		if fsUsers[importPath] {
+			return "it imports " + importPath + ", which uses embed.FS as a type"
+		}
+	}
+	return ""
+}
+
+// packageNames lists the names declared at the top level
+// of the files in a package directory.
+func packageNames(dir string) (map[string]bool, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]bool{}
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, item.Name()), nil, 0)
+		if err != nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					res[decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							res[name.Name] = true
+						}
+					case *ast.TypeSpec:
+						res[spec.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// newEmbedName picks an unexported name for the embedded
+// variable which another variable is decrypted from.
+func newEmbedName(names map[string]bool, name string) string {
+	base := "embedded" + strings.ToUpper(name[:1]) + name[1:]
+	res := base
+	for i := 2; names[res]; i++ {
+		res = base + strconv.Itoa(i)
+	}
+	names[res] = true
+	return res
+}
+
+// encryptEmbeddedFile encrypts a file in place, the way
+// the helper decrypts it.
+func encryptEmbeddedFile(path, name string, key []byte) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, embedCrypt(data, key, name), info.Mode())
+}
+
+// embedCrypt encrypts or decrypts an embedded file with
+// AES-CTR, with an IV derived from its name.
+func embedCrypt(data, key []byte, name string) []byte {
+	iv := sha256.Sum256([]byte(name))
+	block, _ := aes.NewCipher(key)
+	res := make([]byte, len(data))
+	cipher.NewCTR(block, iv[:aes.BlockSize]).XORKeyStream(res, data)
+	return res
+}
@@ -0,0 +1,46 @@
+package obfuscate
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunEmbedsGOPATH(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	const secret = "the embedded launch codes"
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/app/main.go": "package main\n\nimport _ \"embed\"\n\n" +
+			"//go:embed data/codes.txt\nvar codes string\n\n" +
+			"func main() {\n\tprintln(len(codes))\n}\n",
+		"example.com/app/data/codes.txt": secret,
+	})
+	defer func(old string) {
+		build.Default.GOPATH = old
+	}(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+	t.Setenv("GOPATH", gopath)
+	t.Setenv("GO111MODULE", "off")
+
+	out := filepath.Join(t.TempDir(), "app")
+	if err := Run(Options{PkgName: "example.com/app", OutPath: out, Embeds: true, Padding: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command(out).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s\n%s", err, output)
+	} else if string(output) != "25\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	} else if bytes.Contains(data, []byte(secret)) {
+		t.Error("the binary contains the embedded text")
+	}
+}
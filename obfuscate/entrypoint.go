@@ -0,0 +1,101 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkEntrypoint verifies that the package which is built
+// is still a main package with a single func main for
+// every target, once it has been moved and renamed, so
+// that mistakes are reported with the original paths
+// rather than as errors of the go command.
+// Plugins need no func main, and build modes which do not
+// need a main package are not checked.
+func checkEntrypoint(gopath, pkgName string, moves PackageMoves) []string {
+	switch buildMode {
+	case "", "exe", "pie", "c-shared", "c-archive", "plugin":
+	default:
+		return nil
+	}
+	newPkg := pkgName
+	if !preservePackageName {
+		newPkg = moves.Obfuscated(pkgName)
+	}
+	name := describeMoved(moves, newPkg)
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(newPkg))
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return []string{name + " is missing from the workspace"}
+	}
+
+	var problems []string
+	seen := map[string]bool{}
+	report := func(problem string) {
+		if !seen[problem] {
+			seen[problem] = true
+			problems = append(problems, problem)
+		}
+	}
+	for _, target := range buildTargets() {
+		ctx := gopathContext(gopath)
+		ctx.GOOS, ctx.GOARCH = target.GOOS, target.GOARCH
+		ctx.CgoEnabled = targetCGO(target) == "1"
+		ctx.BuildTags = strings.Split(targetTags(target), ",")
+		pkg, err := ctx.ImportDir(dir, 0)
+		if multiple, ok := err.(*build.MultiplePackageError); ok {
+			report(fmt.Sprintf("%s declares packages %s in %s", name, strings.Join(multiple.Packages, " and "),
+				strings.Join(multiple.Files, " and ")))
+			continue
+		} else if _, ok := err.(*build.NoGoError); ok {
+			report(fmt.Sprintf("%s has no Go files for %s", name, target))
+			continue
+		} else if err != nil {
+			report(fmt.Sprintf("%s cannot be loaded: %s", name, err))
+			continue
+		}
+		if pkg.Name != "main" {
+			report(fmt.Sprintf("%s is package %s, not main", name, pkg.Name))
+			continue
+		}
+		if buildMode == "plugin" {
+			continue
+		}
+		files, err := mainFuncFiles(dir, append(pkg.GoFiles, pkg.CgoFiles...))
+		if err != nil {
+			report(fmt.Sprintf("%s cannot be parsed: %s", name, err))
+		} else if len(files) == 0 {
+			report(fmt.Sprintf("%s has no func main for %s", name, target))
+		} else if len(files) > 1 {
+			report(fmt.Sprintf("%s declares func main more than once for %s, in %s", name, target,
+				strings.Join(files, " and ")))
+		}
+	}
+	return problems
+}
+
+// mainFuncFiles lists the files which declare func main,
+// once for each declaration.
+func mainFuncFiles(dir string, names []string) ([]string, error) {
+	sort.Strings(names)
+	var res []string
+	for _, name := range names {
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" {
+				res = append(res, name)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,16 @@
+package obfuscate
+
+// stringsMode decides how long decoded strings are kept.
+// With "ephemeral", every literal is decoded by a function
+// of its own each time it is used, as with -lazystrings
+// nocache, and no literal is decoded into a package-level
+// variable, not even encrypted ones in switch cases and
+// map literal keys. The buffers which held the plaintext
+// and the key are wiped once the string has been made.
+var stringsMode string
+
+// ephemeralStrings checks if decoded strings are wiped
+// and never kept in package-level variables.
+func ephemeralStrings() bool {
+	return stringsMode == "ephemeral"
+}
@@ -0,0 +1,171 @@
+package obfuscate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errorCodes enables the pass which replaces error
+// messages with codes.
+var errorCodes bool
+
+// errorCodeDigits is the number of hex digits in an error
+// code, unless codes collide.
+const errorCodeDigits = 6
+
+// An errorCodeTable assigns codes to error messages.
+type errorCodeTable struct {
+	n    NameHasher
+	lock sync.Mutex
+
+	// Messages maps codes to the messages they replaced.
+	Messages map[string]string
+	codes    map[string]string
+}
+
+// NormalizeErrors replaces the messages passed to
+// errors.New and fmt.Errorf in the packages of a GOPATH for
+// which include returns true with short codes, like
+// "E3fa9c1" or "E3fa9c1 %s: %w" (keeping the verbs), and
+// returns the messages of the codes.
+//
+// Codes are derived from the padding, so a message gets
+// the same code in every build with the same padding.
+func NormalizeErrors(gopath string, n NameHasher, include func(string) bool) (map[string]string, error) {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return nil, err
+	}
+	table := &errorCodeTable{n: n, Messages: map[string]string{}, codes: map[string]string{}}
+	err = forEachFile(files, func(f sourceFile) error {
+		return normalizeFileErrors(f.Path, table)
+	})
+	return table.Messages, err
+}
+
+// Code gets the code of a message, assigning it one if it
+// has none yet.
+func (e *errorCodeTable) Code(message string) string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if code, ok := e.codes[message]; ok {
+		return code
+	}
+	sum := sha256.Sum256(append(append([]byte{}, e.n...), "error#"+message...))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for digits := errorCodeDigits; ; digits++ {
+		code := "E" + hash[:digits]
+		if _, taken := e.Messages[code]; !taken {
+			e.Messages[code] = message
+			e.codes[message] = code
+			return code
+		}
+	}
+}
+
+func normalizeFileErrors(path string, table *errorCodeTable) error {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, nil, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	var errorsName, fmtName string
+	for _, spec := range file.Imports {
+		name := ""
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		switch spec.Path.Value {
+		case `"errors"`:
+			if name == "" {
+				name = "errors"
+			}
+			errorsName = name
+		case `"fmt"`:
+			if name == "" {
+				name = "fmt"
+			}
+			fmtName = name
+		}
+	}
+	if errorsName == "" && fmtName == "" {
+		return nil
+	}
+
+	skipped := findSkippedCode(file)
+	var edits []fileEdit
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 || skipped.Contains(call) {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Obj != nil {
+			return true
+		}
+		isFormat := x.Name == fmtName && sel.Sel.Name == "Errorf"
+		if !isFormat && (x.Name != errorsName || sel.Sel.Name != "New") {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		message, err := strconv.Unquote(lit.Value)
+		if err != nil || message == "" {
+			return true
+		}
+		code := table.Code(message)
+		if isFormat {
+			if verbs := formatVerbs(message); len(verbs) > 0 {
+				code += " " + strings.Join(verbs, " ")
+			}
+		}
+		edits = append(edits, fileEdit{
+			Start: set.Position(lit.Pos()).Offset,
+			End:   set.Position(lit.End()).Offset,
+			Text:  strconv.Quote(code),
+		})
+		return true
+	})
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyFileEdits(path, edits)
+}
+
+// formatVerbs lists the verbs of a format string, with
+// their flags, widths, precisions, and argument indexes,
+// so that a new format uses the same arguments.
+func formatVerbs(format string) []string {
+	var res []string
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		start := i
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if strings.IndexByte("+-# 0123456789.*[]", c) < 0 {
+				break
+			}
+		}
+		if i == len(format) {
+			break
+		}
+		if verb := format[start : i+1]; verb != "%%" {
+			res = append(res, verb)
+		}
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+package obfuscate
+
+import "strings"
+
+// A FlagError reports flags which have invalid values, or
+// which cannot be used together.
+type FlagError struct {
+	Message string
+}
+
+func (f *FlagError) Error() string {
+	return f.Message
+}
+
+// flagError creates a FlagError.
+func flagError(message string) error {
+	return &FlagError{Message: message}
+}
+
+// A StepError reports a step of a run which failed, like
+// copying the sources, one of the passes, or writing one of
+// the outputs.
+type StepError struct {
+	// Step says what failed, like "obfuscate strings".
+	Step string
+	Err  error
+}
+
+func (s *StepError) Error() string {
+	return "Failed to " + s.Step + ": " + s.Err.Error()
+}
+
+func (s *StepError) Unwrap() error {
+	return s.Err
+}
+
+// stepError creates a StepError.
+func stepError(step string, err error) error {
+	return &StepError{Step: step, Err: err}
+}
+
+// A CheckError reports the problems found by a check which
+// fails a run, like the unportable paths of a workspace, or
+// the blocked patterns in a binary.
+type CheckError struct {
+	// Kind names the kind of problem, like "Unportable
+	// path".
+	Kind string
+
+	// Problems describe each problem. Further lines give
+	// details, like where a problem may come from.
+	Problems []string
+}
+
+func (c *CheckError) Error() string {
+	lines := make([]string, len(c.Problems))
+	for i, problem := range c.Problems {
+		lines[i] = c.Kind + ": " + problem
+	}
+	return strings.Join(lines, "\n")
+}
+
+// A TargetError is the error of the build of one target.
+type TargetError struct {
+	GOOS   string
+	GOARCH string
+	Err    error
+}
+
+func (t *TargetError) Error() string {
+	return "[" + t.GOOS + "/" + t.GOARCH + "] " + t.Err.Error()
+}
+
+func (t *TargetError) Unwrap() error {
+	return t.Err
+}
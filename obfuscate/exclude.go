@@ -0,0 +1,54 @@
+package obfuscate
+
+import "strings"
+
+// excludeFlags lists the packages to copy without
+// obfuscating them, as import paths or prefixes separated
+// by commas.
+var excludeFlags listFlag
+
+// excludedPaths are the parsed -exclude import paths.
+var excludedPaths []string
+
+// setupExclude parses the -exclude flags.
+// A trailing "/..." is allowed, since every path already
+// matches the packages under it.
+func setupExclude() error {
+	for _, value := range excludeFlags {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSuffix(strings.TrimSpace(item), "/...")
+			if item == "" {
+				continue
+			}
+			if strings.ContainsAny(item, " \t") || strings.HasPrefix(item, "/") {
+				return flagError("Invalid -exclude import path: " + item)
+			}
+			excludedPaths = append(excludedPaths, item)
+		}
+	}
+	return nil
+}
+
+// isExcluded checks if a package, by its original import
+// path, is one of the excluded packages or under one.
+func isExcluded(importPath string) bool {
+	for _, prefix := range excludedPaths {
+		if importPath == prefix || strings.HasPrefix(importPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsExcluded checks if a package is excluded, or has
+// excluded packages under it, by their original import
+// paths, so that it must not be moved.
+func containsExcluded(importPath string) bool {
+	for _, prefix := range excludedPaths {
+		if importPath == prefix || strings.HasPrefix(prefix, importPath+"/") ||
+			strings.HasPrefix(importPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
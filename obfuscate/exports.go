@@ -0,0 +1,311 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// exportNameFlags are the -exportname flags, which give
+// the //export functions of c-shared builds new names, as
+// Orig=New pairs separated by commas.
+var exportNameFlags listFlag
+
+// exportNames maps the original names of //export
+// functions to their new names.
+var exportNames map[string]string
+
+// setupExportNames parses the -exportname flags.
+func setupExportNames() error {
+	exportNames = map[string]string{}
+	newNames := map[string]bool{}
+	for _, value := range exportNameFlags {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			idx := strings.Index(item, "=")
+			if idx < 0 || !token.IsIdentifier(item[:idx]) || !token.IsIdentifier(item[idx+1:]) {
+				return flagError("Invalid -exportname (expected Orig=New): " + item)
+			}
+			orig, newName := item[:idx], item[idx+1:]
+			if _, ok := exportNames[orig]; ok || newNames[newName] {
+				return flagError("Duplicate -exportname: " + item)
+			}
+			exportNames[orig] = newName
+			newNames[newName] = true
+		}
+	}
+	if len(exportNames) > 0 && buildMode != "c-shared" {
+		return flagError("The -exportname flag requires -buildmode c-shared.")
+	}
+	return nil
+}
+
+// sharedBuild checks if the build makes a library for C
+// programs rather than an executable.
+func sharedBuild() bool {
+	return buildMode == "c-shared" || buildMode == "c-archive"
+}
+
+// exportDirective matches the //export comments of cgo
+// files, which name the functions to export to C.
+var exportDirective = regexp.MustCompile(`^//export ([\p{L}_][\p{L}\p{N}_]*)\s*$`)
+
+// cSourceExts are the extensions of the C and C++ files
+// which may call the exported functions of a package.
+var cSourceExts = map[string]bool{
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".cxx": true,
+	".hh": true, ".hpp": true, ".hxx": true, ".m": true, ".mm": true,
+}
+
+// RenameExports renames the //export functions of the
+// packages of a GOPATH by names, which maps their original
+// names to their new names. Since cgo exports a function
+// by its Go name, the function is renamed along with its
+// uses in its package, and with the calls in the package's
+// C code and cgo preambles.
+// It returns the renames, like ObfuscateSymbols does.
+//
+// Every name must be exported by some package, and the
+// new names must not be taken.
+func RenameExports(gopath string, names map[string]string) ([]symbolRenameReq, error) {
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []sourceFile
+	seen := map[string]bool{}
+	for _, f := range files {
+		dir := filepath.Dir(f.Path)
+		if !seen[dir] && !strings.HasSuffix(f.Path, "_test.go") {
+			seen[dir] = true
+			dirs = append(dirs, sourceFile{Path: dir, PkgPath: f.PkgPath})
+		}
+	}
+	var res []symbolRenameReq
+	found := map[string]bool{}
+	for _, dir := range dirs {
+		renamed, err := renamePackageExports(dir.Path, names)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", dir.PkgPath, err)
+		}
+		for _, orig := range renamed {
+			found[orig] = true
+			res = append(res, symbolRenameReq{
+				OldName: "\"" + dir.PkgPath + "\"." + orig,
+				NewName: names[orig],
+			})
+		}
+	}
+	var missing []string
+	for orig := range names {
+		if !found[orig] {
+			missing = append(missing, orig)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("no //export function named %s", strings.Join(missing, ", "))
+	}
+	return res, nil
+}
+
+// renamePackageExports renames the //export functions of a
+// package directory, and returns their original names.
+// Every file is renamed, whatever its build constraints.
+func renamePackageExports(dir string, names map[string]string) ([]string, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type parsedFile struct {
+		Path string
+		File *ast.File
+		Set  *token.FileSet
+	}
+	var goFiles []parsedFile
+	var cFiles []string
+	exported := map[string]bool{}
+	declared := map[string]bool{}
+	for _, item := range listing {
+		path := filepath.Join(dir, item.Name())
+		if item.IsDir() {
+			continue
+		} else if cSourceExts[filepath.Ext(item.Name())] {
+			cFiles = append(cFiles, path)
+			continue
+		} else if !isGoFile(item.Name()) || strings.HasSuffix(item.Name(), "_test.go") {
+			continue
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		goFiles = append(goFiles, parsedFile{path, file, set})
+		for name := range file.Scope.Objects {
+			declared[name] = true
+		}
+		if !importsC(file) {
+			continue
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Doc == nil || names[fn.Name.Name] == "" {
+				continue
+			}
+			for _, comment := range fn.Doc.List {
+				if m := exportDirective.FindStringSubmatch(comment.Text); m != nil && m[1] == fn.Name.Name {
+					exported[fn.Name.Name] = true
+				}
+			}
+		}
+	}
+	if len(exported) == 0 {
+		return nil, nil
+	}
+	var res []string
+	for orig := range exported {
+		if declared[names[orig]] {
+			return nil, fmt.Errorf("cannot rename export %s to %s, which is already declared", orig, names[orig])
+		}
+		res = append(res, orig)
+	}
+	sort.Strings(res)
+
+	for _, f := range goFiles {
+		edits := exportEdits(f.File, f.Set, exported, names)
+		if len(edits) > 0 {
+			if err := applyFileEdits(f.Path, edits); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, path := range cFiles {
+		if err := renameCExports(path, exported, names); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// exportEdits renames the exported functions of a file,
+// their //export comments, the identifiers which refer to
+// them, and the words in the cgo preamble which name them.
+func exportEdits(file *ast.File, set *token.FileSet, exported map[string]bool,
+	names map[string]string) []fileEdit {
+	var edits []fileEdit
+	rename := func(ident *ast.Ident) {
+		edits = append(edits, fileEdit{
+			Start: set.Position(ident.Pos()).Offset,
+			End:   set.Position(ident.End()).Offset,
+			Text:  names[ident.Name],
+		})
+	}
+
+	// Identifiers refer to the functions if they resolve
+	// to their declarations, or are left unresolved, in
+	// which case they are declared in another file.
+	// Keys of composite literals may resolve to them too,
+	// but functions cannot be keys, so those are fields.
+	unresolved := map[*ast.Ident]bool{}
+	for _, ident := range file.Unresolved {
+		unresolved[ident] = true
+	}
+	keys := map[*ast.Ident]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.CompositeLit); ok {
+			for _, elt := range lit.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.Ident); ok {
+						keys[key] = true
+					}
+				}
+			}
+		}
+		ident, ok := n.(*ast.Ident)
+		if !ok || !exported[ident.Name] || keys[ident] {
+			return true
+		}
+		if unresolved[ident] {
+			rename(ident)
+		} else if ident.Obj != nil && ident.Obj.Kind == ast.Fun {
+			if fn, ok := ident.Obj.Decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+				rename(ident)
+			}
+		}
+		return true
+	})
+
+	preamble := cgoPreamble(file)
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if m := exportDirective.FindStringSubmatch(comment.Text); m != nil && exported[m[1]] {
+				start := set.Position(comment.Pos()).Offset + len("//export ")
+				edits = append(edits, fileEdit{Start: start, End: start + len(m[1]), Text: names[m[1]]})
+			} else if group == preamble {
+				start := set.Position(comment.Pos()).Offset
+				for _, loc := range cWordLocations(comment.Text, exported) {
+					edits = append(edits, fileEdit{
+						Start: start + loc[0],
+						End:   start + loc[1],
+						Text:  names[comment.Text[loc[0]:loc[1]]],
+					})
+				}
+			}
+		}
+	}
+	return edits
+}
+
+// renameCExports renames the exported functions in a C
+// source file.
+func renameCExports(path string, exported map[string]bool, names map[string]string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	locs := cWordLocations(string(contents), exported)
+	if len(locs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	var last int
+	for _, loc := range locs {
+		buf.Write(contents[last:loc[0]])
+		buf.WriteString(names[string(contents[loc[0]:loc[1]])])
+		last = loc[1]
+	}
+	buf.Write(contents[last:])
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// cWordLocations finds the identifiers in C code which are
+// among some names.
+func cWordLocations(code string, names map[string]bool) [][]int {
+	var res [][]int
+	for i := 0; i < len(code); {
+		if !isIdentByte(code[i]) {
+			i++
+			continue
+		}
+		end := i
+		for end < len(code) && isIdentByte(code[end]) {
+			end++
+		}
+		if names[code[i:end]] {
+			res = append(res, []int{i, end})
+		}
+		i = end
+	}
+	return res
+}
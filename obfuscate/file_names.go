@@ -0,0 +1,219 @@
+package obfuscate
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// renameFiles enables RenameFiles.
+var renameFiles bool
+
+// RenameFiles gives the Go files of the packages in a
+// GOPATH for which include returns true names derived from
+// the padding, and shuffles the declarations in them, so
+// that neither file names like "c2_client.go" nor the
+// order of the code give away what it does.
+//
+// The new names sort in the same order as the old ones,
+// since the go command passes files to the compiler in
+// that order, which decides the order in which the
+// package-level variables and init functions of different
+// files are initialized. Suffixes like "_test.go" and
+// "_windows.go" are kept.
+//
+// It returns the new names of the files, by their original
+// paths, as "import/path/name.go".
+func RenameFiles(gopath string, moves PackageMoves, n NameHasher,
+	include func(string) bool) (map[string]string, error) {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]string{}
+	for _, pkg := range pkgs {
+		if !include(pkg) || isIgnoredPath(pkg) {
+			continue
+		}
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		names, err := renamePackageFiles(dir, moves.Original(pkg), n)
+		if err != nil {
+			return nil, err
+		}
+		for oldName, newName := range names {
+			res[moves.Original(pkg)+"/"+oldName] = newName
+			if err := shuffleDecls(filepath.Join(dir, newName)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// isIgnoredPath checks if the go command ignores the
+// directory of a package, like testdata.
+func isIgnoredPath(pkg string) bool {
+	for _, component := range strings.Split(pkg, "/") {
+		if component == "testdata" || strings.HasPrefix(component, "_") || strings.HasPrefix(component, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// renamePackageFiles renames the Go files of a directory,
+// returning their new names by their old ones.
+// Files are moved to temporary names first, so that a new
+// name never replaces a file which was not renamed yet.
+func renamePackageFiles(dir, origPkg string, n NameHasher) (map[string]string, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var oldNames, prefixes []string
+	for _, item := range listing {
+		name := item.Name()
+		if item.IsDir() || !isGoFile(name) || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		oldNames = append(oldNames, name)
+		prefixes = append(prefixes, strings.ToLower(n.Hash("file#"+origPkg+"/"+name)))
+	}
+	// Lowercase letters sort after the "." and "_" of the
+	// suffixes, so sorting the prefixes sorts the names.
+	sort.Strings(oldNames)
+	sort.Strings(prefixes)
+	res := map[string]string{}
+	for i, oldName := range oldNames {
+		// Names which collide are made longer, with a letter
+		// which also sorts after the suffixes.
+		for i > 0 && prefixes[i] <= prefixes[i-1] {
+			prefixes[i] += "z"
+		}
+		res[oldName] = prefixes[i] + goFileSuffix(oldName)
+	}
+	for i, oldName := range oldNames {
+		tmpName := ".gobfuscate-" + strconv.Itoa(i)
+		if err := os.Rename(filepath.Join(dir, oldName), filepath.Join(dir, tmpName)); err != nil {
+			return nil, err
+		}
+	}
+	for i, oldName := range oldNames {
+		tmpName := ".gobfuscate-" + strconv.Itoa(i)
+		if err := os.Rename(filepath.Join(dir, tmpName), filepath.Join(dir, res[oldName])); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// shuffleDecls puts the top-level declarations of a file,
+// other than imports, in a random order.
+// Each declaration keeps its doc comment, the comment at
+// the end of its last line, and any comments between it
+// and the declaration before it.
+// Package-level variables with values and init functions
+// keep their order, since it decides the order in which
+// they run when they do not depend on each other.
+// Files which cannot be parsed are left alone.
+func shuffleDecls(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, contents, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	offset := func(pos token.Pos) int {
+		return set.Position(pos).Offset
+	}
+
+	// Imports come before every other declaration.
+	header := offset(file.Name.End())
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			header = offset(gen.End())
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	if len(decls) < 2 {
+		return nil
+	}
+
+	var chunks [][]byte
+	var pinned []int
+	start := header
+	for i, decl := range decls {
+		end := offset(decl.End())
+		for _, group := range file.Comments {
+			if pos := offset(group.Pos()); pos >= end {
+				if !bytes.Contains(contents[end:pos], []byte("\n")) {
+					end = offset(group.End())
+				}
+				break
+			}
+		}
+		chunk := bytes.TrimLeft(contents[start:end], " \t\r\n;")
+		chunks = append(chunks, chunk)
+		start = end
+		if isPinnedDecl(decl) {
+			pinned = append(pinned, i)
+		}
+	}
+	rest := contents[start:]
+
+	order := newKeyRand("declarations", contents).Perm(len(decls))
+	var slots []int
+	isPinned := map[int]bool{}
+	for _, i := range pinned {
+		isPinned[i] = true
+	}
+	for slot, i := range order {
+		if isPinned[i] {
+			slots = append(slots, slot)
+		}
+	}
+	for j, slot := range slots {
+		order[slot] = pinned[j]
+	}
+
+	var buf bytes.Buffer
+	buf.Write(contents[:header])
+	for _, i := range order {
+		buf.WriteString("\n\n")
+		buf.Write(chunks[i])
+	}
+	buf.Write(rest)
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// isPinnedDecl checks if a declaration has to keep its
+// order relative to the other pinned declarations.
+func isPinnedDecl(decl ast.Decl) bool {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		return decl.Recv == nil && decl.Name.Name == "init"
+	case *ast.GenDecl:
+		if decl.Tok != token.VAR {
+			return false
+		}
+		for _, spec := range decl.Specs {
+			if len(spec.(*ast.ValueSpec).Values) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package obfuscate
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// fipsMode is "boringcrypto" or "fips140" to build
+// binaries whose cryptography is FIPS 140 validated, or ""
+// for ordinary builds.
+var fipsMode string
+
+// boringMarker is part of the names of the functions which
+// call into BoringCrypto, which are only linked when the
+// BoringCrypto module replaces Go's own cryptography.
+const boringMarker = "crypto/internal/boring._Cfunc__goboringcrypto_"
+
+// checkFIPSFlags checks that the -fips mode is known and
+// can be used for every target.
+func checkFIPSFlags() error {
+	switch fipsMode {
+	case "":
+		return nil
+	case "boringcrypto":
+		for _, target := range buildTargets() {
+			if target.GOOS != "linux" || (target.GOARCH != "amd64" && target.GOARCH != "arm64") {
+				return flagError("The -fips boringcrypto mode only supports linux/amd64 and linux/arm64, not " +
+					target.String() + ".")
+			}
+		}
+		return nil
+	case "fips140":
+		minor, err := goMinorVersion()
+		if err != nil {
+			return stepError("check Go version", err)
+		}
+		if minor < 24 {
+			return flagError(fmt.Sprintf("The -fips fips140 mode requires Go 1.24 or newer, not Go 1.%d.", minor))
+		}
+		return nil
+	}
+	return flagError("The -fips flag must be boringcrypto or fips140.")
+}
+
+// fipsEnv adds the settings for the -fips mode to the
+// environment of a build.
+// BoringCrypto is added to the experiments already set,
+// and a GOFIPS140 module version which is already set is
+// kept.
+func fipsEnv(env []string) []string {
+	switch fipsMode {
+	case "boringcrypto":
+		for i, v := range env {
+			if strings.HasPrefix(v, "GOEXPERIMENT=") {
+				env[i] = "GOEXPERIMENT=" + mergeTags(strings.TrimPrefix(v, "GOEXPERIMENT="), "boringcrypto")
+				return env
+			}
+		}
+		return append(env, "GOEXPERIMENT=boringcrypto")
+	case "fips140":
+		for _, v := range env {
+			if strings.HasPrefix(v, "GOFIPS140=") && v != "GOFIPS140=off" {
+				return env
+			}
+		}
+		return append(env, "GOFIPS140=latest")
+	}
+	return env
+}
+
+// fipsCGO decides whether cgo is enabled for a build,
+// since BoringCrypto is linked through cgo.
+func fipsCGO(cgo string) string {
+	if fipsMode == "boringcrypto" {
+		return "1"
+	}
+	return cgo
+}
+
+// verifyFIPS checks that a binary really uses the FIPS
+// cryptography of the -fips mode, and describes it.
+// It must run before the build info is stripped.
+func verifyFIPS(path string) (string, error) {
+	switch fipsMode {
+	case "boringcrypto":
+		var found bool
+		err := scanChunks(path, len(boringMarker), func(chunk []byte, atEOF bool) {
+			found = found || bytes.Contains(chunk, []byte(boringMarker))
+		})
+		if err != nil {
+			return "", err
+		} else if !found {
+			return "", errors.New("binary does not link BoringCrypto (it may not use the crypto packages)")
+		}
+		return "boringcrypto", nil
+	case "fips140":
+		info, err := buildinfo.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		var module string
+		var enabled bool
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "GOFIPS140":
+				module = setting.Value
+			case "DefaultGODEBUG":
+				for _, value := range strings.Split(setting.Value, ",") {
+					enabled = enabled || value == "fips140=on" || value == "fips140=only"
+				}
+			}
+		}
+		if module == "" || module == "off" || !enabled {
+			return "", errors.New("binary was not built in FIPS 140-3 mode")
+		}
+		return "fips140 (GOFIPS140=" + module + ")", nil
+	}
+	return "", nil
+}
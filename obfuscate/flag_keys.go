@@ -0,0 +1,207 @@
+package obfuscate
+
+import (
+	"errors"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// flagKeyFuncs lists the functions which look up feature
+// flags or config values by key, like the patterns of
+// -striplog.
+var flagKeyFuncs listFlag
+
+// flagKeyPatterns are the parsed -flagkeys patterns.
+var flagKeyPatterns []logPattern
+
+// setupFlagKeys parses the -flagkeys patterns.
+func setupFlagKeys() error {
+	for _, pattern := range flagKeyFuncs {
+		p, err := parseLogPattern(pattern)
+		if err == nil && p.Replacement != "" {
+			err = errors.New("cannot downgrade a lookup: " + pattern)
+		}
+		if err != nil {
+			return flagError("Invalid -flagkeys pattern: " + err.Error())
+		}
+		flagKeyPatterns = append(flagKeyPatterns, p)
+	}
+	return nil
+}
+
+// A flagKeyUse is a key passed to a lookup function.
+type flagKeyUse struct {
+	Start int
+	End   int
+	Key   string
+
+	// Type is the named string type which the key is
+	// converted to, if any, as written in its file.
+	Type string
+}
+
+// IndirectFlagKeys replaces the keys passed to the lookup
+// functions which match patterns, in the packages of a
+// GOPATH for which include returns true, with their
+// indices in a table of the helper package, which holds
+// the keys encoded and decodes one at a time.
+//
+// The key of a call is its first argument which is a
+// constant string, whether a literal or a named constant.
+// The packages are type-checked to find it, so calls in
+// files which are not built for the current platform keep
+// their keys, which are then obfuscated like any other
+// string.
+// It returns the number of calls whose keys were replaced.
+func IndirectFlagKeys(gopath string, patterns []logPattern, moves PackageMoves, include func(string) bool,
+	helper *stringHelper) (int, error) {
+	var moved []logPattern
+	for _, p := range patterns {
+		if p.Package != "" {
+			p.Package = moves.Obfuscated(p.Package)
+		}
+		moved = append(moved, p)
+	}
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return 0, err
+	}
+	names := newImportNames(gopath)
+
+	// Only the packages which call a lookup function are
+	// type-checked.
+	var pkgsLock sync.Mutex
+	pkgs := map[string]bool{}
+	err = forEachFile(files, func(f sourceFile) error {
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, 0)
+		if err != nil {
+			return err
+		}
+		if len(flagKeyCalls(file, fileImports(file, names), moved)) > 0 {
+			pkgsLock.Lock()
+			pkgs[f.PkgPath] = true
+			pkgsLock.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	imp := newSourceImporter(gopath)
+	uses := map[string][]flagKeyUse{}
+	// importEnds are the offsets of the ends of the package
+	// clauses of the files which must import the helper.
+	importEnds := map[string]int{}
+	imp.Visit = func(pkg *types.Package, files []*ast.File, info *types.Info) {
+		if !pkgs[pkg.Path()] {
+			return
+		}
+		for _, file := range files {
+			path := imp.set.Position(file.Package).Filename
+			imports := fileImports(file, names)
+			for _, call := range flagKeyCalls(file, imports, moved) {
+				if use, ok := flagKeyArg(imp.set, pkg, imports, info, call); ok {
+					uses[path] = append(uses[path], use)
+				}
+			}
+			if len(uses[path]) > 0 && !importsPath(file, helper.Path) {
+				importEnds[path] = imp.set.Position(file.Name.End()).Offset
+			}
+		}
+	}
+	for _, pkg := range sortedKeys(pkgs) {
+		// Packages which fail to type-check are checked as far
+		// as they can be.
+		imp.Import(pkg)
+	}
+
+	// The table is sorted, so that it does not depend on the
+	// order in which packages are checked.
+	keys := map[string]bool{}
+	for _, fileUses := range uses {
+		for _, use := range fileUses {
+			keys[use.Key] = true
+		}
+	}
+	table := sortedKeys(keys)
+	if len(table) == 0 {
+		return 0, nil
+	}
+	helper.SetFlagKeys(table)
+
+	var count int
+	for path, fileUses := range uses {
+		var edits []fileEdit
+		if end, ok := importEnds[path]; ok {
+			edits = append(edits, fileEdit{Start: end, End: end, Text: "\n" + helper.ImportDecl()})
+		}
+		for _, use := range fileUses {
+			expr := helper.Alias + "." + helper.FlagKey + "(" + strconv.Itoa(sort.SearchStrings(table, use.Key)) + ")"
+			if use.Type != "" {
+				expr = use.Type + "(" + expr + ")"
+			}
+			edits = append(edits, fileEdit{Start: use.Start, End: use.End, Text: expr})
+			count++
+		}
+		if err := applyFileEdits(path, edits); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// flagKeyCalls finds the calls to lookup functions in a
+// file.
+func flagKeyCalls(file *ast.File, imports []fileImport, patterns []logPattern) []*ast.CallExpr {
+	var res []*ast.CallExpr
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && matchCall(call, imports, patterns) != nil {
+			res = append(res, call)
+		}
+		return true
+	})
+	return res
+}
+
+// flagKeyArg finds the key of a call to a lookup function.
+// Keys of named string types which the file cannot refer
+// to are left alone.
+func flagKeyArg(set *token.FileSet, pkg *types.Package, imports []fileImport, info *types.Info,
+	call *ast.CallExpr) (flagKeyUse, bool) {
+	for _, arg := range call.Args {
+		tv, ok := info.Types[arg]
+		if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+			continue
+		}
+		use := flagKeyUse{
+			Start: set.Position(arg.Pos()).Offset,
+			End:   set.Position(arg.End()).Offset,
+			Key:   constant.StringVal(tv.Value),
+		}
+		named, ok := tv.Type.(*types.Named)
+		if !ok {
+			return use, true
+		}
+		obj := named.Obj()
+		if obj.Pkg() == pkg && obj.Parent() == pkg.Scope() {
+			use.Type = obj.Name()
+			return use, true
+		}
+		for _, imp := range imports {
+			if obj.Pkg() != nil && imp.Path == obj.Pkg().Path() && imp.Name != "_" && imp.Name != "." {
+				use.Type = imp.Name + "." + obj.Name()
+				return use, true
+			}
+		}
+		return flagKeyUse{}, false
+	}
+	return flagKeyUse{}, false
+}
@@ -0,0 +1,265 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/refactor/importgraph"
+)
+
+// CopyGopath creates a new Gopath with a copy of a package
+// and all of its dependencies.
+func CopyGopath(packageName, newGopath string, keepTests bool) error {
+	return copyGopathPackages([]string{packageName}, newGopath, keepTests)
+}
+
+// copyGopathPackages is like CopyGopath, but copies
+// several packages and their dependencies.
+func copyGopathPackages(packageNames []string, newGopath string, keepTests bool) error {
+	ctx := sourceContext()
+
+	allDeps := map[string]bool{}
+	for _, packageName := range packageNames {
+		rootPkg, err := ctx.Import(packageName, "", 0)
+		if err != nil {
+			return err
+		}
+
+		deps, err := findDeps(packageName, &ctx)
+		if err != nil {
+			return err
+		}
+
+		var pkgs []*build.Package
+		for dep := range deps {
+			allDeps[dep] = true
+			pkg, err := ctx.Import(dep, rootPkg.Dir, 0)
+			if err != nil {
+				return err
+			}
+			if !pkg.Goroot {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+		if err := copyProgress.Preflight(pkgs); err != nil {
+			return err
+		}
+
+		for _, pkg := range pkgs {
+			if copyProgress.Copied(pkg) {
+				continue
+			}
+			if err := copyProgress.Check(); err != nil {
+				return err
+			}
+			if err := copyDep(pkg, newGopath, keepTests); err != nil {
+				return err
+			}
+			if err := copyProgress.Record(pkg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !keepTests {
+		ctx = gopathContext(newGopath)
+		allDeps = map[string]bool{}
+		for _, packageName := range packageNames {
+			deps, err := findDeps(packageName, &ctx)
+			if err != nil {
+				return err
+			}
+			for dep := range deps {
+				allDeps[dep] = true
+			}
+		}
+	}
+
+	if err := removeUnusedPkgs(newGopath, allDeps); err != nil {
+		return err
+	}
+	return nil
+}
+
+func findDeps(packageName string, ctx *build.Context) (map[string]bool, error) {
+	forward, _, errs := importgraph.Build(ctx)
+	if _, ok := forward[packageName]; !ok {
+		if err, ok := errs[packageName]; ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("package %s not found", packageName)
+	}
+	return forward.Search(packageName), nil
+}
+
+func copyDep(pkg *build.Package, newGopath string, keepTests bool) error {
+	newPath := filepath.Join(newGopath, "src", pkg.ImportPath)
+	err := os.MkdirAll(newPath, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, list := range depFiles(pkg, keepTests) {
+		for _, file := range list {
+			src := filepath.Join(pkg.Dir, file)
+			dst := filepath.Join(newPath, file)
+			// Embedded files may be in subdirectories.
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			if err := copyFile(src, dst); err != nil {
+				return err
+			}
+		}
+	}
+
+	return localizeCgoPaths(pkg.Dir, newPath, pkg.CgoFiles)
+}
+
+// depFiles lists the files of a package which copyDep
+// copies.
+func depFiles(pkg *build.Package, keepTests bool) [][]string {
+	srcFiles := [][]string{
+		pkg.GoFiles,
+		pkg.CgoFiles,
+		pkg.CFiles,
+		pkg.CXXFiles,
+		pkg.MFiles,
+		pkg.HFiles,
+		pkg.FFiles,
+		pkg.SFiles,
+		pkg.SwigFiles,
+		pkg.SwigCXXFiles,
+		pkg.SysoFiles,
+		embeddedFiles(pkg.Dir, pkg.EmbedPatterns),
+		includedFiles(pkg.Dir),
+	}
+	if keepTests {
+		srcFiles = append(srcFiles, pkg.TestGoFiles, pkg.XTestGoFiles,
+			embeddedFiles(pkg.Dir, pkg.TestEmbedPatterns), embeddedFiles(pkg.Dir, pkg.XTestEmbedPatterns))
+	}
+	return srcFiles
+}
+
+// embeddedFiles lists the files in a package directory
+// which match the patterns of its //go:embed directives,
+// like the go tool does: a directory stands for the files
+// in it, other than those whose names start with . or _
+// (unless the pattern starts with "all:"), and other than
+// those in nested modules.
+// Patterns which match nothing are left for the build to
+// report.
+func embeddedFiles(dir string, patterns []string) []string {
+	files := map[string]bool{}
+	for _, pattern := range patterns {
+		all := strings.HasPrefix(pattern, "all:")
+		pattern = strings.TrimPrefix(pattern, "all:")
+		matches, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		for _, match := range matches {
+			filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				name := info.Name()
+				if path != match && !all && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if info.IsDir() {
+					if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil && path != dir {
+						return filepath.SkipDir
+					}
+				} else if info.Mode().IsRegular() {
+					rel, _ := filepath.Rel(dir, path)
+					files[rel] = true
+				}
+				return nil
+			})
+		}
+	}
+	return sortedKeys(files)
+}
+
+// includedFiles lists the files in a package directory
+// which the go tool does not build by themselves, but which
+// its other files may #include, like Objective-C++ files.
+func includedFiles(dir string) []string {
+	listing, _ := ioutil.ReadDir(dir)
+	var res []string
+	for _, item := range listing {
+		if !item.IsDir() && filepath.Ext(item.Name()) == ".mm" {
+			res = append(res, item.Name())
+		}
+	}
+	return res
+}
+
+func removeUnusedPkgs(gopath string, deps map[string]bool) error {
+	srcDir := filepath.Join(gopath, "src")
+	return filepath.Walk(srcDir, func(sub string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if isCgoDir(info.Name()) {
+			return filepath.SkipDir
+		}
+		if !containsDep(gopath, sub, deps) {
+			// Directories without Go files hold the
+			// embedded files of the packages above them.
+			if treeHasGoFiles(sub) {
+				os.RemoveAll(sub)
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// treeHasGoFiles checks if a directory or any directory
+// below it has Go files.
+func treeHasGoFiles(dir string) bool {
+	var res bool
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && isGoFile(info.Name()) {
+			res = true
+			return io.EOF
+		}
+		return nil
+	})
+	return res
+}
+
+func containsDep(gopath, dir string, deps map[string]bool) bool {
+	for dep := range deps {
+		depDir := filepath.Clean(filepath.Join(gopath, "src", dep))
+		if strings.HasPrefix(depDir, filepath.Clean(dir)) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyFile(src, dest string) error {
+	newFile, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+	oldFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+	_, err = io.Copy(newFile, oldFile)
+	return err
+}
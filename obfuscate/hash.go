@@ -0,0 +1,152 @@
+package obfuscate
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const hashedSymbolSize = 10
+
+// nameBits is roughly how much of a hash is kept in a
+// name, in either naming mode.
+const nameBits = 36
+
+var (
+	// namingMode is "hash" for names made of hex digits, or
+	// "words" for names made of dictionary words.
+	namingMode string
+
+	dictionaryPath string
+	dictionary     []string
+)
+
+var dictionaryWordExpr = regexp.MustCompile(`^[a-zA-Z]+$`)
+
+// setupNaming checks the naming mode and path style, and
+// loads the dictionary they use.
+func setupNaming() error {
+	if namingMode != "hash" && namingMode != "words" {
+		return flagError("The -naming flag must be hash or words.")
+	} else if pathStyle != "hash" && pathStyle != "mimic" {
+		return flagError("The -pathstyle flag must be hash or mimic.")
+	} else if dictionaryPath != "" && namingMode != "words" && pathStyle != "mimic" {
+		return flagError("The -dictionary flag requires -naming words or -pathstyle mimic.")
+	}
+	if namingMode != "words" && pathStyle != "mimic" {
+		return nil
+	}
+	if dictionaryPath == "" {
+		dictionary = defaultDictionary
+		return nil
+	}
+	data, err := ioutil.ReadFile(dictionaryPath)
+	if err != nil {
+		return stepError("load dictionary", err)
+	}
+	seen := map[string]bool{}
+	for _, word := range strings.Fields(string(data)) {
+		if !dictionaryWordExpr.MatchString(word) {
+			return flagError("Invalid dictionary word (only ASCII letters are allowed): " + word)
+		}
+		word = strings.ToLower(word)
+		if !seen[word] {
+			seen[word] = true
+			dictionary = append(dictionary, word)
+		}
+	}
+	if len(dictionary) < 16 {
+		return flagError("The dictionary must have at least 16 different words.")
+	}
+	return nil
+}
+
+// A NameHasher is added to the input of a hash function
+// to make it 'impossible' to find the input value
+type NameHasher []byte
+
+// Hash hashes the padding + token.
+// The case of the first letter of the token is preserved.
+func (n NameHasher) Hash(token string) string {
+	hashArray := n.sum(token)
+
+	var name string
+	if namingMode == "words" {
+		name = wordName(hashArray[:])
+	} else {
+		name = hexName(hashArray[:])
+	}
+	if strings.ToUpper(token[:1]) == token[:1] {
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+	return name
+}
+
+// hexName turns a hash into a lowercase name made of
+// hex digits, with the numbers replaced by letters.
+func hexName(hash []byte) string {
+	hexStr := strings.ToLower(hex.EncodeToString(hash[:hashedSymbolSize]))
+	for i, x := range hexStr {
+		if x >= '0' && x <= '9' {
+			x = 'g' + (x - '0')
+			hexStr = hexStr[:i] + string(x) + hexStr[i+1:]
+		}
+	}
+	return hexStr
+}
+
+// wordName turns a hash into a camelCase name made of
+// dictionary words, like "parseBufferIndexState".
+// Smaller dictionaries use more words per name, so that
+// names are as unlikely to collide.
+func wordName(hash []byte) string {
+	numWords := int(math.Ceil(nameBits / math.Log2(float64(len(dictionary)))))
+	if numWords < 2 {
+		numWords = 2
+	}
+	num := new(big.Int).SetBytes(hash)
+	size := big.NewInt(int64(len(dictionary)))
+	var res strings.Builder
+	for i := 0; i < numWords; i++ {
+		var index big.Int
+		num.DivMod(num, size, &index)
+		word := dictionary[index.Int64()]
+		if i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		res.WriteString(word)
+	}
+	return res.String()
+}
+
+// sum hashes the padding + token. The padding is hashed
+// from its own buffer, since appending to it would write
+// into its spare capacity, which hashes on other
+// goroutines read.
+func (n NameHasher) sum(token string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(n)
+	h.Write([]byte(token))
+	var res [sha256.Size]byte
+	h.Sum(res[:0])
+	return res
+}
+
+// Intn deterministically maps the padding + token to an
+// integer in [0, max).
+func (n NameHasher) Intn(token string, max int) int {
+	hashArray := n.sum(token)
+	return int(binary.BigEndian.Uint64(hashArray[:8]) % uint64(max))
+}
+
+// Uint64 deterministically maps the padding + token to a
+// 64-bit integer.
+func (n NameHasher) Uint64(token string) uint64 {
+	hashArray := n.sum(token)
+	return binary.BigEndian.Uint64(hashArray[:8])
+}
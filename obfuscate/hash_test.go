@@ -0,0 +1,56 @@
+package obfuscate
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestNameHasherConcurrent(t *testing.T) {
+	// A padding with spare capacity, like []byte(padding)
+	// often has.
+	padding := make([]byte, 49, 64)
+	copy(padding, "padding")
+	n := NameHasher(padding)
+
+	want := map[string]string{}
+	for i := 0; i < 100; i++ {
+		token := "token" + strconv.Itoa(i)
+		want[token] = n.Hash(token)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 8*len(want))
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for token, name := range want {
+				if got := n.Hash(token); got != name {
+					errs <- token + ": got " + got + ", expected " + name
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestNameHasherCase(t *testing.T) {
+	n := NameHasher("padding")
+	if name := n.Hash("Exported"); name[0] < 'A' || name[0] > 'Z' {
+		t.Errorf("exported name hashed to %s", name)
+	}
+	if name := n.Hash("local"); name[0] < 'a' || name[0] > 'z' {
+		t.Errorf("unexported name hashed to %s", name)
+	}
+	if n.Hash("a") == n.Hash("b") {
+		t.Error("different tokens hashed to the same name")
+	}
+	if n.Hash("a") != NameHasher("padding").Hash("a") {
+		t.Error("hash is not deterministic")
+	}
+}
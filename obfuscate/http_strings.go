@@ -0,0 +1,401 @@
+package obfuscate
+
+import (
+	"bytes"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// httpStrings encrypts the strings which are passed to
+// net/http as URLs, routes, header names and values, and
+// user agents, except for standard ones.
+var httpStrings bool
+
+// httpArgs maps the functions and methods of net/http and
+// net/url to what their string arguments are, by index:
+// "url", "route", "method", "header" (a header name), or
+// "value" (a header value).
+var httpArgs = map[string][]string{
+	"net/http.Get":                    {"url"},
+	"net/http.Head":                   {"url"},
+	"net/http.Post":                   {"url", "value"},
+	"net/http.PostForm":               {"url"},
+	"net/http.NewRequest":             {"method", "url"},
+	"net/http.NewRequestWithContext":  {"", "method", "url"},
+	"net/http.Handle":                 {"route"},
+	"net/http.HandleFunc":             {"route"},
+	"net/http.StripPrefix":            {"route"},
+	"net/http.Redirect":               {"", "", "url"},
+	"(*net/http.Client).Get":          {"url"},
+	"(*net/http.Client).Head":         {"url"},
+	"(*net/http.Client).Post":         {"url", "value"},
+	"(*net/http.Client).PostForm":     {"url"},
+	"(*net/http.ServeMux).Handle":     {"route"},
+	"(*net/http.ServeMux).HandleFunc": {"route"},
+	"(net/http.Header).Add":           {"header", "value"},
+	"(net/http.Header).Set":           {"header", "value"},
+	"(net/http.Header).Get":           {"header"},
+	"(net/http.Header).Del":           {"header"},
+	"(net/http.Header).Values":        {"header"},
+	"net/url.Parse":                   {"url"},
+	"net/url.ParseRequestURI":         {"url"},
+}
+
+// standardHeaders are the header names which RFC 9110 and
+// other common standards define, in canonical form.
+var standardHeaders = stringSet(
+	"Accept", "Accept-Charset", "Accept-Encoding", "Accept-Language", "Accept-Ranges", "Age", "Allow",
+	"Authorization", "Cache-Control", "Connection", "Content-Disposition", "Content-Encoding",
+	"Content-Language", "Content-Length", "Content-Location", "Content-Range", "Content-Type", "Cookie",
+	"Date", "Etag", "Expect", "Expires", "From", "Host", "If-Match", "If-Modified-Since", "If-None-Match",
+	"If-Range", "If-Unmodified-Since", "Last-Modified", "Link", "Location", "Max-Forwards", "Origin",
+	"Pragma", "Proxy-Authenticate", "Proxy-Authorization", "Range", "Referer", "Retry-After", "Server",
+	"Set-Cookie", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "User-Agent", "Vary", "Via",
+	"Www-Authenticate", "Warning", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto",
+	"X-Requested-With", "Sec-Websocket-Key", "Sec-Websocket-Accept", "Sec-Websocket-Version",
+	"Sec-Websocket-Protocol", "Sec-Websocket-Extensions", "Strict-Transport-Security",
+	"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers",
+	"Access-Control-Allow-Credentials", "Access-Control-Expose-Headers", "Access-Control-Max-Age",
+	"Access-Control-Request-Method", "Access-Control-Request-Headers",
+)
+
+// standardMethods are the request methods of RFC 9110 and
+// RFC 5789.
+var standardMethods = stringSet("GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "OPTIONS", "TRACE")
+
+// standardValues are common header values, like media
+// types and codings, which every HTTP client uses.
+var standardValues = stringSet(
+	"*/*", "application/json", "application/xml", "application/octet-stream",
+	"application/x-www-form-urlencoded", "multipart/form-data", "text/plain", "text/html", "text/xml",
+	"text/plain; charset=utf-8", "text/html; charset=utf-8", "application/json; charset=utf-8",
+	"gzip", "deflate", "br", "identity", "chunked", "close", "keep-alive", "upgrade", "websocket",
+	"no-cache", "no-store", "max-age=0", "100-continue", "bytes", "none", "Bearer ", "Basic ",
+)
+
+// standardPaths are the URL paths which any server may
+// have.
+var standardPaths = stringSet("", "/", "*", "/robots.txt", "/favicon.ico", "http://", "https://")
+
+func stringSet(values ...string) map[string]bool {
+	res := map[string]bool{}
+	for _, v := range values {
+		res[v] = true
+	}
+	return res
+}
+
+// standardHTTPString checks if a string passed to net/http
+// as kind (one of the kinds of httpArgs, or "user-agent")
+// is one that standards require, or that every HTTP
+// client or server uses, and so does not give anything
+// away.
+func standardHTTPString(kind, s string) bool {
+	switch kind {
+	case "url", "route":
+		return standardPaths[s] || strings.HasPrefix(s, "/.well-known/")
+	case "method":
+		return standardMethods[s]
+	case "header":
+		return standardHeaders[textproto.CanonicalMIMEHeaderKey(s)]
+	case "value":
+		return standardValues[s]
+	}
+	return false
+}
+
+// A constDecl is the value of a constant declared in a
+// package which is obfuscated.
+type constDecl struct {
+	Value ast.Expr
+	Info  *types.Info
+}
+
+// ProtectHTTPStrings finds the strings which the packages
+// of a GOPATH for which include returns true pass to
+// net/http and net/url, and marks the lines of those which
+// are not standard with //gobfuscate:encrypt, so that the
+// string pass encrypts them.
+//
+// The packages are type-checked, so that calls are found
+// whatever the values are named, and named constants are
+// followed to their declarations, which are marked too.
+// It returns the number of literals which were marked.
+func ProtectHTTPStrings(gopath string, include func(string) bool) (int, error) {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return 0, err
+	}
+	var pkgsLock sync.Mutex
+	local := map[string]bool{}
+	pkgs := map[string]bool{}
+	err = forEachFile(files, func(f sourceFile) error {
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		pkgsLock.Lock()
+		defer pkgsLock.Unlock()
+		local[f.PkgPath] = true
+		if importsPath(file, "net/http") || importsPath(file, "net/url") {
+			pkgs[f.PkgPath] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	imp := newSourceImporter(gopath)
+	h := &httpStringFinder{
+		set:    imp.set,
+		consts: map[*types.Const]constDecl{},
+		lines:  map[string]map[int]bool{},
+		seen:   map[token.Pos]bool{},
+	}
+	imp.Visit = func(pkg *types.Package, files []*ast.File, info *types.Info) {
+		if !local[pkg.Path()] {
+			return
+		}
+		// Imported packages are checked first, so their
+		// constants are known by the time they are used.
+		h.addConsts(files, info)
+		if pkgs[pkg.Path()] {
+			for _, file := range files {
+				h.findFile(file, info)
+			}
+		}
+	}
+	for _, pkg := range sortedKeys(pkgs) {
+		imp.Import(pkg)
+	}
+
+	for path, lines := range h.lines {
+		if err := markEncryptedLines(path, lines); err != nil {
+			return 0, err
+		}
+	}
+	return len(h.seen), nil
+}
+
+// An httpStringFinder finds the literals of strings
+// passed to net/http.
+type httpStringFinder struct {
+	set    *token.FileSet
+	consts map[*types.Const]constDecl
+
+	// lines are the lines to mark, by file, and seen are the
+	// literals which were marked.
+	lines map[string]map[int]bool
+	seen  map[token.Pos]bool
+}
+
+func (h *httpStringFinder) addConsts(files []*ast.File, info *types.Info) {
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			spec, ok := n.(*ast.ValueSpec)
+			if !ok || len(spec.Values) != len(spec.Names) {
+				return true
+			}
+			for i, name := range spec.Names {
+				if c, ok := info.Defs[name].(*types.Const); ok {
+					h.consts[c] = constDecl{Value: spec.Values[i], Info: info}
+				}
+			}
+			return false
+		})
+	}
+}
+
+// findFile finds the strings which a file passes to
+// net/http.
+func (h *httpStringFinder) findFile(file *ast.File, info *types.Info) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			h.findCall(n, info)
+		case *ast.CompositeLit:
+			// http.Header{"X-Name": {"value"}}
+			if !isHTTPHeader(info.TypeOf(n)) {
+				return true
+			}
+			for _, elt := range n.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				h.mark(kv.Key, "header", info)
+				kind := headerValueKind(kv.Key, info)
+				if values, ok := kv.Value.(*ast.CompositeLit); ok {
+					for _, value := range values.Elts {
+						h.mark(value, kind, info)
+					}
+				}
+			}
+		case *ast.IndexExpr:
+			// header["X-Name"]
+			if isHTTPHeader(info.TypeOf(n.X)) {
+				h.mark(n.Index, "header", info)
+			}
+		}
+		return true
+	})
+}
+
+func (h *httpStringFinder) findCall(call *ast.CallExpr, info *types.Info) {
+	var ident *ast.Ident
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		ident = fun
+	case *ast.SelectorExpr:
+		ident = fun.Sel
+	default:
+		return
+	}
+	fn, ok := info.Uses[ident].(*types.Func)
+	if !ok {
+		return
+	}
+	kinds := httpArgs[fn.FullName()]
+	for i, kind := range kinds {
+		if i >= len(call.Args) || kind == "" {
+			continue
+		}
+		if kind == "value" && i > 0 && kinds[i-1] == "header" {
+			kind = headerValueKind(call.Args[i-1], info)
+		}
+		h.mark(call.Args[i], kind, info)
+	}
+}
+
+// headerValueKind is the kind of the values of a header:
+// "user-agent" for the User-Agent header, and "value"
+// otherwise.
+func headerValueKind(name ast.Expr, info *types.Info) string {
+	tv := info.Types[name]
+	if tv.Value != nil && tv.Value.Kind() == constant.String &&
+		textproto.CanonicalMIMEHeaderKey(constant.StringVal(tv.Value)) == "User-Agent" {
+		return "user-agent"
+	}
+	return "value"
+}
+
+func isHTTPHeader(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "net/http" && obj.Name() == "Header"
+}
+
+// mark marks the literals of an expression of some kind,
+// and the declarations of the constants it uses, unless
+// they are standard.
+// A constant expression is checked as a whole, and any
+// other expression one literal or constant at a time.
+func (h *httpStringFinder) mark(expr ast.Expr, kind string, info *types.Info) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case ast.Expr:
+			tv := info.Types[n]
+			if tv.Value == nil {
+				return true
+			}
+			if tv.Value.Kind() == constant.String && !standardHTTPString(kind, constant.StringVal(tv.Value)) {
+				h.markConst(n, info)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// markConst marks the literals of a constant expression,
+// and those of the declarations of the constants it uses.
+func (h *httpStringFinder) markConst(expr ast.Expr, info *types.Info) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BasicLit:
+			if n.Kind == token.STRING && !h.seen[n.Pos()] {
+				h.seen[n.Pos()] = true
+				pos := h.set.Position(n.Pos())
+				if h.lines[pos.Filename] == nil {
+					h.lines[pos.Filename] = map[int]bool{}
+				}
+				h.lines[pos.Filename][pos.Line] = true
+			}
+		case *ast.Ident:
+			if c, ok := info.Uses[n].(*types.Const); ok {
+				if decl, ok := h.consts[c]; ok {
+					h.markConst(decl.Value, decl.Info)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// markEncryptedLines puts //gobfuscate:encrypt on a line of
+// its own before each of some lines of a file, with the
+// same indentation.
+// Lines which begin inside a multi-line literal are left
+// alone.
+func markEncryptedLines(path string, lines map[int]bool) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, contents, 0)
+	if err != nil {
+		return err
+	}
+	var multiline []*ast.BasicLit
+	ast.Inspect(file, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && strings.Contains(lit.Value, "\n") {
+			multiline = append(multiline, lit)
+		}
+		return true
+	})
+	tokFile := set.File(file.Pos())
+
+	var sorted []int
+	for line := range lines {
+		sorted = append(sorted, line)
+	}
+	sort.Ints(sorted)
+	var edits []fileEdit
+LineLoop:
+	for _, line := range sorted {
+		start := tokFile.LineStart(line)
+		for _, lit := range multiline {
+			if lit.Pos() < start && start < lit.End() {
+				continue LineLoop
+			}
+		}
+		offset := tokFile.Offset(start)
+		indent := contents[offset:]
+		indent = indent[:len(indent)-len(bytes.TrimLeft(indent, " \t"))]
+		edits = append(edits, fileEdit{
+			Start: offset,
+			End:   offset,
+			Text:  string(indent) + encryptDirective + "\n",
+		})
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyFileEdits(path, edits)
+}
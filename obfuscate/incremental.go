@@ -0,0 +1,255 @@
+package obfuscate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// incrementalState describes the workspace kept by
+// -incremental after a full run.
+type incrementalState struct {
+	// Key covers the settings which affect how code is
+	// obfuscated.
+	Key     string            `json:"key"`
+	PkgName string            `json:"pkg_name"`
+	Moves   PackageMoves      `json:"moves"`
+	Record  *renameRecord     `json:"record"`
+	GoWork  string            `json:"go_work,omitempty"`
+	Decls   map[string]bool   `json:"decls"`
+	Sources map[string]string `json:"sources"`
+	Helper  *stringHelper     `json:"helper"`
+}
+
+// obfuscateIncremental obfuscates and builds a package,
+// keeping the workspace and the build cache in
+// incrementalDir.
+//
+// If only the files of the main package changed since the
+// last run, and not its declarations, then only those files
+// are obfuscated again, and everything else (including the
+// compiled dependencies) is reused.
+func obfuscateIncremental(pkgName, outPath string) error {
+	if customPadding == "" {
+		return flagError("The -incremental flag requires -padding.")
+	}
+	if err := checkIncrementalFlags("The -incremental flag"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(incrementalDir, 0755); err != nil {
+		return stepError("create incremental directory", err)
+	}
+	unlock, err := lockFile(filepath.Join(incrementalDir, "lock"))
+	if err != nil {
+		return stepError("lock incremental directory", err)
+	}
+	defer unlock()
+	statePath := filepath.Join(incrementalDir, "state.json")
+	wsDir := filepath.Join(incrementalDir, "workspace")
+	goCache := filepath.Join(incrementalDir, "gocache")
+	var modCache string
+	if useModules {
+		modCache = filepath.Join(incrementalDir, "modcache")
+	}
+
+	prepEnv, err := prepareEnv()
+	if err != nil {
+		return flagError("Invalid preparation environment: " + err.Error())
+	}
+	var pkgs []*listedPackage
+	err = withEnv(prepEnv, func() error {
+		var err error
+		if useModules {
+			pkgs, err = ListModulePackages(pkgName, modCache, true)
+		} else {
+			pkgs, err = listPackages(pkgName, append(os.Environ(), "GO111MODULE=off"), true)
+		}
+		return err
+	})
+	if err != nil {
+		return stepError("list packages", err)
+	}
+	root := pkgs[len(pkgs)-1]
+	sources, err := sourceFingerprints(pkgs, root.ImportPath)
+	if err != nil {
+		return stepError("fingerprint sources", err)
+	}
+	key, err := incrementalKey()
+	if err != nil {
+		return stepError("fingerprint settings", err)
+	}
+
+	state, err := loadIncrementalState(statePath)
+	if err != nil {
+		log.Println("Ignoring previous state:", err)
+	}
+	reusable := state != nil && state.Key == key && state.PkgName == root.ImportPath &&
+		reflect.DeepEqual(state.Sources, sources) && state.Helper != nil
+	runMetrics.Cache("workspace", reusable)
+	if reusable {
+		log.Println("Updating the previous workspace...")
+		runMetrics.Phase("update")
+		ws := &Workspace{
+			Gopath:  wsDir,
+			PkgName: state.PkgName,
+			Hasher:  newNameHasher(customPadding),
+			Moves:   state.Moves,
+			GoWork:  state.GoWork,
+			GoCache: goCache,
+			Helper:  state.Helper,
+		}
+		session := &watchSession{
+			ws:      ws,
+			record:  state.Record,
+			pkgs:    pkgs,
+			watched: map[string]string{root.Dir: root.ImportPath},
+			decls:   map[string]map[string]bool{root.ImportPath: state.Decls},
+		}
+		// If the update fails halfway, the workspace cannot
+		// be used again.
+		os.Remove(statePath)
+		err := session.Update([]string{root.Dir})
+		if err == nil {
+			if err := state.Save(statePath); err != nil {
+				return stepError("save state", err)
+			}
+			// The state does not keep the strings, so the
+			// mapping file only has the names.
+			if mapPath != "" {
+				if err := writeMapping(mapPath, state.Record); err != nil {
+					return stepError("write mapping file", err)
+				}
+			}
+			return ws.Build(outPath)
+		}
+		log.Println("Cannot update the previous workspace:", err)
+	}
+
+	os.Remove(statePath)
+	if err := os.RemoveAll(wsDir); err != nil {
+		return stepError("remove previous workspace", err)
+	}
+	if err := os.Mkdir(wsDir, 0755); err != nil {
+		return stepError("create workspace", err)
+	}
+	ws, err := prepareWorkspace(pkgName, wsDir, modCache)
+	if err != nil {
+		return err
+	}
+	ws.GoCache = goCache
+	if mapPath != "" {
+		if err := ws.WriteMapping(mapPath); err != nil {
+			return err
+		}
+	}
+
+	var origPkgs []string
+	for _, pkg := range pkgs {
+		if !pkg.Standard {
+			origPkgs = append(origPkgs, pkg.ImportPath)
+		}
+	}
+	state = &incrementalState{
+		Key:     key,
+		PkgName: root.ImportPath,
+		Moves:   ws.Moves,
+		GoWork:  ws.GoWork,
+		Sources: sources,
+		Helper:  ws.Helper,
+		Record:  ws.renameRecord(origPkgs),
+	}
+	state.Decls, err = packageDecls(root.Dir)
+	if err != nil {
+		return stepError("list declarations", err)
+	}
+	if err := state.Save(statePath); err != nil {
+		return stepError("save state", err)
+	}
+	return ws.Build(outPath)
+}
+
+func loadIncrementalState(path string) (*incrementalState, error) {
+	data, err := readMapFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state incrementalState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Save writes the state to a file.
+func (s *incrementalState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeMapFile(path, data)
+}
+
+// incrementalKey fingerprints the settings which affect
+// how code is obfuscated.
+func incrementalKey() (string, error) {
+	goVersion, err := goLanguageVersion()
+	if err != nil {
+		return "", err
+	}
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	fmt.Fprintln(hash, goVersion, customPadding)
+	fmt.Fprintln(hash, useModules, keepTests, randomRoot, flattenDepth, preservePackageName)
+	fmt.Fprintln(hash, reflectNames, reflectSafety, keepFlags.String(), depCacheDir, exportNames, seed)
+	fmt.Fprintln(hash, passRuns("prunetypes", pruneTypes), numericLiterals, lazyStrings, stringsMode,
+		encryptEmbeds, httpStrings)
+	fmt.Fprintln(hash, string(configData))
+	fmt.Fprintf(hash, "%x\n", blocklist)
+	fmt.Fprintf(hash, "%q\n", logPatterns)
+	fmt.Fprintf(hash, "%q\n", excludedPaths)
+	fmt.Fprintln(hash, namingMode, pathStyle, strings.Join(dictionary, " "), internalMode)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sourceFingerprints hashes the sources of every package
+// except for the main package.
+// Module dependencies are identified by their versions.
+func sourceFingerprints(pkgs []*listedPackage, mainPkg string) (map[string]string, error) {
+	res := map[string]string{}
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.ImportPath == mainPkg {
+			continue
+		}
+		// Replaced modules have no version, and may be
+		// edited like the main module.
+		if pkg.Module != nil && !pkg.Module.Main && pkg.Module.Version != "" {
+			res[pkg.ImportPath] = pkg.Module.Path + "@" + pkg.Module.Version
+			continue
+		}
+		hash := sha256.New()
+		for _, list := range append(pkg.sourceFiles(), includedFiles(pkg.Dir)) {
+			for _, name := range list {
+				data, err := ioutil.ReadFile(filepath.Join(pkg.Dir, name))
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintln(hash, name, len(data))
+				hash.Write(data)
+			}
+		}
+		res[pkg.ImportPath] = hex.EncodeToString(hash.Sum(nil))
+	}
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package obfuscate
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// internalMode decides what happens to the "internal"
+// components of import paths: with "dissolve", they are
+// hashed like any other, so the go command no longer
+// restricts who imports the packages under them, and with
+// "preserve", they are kept as they are.
+var internalMode string
+
+// keepsInternal checks if the package name pass must leave
+// a directory's name alone, by its import path.
+func keepsInternal(importPath string) bool {
+	return internalMode == "preserve" && path.Base(importPath) == "internal"
+}
+
+// internalParent finds the import path under which a
+// package must be to import an internal package, the way
+// the go command does. The second value is false if the
+// package is not internal.
+func internalParent(importPath string) (string, bool) {
+	if strings.HasSuffix(importPath, "/internal") {
+		return strings.TrimSuffix(importPath, "/internal"), true
+	} else if i := strings.LastIndex(importPath, "/internal/"); i >= 0 {
+		return importPath[:i], true
+	} else if importPath == "internal" || strings.HasPrefix(importPath, "internal/") {
+		return "", true
+	}
+	return "", false
+}
+
+// checkInternalImports finds the imports of internal
+// packages in a GOPATH which the go command would reject
+// because the packages were moved apart.
+// Packages of the standard library are not checked.
+func checkInternalImports(gopath string, moves PackageMoves) ([]string, error) {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for _, pkg := range pkgs {
+		imports, err := packageImports(filepath.Join(srcDir, filepath.FromSlash(pkg)))
+		if err != nil {
+			return nil, err
+		}
+		for _, imported := range imports {
+			parent, ok := internalParent(imported)
+			if !ok || parent == "" || pkg == parent || strings.HasPrefix(pkg, parent+"/") {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(srcDir, filepath.FromSlash(imported))); err != nil {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("%s imports %s, which is internal to %s",
+				describeMoved(moves, pkg), describeMoved(moves, imported), moves.Original(parent)))
+		}
+	}
+	return problems, nil
+}
+
+// describeMoved names a package by its new import path,
+// and its original one if it was moved.
+func describeMoved(moves PackageMoves, importPath string) string {
+	if original := moves.Original(importPath); original != importPath {
+		return importPath + " (originally " + original + ")"
+	}
+	return importPath
+}
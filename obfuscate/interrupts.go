@@ -0,0 +1,159 @@
+package obfuscate
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime/pprof"
+	"sync"
+)
+
+// keepWork keeps the temporary directories of a run which
+// is interrupted, so that they can be looked into.
+var keepWork bool
+
+var (
+	interruptLock sync.Mutex
+
+	// handlingInterrupts is set once handleInterrupts runs,
+	// and tearingDown once the run is being torn down.
+	handlingInterrupts bool
+	tearingDown        bool
+
+	// interruptCatcher, if set, gets the next interrupt
+	// instead of the run being torn down.
+	interruptCatcher chan<- os.Signal
+
+	// tempDirs are the temporary directories made by the
+	// run, and children are the build commands running.
+	tempDirs = map[string]bool{}
+	children = map[*exec.Cmd]bool{}
+)
+
+// handleInterrupts makes interrupts tear the run down:
+// running builds are stopped along with the compilers
+// which they started, the metrics are written, and the
+// temporary directories are removed (unless -keepwork is
+// set), before the process exits.
+// It is only used by the command, since a program using
+// the library handles its own signals.
+func handleInterrupts() {
+	interruptLock.Lock()
+	handlingInterrupts = true
+	interruptLock.Unlock()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, interruptSignals...)
+	go func() {
+		for sig := range signals {
+			interruptLock.Lock()
+			catcher := interruptCatcher
+			interruptCatcher = nil
+			interruptLock.Unlock()
+			if catcher != nil {
+				catcher <- sig
+				continue
+			}
+			tearDown(sig)
+		}
+	}()
+}
+
+// catchInterrupts sends the next interrupt to a channel,
+// with a buffer, instead of it tearing the run down, so
+// that a phase can stop cleanly. After that, interrupts
+// tear the run down again. The returned function stops
+// catching interrupts.
+func catchInterrupts(c chan os.Signal) func() {
+	interruptLock.Lock()
+	defer interruptLock.Unlock()
+	if !handlingInterrupts {
+		signal.Notify(c, interruptSignals...)
+		return func() {
+			signal.Stop(c)
+		}
+	}
+	interruptCatcher = c
+	return func() {
+		interruptLock.Lock()
+		defer interruptLock.Unlock()
+		if interruptCatcher == c {
+			interruptCatcher = nil
+		}
+	}
+}
+
+func tearDown(sig os.Signal) {
+	interruptLock.Lock()
+	if tearingDown {
+		interruptLock.Unlock()
+		return
+	}
+	tearingDown = true
+	log.Println("Interrupted by", sig.String()+", stopping...")
+	for cmd := range children {
+		killProcessGroup(cmd)
+	}
+	dirs := sortedKeys(tempDirs)
+	interruptLock.Unlock()
+
+	pprof.StopCPUProfile()
+	writeMetrics(false)
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if keepWork {
+			log.Println("Keeping", dir)
+		} else if err := removeTempDir(dir); err != nil {
+			log.Println("Warning: failed to remove temp dir:", err)
+		}
+	}
+	os.Exit(signalExitCode(sig))
+}
+
+// trackTempDir records a temporary directory, to remove it
+// if the run is torn down.
+func trackTempDir(dir string) {
+	interruptLock.Lock()
+	defer interruptLock.Unlock()
+	tempDirs[dir] = true
+}
+
+// runChild runs a command which may start processes of its
+// own, like go build, and stops them all if the run is torn
+// down.
+func runChild(cmd *exec.Cmd) error {
+	interruptLock.Lock()
+	if tearingDown {
+		interruptLock.Unlock()
+		return errors.New("interrupted")
+	}
+	if handlingInterrupts {
+		// The command gets a process group of its own, so
+		// that it can be stopped with the processes which it
+		// started. Interrupts from the terminal then only
+		// reach this process, which stops the command.
+		newProcessGroup(cmd)
+	}
+	err := cmd.Start()
+	if err == nil {
+		children[cmd] = true
+	}
+	interruptLock.Unlock()
+	if err != nil {
+		return err
+	}
+	err = cmd.Wait()
+	interruptLock.Lock()
+	delete(children, cmd)
+	stopped := tearingDown
+	interruptLock.Unlock()
+	if stopped {
+		// The command was killed, and the process is about
+		// to exit, so its failure is not reported.
+		select {}
+	}
+	return err
+}
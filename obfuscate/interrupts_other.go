@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !solaris && !illumos && !aix && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!solaris,!illumos,!aix,!windows
+
+package obfuscate
+
+import "os"
+
+// interruptSignals stop a run. Other signals are not
+// portable.
+var interruptSignals = []os.Signal{os.Interrupt}
+
+// signalExitCode is the exit status of a process which was
+// stopped by a signal.
+func signalExitCode(sig os.Signal) int {
+	return 1
+}
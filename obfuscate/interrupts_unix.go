@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly || solaris || illumos || aix
+// +build linux darwin freebsd netbsd openbsd dragonfly solaris illumos aix
+
+package obfuscate
+
+import (
+	"os"
+	"syscall"
+)
+
+// interruptSignals stop a run. A dropped SSH session hangs
+// up rather than interrupting.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+// signalExitCode is the exit status of a process which was
+// stopped by a signal, like a shell reports it.
+func signalExitCode(sig os.Signal) int {
+	if num, ok := sig.(syscall.Signal); ok {
+		return 128 + int(num)
+	}
+	return 1
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package obfuscate
+
+import (
+	"os"
+	"syscall"
+)
+
+// interruptSignals stop a run. Closing the console window,
+// logging off, and shutting down are delivered as SIGTERM.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// signalExitCode is the exit status of a process which was
+// stopped by a signal, like a Unix shell reports it.
+func signalExitCode(sig os.Signal) int {
+	if num, ok := sig.(syscall.Signal); ok {
+		return 128 + int(num)
+	}
+	return 1
+}
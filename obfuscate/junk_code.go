@@ -0,0 +1,325 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// junkCode enables InsertJunkCode.
+	junkCode bool
+
+	// junkRatio is how many junk functions are added for
+	// every function of a package.
+	junkRatio float64
+)
+
+// The words which junk function names are made of, so that
+// they look like those of the package before renaming.
+var (
+	junkVerbs = []string{"apply", "build", "check", "compute", "decode", "encode", "flush", "load", "lookup",
+		"merge", "normalize", "parse", "read", "resolve", "scan", "store", "sync", "update", "validate", "write"}
+	junkNouns = []string{"Batch", "Buffer", "Checksum", "Chunk", "Config", "Entry", "Frame", "Header", "Index",
+		"Key", "Offset", "Payload", "Record", "Route", "Segment", "Session", "State", "Table", "Token", "Window"}
+)
+
+// junkStrings are the decoy strings of junk functions,
+// which look like those of real programs.
+var junkStrings = []string{
+	"invalid header", "unexpected EOF", "connection reset by peer", "Content-Type", "application/json",
+	"/api/v1/status", "/api/v2/session", "session expired", "checksum mismatch", "X-Request-ID",
+	"retry limit exceeded", "config.json", "Authorization", "timeout waiting for response",
+	"unsupported version", "license key rejected", "handshake failed", "malformed record",
+	"permission denied", "cache miss", "token refresh", "user-agent", "upload complete", "ERR_STATE",
+}
+
+// A junkKind is the signature of a junk function, which
+// decides how other junk functions call it.
+type junkKind int
+
+const (
+	junkBytes  junkKind = iota // func(buf []byte) uint32
+	junkString                 // func(key string) string
+	junkState                  // func(n int, buf []byte) int
+	numJunkKinds
+)
+
+// A junkFunc is a junk function to be generated.
+type junkFunc struct {
+	Name    string
+	Kind    junkKind
+	Callees []*junkFunc
+	called  bool
+}
+
+// A junkFile is a file of junk code which InsertJunkCode
+// added to a package, by its import path, with the names
+// of its top-level declarations.
+type junkFile struct {
+	Pkg   string
+	Name  string
+	Gate  string
+	Funcs []string
+}
+
+// call is an int expression which calls the function with
+// the locals buf ([]byte) and n (int) of its caller.
+func (f *junkFunc) call() string {
+	switch f.Kind {
+	case junkBytes:
+		return "int(" + f.Name + "(buf))"
+	case junkString:
+		return "len(" + f.Name + "(string(buf)))"
+	default:
+		return f.Name + "(n, buf)"
+	}
+}
+
+// InsertJunkCode adds a file of junk functions to each of
+// the packages of a GOPATH for which include returns true,
+// with junkRatio as many functions as the package declares.
+// The functions call each other, and decoy strings, and
+// an init function calls them behind a condition which is
+// never true, but which the compiler cannot rule out, so
+// the linker keeps them.
+// It returns the files which were added, so that they can
+// be left out of the mapping file.
+func InsertJunkCode(gopath string, include func(string) bool) ([]junkFile, error) {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	var included []string
+	for _, pkg := range pkgs {
+		if include(pkg) {
+			included = append(included, pkg)
+		}
+	}
+	var resLock sync.Mutex
+	var res []junkFile
+	err = forEachPackage(included, func(pkg string) error {
+		file, err := insertPackageJunk(pkg, filepath.Join(srcDir, filepath.FromSlash(pkg)))
+		if err != nil || file == nil {
+			return err
+		}
+		resLock.Lock()
+		defer resLock.Unlock()
+		res = append(res, *file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Pkg < res[j].Pkg
+	})
+	return res, nil
+}
+
+func insertPackageJunk(pkg, dir string) (*junkFile, error) {
+	buildPkg, err := build.Default.ImportDir(dir, 0)
+	if err != nil {
+		// Directories of tests, or of files which are never
+		// built, get nothing.
+		return nil, nil
+	}
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	files := map[string]bool{}
+	var numFuncs int
+	for _, item := range listing {
+		files[item.Name()] = true
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, item.Name()), nil, 0)
+		if err != nil || file.Name.Name != buildPkg.Name {
+			continue
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					names[decl.Name.Name] = true
+				}
+				if !strings.HasSuffix(item.Name(), "_test.go") {
+					numFuncs++
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							names[name.Name] = true
+						}
+					case *ast.TypeSpec:
+						names[spec.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	numJunk := int(math.Ceil(float64(numFuncs) * junkRatio))
+	if numJunk == 0 {
+		return nil, nil
+	}
+
+	r := newKeyRand("junk", []byte(pkg))
+	newName := func(base string) string {
+		name := base
+		for i := 2; names[name]; i++ {
+			name = base + strconv.Itoa(i)
+		}
+		names[name] = true
+		return name
+	}
+	funcs := make([]*junkFunc, numJunk)
+	for i := range funcs {
+		verb, noun := junkVerbs[r.Intn(len(junkVerbs))], junkNouns[r.Intn(len(junkNouns))]
+		funcs[i] = &junkFunc{Name: newName(verb + noun), Kind: junkKind(r.Intn(int(numJunkKinds)))}
+		// Functions only call those before them, so there
+		// is no recursion to make the call graph give
+		// itself away.
+		for j := 0; j < 2 && i > 0; j++ {
+			callee := funcs[r.Intn(i)]
+			callee.called = true
+			funcs[i].Callees = append(funcs[i].Callees, callee)
+		}
+	}
+
+	var code bytes.Buffer
+	fmt.Fprintf(&code, "package %s\n\n", buildPkg.Name)
+	gate := newName("max" + junkNouns[r.Intn(len(junkNouns))])
+	fmt.Fprintf(&code, "var %s = %d\n\n", gate, 1+r.Intn(64))
+	fmt.Fprintf(&code, "func init() {\n\tif %s > %d {\n\t\tbuf := []byte(%q)\n\t\tn := len(buf)\n",
+		gate, 1<<20+r.Intn(1<<20), decoyString(r))
+	for _, f := range funcs {
+		if !f.called {
+			fmt.Fprintf(&code, "\t\tn += %s\n", f.call())
+		}
+	}
+	fmt.Fprintf(&code, "\t\t%s = n\n\t}\n}\n", gate)
+	for _, f := range funcs {
+		code.WriteString("\n")
+		writeJunkFunc(&code, f, r)
+	}
+	source, err := format.Source(code.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generate junk for %s: %s", pkg, err)
+	}
+
+	res := &junkFile{Pkg: pkg, Name: junkFileName(files, r), Gate: gate}
+	for _, f := range funcs {
+		res.Funcs = append(res.Funcs, f.Name)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, res.Name), source, 0644); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// removeJunk takes the junk declarations of files, and
+// their strings and file names, out of a mapping record,
+// since they are not in the original code.
+func (r *renameRecord) removeJunk(moves PackageMoves, files []junkFile) {
+	junkNames := map[string]bool{}
+	for _, f := range files {
+		pkg := moves.Original(f.Pkg)
+		junkNames[pkg+"/"+f.Name] = true
+		delete(r.Symbols, pkg+"."+f.Gate)
+		for _, name := range f.Funcs {
+			delete(r.Symbols, pkg+"."+name)
+		}
+		delete(r.Files, pkg+"/"+f.Name)
+	}
+	var literals []ObfuscatedString
+	for _, lit := range r.Strings {
+		if !junkNames[lit.Package+"/"+lit.File] {
+			literals = append(literals, lit)
+		}
+	}
+	r.Strings = literals
+}
+
+// writeJunkFunc writes a junk function, which looks like
+// it does something with its arguments, and calls its
+// callees along the way.
+func writeJunkFunc(w *bytes.Buffer, f *junkFunc, r *rand.Rand) {
+	var calls bytes.Buffer
+	for _, callee := range f.Callees {
+		fmt.Fprintf(&calls, "\t\tn += %s\n", callee.call())
+	}
+	switch f.Kind {
+	case junkBytes:
+		fmt.Fprintf(w, "func %s(buf []byte) uint32 {\n", f.Name)
+		fmt.Fprintf(w, "\th := uint32(%d)\n\tn := 0\n", r.Uint32())
+		fmt.Fprintf(w, "\tfor i, b := range buf {\n\t\th = (h ^ uint32(b)) * %d\n\t\tn += i\n\t}\n", 16777619+2*r.Intn(1000))
+		shift := uint(8 * r.Intn(3))
+		fmt.Fprintf(w, "\tif h&%#x == %#x {\n%s\t\th += uint32(n)\n\t}\n", 0xff<<shift, r.Intn(256)<<shift, calls.String())
+		fmt.Fprintf(w, "\tif len(buf) > %d && buf[0] == %d {\n\t\treturn h ^ uint32(len(%q))\n\t}\n",
+			r.Intn(64), r.Intn(256), decoyString(r))
+		fmt.Fprintf(w, "\treturn h\n}\n")
+	case junkString:
+		fmt.Fprintf(w, "func %s(key string) string {\n", f.Name)
+		fmt.Fprintf(w, "\ttable := map[string]string{\n")
+		entries := 2 + r.Intn(3)
+		for i := 0; i < entries; i++ {
+			fmt.Fprintf(w, "\t\t%q: %q,\n", decoyString(r)+strconv.Itoa(i), decoyString(r))
+		}
+		fmt.Fprintf(w, "\t}\n\tif v, ok := table[key]; ok {\n\t\treturn v\n\t}\n")
+		fmt.Fprintf(w, "\tbuf := []byte(key)\n\tn := len(buf)\n")
+		fmt.Fprintf(w, "\tif n > %d {\n%s\t\treturn string(buf[:n%%len(buf)])\n\t}\n", 8+r.Intn(24), calls.String())
+		fmt.Fprintf(w, "\treturn %q + key\n}\n", decoyString(r)+": ")
+	default:
+		fmt.Fprintf(w, "func %s(n int, buf []byte) int {\n", f.Name)
+		fmt.Fprintf(w, "\tstate := n %% %d\n\tfor i, c := range buf {\n\t\tswitch state {\n", 3+r.Intn(4))
+		fmt.Fprintf(w, "\t\tcase 0:\n\t\t\tif c == %q {\n\t\t\t\tstate = 1\n\t\t\t}\n", rune('a'+r.Intn(26)))
+		fmt.Fprintf(w, "\t\tcase 1:\n\t\t\tstate = int(c) %% %d\n", 2+r.Intn(5))
+		fmt.Fprintf(w, "\t\tdefault:\n\t\t\tstate = (state + i) %% %d\n\t\t}\n\t}\n", 2+r.Intn(5))
+		fmt.Fprintf(w, "\tif state > %d {\n%s\t\treturn n + len(%q)\n\t}\n", 1+r.Intn(3), calls.String(), decoyString(r))
+		fmt.Fprintf(w, "\treturn state\n}\n")
+	}
+}
+
+func decoyString(r *rand.Rand) string {
+	return junkStrings[r.Intn(len(junkStrings))]
+}
+
+// junkFileName picks a name for the file of junk functions
+// which no file of the package has.
+func junkFileName(files map[string]bool, r *rand.Rand) string {
+	base := strings.ToLower(junkNouns[r.Intn(len(junkNouns))])
+	name := base + ".go"
+	for i := 2; files[name]; i++ {
+		name = base + strconv.Itoa(i) + ".go"
+	}
+	return name
+}
+
+// checkJunkFlags reports a -junkratio which is out of
+// range.
+func checkJunkFlags() error {
+	if !junkCode {
+		return nil
+	} else if junkRatio <= 0 || junkRatio > 10 {
+		return flagError("The -junkratio flag must be above 0 and at most 10.")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package obfuscate
+
+import "testing"
+
+func TestMappingLeavesOutJunk(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"obf/app/main.go": "package main\n\n" +
+			"func greet(name string) string {\n\treturn \"hello, \" + name\n}\n\n" +
+			"func main() {\n\tprintln(greet(\"gopher\"))\n}\n",
+	})
+	defer func(old float64) {
+		junkRatio = old
+	}(junkRatio)
+	junkRatio = 2
+	include := func(pkg string) bool {
+		return pkg == "obf/app"
+	}
+	files, err := InsertJunkCode(gopath, include)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(files) != 1 || len(files[0].Funcs) != 4 {
+		t.Fatalf("unexpected junk files: %v", files)
+	}
+	renames, hoists, err := ObfuscateSymbols(gopath, NameHasher("junk"), nil, include)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTypes(t, gopath)
+
+	ws := &Workspace{
+		Gopath:  gopath,
+		Moves:   PackageMoves{{From: "example.com/app", To: "obf/app"}},
+		Renames: renames,
+		Hoists:  hoists,
+		Strings: &StringCoverage{Literals: []ObfuscatedString{
+			{Package: "example.com/app", File: "main.go", Line: 4, Value: "hello, "},
+			{Package: "example.com/app", File: files[0].Name, Line: 5, Value: "checksum mismatch"},
+		}},
+		Junk: files,
+	}
+	record := ws.Mapping([]string{"example.com/app"})
+	if record.Symbols["example.com/app.greet"] == "" {
+		t.Errorf("greet is not recorded: %v", record.Symbols)
+	}
+	for name := range record.Symbols {
+		if name != "example.com/app.greet" {
+			t.Errorf("junk symbol %s is recorded", name)
+		}
+	}
+	if len(record.Strings) != 1 || record.Strings[0].File != "main.go" {
+		t.Errorf("unexpected strings: %v", record.Strings)
+	}
+}
@@ -0,0 +1,271 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A KeepList names types, methods, and fields which must
+// keep their original names, e.g. because they are looked
+// up with reflect's MethodByName or FieldByName.
+//
+// A nil KeepList keeps nothing.
+type KeepList struct {
+	entries []keepEntry
+
+	// readings are the ways the entries parsed from a list
+	// can be read, by index, since the last element of an
+	// import path may contain dots, like "gopkg.in/yaml.v2".
+	readings [][]keepEntry
+
+	// api are the symbols matched by -keep patterns.
+	api []keepEntry
+
+	// members are names of methods and fields which are
+	// kept on every type.
+	members map[string]bool
+}
+
+// A keepEntry is either a type (Member is empty) or a
+// method or field of a type.
+// Pkg is the package's original import path, and ObfPkg is
+// its import path after packages are renamed.
+type keepEntry struct {
+	Pkg    string
+	ObfPkg string
+	Type   string
+	Member string
+}
+
+func (k keepEntry) String() string {
+	res := k.Pkg + "." + k.Type
+	if k.Member != "" {
+		res += "." + k.Member
+	}
+	return res
+}
+
+// ParseKeepList parses a space-separated list of entries
+// of the form "import/path.Type" or "import/path.Type.Member".
+//
+// The package is split from the symbol after the last "/",
+// but an entry like "gopkg.in/yaml.v2.Decoder" may name a
+// type of "gopkg.in/yaml.v2" or a member of the type v2 of
+// "gopkg.in/yaml". Until Resolve finds which package exists,
+// the entry is read with the shortest package path.
+func ParseKeepList(list string) (*KeepList, error) {
+	res := &KeepList{}
+	for _, item := range strings.Fields(list) {
+		readings := keepEntryReadings(item)
+		if len(readings) == 0 {
+			return nil, fmt.Errorf("invalid keep entry: %s", item)
+		}
+		res.entries = append(res.entries, readings[0])
+		res.readings = append(res.readings, readings)
+	}
+	return res, nil
+}
+
+// keepEntryReadings lists the ways to split an entry into
+// a package and a type, with an optional member, with the
+// shortest package first.
+func keepEntryReadings(item string) []keepEntry {
+	slash := strings.LastIndex(item, "/")
+	parts := strings.Split(item[slash+1:], ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil
+		}
+	}
+	var res []keepEntry
+	for i := 1; i < len(parts); i++ {
+		symbol := parts[i:]
+		if len(symbol) > 2 || !token.IsIdentifier(symbol[0]) {
+			continue
+		}
+		entry := keepEntry{Pkg: item[:slash+1] + strings.Join(parts[:i], "."), Type: symbol[0]}
+		if len(symbol) == 2 {
+			if !token.IsIdentifier(symbol[1]) {
+				continue
+			}
+			entry.Member = symbol[1]
+		}
+		entry.ObfPkg = entry.Pkg
+		res = append(res, entry)
+	}
+	return res
+}
+
+// Resolve updates the list with the new import paths of
+// the packages it refers to, after they were moved in an
+// obfuscated GOPATH.
+// Entries which can be read in more than one way are read
+// with the first package which exists in the GOPATH.
+func (k *KeepList) Resolve(gopath string, moves PackageMoves) {
+	if k == nil {
+		return
+	}
+	for i, readings := range k.readings {
+		for _, reading := range readings {
+			dir := filepath.Join(gopath, "src", filepath.FromSlash(moves.Obfuscated(reading.Pkg)))
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				k.entries[i] = reading
+				break
+			}
+		}
+	}
+	for i, entry := range k.entries {
+		k.entries[i].ObfPkg = moves.Obfuscated(entry.Pkg)
+	}
+}
+
+// AddUses adds the names which code was found to look up
+// at run time.
+func (k *KeepList) AddUses(uses []reflectiveUse) {
+	for _, use := range uses {
+		k.entries = append(k.entries, use.Types...)
+		for _, member := range use.Members {
+			if k.members == nil {
+				k.members = map[string]bool{}
+			}
+			k.members[member] = true
+		}
+	}
+}
+
+// AddAPI adds the symbols matched by -keep patterns.
+func (k *KeepList) AddAPI(entries []keepEntry) {
+	k.api = append(k.api, entries...)
+}
+
+// KeepsName checks if a top-level type, function,
+// variable, or constant must keep its name.
+// The package is identified by its current import path.
+func (k *KeepList) KeepsName(pkgPath, name string) bool {
+	return k.Reason(pkgPath, name, "", nil) != ""
+}
+
+// KeepsMember checks if a method or field must keep its
+// name.
+// The type may be referred to by its original name or by
+// its hashed name.
+func (k *KeepList) KeepsMember(pkgPath, typeName, member string, n NameHasher) bool {
+	return k.Reason(pkgPath, typeName, member, n) != ""
+}
+
+// Reason tells why a symbol keeps its name: "keep" if a
+// -keep pattern matches it, "reflectnames" if it is listed
+// by -reflectnames or looked up by reflection, or "" if
+// it does not keep its name. The member is "" for
+// top-level symbols.
+func (k *KeepList) Reason(pkgPath, typeName, member string, n NameHasher) string {
+	if k == nil {
+		return ""
+	}
+	matches := func(entry keepEntry) bool {
+		if entry.ObfPkg != pkgPath || entry.Member != member {
+			return false
+		}
+		return entry.Type == typeName || (member != "" && n.Hash(entry.Type) == typeName)
+	}
+	for _, entry := range k.api {
+		if matches(entry) {
+			return "keep"
+		}
+	}
+	if member != "" && k.members[member] {
+		return "reflectnames"
+	}
+	for _, entry := range k.entries {
+		if matches(entry) {
+			return "reflectnames"
+		}
+	}
+	return ""
+}
+
+// Surface describes the symbols in the list as they are
+// declared in an obfuscated GOPATH.
+// Entries which are not declared anywhere are reported
+// with the kind "missing".
+func (k *KeepList) Surface(gopath string, n NameHasher) ([]ReflectiveSymbol, error) {
+	if k == nil {
+		return nil, nil
+	}
+	var res []ReflectiveSymbol
+	for _, entry := range k.entries {
+		dir := filepath.Join(gopath, "src", entry.ObfPkg)
+		kind, err := declarationKind(dir, entry, n)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ReflectiveSymbol{
+			Symbol:  entry.String(),
+			Package: entry.ObfPkg,
+			Kind:    kind,
+		})
+	}
+	return res, nil
+}
+
+// declarationKind finds how a keep entry is declared in a
+// package directory: as a "type", "method", or "field".
+func declarationKind(dir string, entry keepEntry, n NameHasher) (string, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "missing", nil
+		}
+		return "", err
+	}
+	isType := func(name string) bool {
+		return name == entry.Type || name == n.Hash(entry.Type)
+	}
+	for _, item := range listing {
+		if !isGoFile(item.Name()) {
+			continue
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, filepath.Join(dir, item.Name()), nil, 0)
+		if err != nil {
+			return "", err
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if entry.Member != "" && d.Recv != nil && d.Name.Name == entry.Member &&
+					isType(receiverTypeName(d.Recv.List[0])) {
+					return "method", nil
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					spec, ok := spec.(*ast.TypeSpec)
+					if !ok || !isType(spec.Name.Name) {
+						continue
+					}
+					if entry.Member == "" {
+						return "type", nil
+					}
+					st, ok := spec.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					for _, field := range st.Fields.List {
+						for _, name := range field.Names {
+							if name.Name == entry.Member {
+								return "field", nil
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return "missing", nil
+}
@@ -0,0 +1,68 @@
+package obfuscate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeepList(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []keepEntry
+	}{
+		{"app/plugin.Handler", []keepEntry{{Pkg: "app/plugin", Type: "Handler"}}},
+		{"app/plugin.Handler.Serve", []keepEntry{{Pkg: "app/plugin", Type: "Handler", Member: "Serve"}}},
+		{"main.Config main.Config.Name", []keepEntry{
+			{Pkg: "main", Type: "Config"},
+			{Pkg: "main", Type: "Config", Member: "Name"},
+		}},
+		{"example.com/a.b/pkg.Type", []keepEntry{{Pkg: "example.com/a.b/pkg", Type: "Type"}}},
+		// Before Resolve, dotted package names are read with
+		// the shortest package.
+		{"gopkg.in/yaml.v2.Decoder", []keepEntry{{Pkg: "gopkg.in/yaml", Type: "v2", Member: "Decoder"}}},
+		{"gopkg.in/yaml.v2.Decoder.Decode", []keepEntry{{Pkg: "gopkg.in/yaml.v2", Type: "Decoder", Member: "Decode"}}},
+	}
+	for _, test := range tests {
+		keep, err := ParseKeepList(test.list)
+		if err != nil {
+			t.Errorf("%s: %s", test.list, err)
+			continue
+		}
+		for i := range test.expected {
+			test.expected[i].ObfPkg = test.expected[i].Pkg
+		}
+		if !reflect.DeepEqual(keep.entries, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.list, test.expected, keep.entries)
+		}
+	}
+
+	for _, list := range []string{"app/plugin", "app/plugin.", "app/plugin..Type", "a/b.c-d", "a.1x"} {
+		if _, err := ParseKeepList(list); err == nil {
+			t.Errorf("%s: expected an error", list)
+		}
+	}
+}
+
+func TestKeepListResolve(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"gopkg.in/yaml.v2/yaml.go": "package yaml\n\ntype Decoder struct{}\n",
+		"app/dep/dep.go":           "package dep\n\ntype v2 struct{ Decoder int }\n",
+	})
+	keep, err := ParseKeepList("gopkg.in/yaml.v2.Decoder app/dep.v2.Decoder gopkg.in/yaml.v2.Decoder.Decode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves := PackageMoves{{"app/dep", "x1"}}
+	keep.Resolve(gopath, moves)
+	expected := []keepEntry{
+		{Pkg: "gopkg.in/yaml.v2", ObfPkg: "gopkg.in/yaml.v2", Type: "Decoder"},
+		{Pkg: "app/dep", ObfPkg: "x1", Type: "v2", Member: "Decoder"},
+		{Pkg: "gopkg.in/yaml.v2", ObfPkg: "gopkg.in/yaml.v2", Type: "Decoder", Member: "Decode"},
+	}
+	if !reflect.DeepEqual(keep.entries, expected) {
+		t.Errorf("expected %v, got %v", expected, keep.entries)
+	}
+	if !keep.KeepsName("gopkg.in/yaml.v2", "Decoder") {
+		t.Error("Decoder is not kept")
+	}
+}
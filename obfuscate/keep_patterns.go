@@ -0,0 +1,199 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// keepFlags lists patterns of symbols which keep their
+// names, so that a package can offer a stable API while
+// everything else is obfuscated. Patterns are separated by
+// commas.
+var keepFlags listFlag
+
+// keepPatterns are the parsed -keep patterns.
+var keepPatterns []keepPattern
+
+// A keepPattern matches symbols by their original names,
+// either with a regular expression or with a package path
+// and globs for the name and member.
+type keepPattern struct {
+	// Regexp, if set, is matched against "import/path.Name"
+	// and "import/path.Type.Member".
+	Regexp *regexp.Regexp
+
+	// Text is the pattern itself. Since import paths may
+	// contain dots, it is only split into the path and the
+	// globs when it is matched against a package.
+	Text string
+
+	// Pkg is set for patterns which match the packages
+	// under an import path, and Globs are then the globs
+	// after it.
+	Pkg   string
+	Globs string
+}
+
+// setupKeep parses the -keep flags. A pattern is either
+// "re:" and a regular expression, or an import path, which
+// may end with "/..." to match the packages under it, a
+// dot, and a name glob, optionally followed by a dot and a
+// member glob, like "corp.com/plugin.[A-Z]*" or
+// "corp.com/plugin/....Handler.*".
+func setupKeep() error {
+	for _, value := range keepFlags {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			pattern, err := parseKeepPattern(item)
+			if err != nil {
+				return flagError("Invalid -keep pattern: " + err.Error())
+			}
+			keepPatterns = append(keepPatterns, pattern)
+		}
+	}
+	return nil
+}
+
+func parseKeepPattern(item string) (keepPattern, error) {
+	if strings.HasPrefix(item, "re:") {
+		expr, err := regexp.Compile(item[3:])
+		if err != nil {
+			return keepPattern{}, err
+		}
+		return keepPattern{Regexp: expr}, nil
+	}
+	res := keepPattern{Text: item}
+	globs := item[strings.LastIndex(item, "/")+1:]
+	if idx := strings.Index(item, "/...."); idx >= 0 {
+		res.Pkg, res.Globs = item[:idx], item[idx+5:]
+		globs = res.Globs
+		if strings.Count(globs, ".") > 1 {
+			return keepPattern{}, fmt.Errorf("%s: expected import/path/....Name or import/path/....Type.Member", item)
+		}
+	} else if !strings.Contains(globs, ".") {
+		return keepPattern{}, fmt.Errorf("%s: missing name", item)
+	}
+	for _, glob := range strings.Split(globs, ".") {
+		if _, err := path.Match(glob, ""); glob == "" || err != nil {
+			return keepPattern{}, fmt.Errorf("%s: invalid name pattern %q", item, glob)
+		}
+	}
+	return res, nil
+}
+
+// Matches checks if a pattern matches a symbol of a
+// package, by original names. The member is empty for
+// top-level symbols.
+func (k keepPattern) Matches(pkg, name, member string) bool {
+	if k.Regexp != nil {
+		symbol := pkg + "." + name
+		if member != "" {
+			symbol += "." + member
+		}
+		return k.Regexp.MatchString(symbol)
+	}
+	globs := k.Globs
+	if k.Pkg == "" {
+		if !strings.HasPrefix(k.Text, pkg+".") {
+			return false
+		}
+		globs = k.Text[len(pkg)+1:]
+	} else if pkg != k.Pkg && !strings.HasPrefix(pkg, k.Pkg+"/") {
+		return false
+	}
+	parts := strings.Split(globs, ".")
+	if (member == "") != (len(parts) == 1) || len(parts) > 2 {
+		return false
+	}
+	if ok, _ := path.Match(parts[0], name); !ok {
+		return false
+	}
+	if member == "" {
+		return true
+	}
+	ok, _ := path.Match(parts[1], member)
+	return ok
+}
+
+// keptByPattern checks if any -keep pattern matches a
+// symbol.
+func keptByPattern(pkg, name, member string) bool {
+	for _, pattern := range keepPatterns {
+		if pattern.Matches(pkg, name, member) {
+			return true
+		}
+	}
+	return false
+}
+
+// findKeptSymbols lists the symbols declared in the
+// packages of a GOPATH, for which include returns true,
+// that -keep patterns match, while they still have their
+// original names.
+func findKeptSymbols(gopath string, moves PackageMoves, include func(string) bool) ([]keepEntry, error) {
+	if len(keepPatterns) == 0 {
+		return nil, nil
+	}
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	var res []keepEntry
+	for _, pkg := range pkgs {
+		if !include(pkg) {
+			continue
+		}
+		original := moves.Original(pkg)
+		add := func(name, member string) {
+			if name != "_" && member != "_" && keptByPattern(original, name, member) {
+				res = append(res, keepEntry{Pkg: original, ObfPkg: pkg, Type: name, Member: member})
+			}
+		}
+		files, err := parsePackageFiles(token.NewFileSet(), filepath.Join(srcDir, filepath.FromSlash(pkg)))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			for _, decl := range file.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					if decl.Recv == nil {
+						add(decl.Name.Name, "")
+					} else if len(decl.Recv.List) == 1 {
+						add(receiverTypeName(decl.Recv.List[0]), decl.Name.Name)
+					}
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							add(spec.Name.Name, "")
+							st, ok := spec.Type.(*ast.StructType)
+							if !ok {
+								continue
+							}
+							for _, field := range st.Fields.List {
+								for _, name := range field.Names {
+									add(spec.Name.Name, name.Name)
+								}
+							}
+						case *ast.ValueSpec:
+							for _, name := range spec.Names {
+								add(name.Name, "")
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
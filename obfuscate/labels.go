@@ -0,0 +1,57 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+// ObfuscateLabels renames the statement labels in the
+// packages of a GOPATH for which include returns true,
+// along with the goto, break, and continue statements
+// which refer to them.
+func ObfuscateLabels(gopath string, n NameHasher, include func(string) bool) error {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return err
+	}
+	return forEachFile(files, func(f sourceFile) error {
+		return obfuscateFileLabels(f.Path, n)
+	})
+}
+
+// obfuscateFileLabels renames the labels in a single file.
+// Labels are scoped to their function, so every label with
+// the same name gets the same new name.
+func obfuscateFileLabels(path string, n NameHasher) error {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, nil, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	skipped := findSkippedCode(file)
+	var edits []fileEdit
+	rename := func(label *ast.Ident) {
+		if label == nil || label.Name == "_" || skipped.Contains(label) {
+			return
+		}
+		edits = append(edits, fileEdit{
+			Start: set.Position(label.Pos()).Offset,
+			End:   set.Position(label.End()).Offset,
+			Text:  n.Hash("label#" + label.Name),
+		})
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LabeledStmt:
+			rename(node.Label)
+		case *ast.BranchStmt:
+			rename(node.Label)
+		}
+		return true
+	})
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyFileEdits(path, edits)
+}
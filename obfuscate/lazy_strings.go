@@ -0,0 +1,104 @@
+package obfuscate
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// lazyStrings decides how strings are decoded. With "off",
+// they are decoded by the function of the helper package
+// wherever they are used. With "cache" or "nocache", every
+// literal is decoded by a function of its own, with its
+// own scheme and key, when it is first used; with "cache",
+// the result is kept for later uses, and with "nocache",
+// it is decoded again every time.
+var lazyStrings string
+
+// lazyString is a literal which is decoded by a function
+// of its own, written at the end of its file.
+type lazyString struct {
+	Name  string
+	Value string
+}
+
+// decodesLazily checks if literals are decoded by
+// functions of their own.
+func decodesLazily() bool {
+	return lazyStrings == "cache" || lazyStrings == "nocache" || ephemeralStrings()
+}
+
+// randomScheme picks the scheme of a lazily decoded
+// string.
+func randomScheme(r *rand.Rand) stringScheme {
+	return stringScheme{
+		Scheme: r.Intn(numSchemes),
+		Mul:    byte(r.Intn(128)*2 + 1),
+		Add:    byte(r.Intn(256)),
+		Shift:  uint(r.Intn(4) * 8),
+	}
+}
+
+// WriteLazyFunc writes a function which decodes str with a
+// scheme and a key from r, so that there is no single
+// routine which decodes every string of the program.
+// With -lazystrings cache, the string is decoded once,
+// into a variable, the first time the function is called.
+// With -strings ephemeral, the decoded bytes and the key
+// are wiped before the function returns.
+func (s *stringHelper) WriteLazyFunc(w *bufio.Writer, r *rand.Rand, name, str string) {
+	scheme := randomScheme(r)
+	reverse := scheme.Scheme != schemeStream && r.Intn(2) == 1
+	data, key := scheme.encode(r, str)
+
+	var value, once string
+	if lazyStrings == "cache" {
+		s.lock.Lock()
+		s.Lazy = true
+		s.lock.Unlock()
+
+		value = hexName(randomBytes(r, hashedSymbolSize))
+		once = hexName(randomBytes(r, hashedSymbolSize))
+		fmt.Fprintf(w, "\nvar (\n\t%s string\n\t%s %s.%s\n)\n", value, once, s.Alias, s.Once)
+	}
+
+	fmt.Fprintf(w, "\nfunc %s() string {\n", name)
+	in := "\t"
+	if once != "" {
+		fmt.Fprintf(w, "\t%s.Do(func() {\n", once)
+		in = "\t\t"
+	}
+	w.WriteString(in + "d := ")
+	writeByteSlice(w, data)
+	keyByte := "k[i]"
+	if scheme.Scheme == schemeStream {
+		keyByte = "byte(k>>" + strconv.Itoa(int(scheme.Shift)) + ")"
+		fmt.Fprintf(w, "\n%sk := uint32(%#x)\n", in,
+			uint32(key[0])|uint32(key[1])<<8|uint32(key[2])<<16|uint32(key[3])<<24)
+	} else {
+		w.WriteString("\n" + in + "k := ")
+		writeByteSlice(w, key)
+		w.WriteString("\n")
+	}
+	if reverse {
+		w.WriteString(in + "for i := len(d) - 1; i >= 0; i-- {\n")
+	} else {
+		w.WriteString(in + "for i := range d {\n")
+	}
+	if scheme.Scheme == schemeStream {
+		fmt.Fprintf(w, "%[1]s\tk ^= k << 13\n%[1]s\tk ^= k >> 17\n%[1]s\tk ^= k << 5\n", in)
+	}
+	fmt.Fprintf(w, "%s\td[i] = %s\n%s}\n", in, scheme.decodeExpr("d[i]", keyByte, "i"), in)
+	if once != "" {
+		fmt.Fprintf(w, "\t\t%s = string(d)\n\t})\n\treturn %s\n}\n", value, value)
+	} else if ephemeralStrings() {
+		w.WriteString("\ts := string(d)\n\tfor i := range d {\n\t\td[i] = 0\n\t}\n")
+		if scheme.Scheme != schemeStream {
+			w.WriteString("\tfor i := range k {\n\t\tk[i] = 0\n\t}\n")
+		}
+		w.WriteString("\treturn s\n}\n")
+	} else {
+		w.WriteString("\treturn string(d)\n}\n")
+	}
+}
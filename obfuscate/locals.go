@@ -0,0 +1,179 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+)
+
+// ObfuscateLocals renames the parameters, results,
+// receivers, and local variables and constants of the
+// functions in the packages of a GOPATH for which include
+// returns true.
+//
+// The packages are type-checked, so that every identifier
+// is renamed with the object it refers to: a composite
+// literal key which names a struct field keeps its name
+// even if a local has the same one, and a local which
+// shadows another name only takes the references in its
+// own scope. Files which are not part of a package that
+// type-checks, like tests and files for other platforms,
+// fall back to the parser's resolution of identifiers.
+func ObfuscateLocals(gopath string, n NameHasher, include func(string) bool) error {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return err
+	}
+	pkgs := map[string]bool{}
+	for _, f := range files {
+		pkgs[f.PkgPath] = true
+	}
+
+	imp := newSourceImporter(gopath)
+	edits := map[string][]fileEdit{}
+	imp.Visit = func(pkg *types.Package, files []*ast.File, info *types.Info) {
+		if !pkgs[pkg.Path()] || !imp.TypeChecks(pkg.Path()) {
+			return
+		}
+		for _, file := range files {
+			path := imp.set.Position(file.Package).Filename
+			edits[path] = typedLocalEdits(imp.set, file, pkg, info, n)
+		}
+	}
+	for _, pkg := range sortedKeys(pkgs) {
+		imp.Import(pkg)
+	}
+
+	return forEachFile(files, func(f sourceFile) error {
+		fileEdits, ok := edits[f.Path]
+		if !ok {
+			return obfuscateFileLocals(f.Path, n)
+		} else if len(fileEdits) == 0 {
+			return nil
+		}
+		return applyFileEdits(f.Path, fileEdits)
+	})
+}
+
+// typedLocalEdits finds the edits which rename the local
+// names in a type-checked file.
+func typedLocalEdits(set *token.FileSet, file *ast.File, pkg *types.Package, info *types.Info,
+	n NameHasher) []fileEdit {
+	skipped := findSkippedCode(file)
+	res := []fileEdit{}
+	rename := func(ident *ast.Ident) {
+		if ident.Name == "_" || skipped.Contains(ident) {
+			return
+		}
+		res = append(res, fileEdit{
+			Start: set.Position(ident.Pos()).Offset,
+			End:   set.Position(ident.End()).Offset,
+			Text:  n.Hash("local#" + ident.Name),
+		})
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.TypeSwitchStmt:
+			// The variable of a type switch is declared anew in
+			// every clause, and not by the switch itself.
+			if assign, ok := node.Assign.(*ast.AssignStmt); ok {
+				rename(assign.Lhs[0].(*ast.Ident))
+			}
+		case *ast.Ident:
+			obj := info.Defs[node]
+			if obj == nil {
+				obj = info.Uses[node]
+			}
+			if isLocalObject(obj, pkg) {
+				rename(node)
+			}
+		}
+		return true
+	})
+	return res
+}
+
+// isLocalObject checks if an object is a variable or
+// constant which a function of a package declares.
+func isLocalObject(obj types.Object, pkg *types.Package) bool {
+	switch obj := obj.(type) {
+	case *types.Var:
+		if obj.IsField() {
+			return false
+		}
+	case *types.Const:
+	default:
+		return false
+	}
+	parent := obj.Parent()
+	return obj.Pkg() == pkg && parent != nil && parent != pkg.Scope() && parent != types.Universe
+}
+
+// obfuscateFileLocals renames the local names in a single
+// file, using the parser's resolution of identifiers.
+// Every local with the same name gets the same new name,
+// so that shadowing works as it did.
+func obfuscateFileLocals(path string, n NameHasher) error {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, nil, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	skipped := findSkippedCode(file)
+
+	// The parser declares struct fields as variables, but
+	// does not resolve selectors to them.
+	fields := map[*ast.Field]bool{}
+
+	// The parser resolves composite literal keys to locals
+	// with the same name, even if they are struct fields.
+	keys := map[string]bool{}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.StructType:
+			for _, field := range node.Fields.List {
+				fields[field] = true
+			}
+		case *ast.CompositeLit:
+			for _, elt := range node.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.Ident); ok {
+						keys[key.Name] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+	topLevel := map[*ast.Object]bool{}
+	for _, obj := range file.Scope.Objects {
+		topLevel[obj] = true
+	}
+
+	var edits []fileEdit
+	ast.Inspect(file, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if !ok || ident.Obj == nil || ident.Name == "_" || keys[ident.Name] || topLevel[ident.Obj] ||
+			skipped.Contains(ident) {
+			return true
+		}
+		if ident.Obj.Kind != ast.Var && ident.Obj.Kind != ast.Con {
+			return true
+		}
+		if field, ok := ident.Obj.Decl.(*ast.Field); ok && fields[field] {
+			return true
+		}
+		edits = append(edits, fileEdit{
+			Start: set.Position(ident.Pos()).Offset,
+			End:   set.Position(ident.End()).Offset,
+			Text:  n.Hash("local#" + ident.Name),
+		})
+		return true
+	})
+	if len(edits) == 0 {
+		return nil
+	}
+	return applyFileEdits(path, edits)
+}
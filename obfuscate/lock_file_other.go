@@ -0,0 +1,53 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package obfuscate
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// tryLockFile creates a lock file, or returns a nil
+// function if another run holds it, where files cannot be
+// locked with flock.
+// A lock file left by a run which exited is taken over.
+func tryLockFile(path string, owner []byte) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.Write(owner)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() {
+				os.Remove(path)
+			}, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+		stale, err := readOwner(path)
+		if err != nil || !stale.Exited() {
+			return nil, nil
+		}
+		// Another run may take over the same lock, and create
+		// a new one between the check and a removal, so the
+		// file is moved aside, and put back if it turns out to
+		// be a new lock.
+		aside := fmt.Sprintf("%s.stale-%d", path, os.Getpid())
+		if err := os.Rename(path, aside); err != nil {
+			continue
+		}
+		if moved, err := readOwner(aside); err == nil && !moved.Same(stale) {
+			os.Link(aside, path)
+		} else {
+			log.Println("Removed lock left by exited process", stale.PID, "at", path)
+		}
+		os.Remove(aside)
+	}
+}
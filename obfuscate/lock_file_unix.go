@@ -0,0 +1,44 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package obfuscate
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes an flock on a lock file, or returns a
+// nil function if another run holds it.
+// The kernel releases the lock of a run which crashed.
+func tryLockFile(path string, owner []byte) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if !lockedPath(f, path) {
+			f.Close()
+			continue
+		}
+		if err := writeLockOwner(f, owner); err != nil {
+			os.Remove(path)
+			f.Close()
+			return nil, err
+		}
+		// The file is removed before it is unlocked, so that a
+		// run which opened it in the meantime sees that it is
+		// gone once it gets the lock.
+		return func() {
+			os.Remove(path)
+			f.Close()
+		}, nil
+	}
+}
@@ -0,0 +1,46 @@
+package obfuscate
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var lockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// tryLockFile locks a lock file with LockFileEx, or returns
+// a nil function if another run holds it.
+// Windows releases the lock of a run which crashed.
+//
+// The file is left in place when the lock is released,
+// since it cannot be removed while another run has it open.
+func tryLockFile(path string, owner []byte) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var overlapped syscall.Overlapped
+	res, _, err := lockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if res == 0 {
+		f.Close()
+		if err == errorLockViolation {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := writeLockOwner(f, owner); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,108 @@
+package obfuscate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+)
+
+// These constants describe the code signatures which the
+// Go linker embeds in darwin/arm64 binaries.
+// They are defined in cmd/internal/codesign.
+const (
+	loadCmdCodeSignature = 0x1d
+	csMagicEmbeddedSig   = 0xfade0cc0
+	csMagicCodeDir       = 0xfade0c02
+	csHashSHA256         = 2
+)
+
+// rehashCodeSignature updates the page hashes of the code
+// signature of a Mach-O binary after its contents have
+// changed, as `codesign -s - -f` would, but without needing
+// a darwin host.
+// Only ad-hoc signatures, which the Go linker creates, can
+// be updated; an identity's signature would still be
+// broken, and is reported as an error.
+// Binaries which are not Mach-O, or are not signed, are left
+// as they are.
+func rehashCodeSignature(data []byte) error {
+	f, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 16 || f.ByteOrder.Uint32(raw) != loadCmdCodeSignature {
+			continue
+		}
+		offset := int(f.ByteOrder.Uint32(raw[8:]))
+		size := int(f.ByteOrder.Uint32(raw[12:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return errors.New("code signature out of range")
+		}
+		return rehashSuperBlob(data, data[offset:offset+size])
+	}
+	return nil
+}
+
+// rehashSuperBlob updates every code directory in the
+// signature blob of a binary.
+// Signature blobs are big-endian regardless of the target.
+func rehashSuperBlob(data, sig []byte) error {
+	be := binary.BigEndian
+	if len(sig) < 12 || be.Uint32(sig) != csMagicEmbeddedSig {
+		return errors.New("unknown code signature format")
+	}
+	count := int(be.Uint32(sig[8:]))
+	for i := 0; i < count; i++ {
+		entry := 12 + i*8
+		if entry+8 > len(sig) {
+			return errors.New("code signature index out of range")
+		}
+		offset := int(be.Uint32(sig[entry+4:]))
+		if offset+8 > len(sig) || be.Uint32(sig[offset:]) != csMagicCodeDir {
+			continue
+		}
+		if err := rehashCodeDirectory(data, sig[offset:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rehashCodeDirectory recomputes the hash of every page of
+// a binary which a code directory covers.
+func rehashCodeDirectory(data, dir []byte) error {
+	be := binary.BigEndian
+	if len(dir) < 40 {
+		return errors.New("code directory out of range")
+	}
+	hashOffset := int(be.Uint32(dir[16:]))
+	numSlots := int(be.Uint32(dir[28:]))
+	codeLimit := int(be.Uint32(dir[32:]))
+	hashSize := int(dir[36])
+	hashType := dir[37]
+	pageSize := 1 << dir[39]
+	if hashType != csHashSHA256 || hashSize != sha256.Size {
+		return errors.New("unsupported code signature hash type")
+	}
+	if codeLimit > len(data) || hashOffset+numSlots*hashSize > len(dir) {
+		return errors.New("code directory out of range")
+	}
+	for i := 0; i < numSlots; i++ {
+		start := i * pageSize
+		end := start + pageSize
+		if end > codeLimit {
+			end = codeLimit
+		}
+		if start > end {
+			return errors.New("code directory out of range")
+		}
+		sum := sha256.Sum256(data[start:end])
+		copy(dir[hashOffset+i*hashSize:], sum[:])
+	}
+	return nil
+}
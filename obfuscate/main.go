@@ -0,0 +1,1616 @@
+package obfuscate
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// Command line arguments.
+var (
+	customPadding       string
+	tags                string
+	outputGopath        bool
+	keepTests           bool
+	winHide             bool
+	noStaticLink        bool
+	preservePackageName bool
+	verbose             bool
+	goos                string
+	goarch              string
+	stripNotes          bool
+	stripGNUVersion     bool
+	keepBuildInfo       bool
+	pruneTypes          bool
+	reflectNames        string
+	reportPath          string
+	mapPath             string
+	randomRoot          bool
+	flattenDepth        int
+	mergePkgs           bool
+	inlineConsts        bool
+	trimPath            bool
+	buildMode           string
+	profileName         string
+	configPath          string
+	useModules          bool
+	depCacheDir         string
+	cpuProfile          string
+	incrementalDir      string
+)
+
+// defaultFlattenDepth is used when a per-package profile
+// enables -flatten but the flag itself is not set.
+const defaultFlattenDepth = 3
+
+var (
+	config       *Config
+	cmdLineFlags map[string]bool
+	prepEnvFlags listFlag
+)
+
+// runFlags is the flag set of the current run, which is
+// flag.CommandLine for the command, or a set of its own
+// for each Run.
+var runFlags = flag.CommandLine
+
+// Main runs the gobfuscate command with the arguments of
+// the process, and exits with an error status if it fails.
+func Main() {
+	// The watch subcommand takes the same flags.
+	watchMode := len(os.Args) > 1 && os.Args[1] == "watch"
+	unmapMode := len(os.Args) > 1 && os.Args[1] == "unmap"
+	unmangleMode := len(os.Args) > 1 && os.Args[1] == "unmangle"
+	cleanMode := len(os.Args) > 1 && os.Args[1] == "clean"
+	selftestMode := len(os.Args) > 1 && os.Args[1] == "selftest"
+	verifyMode := len(os.Args) > 1 && os.Args[1] == "verify"
+	rebuildMode := len(os.Args) > 1 && os.Args[1] == "rebuild"
+	deltaMode := len(os.Args) > 1 && os.Args[1] == "delta"
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		if !printVersion() {
+			os.Exit(1)
+		}
+		return
+	}
+	if watchMode || unmapMode || unmangleMode || cleanMode || selftestMode || verifyMode || rebuildMode || deltaMode {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	registerFlags(flag.CommandLine)
+	flag.Parse()
+
+	if unmapMode {
+		if len(flag.Args()) != 1 {
+			fmt.Fprintln(stderr, "Usage: gobfuscate unmap [flags] mapping_file")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if !unmap(flag.Args()[0]) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if unmangleMode {
+		if len(flag.Args()) != 0 || mapPath == "" {
+			fmt.Fprintln(stderr, "Usage: gobfuscate unmangle -map mapping_file [flags] <trace")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if !unmangle() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cleanMode {
+		if len(flag.Args()) != 0 {
+			fmt.Fprintln(stderr, "Usage: gobfuscate clean [flags]")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if !clean() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if selftestMode {
+		if len(flag.Args()) != 0 {
+			fmt.Fprintln(stderr, "Usage: gobfuscate selftest [flags]")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if !selftest() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if verifyMode {
+		if len(flag.Args()) != 0 {
+			fmt.Fprintln(stderr, "Usage: gobfuscate verify -config file [flags]")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if !verify() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if rebuildMode {
+		if len(flag.Args()) != 1 && len(flag.Args()) != 2 {
+			fmt.Fprintln(stderr, "Usage: gobfuscate rebuild [flags] manifest_file [out_path]")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		var outPath string
+		if len(flag.Args()) == 2 {
+			outPath = flag.Args()[1]
+		}
+		if !rebuild(flag.Args()[0], outPath) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if deltaMode {
+		if len(flag.Args()) != 4 {
+			fmt.Fprintln(stderr, "Usage: gobfuscate delta -seed seed [flags] old_rev new_rev pkg_name out_dir")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		args := flag.Args()
+		if !delta(args[0], args[1], args[2], args[3]) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(flag.Args()) != 2 && !(dryRun && len(flag.Args()) == 1) {
+		fmt.Fprintln(stderr, "Usage: gobfuscate [watch] [flags] pkg_name out_path")
+		fmt.Fprintln(stderr, "       gobfuscate -dryrun [flags] pkg_name")
+		fmt.Fprintln(stderr, "       gobfuscate unmap [flags] mapping_file")
+		fmt.Fprintln(stderr, "       gobfuscate unmangle -map mapping_file [flags] <trace")
+		fmt.Fprintln(stderr, "       gobfuscate clean [flags]")
+		fmt.Fprintln(stderr, "       gobfuscate selftest [flags]")
+		fmt.Fprintln(stderr, "       gobfuscate verify -config file [flags]")
+		fmt.Fprintln(stderr, "       gobfuscate rebuild [flags] manifest_file [out_path]")
+		fmt.Fprintln(stderr, "       gobfuscate delta -seed seed [flags] old_rev new_rev pkg_name out_dir")
+		fmt.Fprintln(stderr, "       gobfuscate version")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	pkgName := flag.Args()[0]
+	var outPath string
+	if len(flag.Args()) == 2 {
+		outPath = flag.Args()[1]
+	}
+
+	handleInterrupts()
+	if err := runCommand(pkgName, outPath, watchMode); err != nil {
+		fmt.Fprintln(stderr, err)
+		os.Exit(1)
+	}
+}
+
+// registerFlags defines the command line flags on fs,
+// which sets them to their defaults.
+func registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&customPadding, "padding", "", "use a custom padding for hashing sensitive information (otherwise a random padding will be used)")
+	fs.StringVar(&seed, "seed", "",
+		"derive the padding and every random choice from this seed, so that runs on the same sources give identical output")
+	fs.StringVar(&namingMode, "naming", "hash",
+		"how to make new names: hash (random-looking letters) or words (dictionary words)")
+	fs.StringVar(&dictionaryPath, "dictionary", "",
+		"with -naming words or -pathstyle mimic, read the words from this file")
+	fs.StringVar(&pathStyle, "pathstyle", "hash",
+		"how to rename package paths: hash, or mimic (paths resembling open source projects)")
+	fs.StringVar(&sourceArchive, "src", "",
+		"obfuscate the module in this tar or zip archive, or - to read it from stdin, instead of code from the GOPATH")
+	fs.BoolVar(&outputGopath, "outdir", false, "output a full GOPATH")
+	fs.BoolVar(&dryRun, "dryrun", false,
+		"obfuscate without building, and print a summary of what changed (the out_path may be left out)")
+	fs.StringVar(&diffPath, "diff", "", "with -dryrun, write a unified diff of the obfuscated sources to this path")
+	fs.BoolVar(&keepTests, "keeptests", false, "keep _test.go files")
+	fs.BoolVar(&keepWork, "keepwork", false, "keep the temporary directories of a run which is interrupted")
+	fs.BoolVar(&winHide, "winhide", false, "hide windows GUI")
+	fs.BoolVar(&useModules, "modules", false,
+		"obfuscate a package of the module in the current directory, using a private module cache")
+	fs.StringVar(&depCacheDir, "depcache", "",
+		"with -modules, reuse obfuscated dependency modules from this cache directory (requires -padding)")
+	fs.StringVar(&buildCacheDir, "buildcache", "",
+		"copy the binaries from this directory if it has a build with the same sources, flags, and toolchain, "+
+			"or else store them in it (requires -seed)")
+	fs.StringVar(&resumeDir, "resume", "",
+		"copy the sources through this directory, so that an interrupted run resumes copying where it stopped")
+	fs.DurationVar(&copyTimeout, "copytimeout", 0, "stop copying the sources after this long (e.g. 10m)")
+	fs.BoolVar(&noStaticLink, "nostatic", false, "do not statically link")
+	fs.StringVar(&workDir, "workdir", "",
+		"make the workspace and other temporary directories in this directory, instead of the system's temp directory")
+	fs.BoolVar(&noDiskCheck, "nodiskcheck", false, "do not check that there is enough disk space for the run before copying")
+	fs.StringVar(&staticTags, "statictags", "netgo,osusergo",
+		"build tags added to statically linked linux binaries")
+	fs.BoolVar(&preservePackageName, "noencrypt", false,
+		"no encrypted package name for go build command (works when main package has CGO code)")
+	fs.BoolVar(&randomRoot, "randomroot", false,
+		"move the package's module (or the package itself) to a random single-component path")
+	fs.IntVar(&flattenDepth, "flatten", 0,
+		"move every package to a random path with at most this many components (0 keeps the original layout)")
+	fs.BoolVar(&inlineConsts, "inlineconsts", false,
+		"inline the exported constants of the main package's module and drop their declarations")
+	fs.StringVar(&internalMode, "internal", "dissolve",
+		"what to do with internal/ path components: dissolve (hash them, lifting the import restriction) or preserve (keep them)")
+	fs.BoolVar(&mergePkgs, "merge", false, "merge the packages of the main package's module into the main package")
+	fs.BoolVar(&renameFiles, "renamefiles", false,
+		"give Go files names derived from the padding and shuffle the declarations in them")
+	fs.BoolVar(&checkStrings, "checkstrings", false,
+		"check that the string helper decodes edge cases and random strings correctly, by running a generated program")
+	fs.StringVar(&lazyStrings, "lazystrings", "off",
+		"decode every string with a function, scheme, and key of its own on first use: off, cache (keep the result), or nocache")
+	fs.StringVar(&stringsMode, "strings", "default",
+		"how long decoded strings are kept: default, or ephemeral (decode them at every use, wipe the buffers, and never keep them in package-level variables)")
+	fs.BoolVar(&junkCode, "junk", false,
+		"add unreachable junk functions, decoy strings, and bogus calls to the packages of the main package's module")
+	fs.Float64Var(&junkRatio, "junkratio", 0.2, "with -junk, how many junk functions to add for every function of a package")
+	fs.StringVar(&funcListPath, "funcs", "",
+		"write the fingerprints of the obfuscated functions to this file, which the delta subcommand compares")
+	fs.BoolVar(&encryptEmbeds, "embeds", false,
+		"encrypt the files embedded with //go:embed, and decrypt them at runtime")
+	fs.BoolVar(&httpStrings, "httpstrings", false,
+		"encrypt the URLs, routes, header names and values, and user agents passed to net/http, except standard ones")
+	fs.BoolVar(&numericLiterals, "literals", false,
+		"also obfuscate the integer and floating-point constants in expressions, computing them at runtime")
+	fs.BoolVar(&errorCodes, "errorcodes", false,
+		"replace error messages in the main package's module with codes, listed in the mapping file")
+	fs.Var(&stripLogs, "striplog",
+		"remove calls to a logging function or package, e.g. log.Printf or corp.com/trace (can be repeated)")
+	fs.Var(&flagKeyFuncs, "flagkeys",
+		"move the keys passed to a feature flag or config lookup function, e.g. corp.com/flags.Enabled or *.GetString, "+
+			"into a table of the helper package (can be repeated)")
+	fs.Var(&excludeFlags, "exclude",
+		"copy packages with these import paths or prefixes without obfuscating them, separated by commas (can be repeated)")
+	fs.Var(&keepFlags, "keep",
+		"keep the names of symbols matching these patterns, like pkg/path.Name, pkg/path.Type.Member, or re:regexp, "+
+			"separated by commas (can be repeated)")
+	fs.Var(&yaraRules, "yara", "fail if the YARA rules in this file match a binary (can be repeated)")
+	fs.IntVar(&numVariants, "variants", 1,
+		"build this many differently obfuscated variants, each with its own mapping file")
+	fs.BoolVar(&verbose, "verbose", false, "verbose mode")
+	fs.IntVar(&numJobs, "jobs", runtime.NumCPU(), "number of files or packages to parse and rewrite in parallel")
+	fs.IntVar(&numBuildJobs, "buildjobs", runtime.NumCPU(), "number of targets to build in parallel")
+	fs.DurationVar(&watchInterval, "interval", time.Second, "in watch mode, how often to check for changes")
+	fs.StringVar(&metricsPath, "metrics", "",
+		"write Prometheus metrics of the run to this file (for the node_exporter textfile collector)")
+	fs.StringVar(&cpuProfile, "cpuprofile", "", "write a CPU profile of the obfuscation to this file")
+	fs.StringVar(&mapKeyPath, "mapkey", "",
+		"encrypt mapping files with the key or passphrase in this file (see also "+mapPassphraseEnv+")")
+	fs.StringVar(&mapSignPath, "mapsign", "", "sign mapping files with this Ed25519 private key (PEM)")
+	fs.StringVar(&mapVerifyPath, "mapverify", "",
+		"only read mapping files signed by this Ed25519 public key (PEM)")
+	fs.BoolVar(&trimPath, "trimpath", true,
+		"pass -trimpath to the go compiler, which leaves the paths of the workspace and GOROOT out of binaries")
+	fs.StringVar(&buildMode, "buildmode", "", "pass -buildmode to the go compiler (e.g. c-shared for a DLL or shared library)")
+	fs.Var(&exportNameFlags, "exportname",
+		"with -buildmode c-shared, export a function under another name, as Orig=New (can be repeated)")
+	fs.StringVar(&incrementalDir, "incremental", "",
+		"keep the workspace and build cache in this directory, and reuse them when only the main package changed (requires -padding)")
+	fs.StringVar(&profileName, "profile", "", "apply a preset of flags: light, standard, or paranoid")
+	fs.StringVar(&configPath, "config", "", "read settings from a JSON or YAML config file (default: gobfuscate.yaml in the project root, if it exists)")
+	fs.Var(&prepEnvFlags, "prepenv",
+		"set a Go environment variable (e.g. GOPRIVATE=corp.com) while preparing the workspace (can be repeated)")
+	fs.Var(&scanCommands, "scancmd",
+		"fail if this command exits with an error when run on a binary (can be repeated)")
+	fs.StringVar(&secretsMode, "secrets", "warn",
+		"what to do when hardcoded secrets are found: warn, fail, or off")
+	fs.StringVar(&tags, "tags", "", "tags are passed to the go compiler")
+	fs.StringVar(&fipsMode, "fips", "",
+		"build with FIPS 140 validated cryptography: boringcrypto or fips140 (Go 1.24+), and verify the binaries")
+	fs.StringVar(&packer, "pack", "", "pack the binaries with this executable packer after they are checked: upx")
+	fs.StringVar(&packFlags, "packflags", "", "with -pack, pass these flags to the packer (e.g. \"--best --lzma\")")
+	fs.StringVar(&pgoProfile, "pgo", "",
+		"a CPU profile of the original program, translated to the new names for profile-guided optimization")
+	fs.StringVar(&goos, "goos", build.Default.GOOS, "the GOOS variables to build on (can be multiple)")
+	fs.StringVar(&goarch, "goarch", build.Default.GOARCH, "the GOARCH variable to build on (can be multiple)")
+	fs.BoolVar(&stripNotes, "stripnotes", false, "remove .note.* and .comment sections from linux binaries")
+	fs.BoolVar(&stripGNUVersion, "stripgnuversion", false,
+		"with -stripnotes, also remove .gnu.version* sections from static linux binaries")
+	fs.BoolVar(&pruneTypes, "prunetypes", false,
+		"rename local types and unexported fields, and check the binary for leftover type names")
+	fs.StringVar(&reflectSafety, "reflectsafety", "keep",
+		"what to do with names which templates and reflection look up: keep (them), warn, or off")
+	fs.StringVar(&reflectNames, "reflectnames", "",
+		"types, methods, and fields to keep for reflection, as pkg/path.Type[.Member] (can be multiple)")
+	fs.StringVar(&reportPath, "report", "", "write a JSON report of what was left readable to this path")
+	fs.StringVar(&manifestPath, "manifest", "",
+		"write a manifest of the build environment to this path, from which the rebuild subcommand reproduces the binaries")
+	fs.StringVar(&releasePath, "release", "", "write a signed release descriptor of the binaries to this path")
+	fs.StringVar(&provenancePath, "provenance", "",
+		"write a signed in-toto SLSA provenance attestation of the binaries to this path")
+	fs.StringVar(&provenanceBuilder, "provenancebuilder", "", "the builder ID of the -provenance, like the URI of a CI runner")
+	fs.StringVar(&sbomFormat, "sbom", "",
+		"write an SBOM of the original dependencies next to every binary: cyclonedx or spdx")
+	fs.StringVar(&releaseSignPath, "releasesign", "",
+		"sign the release descriptor with this Ed25519 private key (PEM), instead of the -mapsign key")
+	fs.StringVar(&mapPath, "map", "",
+		"write a mapping file of the original and obfuscated names of packages, symbols, and strings to this path")
+	fs.StringVar(&blocklistPath, "blocklist", "",
+		"fail if any pattern in this file (one per line, or hex:... for bytes) appears in a binary")
+	fs.BoolVar(&keepBuildInfo, "keepbuildinfo", false, "keep the embedded module and build info in binaries")
+	fs.DurationVar(&cleanMaxAge, "maxage", 0,
+		"with clean, remove cache entries and artifacts unused for longer than this (e.g. 720h)")
+	fs.StringVar(&cleanMaxSize, "maxsize", "",
+		"with clean, remove the oldest cache entries and artifacts until each directory fits in this size (e.g. 10GiB)")
+	fs.StringVar(&artifactsDir, "artifacts", "", "with clean, apply -maxage and -maxsize to the files in this directory")
+}
+
+// runCommand obfuscates (or watches) a package, and builds
+// it to outPath, once the flags are parsed.
+func runCommand(pkgName, outPath string, watchMode bool) error {
+	if err := startManifest(pkgName); err != nil {
+		return err
+	}
+	if err := startProvenance(pkgName); err != nil {
+		return err
+	}
+	if workDir != "" {
+		// The go command may run in sourceDir, which is
+		// another directory.
+		if abs, err := filepath.Abs(workDir); err == nil {
+			workDir = abs
+		}
+	}
+	pkgName, extraPackages = splitPackages(pkgName)
+	if watchMode && len(extraPackages) > 0 {
+		return flagError("Watch mode cannot build several packages.")
+	} else if watchMode && dryRun {
+		return flagError("Watch mode cannot be combined with -dryrun.")
+	}
+	if sourceArchive != "" {
+		if watchMode {
+			return flagError("Watch mode cannot build a source archive.")
+		}
+		if isRemoteRef(pkgName) {
+			return flagError("The -src flag cannot be combined with a remote package.")
+		}
+		source, err := unpackSource(pkgName)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		pkgName = source.PkgName
+	} else if isRemoteRef(pkgName) {
+		if watchMode {
+			return flagError("Watch mode cannot build a remote package.")
+		}
+		for _, pkg := range extraPackages {
+			if isRemoteRef(pkg) {
+				return flagError("Only the first package can be remote: " + pkg)
+			}
+		}
+		remote, err := fetchRemote(pkgName)
+		if err != nil {
+			return err
+		}
+		defer remote.Close()
+		pkgName = remote.PkgName
+	}
+	setups := []func() error{setupSeed, setupBlocklist, setupStripLogs, setupFlagKeys, setupExclude, setupKeep,
+		setupNaming, setupExportNames}
+	if err := setupProfile(pkgName); err != nil {
+		return err
+	}
+	for _, setup := range setups {
+		if err := setup(); err != nil {
+			return err
+		}
+	}
+	if !useModules {
+		vanity, err := fetchMissingImports(append([]string{pkgName}, extraPackages...))
+		if err != nil {
+			return err
+		}
+		defer vanity.Close()
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return stepError("create CPU profile", err)
+		}
+		pprof.StartCPUProfile(f)
+	}
+	var err error
+	if watchMode {
+		err = watch(pkgName, outPath)
+	} else {
+		err = obfuscate(pkgName, outPath)
+	}
+	if cpuProfile != "" {
+		pprof.StopCPUProfile()
+	}
+	writeMetrics(err == nil)
+	return err
+}
+
+// setupProfile loads the config file, if there is one,
+// and applies its settings and the selected profile.
+// Without -config, a config file is looked for in the
+// root of the project which contains pkgName, if it is
+// not empty.
+func setupProfile(pkgName string) error {
+	cmdLineFlags = explicitFlags()
+	if configPath == "" && pkgName != "" {
+		path, err := findConfigFile(pkgName)
+		if err != nil {
+			return stepError("find config file", err)
+		} else if path != "" {
+			log.Println("Using config file", path)
+			configPath = path
+		}
+	}
+	if configPath != "" {
+		var err error
+		config, err = LoadConfig(configPath)
+		if configErr, ok := err.(*ConfigError); ok {
+			return &CheckError{Kind: "Invalid config", Problems: configErr.Problems}
+		} else if err != nil {
+			return stepError("load config", err)
+		}
+		applyConfig()
+	}
+	profile := profileName
+	if profile == "" && config != nil {
+		profile = config.Profile
+	}
+	if profile == "" {
+		return nil
+	}
+	if err := applyProfile(profile, cmdLineFlags); err != nil {
+		return stepError("apply profile", err)
+	}
+	return nil
+}
+
+// applyConfig applies the settings of the config file
+// which have flags, unless the flags were passed.
+// Excluded packages and tags are added to those of the
+// flags.
+func applyConfig() {
+	if config.Padding != "" && !cmdLineFlags["padding"] {
+		customPadding = config.Padding
+	}
+	if len(config.Exclude) > 0 {
+		excludeFlags = append(excludeFlags, strings.Join(config.Exclude, ","))
+	}
+	tags = mergeTags(tags, config.Tags)
+}
+
+// passRuns checks if the pass controlled by a flag should
+// run for at least one package.
+func passRuns(flagName string, enabled bool) bool {
+	if cmdLineFlags[flagName] {
+		return enabled
+	}
+	return enabled || config.AnyPackageEnables(flagName)
+}
+
+// packageFilter creates a function which checks if the
+// pass controlled by a flag applies to a package in the
+// obfuscated GOPATH, taking per-package profiles into
+// account.
+// Flags passed on the command line apply to every package
+// which is not excluded.
+func packageFilter(flagName string, enabled bool, moves *PackageMoves) func(string) bool {
+	return func(pkg string) bool {
+		original := moves.Original(pkg)
+		if isExcluded(original) {
+			return false
+		} else if cmdLineFlags[flagName] {
+			return enabled
+		}
+		profile := config.PackageProfile(original)
+		if profile == "" {
+			return enabled
+		}
+		return profileEnables(profile, flagName)
+	}
+}
+
+func obfuscate(pkgName, outPath string) error {
+	for _, check := range []func() error{checkFlags, checkExtraPackageFlags, checkReleaseFlags, checkTargets} {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	if err := checkOutputTemplate(outPath); err != nil {
+		return err
+	}
+	if dryRun {
+		return obfuscateDryRun(pkgName)
+	}
+	if buildCacheDir != "" {
+		if done, err := useBuildCache(pkgName, outPath); done || err != nil {
+			return err
+		}
+	}
+	if incrementalDir != "" {
+		return obfuscateIncremental(pkgName, outPath)
+	} else if resumeDir != "" {
+		release, err := openResumeDir()
+		if err != nil {
+			return err
+		}
+		err = obfuscateBuild(pkgName, outPath)
+		release(err == nil)
+		return err
+	}
+	return obfuscateBuild(pkgName, outPath)
+}
+
+// obfuscateBuild obfuscates and builds a package, or its
+// -variants.
+func obfuscateBuild(pkgName, outPath string) error {
+	if numVariants > 1 {
+		return obfuscateVariants(pkgName, outPath)
+	}
+
+	var newGopath string
+	if outputGopath {
+		newGopath = outPath
+		if err := os.Mkdir(newGopath, 0755); err != nil {
+			return stepError("create destination", err)
+		}
+	} else {
+		var err error
+		newGopath, err = newTempDir("gopath")
+		if err != nil {
+			return stepError("create temp dir", err)
+		}
+		defer os.RemoveAll(newGopath)
+	}
+
+	var modCache string
+	if useModules {
+		var err error
+		modCache, err = newTempDir("modcache")
+		if err != nil {
+			return stepError("create temp dir", err)
+		}
+		defer cleanModCache(modCache)
+	}
+
+	ws, err := prepareWorkspace(pkgName, newGopath, modCache)
+	if err != nil {
+		return err
+	}
+	mapFile := mappingPath(outPath)
+	if mapFile != "" {
+		if err := ws.WriteMapping(mapFile); err != nil {
+			return err
+		}
+	}
+	if funcListPath != "" {
+		if err := ws.WriteFuncList(funcListPath); err != nil {
+			return err
+		}
+	}
+	if outputGopath {
+		return nil
+	}
+	if err := ws.Build(outPath); err != nil {
+		return err
+	}
+	if buildCacheKey != "" {
+		if err := storeBuild(ws, mapFile); err != nil {
+			log.Println("Warning: failed to store the build in the build cache:", err)
+		}
+	}
+	if sbomFormat != "" {
+		if err := ws.WriteSBOMs(); err != nil {
+			return err
+		}
+	}
+	if releasePath != "" {
+		if err := ws.WriteRelease(mapFile); err != nil {
+			return err
+		}
+	}
+	if provenancePath != "" {
+		if err := ws.WriteProvenance(mapFile); err != nil {
+			return err
+		}
+	}
+	if manifestPath == "" {
+		return nil
+	}
+	return ws.WriteManifest(outPath)
+}
+
+// checkFlags reports combinations of flags which cannot
+// be used together.
+func checkFlags() error {
+	if passRuns("merge", mergePkgs) && keepTests {
+		return flagError("The -merge and -keeptests flags cannot be combined.")
+	}
+
+	if preservePackageName && (randomRoot || passRuns("flatten", flattenDepth > 0)) {
+		return flagError("The -noencrypt flag cannot be combined with -randomroot or -flatten, " +
+			"which move the main package.")
+	}
+
+	if internalMode != "dissolve" && internalMode != "preserve" {
+		return flagError("The -internal flag must be dissolve or preserve.")
+	} else if internalMode == "preserve" && passRuns("flatten", flattenDepth > 0) {
+		return flagError("The -internal preserve flag cannot be combined with -flatten, " +
+			"which moves packages out from under their parents.")
+	}
+
+	if secretsMode != "warn" && secretsMode != "fail" && secretsMode != "off" {
+		return flagError("The -secrets flag must be warn, fail, or off.")
+	}
+
+	if reflectSafety != "keep" && reflectSafety != "warn" && reflectSafety != "off" {
+		return flagError("The -reflectsafety flag must be keep, warn, or off.")
+	}
+
+	if lazyStrings != "off" && lazyStrings != "cache" && lazyStrings != "nocache" {
+		return flagError("The -lazystrings flag must be off, cache, or nocache.")
+	}
+	if stringsMode != "default" && stringsMode != "ephemeral" {
+		return flagError("The -strings flag must be default or ephemeral.")
+	} else if ephemeralStrings() && lazyStrings == "cache" {
+		return flagError("The -strings ephemeral flag cannot be combined with -lazystrings cache.")
+	}
+
+	if _, err := ParseKeepList(reflectNames); err != nil {
+		return stepError("parse reflect names", err)
+	}
+
+	if pgoProfile != "" {
+		if _, err := os.Stat(pgoProfile); err != nil {
+			return stepError("read PGO profile", err)
+		}
+	}
+
+	if numVariants < 1 {
+		return flagError("The -variants flag must be at least 1.")
+	} else if numVariants > 1 && (depCacheDir != "" || incrementalDir != "" || outputGopath) {
+		return flagError("The -variants flag cannot be combined with -depcache, -incremental, or -outdir.")
+	}
+
+	if depCacheDir != "" {
+		if resumeDir != "" {
+			return flagError("The -depcache and -resume flags cannot be combined.")
+		}
+		if !useModules || customPadding == "" {
+			return flagError("The -depcache flag requires -modules and -padding.")
+		}
+		if randomRoot || passRuns("flatten", flattenDepth > 0) {
+			return flagError("The -depcache flag cannot be combined with -randomroot or -flatten.")
+		}
+		if pathStyle == "mimic" {
+			return flagError("The -depcache flag cannot be combined with -pathstyle mimic.")
+		}
+	}
+	if funcListPath != "" && (numVariants > 1 || buildCacheDir != "") {
+		return flagError("The -funcs flag cannot be combined with -variants or -buildcache.")
+	}
+	for _, check := range []func() error{checkFIPSFlags, checkPackFlags, checkJunkFlags, checkSBOMFlags,
+		checkProvenanceFlags, checkDryRunFlags} {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Workspace is a GOPATH (or module workspace) holding
+// obfuscated code which is ready to be built.
+type Workspace struct {
+	Gopath  string
+	PkgName string
+	Hasher  NameHasher
+
+	// ExtraPkgs are the other main packages which are built
+	// from the workspace.
+	ExtraPkgs []string
+
+	// Moves and Renames record how packages and symbols
+	// were renamed, and Hoists which functions had their
+	// bodies hoisted by HoistClosures.
+	// If the dependencies came from a cache, the renames in
+	// DepRecord apply to them as well.
+	Moves     PackageMoves
+	Renames   []symbolRenameReq
+	Hoists    []symbolRenameReq
+	DepRecord *renameRecord
+
+	// Junk lists the files of junk code, which are left out
+	// of the mapping file.
+	Junk []junkFile
+
+	// GoWork is the go.work file of a module workspace.
+	GoWork string
+
+	// GoCache is the build cache, which defaults to a
+	// directory inside the workspace.
+	GoCache string
+
+	// TypeNames are checked for in the binaries if type
+	// metadata was pruned.
+	TypeNames []typeName
+	Prune     bool
+
+	// Strings tells which string literals were left in
+	// plaintext, to explain blocklist matches.
+	Strings *StringCoverage
+
+	// Report is the report of the run, if one is written.
+	// The built binaries are added to it.
+	Report *Report
+
+	// Artifacts lists the binaries which were built.
+	Artifacts []Artifact
+
+	// Helper is the package which decodes strings.
+	Helper *stringHelper
+
+	// Audit finds original names in the symbols of the
+	// binaries.
+	Audit *symbolAudit
+
+	// ErrorMessages maps the codes which replaced error
+	// messages to the messages.
+	ErrorMessages map[string]string
+
+	// FileNames maps the original paths of renamed files,
+	// as "import/path/name.go", to their new names.
+	FileNames map[string]string
+}
+
+// prepareWorkspace copies a package and its dependencies
+// into a new GOPATH and obfuscates them.
+// With -modules, dependencies are downloaded to modCache.
+func prepareWorkspace(pkgName, newGopath, modCache string) (*Workspace, error) {
+	n := newNameHasher(customPadding)
+	pkgName, depCache, err := copyWorkspace(pkgName, newGopath, modCache, n)
+	if err != nil {
+		return nil, err
+	}
+	return obfuscateWorkspace(pkgName, newGopath, n, depCache)
+}
+
+// newNameHasher creates a hasher with a padding, or with
+// a random padding if it is empty.
+func newNameHasher(padding string) NameHasher {
+	if padding == "" {
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		return buf
+	}
+	return []byte(padding)
+}
+
+// copyWorkspace copies a package and its dependencies into
+// a new GOPATH, or restores them from the -depcache.
+// It returns the import path of the package, which a
+// pattern given with -modules is resolved to.
+func copyWorkspace(pkgName, newGopath, modCache string, n NameHasher) (string, *DepCache, error) {
+	prepEnv, err := prepareEnv()
+	if err != nil {
+		return "", nil, flagError("Invalid preparation environment: " + err.Error())
+	}
+	runMetrics.Phase("copy")
+	// With -resume, the sources are copied into the resume
+	// directory, and then into the workspace once they are
+	// all there.
+	copyDest := newGopath
+	if resumeDir != "" {
+		copyDest = filepath.Join(resumeDir, "gopath")
+		if useModules {
+			modCache = filepath.Join(resumeDir, "modcache")
+		}
+	}
+	copyProgress, err = startCopy(pkgName, newGopath, copyDest)
+	if err != nil {
+		return "", nil, stepError("open the copy checkpoint", err)
+	}
+	defer func() {
+		copyProgress.Close()
+		copyProgress = nil
+	}()
+	var depCache *DepCache
+	if useModules {
+		err = withEnv(prepEnv, func() error {
+			var err error
+			if depCacheDir != "" {
+				pkgName, depCache, err = CopyModulesCached(pkgName, copyDest, modCache, keepTests,
+					depCacheDir, n)
+			} else {
+				pkgName, err = CopyModules(pkgName, copyDest, modCache, keepTests)
+			}
+			for i := 0; err == nil && i < len(extraPackages); i++ {
+				extraPackages[i], err = CopyModules(extraPackages[i], copyDest, modCache, keepTests)
+			}
+			return err
+		})
+		if err != nil {
+			return "", nil, stepError("copy modules into a workspace", err)
+		}
+	} else {
+		err = withEnv(prepEnv, func() error {
+			return copyGopathPackages(append([]string{pkgName}, extraPackages...), copyDest, keepTests)
+		})
+		if err != nil {
+			return "", nil, stepError("copy into a new GOPATH", err)
+		}
+	}
+	if copyDest != newGopath {
+		if err := copyTree(filepath.Join(copyDest, "src"), filepath.Join(newGopath, "src")); err != nil {
+			return "", nil, stepError("copy the checkpointed sources", err)
+		}
+	}
+	if err := checkBinaryNames(pkgName); err != nil {
+		return "", nil, err
+	}
+	return pkgName, depCache, nil
+}
+
+// obfuscateWorkspace obfuscates a workspace made by
+// copyWorkspace.
+func obfuscateWorkspace(pkgName, newGopath string, n NameHasher, depCache *DepCache) (*Workspace, error) {
+	keep, err := ParseKeepList(reflectNames)
+	if err != nil {
+		return nil, stepError("parse reflect names", err)
+	}
+
+	var moves PackageMoves
+	// Passes which rename or rewrite code skip dependencies
+	// restored from the cache.
+	include := func(pkg string) bool { return true }
+	if depCache != nil {
+		runMetrics.Cache("dependencies", depCache.Hit)
+	}
+	if depCache != nil && depCache.Hit {
+		log.Println("Using cached dependencies...")
+		moves = depCache.Moves
+		include = func(pkg string) bool {
+			return !depCache.IsDep(moves, pkg)
+		}
+	}
+	secrets, err := checkSecrets(newGopath, pkgName, moves, include)
+	if err != nil {
+		return nil, err
+	}
+	for _, mainPkg := range append([]string{pkgName}, extraPackages...) {
+		if isExcluded(mainPkg) {
+			return nil, flagError("The main package cannot be excluded: " + mainPkg)
+		}
+	}
+	// Excluded packages are still scanned for secrets, but
+	// left alone by every other pass.
+	if len(excludedPaths) > 0 {
+		rewritten := include
+		include = func(pkg string) bool {
+			return rewritten(pkg) && !isExcluded(moves.Original(pkg))
+		}
+	}
+	if randomRoot {
+		log.Println("Randomizing module root...")
+		runMetrics.Phase("packages")
+		root, err := projectRoot(pkgName)
+		if err != nil {
+			return nil, stepError("find module root", err)
+		}
+		if containsExcluded(root) {
+			return nil, flagError("The -randomroot flag cannot move excluded packages in " + root)
+		}
+		moves, err = RandomizeRoot(newGopath, root, n)
+		if err != nil {
+			return nil, stepError("randomize module root", err)
+		}
+	}
+	if passRuns("flatten", flattenDepth > 0) {
+		log.Println("Restructuring packages...")
+		runMetrics.Phase("packages")
+		depth := flattenDepth
+		if depth <= 0 {
+			depth = defaultFlattenDepth
+		}
+		flattened := packageFilter("flatten", flattenDepth > 0, &moves)
+		layoutMoves, err := RestructurePackages(newGopath, depth, n, func(pkg string) bool {
+			return flattened(pkg) && !containsExcluded(moves.Original(pkg))
+		})
+		if err != nil {
+			return nil, stepError("restructure packages", err)
+		}
+		moves = append(moves, layoutMoves...)
+	}
+	if depCache == nil || !depCache.Hit {
+		log.Println("Obfuscating package names...")
+		runMetrics.Phase("packages")
+		nameMoves, err := ObfuscatePackageNames(newGopath, n, func(pkg string) bool {
+			return !containsExcluded(moves.Original(pkg)) && !keepsInternal(pkg)
+		})
+		if err != nil {
+			return nil, stepError("obfuscate package names", err)
+		}
+		moves = append(moves, nameMoves...)
+	}
+	keep.Resolve(newGopath, moves)
+	if len(keepPatterns) > 0 {
+		api, err := findKeptSymbols(newGopath, moves, include)
+		if err != nil {
+			return nil, stepError("find kept symbols", err)
+		}
+		log.Println("Keeping the names of", len(api), "symbol(s) matched by -keep")
+		keep.AddAPI(api)
+	}
+	if reflectSafety != "off" {
+		log.Println("Looking for names used by reflection...")
+		runMetrics.Phase("reflection")
+		uses, err := findReflectiveUses(newGopath, moves, include)
+		if err != nil {
+			return nil, stepError("look for names used by reflection", err)
+		}
+		for _, use := range uses {
+			if use.Problem != "" {
+				log.Println("Warning:", use.Pos+":", use.Problem)
+			} else if reflectSafety == "keep" {
+				log.Println("Keeping names used by reflection at", use.Pos+":", use.Names())
+			} else {
+				log.Println("Warning:", use.Pos+": names used by reflection may be renamed:", use.Names())
+			}
+		}
+		if reflectSafety == "keep" {
+			keep.AddUses(uses)
+		}
+	}
+	log.Println("Stripping debug code...")
+	runMetrics.Phase("debug")
+	if err := StripDebugCode(newGopath, include); err != nil {
+		return nil, stepError("strip debug code", err)
+	}
+	if passRuns("inlineconsts", inlineConsts) {
+		log.Println("Inlining constants...")
+		runMetrics.Phase("constants")
+		inModule, err := moduleFilter(pkgName, moves)
+		if err != nil {
+			return nil, stepError("find module root", err)
+		}
+		include := packageFilter("inlineconsts", inlineConsts, &moves)
+		err = InlineConstants(newGopath, func(pkg string) bool {
+			return inModule(pkg) && include(pkg)
+		})
+		if err != nil {
+			return nil, stepError("inline constants", err)
+		}
+	}
+	if len(logPatterns) > 0 {
+		log.Println("Stripping log calls...")
+		runMetrics.Phase("logs")
+		if err := StripLogCalls(newGopath, logPatterns, moves, include); err != nil {
+			return nil, stepError("strip log calls", err)
+		}
+	}
+	var errorMessages map[string]string
+	if errorCodes {
+		log.Println("Replacing error messages with codes...")
+		runMetrics.Phase("errors")
+		inModule, err := moduleFilter(pkgName, moves)
+		if err != nil {
+			return nil, stepError("find module root", err)
+		}
+		errorMessages, err = NormalizeErrors(newGopath, n, func(pkg string) bool {
+			return inModule(pkg) && include(pkg)
+		})
+		if err != nil {
+			return nil, stepError("replace error messages", err)
+		}
+	}
+	var junkFiles []junkFile
+	if junkCode {
+		log.Println("Inserting junk code...")
+		runMetrics.Phase("junk")
+		inModule, err := moduleFilter(pkgName, moves)
+		if err != nil {
+			return nil, stepError("find module root", err)
+		}
+		junkFiles, err = InsertJunkCode(newGopath, func(pkg string) bool {
+			return inModule(pkg) && include(pkg)
+		})
+		if err != nil {
+			return nil, stepError("insert junk code", err)
+		}
+		var count int
+		for _, f := range junkFiles {
+			count += len(f.Funcs)
+		}
+		log.Println("Added", count, "junk function(s)")
+	}
+	helper := newStringHelper(newGopath, n)
+	if encryptEmbeds {
+		log.Println("Encrypting embedded files...")
+		runMetrics.Phase("embeds")
+		count, err := EncryptEmbeds(newGopath, moves, include, helper)
+		if err != nil {
+			return nil, stepError("encrypt embedded files", err)
+		}
+		log.Println("Encrypted", count, "embedded file(s)")
+	}
+	if len(flagKeyPatterns) > 0 {
+		log.Println("Moving feature flag keys into a table...")
+		runMetrics.Phase("flagkeys")
+		inModule, err := moduleFilter(pkgName, moves)
+		if err != nil {
+			return nil, stepError("find module root", err)
+		}
+		count, err := IndirectFlagKeys(newGopath, flagKeyPatterns, moves, func(pkg string) bool {
+			return inModule(pkg) && include(pkg)
+		}, helper)
+		if err != nil {
+			return nil, stepError("move feature flag keys", err)
+		}
+		log.Println("Moved the keys of", count, "lookup(s) into a table")
+	}
+	if httpStrings {
+		log.Println("Finding HTTP strings...")
+		runMetrics.Phase("http")
+		count, err := ProtectHTTPStrings(newGopath, include)
+		if err != nil {
+			return nil, stepError("find HTTP strings", err)
+		}
+		log.Println("Encrypting", count, "HTTP string(s)")
+	}
+	log.Println("Obfuscating strings...")
+	runMetrics.Phase("strings")
+	stringCoverage, err := ObfuscateStrings(newGopath, moves, include, helper)
+	if err != nil {
+		return nil, stepError("obfuscate strings", err)
+	}
+	if checkStrings {
+		log.Println("Checking string decoding...")
+		if err := CheckStringHelper(helper); err != nil {
+			return nil, stepError("check strings", err)
+		}
+	}
+	if count := stringCoverage.PlaintextSensitive(); count > 0 {
+		log.Println("Warning:", count, "sensitive string(s) left in plaintext (see -report)")
+	}
+	prune := passRuns("prunetypes", pruneTypes)
+	var typeNames []typeName
+	if prune {
+		var err error
+		typeNames, err = collectTypeNames(newGopath)
+		if err != nil {
+			return nil, stepError("collect type names", err)
+		}
+	}
+	log.Println("Obfuscating symbols...")
+	runMetrics.Phase("symbols")
+	// The helper package's names are already random.
+	renames, hoists, err := ObfuscateSymbols(newGopath, n, keep, func(pkg string) bool {
+		return include(pkg) && pkg != helper.Path
+	})
+	if err != nil {
+		return nil, stepError("obfuscate symbols", err)
+	}
+	if prune {
+		log.Println("Pruning type metadata...")
+		runMetrics.Phase("types")
+		pruned := packageFilter("prunetypes", pruneTypes, &moves)
+		err := PruneTypeMetadata(newGopath, n, keep, func(pkg string) bool {
+			return include(pkg) && pruned(pkg)
+		})
+		if err != nil {
+			return nil, stepError("prune type metadata", err)
+		}
+	}
+	if depCache != nil && !depCache.Hit {
+		log.Println("Caching dependencies...")
+		runMetrics.Phase("cache")
+		if err := depCache.Store(newGopath, moves, renames, hoists); err != nil {
+			return nil, stepError("cache dependencies", err)
+		}
+	}
+
+	if len(exportNames) > 0 {
+		log.Println("Renaming exports...")
+		exportRenames, err := RenameExports(newGopath, exportNames)
+		if err != nil {
+			return nil, stepError("rename exports", err)
+		}
+		renames = append(renames, exportRenames...)
+	}
+
+	if passRuns("merge", mergePkgs) {
+		log.Println("Merging packages...")
+		runMetrics.Phase("merge")
+		inModule, err := moduleFilter(pkgName, moves)
+		if err != nil {
+			return nil, stepError("find module root", err)
+		}
+		include := packageFilter("merge", mergePkgs, &moves)
+		merged := func(pkg string) bool {
+			return inModule(pkg) && include(pkg)
+		}
+		if err := MergePackages(newGopath, moves.Obfuscated(pkgName), moves, merged, n); err != nil {
+			return nil, stepError("merge packages", err)
+		}
+	}
+
+	var fileNames map[string]string
+	if renameFiles {
+		log.Println("Renaming files...")
+		runMetrics.Phase("files")
+		// Dependencies restored from the cache were stored
+		// before their files were renamed.
+		fileNames, err = RenameFiles(newGopath, moves, n, func(pkg string) bool {
+			return !isExcluded(moves.Original(pkg))
+		})
+		if err != nil {
+			return nil, stepError("rename files", err)
+		}
+	}
+
+	var depRecord *renameRecord
+	if depCache != nil {
+		depRecord = depCache.Record
+	}
+	audit, err := newSymbolAudit(newGopath, renames, depRecord)
+	if err != nil {
+		return nil, stepError("prepare symbol audit", err)
+	}
+
+	var goWork string
+	if useModules {
+		goWork, err = WriteWorkspaceModules(newGopath, moves)
+		if err != nil {
+			return nil, stepError("write workspace modules", err)
+		}
+	}
+
+	problems, original, err := checkPortablePaths(newGopath, moves)
+	if err != nil {
+		return nil, stepError("check paths", err)
+	}
+	for _, problem := range original {
+		log.Println("Warning: path cannot be created on every OS:", problem)
+	}
+	if len(problems) > 0 {
+		return nil, &CheckError{Kind: "Unportable path", Problems: problems}
+	}
+	problems, err = checkInternalImports(newGopath, moves)
+	if err != nil {
+		return nil, stepError("check internal imports", err)
+	}
+	if len(problems) > 0 {
+		return nil, &CheckError{Kind: "Broken internal import", Problems: problems}
+	}
+	problems = nil
+	for _, mainPkg := range append([]string{pkgName}, extraPackages...) {
+		problems = append(problems, checkEntrypoint(newGopath, mainPkg, moves)...)
+	}
+	if len(problems) > 0 {
+		return nil, &CheckError{Kind: "Broken entrypoint", Problems: problems}
+	}
+
+	var report *Report
+	if reportPath != "" {
+		log.Println("Finding bridges between kept and renamed symbols...")
+		record := newRenameRecord(nil, moves, renames, func(string) bool { return true })
+		if depRecord != nil {
+			record.merge(depRecord)
+		}
+		bridges, err := findBridges(newGopath, helper.Path, moves, keep, n, record)
+		if err != nil {
+			return nil, stepError("find bridges", err)
+		}
+		report, err = writeReport(newGopath, n, keep, stringCoverage, secrets, bridges)
+		if err != nil {
+			return nil, stepError("write report", err)
+		}
+	}
+
+	ws := &Workspace{
+		Gopath:    newGopath,
+		PkgName:   pkgName,
+		ExtraPkgs: extraPackages,
+		Hasher:    n,
+		Moves:     moves,
+		Renames:   renames,
+		Hoists:    hoists,
+		Junk:      junkFiles,
+		GoWork:    goWork,
+		TypeNames: typeNames,
+		Prune:     prune,
+		Strings:   stringCoverage,
+		Report:    report,
+		Helper:    helper,
+		Audit:     audit,
+		DepRecord: depRecord,
+		FileNames: fileNames,
+
+		ErrorMessages: errorMessages,
+	}
+	return ws, nil
+}
+
+// Build builds the obfuscated package for every target
+// platform.
+func (w *Workspace) Build(outPath string) error {
+	ldflags := `-s -w`
+	if winHide {
+		ldflags += " -H=windowsgui"
+	}
+	if !noStaticLink && !sharedBuild() {
+		ldflags += ` -extldflags '-static'`
+	}
+	if config != nil && config.LDFlags != "" {
+		ldflags += " " + config.LDFlags
+	}
+
+	goCache := w.GoCache
+	if goCache == "" {
+		goCache = w.Gopath + "/cache"
+	}
+	os.MkdirAll(goCache, 0755)
+
+	var pgoPath string
+	if pgoProfile != "" {
+		log.Println("Translating PGO profile...")
+		f, err := ioutil.TempFile(tempRoot(), "gobfuscate-*.pgo")
+		if err != nil {
+			return stepError("create temp file", err)
+		}
+		f.Close()
+		pgoPath = f.Name()
+		defer os.Remove(pgoPath)
+		if err := w.translateProfile(pgoProfile, pgoPath); err != nil {
+			return stepError("translate PGO profile", err)
+		}
+	}
+
+	if !trimPath {
+		log.Println("Warning: building without -trimpath, which embeds the paths of the workspace in binaries")
+	}
+
+	binaries, err := w.binaries(outPath)
+	if err != nil {
+		return stepError("create output directory", err)
+	}
+	paths, err := outputPaths(binaries)
+	if err != nil {
+		return stepError("name binaries", err)
+	}
+	for _, bin := range binaries {
+		if len(binaries) > 1 {
+			log.Println("Building", bin.PkgName+"...")
+		}
+		if err := w.buildBinary(bin.PkgName, paths[bin], ldflags, goCache, pgoPath); err != nil {
+			return err
+		}
+	}
+
+	if w.Report != nil {
+		if err := w.Report.Write(reportPath); err != nil {
+			return stepError("write report", err)
+		}
+	}
+	return nil
+}
+
+// buildBinary builds one main package of the workspace for
+// every target platform, to the path of each target.
+// Up to -buildjobs targets are built at the same time, each
+// with a build cache of its own.
+func (w *Workspace) buildBinary(pkgName string, paths map[buildTarget]string, ldflags, goCache, pgoPath string) error {
+	newPkg := pkgName
+	if !preservePackageName {
+		newPkg = w.Moves.Obfuscated(pkgName)
+	}
+
+	targets := buildTargets()
+	artifacts := make([]*Artifact, len(targets))
+	parallel := len(targets) > 1 && numBuildJobs > 1
+	err := runWorkers(numBuildJobs, len(targets), func(i int) error {
+		out := newTargetOutput(targets[i], parallel)
+		defer out.Flush()
+		targetCache := filepath.Join(goCache, targets[i].GOOS+"_"+targets[i].GOARCH)
+		artifact, err := w.buildTarget(newPkg, pkgName, targets[i], paths[targets[i]], ldflags, targetCache, pgoPath, out)
+		if err != nil {
+			return &TargetError{GOOS: targets[i].GOOS, GOARCH: targets[i].GOARCH, Err: err}
+		}
+		artifacts[i] = artifact
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, artifact := range artifacts {
+		w.Artifacts = append(w.Artifacts, *artifact)
+		if w.Report != nil {
+			w.Report.Artifacts = append(w.Report.Artifacts, *artifact)
+		}
+	}
+	return nil
+}
+
+// buildTarget builds a main package of the workspace for
+// one target, and checks and post-processes the binary.
+// Messages go to out, so that those of targets which are
+// built at the same time can be told apart.
+func (w *Workspace) buildTarget(newPkg, pkgName string, target buildTarget, packagePath, ldflags, goCache,
+	pgoPath string, out *targetOutput) (*Artifact, error) {
+	ctx := build.Default
+	operatingSytem, arch := target.GOOS, target.GOARCH
+	if err := os.MkdirAll(goCache, 0755); err != nil {
+		return nil, stepError("create build cache", err)
+	}
+
+	cgo := targetCGO(target)
+
+	var cc, cxx, libc string
+	if cgo == "1" {
+		cc, cxx = targetCompilers(target)
+		if cc != "" {
+			out.Log.Println("Using C compiler", cc, "for", target)
+		} else if target != (buildTarget{ctx.GOOS, ctx.GOARCH}) {
+			out.Log.Println("Warning: no C cross compiler found for", target,
+				"(set CC_"+operatingSytem+"_"+arch+" to choose one)")
+		}
+		if staticLinux(target) {
+			libc = compilerLibc(cc)
+			if libc == "glibc" {
+				out.Log.Println("Warning: statically linking cgo code against glibc for", target,
+					"(functions like getaddrinfo may still load shared libraries at run time)")
+			}
+		}
+		problems, err := checkCgoDirectives(w.Gopath, target, targetTags(target))
+		if err != nil {
+			return nil, stepError("check cgo directives", err)
+		}
+		if len(problems) > 0 {
+			return nil, &CheckError{Kind: "Unresolvable cgo directive", Problems: problems}
+		}
+	}
+
+	targetConfig := config.TargetConfig(target)
+	targetLDFlags := ldflags
+	if targetConfig.LDFlags != "" {
+		targetLDFlags += " " + targetConfig.LDFlags
+	}
+	arguments := []string{"build", "-ldflags", targetLDFlags, "-tags", targetTags(target), "-o", packagePath}
+	if trimPath {
+		arguments = append(arguments, "-trimpath")
+	}
+	if buildMode != "" {
+		arguments = append(arguments, "-buildmode="+buildMode)
+	}
+	if pgoPath != "" {
+		arguments = append(arguments, "-pgo="+pgoPath)
+	}
+	if targetConfig.GCFlags != "" {
+		arguments = append(arguments, "-gcflags", targetConfig.GCFlags)
+	}
+	arguments = append(arguments, newPkg)
+	environment := []string{
+		"GOROOT=" + ctx.GOROOT,
+		"GOARCH=" + arch,
+		"GOOS=" + operatingSytem,
+		"GOPATH=" + w.Gopath,
+		"PATH=" + os.Getenv("PATH"),
+		"GOCACHE=" + goCache,
+		"CGO_ENABLED=" + cgo,
+		"CC=" + cc,
+		"CXX=" + cxx,
+		"MACOSX_DEPLOYMENT_TARGET=" + os.Getenv("MACOSX_DEPLOYMENT_TARGET"),
+	}
+	if useModules {
+		environment = append(environment, "GO111MODULE=on", "GOWORK="+w.GoWork, "GOPROXY=off")
+	} else {
+		// Since Go 1.16, the go command is in module mode
+		// unless told otherwise.
+		environment = append(environment, "GO111MODULE=off")
+	}
+	if workDir != "" {
+		// The go command's own work directory goes there too.
+		environment = append(environment, "GOTMPDIR="+workDir)
+	}
+	environment = fipsEnv(append(environment, targetEnv(targetConfig)...))
+
+	cmd := exec.Command("go", arguments...)
+	cmd.Env = environment
+	cmd.Stdout = out.Stdout
+	cmd.Stderr = out.Stderr
+
+	if verbose {
+		fmt.Fprintln(out.Stdout)
+		fmt.Fprintln(out.Stdout, "[Verbose] Temporary path:", w.Gopath)
+		fmt.Fprintln(out.Stdout, "[Verbose] Go build command: go", strings.Join(arguments, " "))
+		fmt.Fprintln(out.Stdout, "[Verbose] Environment variables:")
+		for _, envLine := range environment {
+			fmt.Fprintln(out.Stdout, envLine)
+		}
+		fmt.Fprintln(out.Stdout)
+	}
+
+	runMetrics.Phase("build")
+	if err := runChild(cmd); err != nil {
+		return nil, stepError("compile", err)
+	}
+
+	if staticLinux(target) {
+		if err := checkStaticBinary(packagePath, target, cgo == "1", libc); err != nil {
+			return nil, err
+		}
+	}
+	artifact := Artifact{Package: pkgName, GOOS: operatingSytem, GOARCH: arch, Path: packagePath}
+	if w.Audit != nil {
+		// Some formats, like WebAssembly, cannot be
+		// audited.
+		leaks, err := w.Audit.Leaks(packagePath)
+		if err != nil {
+			out.Log.Println("Cannot audit symbols:", err)
+		}
+		for _, leak := range leaks {
+			out.Log.Println("Original name in symbol:", leak)
+		}
+		artifact.SymbolLeaks = leaks
+	}
+	if fipsMode != "" {
+		fips, err := verifyFIPS(packagePath)
+		if err != nil {
+			return nil, stepError("verify FIPS mode", err)
+		}
+		out.Log.Println("Verified FIPS mode for", target.String()+":", fips)
+		artifact.FIPS = fips
+	}
+
+	runMetrics.Phase("postprocess")
+	if err := postProcess(packagePath, operatingSytem, arch); err != nil {
+		return nil, stepError("post-process binary", err)
+	}
+	if trimPath {
+		leaks, err := pathLeaks(packagePath, localPaths(w.Gopath))
+		if err != nil {
+			return nil, stepError("check paths in binary", err)
+		}
+		for _, leak := range leaks {
+			out.Log.Println("Warning: path left in binary:", leak)
+		}
+		artifact.PathLeaks = leaks
+	}
+
+	if err := checkBlocklist(packagePath, w.Strings); err != nil {
+		return nil, err
+	}
+	if w.Prune {
+		leaks, err := typeNameLeaks(packagePath, w.TypeNames)
+		if err != nil {
+			return nil, stepError("verify type names", err)
+		}
+		for _, leak := range leaks {
+			out.Log.Println("Type name left in binary:", leak)
+		}
+	}
+	// The checks above look into the binary, which they
+	// cannot do once it is packed.
+	if packer != "" {
+		runMetrics.Phase("pack")
+		size, err := packBinary(packagePath, out)
+		if err != nil {
+			return nil, stepError("pack binary", err)
+		}
+		artifact.UnpackedSize = size
+	}
+	runMetrics.Artifact(operatingSytem, arch, packagePath)
+
+	runMetrics.Phase("scan")
+	detections, err := ScanArtifact(packagePath)
+	if err != nil {
+		return nil, stepError("scan binary", err)
+	}
+	if len(detections) > 0 {
+		var problems []string
+		for _, detection := range detections {
+			problems = append(problems, packagePath+": "+detection.String())
+		}
+		return nil, &CheckError{Kind: "Binary was detected by artifact scanner", Problems: problems}
+	}
+	return &artifact, nil
+}
+
+// moduleFilter creates a function which checks if a
+// package in the obfuscated GOPATH belongs to the same
+// module as pkgName.
+func moduleFilter(pkgName string, moves PackageMoves) (func(string) bool, error) {
+	root, err := projectRoot(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	return func(pkg string) bool {
+		orig := moves.Original(pkg)
+		return orig == root || strings.HasPrefix(orig, root+"/")
+	}, nil
+}
+
+// checkSecrets scans the main module for hardcoded
+// secrets, and fails the run on any with -secrets fail.
+func checkSecrets(gopath, pkgName string, moves PackageMoves, include func(string) bool) ([]SecretFinding, error) {
+	if secretsMode == "off" {
+		return nil, nil
+	}
+	log.Println("Scanning for secrets...")
+	inModule, err := moduleFilter(pkgName, moves)
+	if err != nil {
+		return nil, stepError("find module root", err)
+	}
+	secrets, err := ScanSecrets(gopath, moves, func(pkg string) bool {
+		return inModule(pkg) && include(pkg)
+	})
+	if err != nil {
+		return nil, stepError("scan for secrets", err)
+	}
+	if len(secrets) > 0 && secretsMode == "fail" {
+		problems := make([]string, len(secrets))
+		for i, secret := range secrets {
+			problems[i] = secret.String()
+		}
+		problems[len(problems)-1] += "\nRemove them, mark harmless ones with a // " + allowSecretComment +
+			" comment, or pass -secrets warn."
+		return nil, &CheckError{Kind: "Possible secret", Problems: problems}
+	}
+	for _, secret := range secrets {
+		log.Println("Warning:", secret)
+	}
+	return secrets, nil
+}
+
+func writeReport(gopath string, n NameHasher, keep *KeepList, coverage *StringCoverage,
+	secrets []SecretFinding, bridges []Bridge) (*Report, error) {
+	report := Report{Tool: currentTool()}
+	surface, err := keep.Surface(gopath, n)
+	if err != nil {
+		return nil, err
+	}
+	for _, sym := range surface {
+		if sym.Kind == "missing" {
+			log.Println("Reflect name not found:", sym.Symbol)
+		}
+	}
+	report.ReflectiveSurface = surface
+	report.Strings = coverage
+	report.Secrets = secrets
+	report.Bridges = bridges
+	return &report, report.Write(reportPath)
+}
+
+// postProcess applies the requested binary-level
+// transformations to a freshly built executable.
+func postProcess(path, goos, goarch string) error {
+	if !keepBuildInfo {
+		if err := stripBuildInfo(path); err != nil {
+			return fmt.Errorf("strip build info: %s", err)
+		}
+	}
+	if stripNotes && goos == "linux" {
+		if err := stripELFNotes(path, goos, goarch, stripGNUVersion); err != nil {
+			return fmt.Errorf("strip notes: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+package obfuscate
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestPath, if set, is where a manifest of the build is
+// written, from which `gobfuscate rebuild` reproduces it.
+var manifestPath string
+
+// manifestPackage and manifestDir are the package argument
+// and the working directory of the run, as they were before
+// a remote package or a -src archive was checked out.
+var manifestPackage, manifestDir string
+
+const manifestFormat = "gobfuscate-manifest-1"
+
+// manifestEnvVars are the settings of the go command which
+// are recorded in a manifest, besides the toolchain
+// settings, since they may change what is built.
+var manifestEnvVars = append([]string{
+	"GO111MODULE", "GOFLAGS", "GOTOOLCHAIN", "GOPROXY", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOSUMDB",
+	"CGO_ENABLED", "CC", "CXX", "CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_LDFLAGS", "PKG_CONFIG", "AR",
+}, toolchainEnvVars...)
+
+// manifestOutputFlags are the flags which only write files
+// about a build, or only affect where and how the sources
+// are copied, and are not recorded, so that a rebuild does
+// not replace those files.
+var manifestOutputFlags = map[string]bool{
+	"manifest":          true,
+	"map":               true,
+	"report":            true,
+	"release":           true,
+	"metrics":           true,
+	"cpuprofile":        true,
+	"resume":            true,
+	"copytimeout":       true,
+	"workdir":           true,
+	"buildcache":        true,
+	"sbom":              true,
+	"provenance":        true,
+	"provenancebuilder": true,
+	"funcs":             true,
+}
+
+// A Manifest records how a build was made, so that it can
+// be made again from the same sources. It holds the -seed,
+// so it is as secret as a mapping file.
+type Manifest struct {
+	Format string `json:"format"`
+
+	// Tool is the gobfuscate which made the build, and
+	// GoVersion is the go command it built with.
+	Tool      ToolInfo `json:"gobfuscate"`
+	GoVersion string   `json:"go_version"`
+
+	// Dir is the directory gobfuscate ran in, and
+	// SourceCommit is its git commit, if it is in a
+	// repository.
+	Dir          string `json:"dir"`
+	SourceCommit string `json:"source_commit,omitempty"`
+
+	// Package and OutPath are the arguments of the run, and
+	// Args are its flags, including the -seed.
+	Package string   `json:"package"`
+	OutPath string   `json:"out_path"`
+	Args    []string `json:"args"`
+
+	// Env holds the settings of the go command and the C
+	// compilers of each target.
+	Env map[string]string `json:"env"`
+
+	// Compilers are the versions of the C compilers of the
+	// targets which use cgo, by target.
+	Compilers map[string]string `json:"compilers,omitempty"`
+
+	Artifacts []ReleaseArtifact `json:"artifacts"`
+}
+
+// startManifest records the arguments of a run which
+// writes a manifest, and picks a random seed if there is
+// none, since builds are only reproducible with a seed.
+func startManifest(pkgName string) error {
+	if manifestPath == "" {
+		return nil
+	}
+	if outputGopath || numVariants > 1 {
+		return flagError("The -manifest flag cannot be combined with -outdir or -variants.")
+	} else if sourceArchive == "-" {
+		return flagError("The -manifest flag cannot be combined with -src - (the archive cannot be read again).")
+	}
+	var err error
+	if manifestDir, err = currentDir(); err != nil {
+		return stepError("get working directory", err)
+	}
+	manifestPackage = pkgName
+	if seed == "" {
+		var seedBytes [16]byte
+		if _, err := cryptorand.Read(seedBytes[:]); err != nil {
+			return stepError("generate seed", err)
+		}
+		seed = hex.EncodeToString(seedBytes[:])
+		log.Println("Using a random seed, which is recorded in the manifest")
+	}
+	return nil
+}
+
+// WriteManifest writes the manifest of the workspace's
+// binaries, which were built to outPath.
+func (w *Workspace) WriteManifest(outPath string) error {
+	manifest := &Manifest{
+		Format:    manifestFormat,
+		Tool:      currentTool(),
+		Dir:       manifestDir,
+		Package:   manifestPackage,
+		OutPath:   outPath,
+		Args:      manifestArgs(),
+		Compilers: map[string]string{},
+		Artifacts: []ReleaseArtifact{},
+	}
+	var err error
+	if manifest.GoVersion, err = goVersion(); err != nil {
+		return stepError("get Go version", err)
+	}
+	if manifest.Env, err = manifestEnv(); err != nil {
+		return stepError("read go env", err)
+	}
+	if commit, dirty := sourceCommit(manifestDir); commit != "" {
+		manifest.SourceCommit = commit
+		if dirty {
+			manifest.SourceCommit += "-dirty"
+		}
+	}
+	for _, target := range buildTargets() {
+		if targetCGO(target) != "1" {
+			continue
+		}
+		cc, _ := targetCompilers(target)
+		if cc == "" {
+			cc = manifest.Env["CC"]
+		}
+		if version := compilerVersion(cc); version != "" {
+			manifest.Compilers[target.String()] = version
+		}
+	}
+	for _, artifact := range w.Artifacts {
+		file, err := releaseFile(artifact.Path)
+		if err != nil {
+			return stepError("hash binary", err)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, ReleaseArtifact{
+			Package:     artifact.Package,
+			GOOS:        artifact.GOOS,
+			GOARCH:      artifact.GOARCH,
+			ReleaseFile: *file,
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return stepError("encode manifest", err)
+	}
+	if err := ioutil.WriteFile(manifestPath, append(data, '\n'), 0600); err != nil {
+		return stepError("write manifest", err)
+	}
+	return nil
+}
+
+// sourceCommit gets the git commit of the repository which
+// contains dir, if there is one, and whether it has
+// uncommitted changes.
+func sourceCommit(dir string) (string, bool) {
+	commit, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	return strings.TrimSpace(string(commit)), err == nil && len(status) > 0
+}
+
+// manifestArgs lists the flags of the run, with the seed
+// it used, which may have been picked by startManifest.
+func manifestArgs() []string {
+	var res []string
+	runFlags.Visit(func(f *flag.Flag) {
+		if manifestOutputFlags[f.Name] || f.Name == "seed" {
+			return
+		}
+		if list, ok := f.Value.(*listFlag); ok {
+			for _, value := range *list {
+				res = append(res, "-"+f.Name+"="+value)
+			}
+			return
+		}
+		res = append(res, "-"+f.Name+"="+f.Value.String())
+	})
+	return append(res, "-seed="+seed)
+}
+
+// manifestEnv reads the settings of the go command which
+// are set, and the per-target compiler and cgo variables
+// of the environment.
+func manifestEnv() (map[string]string, error) {
+	output, err := sourceCommand("go", append([]string{"env", "-json"}, manifestEnvVars...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env: %s", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(output, &values); err != nil {
+		return nil, err
+	}
+	res := map[string]string{}
+	for name, value := range values {
+		if value != "" {
+			res[name] = value
+		}
+	}
+	for _, target := range buildTargets() {
+		suffix := "_" + target.GOOS + "_" + target.GOARCH
+		for _, name := range []string{"CC", "CXX", "CGO_ENABLED"} {
+			if value := os.Getenv(name + suffix); value != "" {
+				res[name+suffix] = value
+			}
+		}
+	}
+	return res, nil
+}
+
+// compilerVersion gets the first line of the --version
+// output of a C compiler, or "" if it cannot be run.
+func compilerVersion(cc string) string {
+	fields := strings.Fields(cc)
+	if len(fields) == 0 {
+		return ""
+	}
+	output, err := exec.Command(fields[0], append(fields[1:], "--version")...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+}
+
+// rebuild reproduces the build of a manifest with the
+// environment it records, and checks that every binary is
+// identical to the one it lists.
+// The binaries are written to outPath, or else to the out
+// path of the manifest.
+func rebuild(path, outPath string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to read manifest:", err)
+		return false
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		fmt.Fprintln(stderr, "Failed to parse manifest:", err)
+		return false
+	} else if manifest.Format != manifestFormat {
+		fmt.Fprintln(stderr, "Unsupported manifest format:", manifest.Format)
+		return false
+	}
+	if tool := currentTool(); tool != manifest.Tool {
+		log.Println("Warning: the manifest was written by gobfuscate", manifest.Tool.Version, manifest.Tool.Commit,
+			"but this is", tool.Version, tool.Commit)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to find executable:", err)
+		return false
+	}
+
+	env := os.Environ()
+	for name, value := range manifest.Env {
+		env = append(env, name+"="+value)
+	}
+	if version, err := goVersion(); err == nil && version != manifest.GoVersion {
+		log.Println("Using toolchain", manifest.GoVersion, "instead of", version)
+		env = append(env, "GOTOOLCHAIN="+manifest.GoVersion)
+	}
+	dir := manifest.Dir
+	if _, err := os.Stat(dir); err != nil {
+		log.Println("Warning: rebuilding in the working directory, since", dir, "does not exist")
+		dir = ""
+	}
+	if outPath == "" {
+		outPath = manifest.OutPath
+	} else if outPath, err = filepath.Abs(outPath); err != nil {
+		fmt.Fprintln(stderr, "Failed to resolve output path:", err)
+		return false
+	}
+
+	tmpDir, err := newTempDir("rebuild")
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to create temp dir:", err)
+		return false
+	}
+	defer os.RemoveAll(tmpDir)
+	newManifest := filepath.Join(tmpDir, "manifest.json")
+	args := append(append([]string{}, manifest.Args...), "-manifest="+newManifest, manifest.Package, outPath)
+	log.Println("Rebuilding", manifest.Package+"...")
+	cmd := exec.Command(self, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintln(stderr, "Failed to rebuild:", err)
+		return false
+	}
+	data, err = ioutil.ReadFile(newManifest)
+	var rebuilt Manifest
+	if err == nil {
+		err = json.Unmarshal(data, &rebuilt)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to read manifest of rebuild:", err)
+		return false
+	}
+	return compareManifests(&manifest, &rebuilt)
+}
+
+// compareManifests checks that the binaries of a rebuild
+// are those of the original build, and points out the
+// differences in the environment which may explain it if
+// they are not.
+func compareManifests(original, rebuilt *Manifest) bool {
+	var targets []string
+	for target := range original.Compilers {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		if version := rebuilt.Compilers[target]; version != original.Compilers[target] {
+			log.Printf("Warning: the C compiler for %s is %q, but was %q", target, version, original.Compilers[target])
+		}
+	}
+	if rebuilt.GoVersion != original.GoVersion {
+		log.Println("Warning: built with", rebuilt.GoVersion, "but the manifest lists", original.GoVersion)
+	}
+	if rebuilt.SourceCommit != original.SourceCommit {
+		log.Println("Warning: the sources are at", rebuilt.SourceCommit, "but were at", original.SourceCommit)
+	}
+	hashes := map[string]string{}
+	for _, artifact := range rebuilt.Artifacts {
+		hashes[artifact.Package+" "+artifact.GOOS+"/"+artifact.GOARCH] = artifact.SHA256
+	}
+	var failed bool
+	for _, artifact := range original.Artifacts {
+		key := artifact.Package + " " + artifact.GOOS + "/" + artifact.GOARCH
+		if hash, ok := hashes[key]; !ok {
+			fmt.Fprintln(stderr, "Not rebuilt:", key)
+			failed = true
+		} else if hash != artifact.SHA256 {
+			fmt.Fprintln(stderr, "Differs from the manifest:", key)
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Fprintln(stderr, "Failed to reproduce the build.")
+		return false
+	}
+	log.Println("Reproduced all", len(original.Artifacts), "binaries identically")
+	return true
+}
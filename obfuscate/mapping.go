@@ -0,0 +1,105 @@
+package obfuscate
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// Mapping records how every package and symbol of the
+// workspace was renamed, by the original import paths in
+// pkgs, and which strings were obfuscated, leaving out
+// the junk code.
+func (w *Workspace) Mapping(pkgs []string) *renameRecord {
+	record := w.renameRecord(pkgs)
+	record.Errors = w.ErrorMessages
+	record.Files = w.FileNames
+	if w.Strings != nil {
+		record.Strings = w.Strings.Literals
+	}
+	record.removeJunk(w.Moves, w.Junk)
+	return record
+}
+
+// renameRecord records how every package and symbol of
+// the workspace was renamed, and which functions were
+// hoisted, by the original import paths in pkgs.
+func (w *Workspace) renameRecord(pkgs []string) *renameRecord {
+	all := func(string) bool {
+		return true
+	}
+	record := newRenameRecord(pkgs, w.Moves, w.Renames, all)
+	record.addHoists(w.Moves, w.Hoists, all)
+	if w.DepRecord != nil {
+		record.merge(w.DepRecord)
+	}
+	return record
+}
+
+// mappingPath is where the mapping file of a build is
+// written: the -map path, or next to the binary if error
+// codes need one, or "" if there is none.
+func mappingPath(outPath string) string {
+	if mapPath != "" {
+		return mapPath
+	} else if errorCodes && isOutputTemplate(outPath) {
+		return filepath.Join(outputTemplateDir(outPath), "map.json")
+	} else if errorCodes {
+		return outPath + ".map.json"
+	}
+	return ""
+}
+
+// WriteMapping saves the mapping file of the workspace,
+// with the original import paths of its packages.
+func (w *Workspace) WriteMapping(path string) error {
+	origPkgs, err := w.originalPackages()
+	if err != nil {
+		return stepError("list packages", err)
+	}
+	if err := writeMapping(path, w.Mapping(origPkgs)); err != nil {
+		return stepError("write mapping file", err)
+	}
+	return nil
+}
+
+// originalPackages lists the original import paths of the
+// packages of the workspace, other than the helper.
+func (w *Workspace) originalPackages() ([]string, error) {
+	pkgs, err := workspacePackages(w.Gopath)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, pkg := range pkgs {
+		if w.Helper == nil || pkg != w.Helper.Path {
+			res = append(res, w.Moves.Original(pkg))
+		}
+	}
+	return res, nil
+}
+
+// writeMapping saves a mapping file, which is encrypted
+// and signed like other mapping files.
+func writeMapping(path string, record *renameRecord) error {
+	tool := currentTool()
+	record.Tool = &tool
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeMapFile(path, append(data, '\n'))
+}
+
+// workspacePackages lists the import paths of the packages
+// in a GOPATH.
+func workspacePackages(gopath string) ([]string, error) {
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := map[string]bool{}
+	for _, file := range files {
+		pkgs[file.PkgPath] = true
+	}
+	return sortedKeys(pkgs), nil
+}
@@ -0,0 +1,68 @@
+package obfuscate
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappingRoundTrip(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"obf/app/main.go": "package main\n\nimport \"fmt\"\n\n" +
+			"func greet(name string) string {\n\treturn \"hello, \" + name\n}\n\n" +
+			"func main() {\n\tdefer func() {\n\t\tfmt.Println(greet(\"gopher\"))\n\t}()\n}\n",
+	})
+	n := NameHasher("mapping")
+	renames, hoists, err := ObfuscateSymbols(gopath, n, nil, func(pkg string) bool {
+		return pkg == "obf/app"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hoists) != 1 || hoists[0].OldName != `"obf/app".main` {
+		t.Fatalf("unexpected hoists: %v", hoists)
+	}
+	hoisted := hoists[0].NewName
+
+	ws := &Workspace{
+		Gopath:  gopath,
+		Moves:   PackageMoves{{From: "example.com/app", To: "obf/app"}},
+		Renames: renames,
+		Hoists:  hoists,
+	}
+	path := filepath.Join(t.TempDir(), "map.json")
+	if err := ws.WriteMapping(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := readMapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record renameRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Hoisted["example.com/app.main"] != hoisted {
+		t.Errorf("main is not recorded as hoisted: %v", record.Hoisted)
+	}
+	if _, ok := record.Symbols["example.com/app.main"]; ok {
+		t.Error("main is recorded as renamed")
+	}
+	greet := record.Symbols["example.com/app.greet"]
+	if greet == "" {
+		t.Fatalf("greet is not recorded: %v", record.Symbols)
+	}
+
+	u := newUnmangler(&record)
+	for obfuscated, expected := range map[string]string{
+		"main." + hoisted + "()\n":                  "main.main()\n",
+		"main." + hoisted + ".func1()\n":            "main.main.func1()\n",
+		"main." + greet + "({0x4b, 0x6})\n":         "main.greet({0x4b, 0x6})\n",
+		"\tobf/app/main.go:12 +0x1d\n":              "\texample.com/app/main.go:12 +0x1d\n",
+		"main." + hoisted + ".deferwrap1() +0x2a\n": "main.main.deferwrap1() +0x2a\n",
+	} {
+		if actual := u.Line(obfuscated); actual != expected {
+			t.Errorf("expected %q but got %q", expected, actual)
+		}
+	}
+}
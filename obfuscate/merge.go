@@ -0,0 +1,412 @@
+package obfuscate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/refactor/rename"
+)
+
+// MergePackages moves the code of packages into the main
+// package mainPkg, so that the boundaries between them
+// disappear from the binary.
+//
+// Only packages for which inModule returns true are merged.
+// Packages which cannot be merged safely are left alone, as
+// are packages imported by any package which is not merged,
+// and the reason is logged with the original import path,
+// which moves maps back to.
+func MergePackages(gopath, mainPkg string, moves PackageMoves, inModule func(string) bool, n NameHasher) error {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return err
+	}
+	imports := map[string][]string{}
+	candidates := map[string]bool{}
+	for _, pkg := range pkgs {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		imports[pkg], err = packageImports(dir)
+		if err != nil {
+			return err
+		}
+		if pkg == mainPkg || !inModule(pkg) {
+			continue
+		}
+		if problem := mergeProblem(dir); problem != "" {
+			log.Println("Not merging package", moves.Original(pkg), "because", problem)
+		} else {
+			candidates[pkg] = true
+		}
+	}
+	pruneMergeCandidates(candidates, imports, mainPkg, moves)
+
+	ctx := gopathContext(gopath)
+	for _, pkg := range sortedKeys(candidates) {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		names, _ := topLevelNames(dir)
+		for _, name := range names {
+			oldName := "\"" + pkg + "\"." + name
+			if err := rename.Main(&ctx, "", oldName, mergedName(n, pkg, name)); err != nil {
+				log.Println("Not merging package", moves.Original(pkg), "because of rename error:", err)
+				delete(candidates, pkg)
+				break
+			}
+		}
+	}
+	pruneMergeCandidates(candidates, imports, mainPkg, moves)
+	if len(candidates) == 0 {
+		log.Println("No packages could be merged")
+		return nil
+	}
+
+	pkgNames := map[string]string{}
+	for pkg := range candidates {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		pkgNames[pkg], err = packageName(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	mainDir := filepath.Join(srcDir, filepath.FromSlash(mainPkg))
+	mainName, err := packageName(mainDir)
+	if err != nil {
+		return err
+	}
+	mainFiles, err := ioutil.ReadDir(mainDir)
+	if err != nil {
+		return err
+	}
+	for _, item := range mainFiles {
+		if !isGoFile(item.Name()) {
+			continue
+		}
+		path := filepath.Join(mainDir, item.Name())
+		if err := mergeFile(path, path, mainName, pkgNames); err != nil {
+			return err
+		}
+	}
+
+	for _, pkg := range sortedKeys(candidates) {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		listing, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, item := range listing {
+			if !isGoFile(item.Name()) {
+				continue
+			}
+			path := filepath.Join(dir, item.Name())
+			newName := strings.ToLower(n.Hash(pkg+"/"+item.Name())) + goFileSuffix(item.Name())
+			if err := mergeFile(path, filepath.Join(mainDir, newName), mainName, pkgNames); err != nil {
+				return err
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		// Fails harmlessly if the directory has sub-packages.
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// pruneMergeCandidates removes every candidate which is
+// imported by a package that will not be merged, other
+// than mainPkg, since a package cannot import a main
+// package.
+func pruneMergeCandidates(candidates map[string]bool, imports map[string][]string, mainPkg string,
+	moves PackageMoves) {
+	for changed := true; changed; {
+		changed = false
+		for _, pkg := range sortedImporters(imports) {
+			if pkg == mainPkg || candidates[pkg] {
+				continue
+			}
+			for _, imp := range imports[pkg] {
+				if candidates[imp] {
+					log.Println("Not merging package", moves.Original(imp), "because it is imported by",
+						moves.Original(pkg)+", which is not merged")
+					delete(candidates, imp)
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+func sortedImporters(imports map[string][]string) []string {
+	var res []string
+	for pkg := range imports {
+		res = append(res, pkg)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// mergeProblem explains why a package cannot be merged
+// into another package, or returns "" if it can be.
+// The package may only consist of pure Go files, without
+// embedded files, and with no top-level name declared in
+// more than one file (i.e. for different build constraints).
+// Since the merged code is initialized along with the main
+// package, and not before it like an imported package, the
+// package may not have init functions, or package-level
+// variables initialized with anything but a literal.
+func mergeProblem(dir string) string {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err.Error()
+	}
+	for _, item := range listing {
+		if !item.IsDir() && !isGoFile(item.Name()) {
+			return "it has other files than Go files"
+		}
+	}
+	if containsCGO(dir) {
+		return "it uses cgo"
+	}
+	_, err = topLevelNames(dir)
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// topLevelNames lists the names declared at the top level
+// of a package, besides methods and blank identifiers.
+// It fails if a name is declared more than once, if the
+// package has init functions or initializes variables with
+// anything but a literal, or if the package cannot be
+// parsed or embeds files.
+func topLevelNames(dir string) ([]string, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var res []string
+	addName := func(name string) error {
+		if name == "_" {
+			return nil
+		}
+		if seen[name] {
+			return fmt.Errorf("it declares %s more than once", name)
+		}
+		seen[name] = true
+		res = append(res, name)
+		return nil
+	}
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, filepath.Join(dir, item.Name()), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if strings.HasPrefix(comment.Text, "//go:embed") {
+					return nil, errors.New("it embeds files")
+				}
+			}
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv != nil {
+					continue
+				} else if d.Name.Name == "init" {
+					return nil, errors.New("it has an init function")
+				} else if err := addName(d.Name.Name); err != nil {
+					return nil, err
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if err := addName(spec.Name.Name); err != nil {
+							return nil, err
+						}
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							if err := addName(name.Name); err != nil {
+								return nil, err
+							}
+						}
+						if d.Tok != token.VAR {
+							continue
+						}
+						for _, value := range spec.Values {
+							if _, ok := value.(*ast.BasicLit); !ok {
+								return nil, fmt.Errorf("it initializes %s at run time", spec.Names[0].Name)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// mergedName generates a name for a top-level symbol which
+// is unique across packages and keeps the symbol's export
+// status.
+func mergedName(n NameHasher, pkg, name string) string {
+	hash := n.Hash(pkg + "." + name)
+	if ast.IsExported(name) {
+		return strings.ToUpper(hash[:1]) + hash[1:]
+	}
+	return strings.ToLower(hash[:1]) + hash[1:]
+}
+
+// mergeFile rewrites a Go file for the package pkgName,
+// dropping imports of the merged packages and any
+// references which qualify identifiers with them.
+// The merged map is from import paths to package names.
+func mergeFile(src, dst, pkgName string, merged map[string]string) error {
+	contents, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, src, contents, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+
+	type edit struct {
+		Start, End token.Pos
+		Text       string
+	}
+	edits := []edit{{file.Name.Pos(), file.Name.End(), pkgName}}
+
+	localNames := map[string]bool{}
+	for _, decl := range file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		var removed int
+		for _, spec := range d.Specs {
+			spec := spec.(*ast.ImportSpec)
+			path, _ := strconv.Unquote(spec.Path.Value)
+			name, ok := merged[path]
+			if !ok {
+				continue
+			}
+			removed++
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			if name != "_" && name != "." {
+				localNames[name] = true
+			}
+			if d.Lparen.IsValid() {
+				edits = append(edits, edit{spec.Pos(), spec.End(), ""})
+			}
+		}
+		if removed > 0 && !d.Lparen.IsValid() {
+			edits = append(edits, edit{d.Pos(), d.End(), ""})
+		}
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		sel, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil && localNames[x.Name] {
+			edits = append(edits, edit{x.Pos(), sel.Sel.Pos(), ""})
+		}
+		return true
+	})
+
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].Start < edits[j].Start
+	})
+	var result bytes.Buffer
+	var lastIdx int
+	for _, e := range edits {
+		start := set.Position(e.Start).Offset
+		if start < lastIdx {
+			return errors.New("overlapping edits in " + src)
+		}
+		result.Write(contents[lastIdx:start])
+		result.WriteString(e.Text)
+		lastIdx = set.Position(e.End).Offset
+	}
+	result.Write(contents[lastIdx:])
+	return ioutil.WriteFile(dst, result.Bytes(), 0755)
+}
+
+// packageImports lists the imports of every Go file in a
+// directory.
+func packageImports(dir string) ([]string, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, filepath.Join(dir, item.Name()), nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			res = append(res, path)
+		}
+	}
+	return res, nil
+}
+
+// packageName reads the package clause of the first Go
+// file in a directory.
+func packageName(dir string) (string, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, filepath.Join(dir, item.Name()), nil, parser.PackageClauseOnly)
+		if err != nil {
+			return "", err
+		}
+		return file.Name.Name, nil
+	}
+	return "", fmt.Errorf("no Go files in %s", dir)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var res []string
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,54 @@
+package obfuscate
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergePackages(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/app/main.go": "package main\n\nimport (\n\t\"example.com/app/greet\"\n\t\"example.com/app/setup\"\n)\n\n" +
+			"func main() {\n\tprintln(greet.Hello(setup.Name))\n}\n",
+		"example.com/app/greet/greet.go": "package greet\n\nvar prefix = \"hello, \"\n\n" +
+			"func Hello(name string) string {\n\treturn prefix + name\n}\n",
+		"example.com/app/setup/setup.go": "package setup\n\nvar Name string\n\n" +
+			"func init() {\n\tName = \"gopher\"\n}\n",
+	})
+	inModule := func(pkg string) bool {
+		return strings.HasPrefix(pkg, "example.com/app/")
+	}
+	if err := MergePackages(gopath, "example.com/app", nil, inModule, NameHasher("merge")); err != nil {
+		t.Fatal(err)
+	}
+
+	srcDir := filepath.Join(gopath, "src", "example.com", "app")
+	if _, err := os.Stat(filepath.Join(srcDir, "greet")); !os.IsNotExist(err) {
+		t.Error("the greet package was not merged")
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "setup", "setup.go")); err != nil {
+		t.Error("the setup package, which has an init function, was merged")
+	}
+	mainCode, err := ioutil.ReadFile(filepath.Join(srcDir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(mainCode), "example.com/app/greet") {
+		t.Errorf("main.go still imports the merged package:\n%s", mainCode)
+	}
+
+	cmd := exec.Command("go", "run", "example.com/app")
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s\n%s", err, output)
+	} else if string(output) != "hello, gopher\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
@@ -0,0 +1,208 @@
+package obfuscate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPath is where -metrics writes a Prometheus text
+// file, or "" to skip it.
+var metricsPath string
+
+// runMetrics are the metrics of a single run.
+var runMetrics = newMetrics()
+
+// Metrics collects the durations of the phases of a run,
+// cache results, and artifact sizes, to be written in the
+// Prometheus text format.
+type Metrics struct {
+	lock       sync.Mutex
+	start      time.Time
+	phase      string
+	phaseStart time.Time
+	durations  map[string]time.Duration
+	phases     []string
+	cacheHits  map[string]bool
+	artifacts  map[string]int64
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		start:     time.Now(),
+		durations: map[string]time.Duration{},
+		cacheHits: map[string]bool{},
+		artifacts: map[string]int64{},
+	}
+}
+
+// writeMetrics writes the metrics of the run, if -metrics
+// was given.
+func writeMetrics(success bool) {
+	if metricsPath == "" {
+		return
+	}
+	// Concurrent runs may share the file, and each one adds
+	// to the run counts.
+	unlock, err := lockFile(metricsPath + ".lock")
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to lock metrics:", err)
+		return
+	}
+	defer unlock()
+	if err := runMetrics.Write(metricsPath, success); err != nil {
+		fmt.Fprintln(stderr, "Failed to write metrics:", err)
+	}
+}
+
+// Phase ends the current phase, if there is one, and
+// starts a new one.
+// An empty name only ends the current phase.
+func (m *Metrics) Phase(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	if m.phase != "" {
+		if _, ok := m.durations[m.phase]; !ok {
+			m.phases = append(m.phases, m.phase)
+		}
+		m.durations[m.phase] += now.Sub(m.phaseStart)
+	}
+	m.phase = name
+	m.phaseStart = now
+}
+
+// Cache records whether a cache was used.
+func (m *Metrics) Cache(name string, hit bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cacheHits[name] = hit
+}
+
+// Artifact records the size of a built binary.
+func (m *Metrics) Artifact(goos, goarch, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.artifacts[goos+"/"+goarch] = info.Size()
+}
+
+// Write writes the metrics to a file, adding to the run
+// counts already in it.
+// The file is replaced atomically, so that it can be read
+// by the node_exporter textfile collector at any time.
+func (m *Metrics) Write(path string, success bool) error {
+	m.Phase("")
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counts := readRunCounts(path)
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	counts[result]++
+
+	var buf bytes.Buffer
+	tool := currentTool()
+	buf.WriteString("# HELP gobfuscate_build_info The version of gobfuscate which made the last run.\n")
+	buf.WriteString("# TYPE gobfuscate_build_info gauge\n")
+	fmt.Fprintf(&buf, "gobfuscate_build_info{version=%q,commit=%q} 1\n", tool.Version, tool.Commit)
+	buf.WriteString("# HELP gobfuscate_runs_total Obfuscation runs by result.\n")
+	buf.WriteString("# TYPE gobfuscate_runs_total counter\n")
+	for _, result := range []string{"success", "failure"} {
+		fmt.Fprintf(&buf, "gobfuscate_runs_total{result=%q} %d\n", result, counts[result])
+	}
+	buf.WriteString("# HELP gobfuscate_last_run_timestamp_seconds When the last run finished.\n")
+	buf.WriteString("# TYPE gobfuscate_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(&buf, "gobfuscate_last_run_timestamp_seconds %d\n", time.Now().Unix())
+	buf.WriteString("# HELP gobfuscate_last_run_duration_seconds How long the last run took.\n")
+	buf.WriteString("# TYPE gobfuscate_last_run_duration_seconds gauge\n")
+	fmt.Fprintf(&buf, "gobfuscate_last_run_duration_seconds %f\n", time.Since(m.start).Seconds())
+
+	buf.WriteString("# HELP gobfuscate_phase_duration_seconds How long each phase of the last run took.\n")
+	buf.WriteString("# TYPE gobfuscate_phase_duration_seconds gauge\n")
+	for _, phase := range m.phases {
+		fmt.Fprintf(&buf, "gobfuscate_phase_duration_seconds{phase=%q} %f\n", phase,
+			m.durations[phase].Seconds())
+	}
+	if len(m.cacheHits) > 0 {
+		buf.WriteString("# HELP gobfuscate_cache_hit Whether the last run could use each cache.\n")
+		buf.WriteString("# TYPE gobfuscate_cache_hit gauge\n")
+		for _, name := range sortedKeys(m.cacheHits) {
+			value := 0
+			if m.cacheHits[name] {
+				value = 1
+			}
+			fmt.Fprintf(&buf, "gobfuscate_cache_hit{cache=%q} %d\n", name, value)
+		}
+	}
+	if len(m.artifacts) > 0 {
+		var targets []string
+		for target := range m.artifacts {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		buf.WriteString("# HELP gobfuscate_artifact_size_bytes The size of each binary built by the last run.\n")
+		buf.WriteString("# TYPE gobfuscate_artifact_size_bytes gauge\n")
+		for _, target := range targets {
+			parts := strings.SplitN(target, "/", 2)
+			fmt.Fprintf(&buf, "gobfuscate_artifact_size_bytes{goos=%q,goarch=%q} %d\n",
+				parts[0], parts[1], m.artifacts[target])
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".metrics-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readRunCounts reads the run counters from a previous
+// metrics file, if there is one.
+func readRunCounts(path string) map[string]int64 {
+	counts := map[string]int64{}
+	f, err := os.Open(path)
+	if err != nil {
+		return counts
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var result string
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if _, err := fmt.Sscanf(fields[0], "gobfuscate_runs_total{result=%q}", &result); err != nil {
+			continue
+		}
+		if count, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			counts[result] = count
+		}
+	}
+	return counts
+}
@@ -0,0 +1,96 @@
+package obfuscate
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RandomizeRoot moves the root of a project, and every
+// package under it, to a random single-component import
+// path in a GOPATH.
+// This way, no part of the original module path (e.g. a
+// company or product name) survives in the binary.
+func RandomizeRoot(gopath, root string, n NameHasher) (PackageMoves, error) {
+	srcDir := filepath.Join(gopath, "src")
+	rootDir := filepath.Join(srcDir, root)
+	if containsCGO(rootDir) {
+		return nil, fmt.Errorf("root package %s uses cgo", root)
+	}
+	origPkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	newRoot := strings.ToLower(n.Hash(root))
+	if err := movePackageDir(srcDir, root, newRoot); err != nil {
+		return nil, fmt.Errorf("package move: %s", err)
+	}
+	moves := PackageMoves{{root, newRoot}}
+	if err := rewritePackageMoves(gopath, origPkgs, moves); err != nil {
+		return nil, fmt.Errorf("package moves: %s", err)
+	}
+	return moves, nil
+}
+
+// projectRoot finds the import path of the module which
+// contains a package.
+// With -modules, this is the main module.
+// If the package is not in a module, the package itself is
+// treated as the root.
+func projectRoot(pkgName string) (string, error) {
+	if mainModulePath != "" {
+		if pkgName == mainModulePath || strings.HasPrefix(pkgName, mainModulePath+"/") {
+			return mainModulePath, nil
+		}
+	}
+	ctx := sourceContext()
+	pkg, err := ctx.Import(pkgName, "", build.FindOnly)
+	if err != nil {
+		return "", err
+	}
+	dir := pkg.Dir
+	for {
+		modPath, err := readModulePath(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			if modPath == pkgName || strings.HasPrefix(pkgName, modPath+"/") {
+				return modPath, nil
+			}
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || pkg.SrcRoot == "" || dir == pkg.SrcRoot {
+			break
+		}
+		dir = parent
+	}
+	return pkgName, nil
+}
+
+// readModulePath reads the module path from a go.mod file.
+func readModulePath(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			if unquoted, err := strconv.Unquote(fields[1]); err == nil {
+				return unquoted, nil
+			}
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no module directive in %s", path)
+}
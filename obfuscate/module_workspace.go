@@ -0,0 +1,427 @@
+package obfuscate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mainModulePath is the path of the module being
+// obfuscated when -modules is used.
+var mainModulePath string
+
+// moduleGoVersions are the versions in the go directives of
+// the modules which the packages copied with -modules come
+// from, by the original import path of each package.
+// A module without a go directive has an empty version.
+var moduleGoVersions = map[string]string{}
+
+// listedPackage is the subset of `go list -json` output
+// which is needed to copy a package.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	Standard   bool
+	Export     string
+	Module     *struct {
+		Path      string
+		Version   string
+		Main      bool
+		GoVersion string
+		Replace   *struct {
+			Path    string
+			Version string
+		}
+	}
+	Error *struct {
+		Err string
+	}
+
+	GoFiles      []string
+	CgoFiles     []string
+	CFiles       []string
+	CXXFiles     []string
+	MFiles       []string
+	HFiles       []string
+	FFiles       []string
+	SFiles       []string
+	SwigFiles    []string
+	SwigCXXFiles []string
+	SysoFiles    []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+
+	EmbedPatterns      []string
+	TestEmbedPatterns  []string
+	XTestEmbedPatterns []string
+	EmbedFiles         []string
+	TestEmbedFiles     []string
+	XTestEmbedFiles    []string
+}
+
+// usesCgo checks if a package is built with cgo, either
+// for its own cgo files or for its SWIG files.
+func (l *listedPackage) usesCgo() bool {
+	return len(l.CgoFiles)+len(l.SwigFiles)+len(l.SwigCXXFiles) > 0
+}
+
+// sourceFiles lists the names of all of a package's
+// source files, grouped by kind.
+func (l *listedPackage) sourceFiles() [][]string {
+	return [][]string{
+		l.GoFiles, l.CgoFiles, l.CFiles, l.CXXFiles, l.MFiles, l.HFiles, l.FFiles, l.SFiles,
+		l.SwigFiles, l.SwigCXXFiles, l.SysoFiles, l.TestGoFiles, l.XTestGoFiles,
+		l.EmbedFiles, l.TestEmbedFiles, l.XTestEmbedFiles,
+	}
+}
+
+// CopyModules creates a workspace with a copy of a package
+// from the module in the current directory, and of every
+// package it depends on.
+//
+// Dependencies are downloaded into modCache, which is used
+// as a private GOMODCACHE, and only the packages which are
+// needed are copied out of it.
+// The packages are laid out by import path under the
+// workspace's src directory, so that the obfuscation passes
+// can work on them like on a GOPATH.
+//
+// It returns the import path of the package.
+func CopyModules(pattern, workspace, modCache string, keepTests bool) (string, error) {
+	pkgs, err := ListModulePackages(pattern, modCache, false)
+	if err != nil {
+		return "", err
+	}
+	if err := copyListedPackages(pkgs, workspace, keepTests); err != nil {
+		return "", err
+	}
+	return rootPackage(pkgs), nil
+}
+
+// ListModulePackages downloads the dependencies of the
+// module in the current directory into modCache, and lists
+// a package and all of its dependencies.
+// Dependencies are listed before the packages which import
+// them.
+// If the module vendors its dependencies, nothing is
+// downloaded and they are listed from its vendor directory.
+// If export is set, the packages are compiled and the paths
+// to their export data are included.
+func ListModulePackages(pattern, modCache string, export bool) ([]*listedPackage, error) {
+	env := append(os.Environ(), "GOMODCACHE="+modCache, "GO111MODULE=on")
+
+	vendored, err := moduleVendored(env)
+	if err != nil {
+		return nil, err
+	} else if vendored {
+		env = append(env, "GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" -mod=vendor"))
+		return listPackages(pattern, env, export)
+	}
+
+	download := sourceCommand("go", "mod", "download")
+	download.Env = env
+	download.Stdout = os.Stdout
+	download.Stderr = stderr
+	if err := download.Run(); err != nil {
+		return nil, fmt.Errorf("go mod download: %s", err)
+	}
+
+	return listPackages(pattern, env, export)
+}
+
+// moduleVendored checks if the module in sourceDir
+// vendors its dependencies, i.e. it has a
+// vendor/modules.txt file and GOFLAGS does not select
+// another -mod mode.
+func moduleVendored(env []string) (bool, error) {
+	cmd := sourceCommand("go", "env", "GOMOD", "GOFLAGS")
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("go env: %s", err)
+	}
+	lines := strings.Split(string(output), "\n")
+	goMod, goFlags := strings.TrimSpace(lines[0]), ""
+	if len(lines) > 1 {
+		goFlags = lines[1]
+	}
+	if goMod == "" || goMod == os.DevNull {
+		return false, nil
+	}
+	for _, flag := range strings.Fields(goFlags) {
+		if strings.HasPrefix(flag, "-mod=") && flag != "-mod=vendor" {
+			return false, nil
+		}
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(goMod), "vendor", "modules.txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// listPackages lists a package and all of its dependencies
+// with `go list -deps`, running the go command with env.
+func listPackages(pattern string, env []string, export bool) ([]*listedPackage, error) {
+	args := []string{"list", "-deps", "-json"}
+	if export {
+		args = append(args, "-export")
+	}
+	var output bytes.Buffer
+	list := sourceCommand("go", append(args, pattern)...)
+	list.Env = env
+	list.Stdout = &output
+	list.Stderr = stderr
+	if err := list.Run(); err != nil {
+		return nil, fmt.Errorf("go list: %s", err)
+	}
+
+	var pkgs []*listedPackage
+	decoder := json.NewDecoder(&output)
+	for {
+		var pkg listedPackage
+		if err := decoder.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parse go list output: %s", err)
+		}
+		if pkg.Error != nil {
+			return nil, fmt.Errorf("package %s: %s", pkg.ImportPath, pkg.Error.Err)
+		}
+		pkgs = append(pkgs, &pkg)
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages match %s", pattern)
+	}
+	return pkgs, nil
+}
+
+// copyListedPackages copies packages into a workspace,
+// skipping those from the standard library.
+func copyListedPackages(pkgs []*listedPackage, workspace string, keepTests bool) error {
+	var buildPkgs []*build.Package
+	for _, pkg := range pkgs {
+		if pkg.Standard {
+			continue
+		}
+		buildPkgs = append(buildPkgs, &build.Package{
+			ImportPath:   pkg.ImportPath,
+			Dir:          pkg.Dir,
+			GoFiles:      pkg.GoFiles,
+			CgoFiles:     pkg.CgoFiles,
+			CFiles:       pkg.CFiles,
+			CXXFiles:     pkg.CXXFiles,
+			MFiles:       pkg.MFiles,
+			HFiles:       pkg.HFiles,
+			FFiles:       pkg.FFiles,
+			SFiles:       pkg.SFiles,
+			SwigFiles:    pkg.SwigFiles,
+			SwigCXXFiles: pkg.SwigCXXFiles,
+			SysoFiles:    pkg.SysoFiles,
+			TestGoFiles:  pkg.TestGoFiles,
+			XTestGoFiles: pkg.XTestGoFiles,
+
+			EmbedPatterns:      pkg.EmbedPatterns,
+			TestEmbedPatterns:  pkg.TestEmbedPatterns,
+			XTestEmbedPatterns: pkg.XTestEmbedPatterns,
+		})
+	}
+	if err := copyProgress.Preflight(buildPkgs); err != nil {
+		return err
+	}
+
+	for _, pkg := range buildPkgs {
+		if copyProgress.Copied(pkg) {
+			continue
+		}
+		if err := copyProgress.Check(); err != nil {
+			return err
+		}
+		if err := copyDep(pkg, workspace, keepTests); err != nil {
+			return err
+		}
+		if err := copyProgress.Record(pkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rootPackage finds the import path of the package whose
+// dependencies were listed, and records its module as the
+// main module, along with the go versions of the modules of
+// all of the packages.
+func rootPackage(pkgs []*listedPackage) string {
+	for _, pkg := range pkgs {
+		if !pkg.Standard && pkg.Module != nil {
+			moduleGoVersions[pkg.ImportPath] = pkg.Module.GoVersion
+		}
+	}
+	// Dependencies are listed before the packages which
+	// import them, so the root package comes last.
+	root := pkgs[len(pkgs)-1]
+	if root.Module != nil && root.Module.Main {
+		mainModulePath = root.Module.Path
+	}
+	return root.ImportPath
+}
+
+// cleanModCache removes a private module cache.
+// The go command makes the cache read-only, so it has to
+// be cleaned by the go command itself.
+func cleanModCache(modCache string) {
+	cmd := exec.Command("go", "clean", "-modcache")
+	cmd.Env = append(os.Environ(), "GOMODCACHE="+modCache)
+	cmd.Run()
+	os.RemoveAll(modCache)
+}
+
+// WriteWorkspaceModules turns every top-level directory of
+// a workspace's src directory into a module named after
+// the directory, and writes a go.work file which uses all
+// of them.
+// This lets the workspace be built in module mode, with
+// every package keeping its import path.
+//
+// Each package keeps the language version of the module it
+// was copied from, since the version changes the meaning of
+// some code, like loop variables in go1.22. A top-level
+// module gets the version of most of its packages, and the
+// packages with another version are made modules of their
+// own. The moves made by the obfuscation passes tell which
+// package each directory was copied as.
+func WriteWorkspaceModules(workspace string, moves PackageMoves) (string, error) {
+	goVersion, err := goLanguageVersion()
+	if err != nil {
+		return "", err
+	}
+	srcDir := filepath.Join(workspace, "src")
+	listing, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return "", err
+	}
+	dirs, err := packageDirs(srcDir)
+	if err != nil {
+		return "", err
+	}
+	// Parents sort before their children, so each package
+	// is compared below with the module it would belong to.
+	sort.Strings(dirs)
+	var copied []string
+	versions := map[string]string{}
+	for _, dir := range dirs {
+		if version, ok := moduleGoVersions[moves.Original(dir)]; ok {
+			copied = append(copied, dir)
+			versions[dir] = version
+		}
+	}
+
+	var work bytes.Buffer
+	work.WriteString("go " + goVersion + "\n\nuse (\n")
+	modules := map[string]string{}
+	writeModule := func(dir, version string) error {
+		modData := "module " + dir + "\n"
+		if version != "" {
+			modData += "\ngo " + version + "\n"
+		}
+		modFile := filepath.Join(srcDir, filepath.FromSlash(dir), "go.mod")
+		if err := ioutil.WriteFile(modFile, []byte(modData), 0644); err != nil {
+			return err
+		}
+		modules[dir] = version
+		work.WriteString("\t./src/" + dir + "\n")
+		return nil
+	}
+	for _, item := range listing {
+		if !item.IsDir() {
+			continue
+		}
+		version, ok := commonGoVersion(copied, versions, item.Name())
+		if !ok {
+			// Packages which were not copied, like the string
+			// helper, are generated for this toolchain.
+			version = goVersion
+		}
+		if err := writeModule(item.Name(), version); err != nil {
+			return "", err
+		}
+	}
+	for _, dir := range copied {
+		enclosing := dir
+		for {
+			if _, ok := modules[enclosing]; ok || !strings.Contains(enclosing, "/") {
+				break
+			}
+			enclosing = enclosing[:strings.LastIndex(enclosing, "/")]
+		}
+		if modules[enclosing] != versions[dir] {
+			if err := writeModule(dir, versions[dir]); err != nil {
+				return "", err
+			}
+		}
+	}
+	work.WriteString(")\n")
+	workFile := filepath.Join(workspace, "go.work")
+	if err := ioutil.WriteFile(workFile, work.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return workFile, nil
+}
+
+// commonGoVersion finds the go version of most of the
+// packages in a top-level directory, preferring the first
+// of them by import path on ties.
+func commonGoVersion(dirs []string, versions map[string]string, topDir string) (string, bool) {
+	counts := map[string]int{}
+	var res string
+	var found bool
+	for _, dir := range dirs {
+		if dir != topDir && !strings.HasPrefix(dir, topDir+"/") {
+			continue
+		}
+		version := versions[dir]
+		counts[version]++
+		if !found || counts[version] > counts[res] {
+			res, found = version, true
+		}
+	}
+	return res, found
+}
+
+// goVersion gets the version of the go command, e.g.
+// "go1.22.3".
+func goVersion() (string, error) {
+	output, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env: %s", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// goLanguageVersion gets the language version of the go
+// command, e.g. "1.22".
+func goLanguageVersion() (string, error) {
+	output, err := goVersion()
+	if err != nil {
+		return "", err
+	}
+	version := strings.TrimPrefix(output, "go")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return "", fmt.Errorf("unexpected go version: %s", output)
+	}
+	minor := parts[1]
+	if idx := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); idx >= 0 {
+		minor = minor[:idx]
+	}
+	return parts[0] + "." + minor, nil
+}
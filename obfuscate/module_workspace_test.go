@@ -0,0 +1,74 @@
+package obfuscate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteWorkspaceModules(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/app/main.go":      "package main\n\nfunc main() {}\n",
+		"example.com/app/util/util.go": "package util\n",
+		"example.com/old/old.go":       "package old\n",
+		"example.com/old/sub/sub.go":   "package sub\n",
+		"golang.org/x/nogo/nogo.go":    "package nogo\n",
+		"helper/helper.go":             "package helper\n",
+	})
+	defer func(old map[string]string) {
+		moduleGoVersions = old
+	}(moduleGoVersions)
+	moduleGoVersions = map[string]string{
+		"example.com/app":        "1.22",
+		"example.com/app/util":   "1.22",
+		"example.com/legacy":     "1.16",
+		"example.com/legacy/sub": "1.22",
+		"golang.org/x/nogo":      "",
+	}
+	moves := PackageMoves{{"example.com/legacy", "example.com/old"}}
+	goWork, err := WriteWorkspaceModules(gopath, moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goVersion, err := goLanguageVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcDir := filepath.Join(gopath, "src")
+	expected := map[string]string{
+		"example.com":          "module example.com\n\ngo 1.22\n",
+		"example.com/old":      "module example.com/old\n\ngo 1.16\n",
+		"example.com/old/sub":  "module example.com/old/sub\n\ngo 1.22\n",
+		"golang.org":           "module golang.org\n",
+		"helper":               "module helper\n\ngo " + goVersion + "\n",
+		"example.com/app":      "",
+		"example.com/app/util": "",
+		"golang.org/x/nogo":    "",
+	}
+	for dir, contents := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(srcDir, filepath.FromSlash(dir), "go.mod"))
+		if contents == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s should not be a module", dir)
+			}
+			continue
+		} else if err != nil {
+			t.Error(err)
+		} else if string(data) != contents {
+			t.Errorf("go.mod of %s: expected %q but got %q", dir, contents, data)
+		}
+	}
+
+	work, err := ioutil.ReadFile(goWork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"example.com", "example.com/old", "example.com/old/sub", "golang.org", "helper"} {
+		if !strings.Contains(string(work), "\t./src/"+dir+"\n") {
+			t.Errorf("go.work does not use %s:\n%s", dir, work)
+		}
+	}
+}
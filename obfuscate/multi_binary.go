@@ -0,0 +1,95 @@
+package obfuscate
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extraPackages are the main packages which are built
+// along with the first one, from the same obfuscated
+// workspace, so that the packages they share are renamed
+// the same way in every binary and one mapping file covers
+// them all. They are given as a comma-separated list in
+// place of the package name.
+var extraPackages []string
+
+// splitPackages splits the package argument into the
+// first package and the extra ones.
+func splitPackages(arg string) (string, []string) {
+	var pkgs []string
+	for _, pkg := range strings.Split(arg, ",") {
+		if pkg = strings.TrimSpace(pkg); pkg != "" {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	if len(pkgs) == 0 {
+		return arg, nil
+	}
+	return pkgs[0], pkgs[1:]
+}
+
+// checkExtraPackageFlags reports flags which cannot be
+// used when several binaries are built together.
+func checkExtraPackageFlags() error {
+	if len(extraPackages) == 0 {
+		return nil
+	}
+	if passRuns("merge", mergePkgs) || pgoProfile != "" || numVariants > 1 || incrementalDir != "" ||
+		depCacheDir != "" {
+		return flagError("Several packages cannot be built with -merge, -pgo, -variants, " +
+			"-incremental, or -depcache.")
+	}
+	return nil
+}
+
+// checkBinaryNames reports main packages whose binaries
+// would have the same name, once their import paths are
+// known.
+func checkBinaryNames(pkgName string) error {
+	byName := map[string]string{}
+	for _, pkg := range append([]string{pkgName}, extraPackages...) {
+		name := path.Base(pkg)
+		if other, ok := byName[name]; ok {
+			if other == pkg {
+				return flagError("Package given more than once: " + pkg)
+			}
+			return flagError("Binaries would have the same name: " + other + " and " + pkg)
+		}
+		byName[name] = pkg
+	}
+	return nil
+}
+
+// A mainBinary is a main package and the path it is built
+// to.
+type mainBinary struct {
+	PkgName string
+	OutPath string
+}
+
+// binaries lists the binaries of a workspace. With extra
+// packages, outPath is a directory, and every binary is
+// named after the last element of its import path, unless
+// outPath is a template, which names every binary.
+func (w *Workspace) binaries(outPath string) ([]mainBinary, error) {
+	if len(w.ExtraPkgs) == 0 {
+		return []mainBinary{{PkgName: w.PkgName, OutPath: outPath}}, nil
+	}
+	templated := isOutputTemplate(outPath)
+	if !templated {
+		if err := os.MkdirAll(outPath, 0755); err != nil {
+			return nil, err
+		}
+	}
+	var res []mainBinary
+	for _, pkg := range append([]string{w.PkgName}, w.ExtraPkgs...) {
+		binPath := outPath
+		if !templated {
+			binPath = filepath.Join(outPath, path.Base(pkg))
+		}
+		res = append(res, mainBinary{PkgName: pkg, OutPath: binPath})
+	}
+	return res, nil
+}
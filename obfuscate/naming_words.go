@@ -0,0 +1,59 @@
+package obfuscate
+
+import "strings"
+
+// defaultDictionary is the word list of -naming words,
+// made of words which are common in ordinary code.
+var defaultDictionary = strings.Fields(`
+	abort absolute accept access account action active adapter add address adjust after agent
+	aggregate alias align all allocate allow alpha amount anchor append apply archive area
+	argument array ascii assert asset assign async attach attempt attribute audit auth author
+	auto available average await backend backup balance bank base basic batch before begin
+	binary bind bit blank block blob body bool boot border bottom bound box branch break
+	bridge browse bucket buffer build bulk bundle button byte cache calc calendar call
+	callback cancel canvas capacity capture card case catalog category cell center chain
+	change channel char chart check checksum child chunk cipher circle class clean clear
+	click client clock clone close cluster code codec collect color column combine command
+	comment commit common compact compare compile complete component compose compress
+	compute concat config confirm connect console const consume contact container content
+	context control convert cookie copy core count counter cover create credit cron cross
+	current cursor custom cycle daemon daily data database date day deadline debug decimal
+	decode default defer define delay delete delta demo depth derive describe design detail
+	detect device dialog diff digest digit dir direct disable disk dispatch display
+	distance divide doc document domain done double down draft draw driver drop dump
+	duration dynamic edge edit effect element email embed empty enable encode end engine
+	entity entry enum env equal error escape event exact example exec exit expand expect
+	expire export expr extend extra factor fail fallback fast feature fetch field file
+	fill filter final find first fixed flag flat float flush focus fold folder font footer
+	force form format forward frame free front full func gateway gather general generate
+	get global goal grant graph grid group guard guest handle handler hash head header
+	health heap height help hidden hint history hold home hook host hour icon ident idle
+	image import inbox include index info init inline inner input insert inspect install
+	instance int integer interval invoke issue item iterate job join journal json keep
+	kernel key kind label last latest launch layer layout lazy leader leaf left length
+	level library limit line link list listen load local locale lock log login lookup
+	loop main manage manifest map margin mark marker mask master match matrix max measure
+	media member memo memory menu merge message meta method metric middle migrate min
+	mirror mode model modify module monitor month mount move multi mutex name native
+	network next node normal note notify null number object offset online open operator
+	option order origin outer output owner package packet page pair panel param parent
+	parse part partial pass patch path pattern pause payload peer pending period phase
+	pick pipe pixel place plain plan player plugin point policy poll pool port position
+	post power prefix prepare preset press preview price primary print priority private
+	probe process produce profile program progress project prompt property protocol proxy
+	public publish pull purge push query queue quick quota random range rank rate raw read
+	ready reader real reason receive record recover reduce ref refresh region register
+	reject relay release reload remote remove render repeat replace reply report request
+	require reset resize resolve resource response restore result resume retry return
+	reverse review revision role root rotate round route row rule run runner runtime safe
+	sample save scale scan schema scope score screen script scroll search second section
+	secure seed segment select send sequence serial server service session set setting
+	setup shape share shift short show side sign signal simple single size skip slice
+	slot small snapshot socket sort source space span spec split stack stage standard
+	start state static status step stop storage store stream string struct style submit
+	subject suffix sum summary supply swap switch symbol sync system table tag target
+	task template temp term test text thread throttle ticket time timeout timer title
+	token tool top topic total trace track transfer transform tree trigger trim type unit
+	update upload usage user util valid value vector verify version view visit volume
+	wait walk warn watch weight width window word worker wrap write writer year zone
+`)
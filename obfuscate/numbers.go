@@ -0,0 +1,266 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// numericLiterals enables ObfuscateNumbers, which runs
+// along with the string pass.
+var numericLiterals bool
+
+// The kinds of numbers that NumberExpr computes.
+type numberKind int
+
+const (
+	numberInt numberKind = iota
+	numberFloat32
+	numberFloat64
+)
+
+// ObfuscateNumbers replaces the integer and floating point
+// constants in the expressions of some Go files with
+// expressions which compute them at runtime from the keys
+// of the helper package. It returns how many constants
+// were replaced.
+//
+// Every package is type-checked on its own, so constants
+// whose type comes from another package are left alone, as
+// are those which must stay constant (in constant
+// declarations, array lengths, and array indices), and 0
+// and 1, which are everywhere and give nothing away.
+func ObfuscateNumbers(files []sourceFile, helper *stringHelper) (int, error) {
+	// Each job is a package directory rather than a file.
+	var dirs []sourceFile
+	seen := map[string]bool{}
+	for _, f := range files {
+		dir := filepath.Dir(f.Path)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, sourceFile{Path: dir, PkgPath: f.PkgPath})
+		}
+	}
+	var countLock sync.Mutex
+	var count int
+	err := forEachFile(dirs, func(f sourceFile) error {
+		n, err := obfuscatePackageNumbers(f.PkgPath, f.Path, helper)
+		countLock.Lock()
+		count += n
+		countLock.Unlock()
+		return err
+	})
+	return count, err
+}
+
+// obfuscatePackageNumbers replaces the constants in the
+// files of a package directory which have no build
+// constraints.
+func obfuscatePackageNumbers(pkg, dir string, helper *stringHelper) (int, error) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	set := token.NewFileSet()
+	var files []*ast.File
+	var paths []string
+	var sources [][]byte
+	for _, item := range listing {
+		if item.IsDir() || !isGoFile(item.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, item.Name())
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+		file, err := parser.ParseFile(set, path, contents, parser.ParseComments)
+		if err != nil {
+			return 0, err
+		}
+		if !hasBuildConstraints(path, file) {
+			files = append(files, file)
+			paths = append(paths, path)
+			sources = append(sources, contents)
+		}
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+
+	// Imports are not resolved, so there are errors, and the
+	// constants used with other packages stay untyped, which
+	// leaves them alone.
+	conf := types.Config{Importer: noImporter{}, Error: func(error) {}}
+	info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+	typesPkg, _ := conf.Check(pkg, set, files, info)
+	if typesPkg == nil {
+		return 0, nil
+	}
+
+	var count int
+	for i, file := range files {
+		obfuscator := &numberObfuscator{
+			Info:   info,
+			Pkg:    typesPkg,
+			Set:    set,
+			Helper: helper,
+			Rand:   newKeyRand("numbers", sources[i]),
+		}
+		skipped := findSkippedCode(file)
+		for _, decl := range file.Decls {
+			if !skipped.Contains(decl) {
+				ast.Walk(obfuscator, decl)
+			}
+		}
+		if len(obfuscator.Edits) == 0 {
+			continue
+		}
+		count += len(obfuscator.Edits)
+		if !importsPath(file, helper.Path) {
+			pos := set.Position(file.Name.End()).Offset
+			obfuscator.Edits = append(obfuscator.Edits, fileEdit{
+				Start: pos,
+				End:   pos,
+				Text:  "\n" + helper.ImportDecl(),
+			})
+		}
+		if err := applyFileEdits(paths[i], obfuscator.Edits); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// importsPath checks if a file imports a package.
+func importsPath(file *ast.File, importPath string) bool {
+	quoted := strconv.Quote(importPath)
+	for _, spec := range file.Imports {
+		if spec.Path.Value == quoted {
+			return true
+		}
+	}
+	return false
+}
+
+type numberObfuscator struct {
+	Info   *types.Info
+	Pkg    *types.Package
+	Set    *token.FileSet
+	Helper *stringHelper
+	Rand   *rand.Rand
+	Edits  []fileEdit
+}
+
+func (n *numberObfuscator) Visit(node ast.Node) ast.Visitor {
+	switch node := node.(type) {
+	case *ast.GenDecl:
+		if node.Tok == token.CONST || node.Tok == token.IMPORT {
+			return nil
+		}
+	case *ast.TypeSpec, *ast.ArrayType, *ast.StructType, *ast.FuncType:
+		// Types only hold constants which must stay constant.
+		return nil
+	case *ast.CompositeLit:
+		t := n.Info.TypeOf(node)
+		if t == nil {
+			break
+		}
+		switch t.Underlying().(type) {
+		case *types.Array, *types.Slice:
+			// Indices must be constants.
+			for _, elt := range node.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					ast.Walk(n, kv.Value)
+				} else {
+					ast.Walk(n, elt)
+				}
+			}
+			return nil
+		}
+	case ast.Expr:
+		if tv, ok := n.Info.Types[node]; ok && tv.Value != nil {
+			n.replace(node, tv)
+			return nil
+		}
+	}
+	return n
+}
+
+// replace replaces a constant expression, if it is a
+// number with a type that can be named at its position.
+func (n *numberObfuscator) replace(expr ast.Expr, tv types.TypeAndValue) {
+	basic, ok := tv.Type.Underlying().(*types.Basic)
+	if !ok || basic.Info()&types.IsUntyped != 0 {
+		return
+	}
+	val := tv.Value
+	var bits uint64
+	var kind numberKind
+	switch {
+	case basic.Info()&types.IsInteger != 0:
+		if constant.Sign(val) == 0 || constant.Compare(val, token.EQL, constant.MakeInt64(1)) {
+			return
+		}
+		if constant.Sign(val) < 0 {
+			i, _ := constant.Int64Val(val)
+			bits = uint64(i)
+		} else {
+			bits, _ = constant.Uint64Val(val)
+		}
+	case basic.Kind() == types.Float32:
+		f, _ := constant.Float32Val(val)
+		if f == 0 || f == 1 {
+			return
+		}
+		bits, kind = uint64(math.Float32bits(f)), numberFloat32
+	case basic.Kind() == types.Float64:
+		f, _ := constant.Float64Val(val)
+		if f == 0 || f == 1 {
+			return
+		}
+		bits, kind = math.Float64bits(f), numberFloat64
+	default:
+		return
+	}
+	typeName, ok := n.typeName(tv.Type, expr.Pos())
+	if !ok {
+		return
+	}
+	n.Edits = append(n.Edits, fileEdit{
+		Start: n.Set.Position(expr.Pos()).Offset,
+		End:   n.Set.Position(expr.End()).Offset,
+		Text:  n.Helper.NumberExpr(n.Rand, typeName, bits, kind),
+	})
+}
+
+// typeName finds the name of a predeclared type, or of a
+// type declared at the top level of the package, which
+// refers to it at a position.
+func (n *numberObfuscator) typeName(t types.Type, pos token.Pos) (string, bool) {
+	var obj types.Object
+	switch t := t.(type) {
+	case *types.Basic:
+		obj = types.Universe.Lookup(t.Name())
+	case *types.Named:
+		if t.TypeArgs() != nil || t.Obj().Pkg() != n.Pkg || t.Obj().Parent() != n.Pkg.Scope() {
+			return "", false
+		}
+		obj = t.Obj()
+	}
+	if obj == nil {
+		return "", false
+	}
+	if _, found := n.Pkg.Scope().Innermost(pos).LookupParent(obj.Name(), pos); found != obj {
+		return "", false
+	}
+	return obj.Name(), true
+}
@@ -0,0 +1,138 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// An outputName holds what a templated output path, like
+// "dist/{{.Name}}_{{.GOOS}}_{{.GOARCH}}", can refer to for
+// one binary and target.
+type outputName struct {
+	// Name is the last element of the main package's
+	// import path, and Pkg is the whole path.
+	Name string
+	Pkg  string
+
+	GOOS   string
+	GOARCH string
+
+	// Ext is ".exe" for windows programs, ".dll" for windows
+	// shared libraries, and empty otherwise. It is added
+	// to paths whose template does not use it.
+	Ext string
+}
+
+// isOutputTemplate checks if an output path is a template
+// for the paths of the binaries.
+func isOutputTemplate(outPath string) bool {
+	return strings.Contains(outPath, "{{")
+}
+
+// checkOutputTemplate reports templated output paths which
+// do not parse, or which are used where a single path is
+// needed.
+func checkOutputTemplate(outPath string) error {
+	if !isOutputTemplate(outPath) {
+		return nil
+	}
+	if _, err := template.New("out").Parse(outPath); err != nil {
+		return flagError("Invalid output path template: " + err.Error())
+	}
+	if outputGopath || numVariants > 1 {
+		return flagError("An output path template cannot be combined with -outdir or -variants.")
+	}
+	return nil
+}
+
+// binaryExt is the extension added to the binaries built
+// for an operating system.
+func binaryExt(goos string) string {
+	if goos == "windows" && buildMode == "c-shared" {
+		return ".dll"
+	} else if goos == "windows" && !sharedBuild() {
+		return ".exe"
+	}
+	return ""
+}
+
+// targetOutPath finds the path of a main package's binary
+// for a target, from the output path of the binary, which
+// may be a template.
+func targetOutPath(outPath, pkgName string, target buildTarget) (string, error) {
+	ext := binaryExt(target.GOOS)
+	if !isOutputTemplate(outPath) {
+		return outPath + ext, nil
+	}
+	tmpl, err := template.New("out").Parse(outPath)
+	if err != nil {
+		return "", err
+	}
+	var res bytes.Buffer
+	name := outputName{
+		Name:   path.Base(pkgName),
+		Pkg:    pkgName,
+		GOOS:   target.GOOS,
+		GOARCH: target.GOARCH,
+		Ext:    ext,
+	}
+	if err := tmpl.Execute(&res, name); err != nil {
+		return "", err
+	}
+	if !strings.Contains(outPath, ".Ext") {
+		res.WriteString(ext)
+	}
+	return res.String(), nil
+}
+
+// outputPaths finds the path of every binary for every
+// target, and creates their directories if the output path
+// is a template. Two builds with the same path are an
+// error, since one would overwrite the other.
+func outputPaths(binaries []mainBinary) (map[mainBinary]map[buildTarget]string, error) {
+	res := map[mainBinary]map[buildTarget]string{}
+	builtBy := map[string]string{}
+	for _, bin := range binaries {
+		res[bin] = map[buildTarget]string{}
+		for _, target := range buildTargets() {
+			outPath, err := targetOutPath(bin.OutPath, bin.PkgName, target)
+			if err != nil {
+				return nil, err
+			}
+			desc := bin.PkgName + " for " + target.String()
+			if other, ok := builtBy[outPath]; ok {
+				return nil, fmt.Errorf("%s and %s would both be built to %s (use a template like "+
+					"out_{{.GOOS}}_{{.GOARCH}})", other, desc, outPath)
+			}
+			builtBy[outPath] = desc
+			res[bin][target] = outPath
+		}
+	}
+	for _, bin := range binaries {
+		if !isOutputTemplate(bin.OutPath) {
+			continue
+		}
+		for _, outPath := range res[bin] {
+			if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// outputTemplateDir is the directory part of a templated
+// output path before its first action, where files about
+// the build, like the mapping file, are written.
+func outputTemplateDir(outPath string) string {
+	prefix := outPath[:strings.Index(outPath, "{{")]
+	if idx := strings.LastIndexAny(prefix, `/\`); idx >= 0 {
+		return prefix[:idx+1]
+	}
+	return "."
+}
@@ -0,0 +1,89 @@
+package obfuscate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	// packer, if set, is the executable packer which
+	// compresses the binaries after they are built and
+	// checked. Only "upx" is supported.
+	packer string
+
+	// packFlags are passed to the packer before the path
+	// of the binary, like "--best --lzma".
+	packFlags string
+)
+
+// checkPackFlags checks that the -pack packer is known and
+// installed, and can pack the binaries of every target.
+func checkPackFlags() error {
+	if packer == "" {
+		if packFlags != "" {
+			return flagError("The -packflags flag requires -pack.")
+		}
+		return nil
+	} else if packer != "upx" {
+		return flagError("The -pack flag must be upx.")
+	}
+	if buildMode == "c-archive" {
+		return flagError("The -pack flag cannot be combined with -buildmode c-archive.")
+	}
+	supported, kind := upxTargets, "binaries"
+	if buildMode == "c-shared" {
+		supported, kind = upxSharedTargets, "shared libraries"
+	}
+	for _, target := range buildTargets() {
+		if !supported[target.String()] {
+			return flagError("The -pack flag cannot pack " + kind + " for " + target.String() + ".")
+		}
+	}
+	if _, err := exec.LookPath(packer); err != nil {
+		return stepError("find packer", err)
+	}
+	return nil
+}
+
+// upxTargets are the targets whose executables UPX packs
+// into binaries which run.
+// UPX no longer packs macOS binaries without --force-macos,
+// and those it packs do not run on recent versions.
+var upxTargets = map[string]bool{
+	"linux/386": true, "linux/amd64": true, "linux/arm": true, "linux/arm64": true,
+	"linux/mips": true, "linux/mipsle": true, "linux/ppc64le": true,
+	"windows/386": true, "windows/amd64": true,
+	"freebsd/386": true, "freebsd/amd64": true,
+}
+
+// upxSharedTargets are the targets whose shared libraries
+// UPX packs.
+var upxSharedTargets = map[string]bool{
+	"linux/386": true, "linux/amd64": true, "linux/arm": true, "linux/arm64": true,
+	"windows/386": true, "windows/amd64": true,
+}
+
+// packBinary packs a binary in place with the -pack packer,
+// writing its messages to out, and returns the size of the
+// binary before it was packed.
+func packBinary(path string, out *targetOutput) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(packer, append(strings.Fields(packFlags), path)...)
+	cmd.Stdout = out.Stdout
+	cmd.Stderr = out.Stderr
+	if err := runChild(cmd); err != nil {
+		return 0, fmt.Errorf("%s: %s", packer, err)
+	}
+	packed, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	out.Log.Printf("Packed %s: %s -> %s (%.0f%%)", path, formatSize(info.Size()), formatSize(packed.Size()),
+		100*float64(packed.Size())/float64(info.Size()))
+	return info.Size(), nil
+}
@@ -0,0 +1,94 @@
+package obfuscate
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// pathStyle is "hash" for hashed import paths, or "mimic"
+// for import paths which look like those of ordinary open
+// source projects.
+var pathStyle string
+
+// mimicTLDs are the top-level domains of mimicked hosts.
+var mimicTLDs = []string{"io", "dev", "org", "net"}
+
+// trademarks are names which mimicked paths must never
+// contain, so that a binary does not pretend to come from
+// a real company or project.
+var trademarks = []string{
+	"adobe", "amazon", "android", "apache", "apple", "atlassian", "aws", "azure", "bitbucket",
+	"cisco", "cloudflare", "docker", "elastic", "facebook", "github", "gitlab", "golang",
+	"google", "hashicorp", "ibm", "intel", "jetbrains", "kubernetes", "microsoft", "mongodb",
+	"mozilla", "netflix", "nvidia", "oracle", "redhat", "redis", "salesforce", "slack",
+	"stripe", "twitter", "uber", "ubuntu", "vmware",
+}
+
+// mimicComponent makes a plausible name for a component of
+// an import path, at a level of the path (starting at 1).
+// Different attempts give different names, in case a name
+// is already taken.
+//
+// The first level looks like a host, the second like an
+// organization, the third like a project, and deeper
+// levels like ordinary packages. Only names of directories
+// which are not packages may contain dots, since the last
+// component of a package's path becomes its name.
+func mimicComponent(n NameHasher, base string, level, attempt int, isPackage bool) string {
+	for salt := 0; ; salt++ {
+		token := base + "#" + strconv.Itoa(attempt) + "#" + strconv.Itoa(salt)
+		word := func(i int) string {
+			return dictionary[n.Intn(token+"#"+strconv.Itoa(i), len(dictionary))]
+		}
+		var name string
+		switch {
+		case level == 1 && !isPackage:
+			name = word(0) + word(1) + "." + mimicTLDs[n.Intn(token+"#tld", len(mimicTLDs))]
+		case level <= 2:
+			name = word(0) + word(1)
+		case level == 3:
+			switch n.Intn(token+"#style", 3) {
+			case 0:
+				name = "go" + word(0)
+			case 1:
+				name = word(0) + "kit"
+			default:
+				name = word(0) + word(1)
+			}
+		case attempt == 0:
+			name = word(0)
+		default:
+			name = word(0) + word(1)
+		}
+		if !containsTrademark(name) && !reservedComponent(name) {
+			return name
+		}
+	}
+}
+
+func containsTrademark(name string) bool {
+	for _, mark := range trademarks {
+		if strings.Contains(name, mark) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedComponent checks if the go command treats a path
+// component specially.
+func reservedComponent(name string) bool {
+	return name == "internal" || name == "vendor" || name == "testdata" || name == "main"
+}
+
+// containsGoFiles checks if a directory is a package.
+func containsGoFiles(dir string) bool {
+	listing, _ := ioutil.ReadDir(dir)
+	for _, item := range listing {
+		if !item.IsDir() && isGoFile(item.Name()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package obfuscate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// pgoProfile is a CPU profile of the original program, to
+// be used for profile-guided optimization.
+var pgoProfile string
+
+const preprofileHeader = "GO PREPROFILE V1\n"
+
+// The string table of a pprof profile, which holds the
+// names of every function.
+const pprofStringTableField = 6
+
+// closureExpr matches the names which the compiler gives
+// to closures and wrappers within a function.
+var closureExpr = regexp.MustCompile(`^(func|gowrap|deferwrap)?\d+$`)
+
+// translateProfile rewrites a pprof profile (or a profile
+// preprocessed by go tool preprofile) of the original
+// program so that its function names match the obfuscated
+// program, and writes it to outPath.
+func (w *Workspace) translateProfile(profilePath, outPath string) error {
+	data, err := ioutil.ReadFile(profilePath)
+	if err != nil {
+		return err
+	}
+	t := &symbolTranslator{
+		Record:  w.Mapping(nil),
+		Moves:   w.Moves,
+		MainPkg: w.PkgName,
+	}
+	if bytes.HasPrefix(data, []byte(preprofileHeader)) {
+		lines := strings.Split(string(data), "\n")
+		for i, line := range lines {
+			lines[i] = t.Translate(line)
+		}
+		return ioutil.WriteFile(outPath, []byte(strings.Join(lines, "\n")), 0644)
+	}
+
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("decompress profile: %s", err)
+		}
+	}
+	translated, err := translateProfileStrings(data, t.Translate)
+	if err != nil {
+		return fmt.Errorf("parse profile: %s", err)
+	}
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	zw.Write(translated)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outPath, out.Bytes(), 0644)
+}
+
+// translateProfileStrings rewrites the string table of an
+// encoded pprof Profile message, copying every other field
+// as it is.
+func translateProfileStrings(data []byte, f func(string) string) ([]byte, error) {
+	var res []byte
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("invalid field key")
+		}
+		var size int
+		switch key & 7 {
+		case 0:
+			_, m := binary.Uvarint(data[n:])
+			if m <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			size = n + m
+		case 1:
+			size = n + 8
+		case 2:
+			length, m := binary.Uvarint(data[n:])
+			if m <= 0 || length > uint64(len(data)-n-m) {
+				return nil, errors.New("invalid length")
+			}
+			if key>>3 == pprofStringTableField {
+				value := f(string(data[n+m : n+m+int(length)]))
+				res = binary.AppendUvarint(res, key)
+				res = binary.AppendUvarint(res, uint64(len(value)))
+				res = append(res, value...)
+				data = data[n+m+int(length):]
+				continue
+			}
+			size = n + m + int(length)
+		case 5:
+			size = n + 4
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", key&7)
+		}
+		if size > len(data) {
+			return nil, errors.New("truncated field")
+		}
+		res = append(res, data[:size]...)
+		data = data[size:]
+	}
+	return res, nil
+}
+
+// A symbolTranslator turns the linker's names for the
+// functions of the original program, like
+// "github.com/a/b.(*Type).Method.func1", into their names
+// in the obfuscated program.
+type symbolTranslator struct {
+	Record *renameRecord
+	Moves  PackageMoves
+
+	// MainPkg is the original import path of the main
+	// package, whose functions are named "main.Name".
+	MainPkg string
+}
+
+// Translate translates a symbol name, or returns the name
+// unchanged if it is not a symbol of the program.
+func (s *symbolTranslator) Translate(name string) string {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return name
+	}
+	dot += slash + 1
+	pkg := strings.Replace(name[:dot], "%2e", ".", -1)
+	origPkg, newPkg := pkg, pkg
+	if pkg == "main" {
+		origPkg = s.MainPkg
+	} else if obfPkg, ok := s.Record.Packages[pkg]; ok {
+		newPkg = obfPkg
+	} else {
+		newPkg = s.Moves.Obfuscated(pkg)
+	}
+	if newPkg != "main" {
+		i := strings.LastIndex(newPkg, "/")
+		newPkg = newPkg[:i+1] + strings.Replace(newPkg[i+1:], ".", "%2e", -1)
+	}
+
+	rest := name[dot+1:]
+	var res string
+	if strings.HasPrefix(rest, "(*") {
+		end := strings.Index(rest, ")")
+		if end < 0 || !strings.HasPrefix(rest[end+1:], ".") {
+			return name
+		}
+		typeName, typeArgs, _ := splitSymbol(rest[2:end])
+		method, methodArgs, tail := splitSymbol(rest[end+2:])
+		res = "(*" + s.symbol(origPkg+"."+typeName) + typeArgs + ")." +
+			s.symbol(origPkg+"."+typeName+"."+method) + methodArgs + tail
+	} else {
+		first, firstArgs, tail := splitSymbol(rest)
+		res = s.symbol(origPkg+"."+first) + firstArgs
+		if strings.HasPrefix(tail, ".") {
+			second, secondArgs, secondTail := splitSymbol(tail[1:])
+			if !closureExpr.MatchString(second) {
+				tail = "." + s.symbol(origPkg+"."+first+"."+second) + secondArgs + secondTail
+			}
+		}
+		res += tail
+	}
+	return newPkg + "." + res
+}
+
+// symbol gets the new name of a symbol, given as
+// "import/path.Name" or "import/path.Type.Method".
+func (s *symbolTranslator) symbol(fullName string) string {
+	if newName, ok := s.Record.Symbols[fullName]; ok {
+		return newName
+	}
+	return fullName[strings.LastIndex(fullName, ".")+1:]
+}
+
+// splitSymbol splits the first name off of part of a
+// symbol, along with its type arguments (in brackets),
+// if it has any.
+func splitSymbol(s string) (name, typeArgs, tail string) {
+	i := strings.IndexAny(s, ".[")
+	if i < 0 {
+		return s, "", ""
+	}
+	name, tail = s[:i], s[i:]
+	if tail[0] == '[' {
+		depth := 0
+		for j, ch := range tail {
+			if ch == '[' {
+				depth++
+			} else if ch == ']' {
+				depth--
+				if depth == 0 {
+					return name, tail[:j+1], tail[j+1:]
+				}
+			}
+		}
+	}
+	return name, "", tail
+}
@@ -0,0 +1,97 @@
+package obfuscate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RestructurePackages moves every package in a GOPATH to a
+// new path with between 1 and maxDepth components, so that
+// the shape of the original hierarchy is lost.
+//
+// Every component but the last is drawn from a small pool
+// of names, so that unrelated packages end up grouped
+// together under the same directories.
+//
+// Only packages for which include returns true are moved.
+func RestructurePackages(gopath string, maxDepth int, n NameHasher, include func(string) bool) (PackageMoves, error) {
+	srcDir := filepath.Join(gopath, "src")
+
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Move the deepest packages first, so that no package
+	// drags its sub-packages along when it is moved.
+	sort.Slice(pkgs, func(i, j int) bool {
+		di, dj := strings.Count(pkgs[i], "/"), strings.Count(pkgs[j], "/")
+		if di != dj {
+			return di > dj
+		}
+		return pkgs[i] < pkgs[j]
+	})
+
+	groups := int(math.Ceil(math.Sqrt(float64(len(pkgs)))))
+	var moves PackageMoves
+	for _, pkg := range pkgs {
+		dir := filepath.Join(srcDir, pkg)
+		if !include(pkg) || containsCGO(dir) {
+			continue
+		}
+
+		depth := 1 + n.Intn("depth:"+pkg, maxDepth)
+		var comps []string
+		for i := 0; i < depth-1; i++ {
+			group := n.Intn("group"+strconv.Itoa(i)+":"+pkg, groups)
+			comps = append(comps, strings.ToLower(n.Hash("group"+strconv.Itoa(i)+":"+strconv.Itoa(group))))
+		}
+		comps = append(comps, strings.ToLower(n.Hash("pkg:"+pkg)))
+		newPkg := strings.Join(comps, "/")
+
+		if err := movePackageDir(srcDir, pkg, newPkg); err != nil {
+			return nil, fmt.Errorf("package move: %s", err)
+		}
+		moves = append(moves, packageMove{pkg, newPkg})
+	}
+	if err := rewritePackageMoves(gopath, pkgs, moves); err != nil {
+		return nil, fmt.Errorf("package moves: %s", err)
+	}
+	return moves, nil
+}
+
+// packageDirs lists the import paths of every directory in
+// a source tree which contains Go files.
+func packageDirs(srcDir string) ([]string, error) {
+	var res []string
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		listing, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, item := range listing {
+			if !item.IsDir() && isGoFile(item.Name()) {
+				pkg, err := filepath.Rel(srcDir, path)
+				if err != nil {
+					return err
+				}
+				res = append(res, filepath.ToSlash(pkg))
+				break
+			}
+		}
+		return nil
+	})
+	return res, err
+}
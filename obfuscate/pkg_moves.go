@@ -0,0 +1,201 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// movePackageDir moves the directory of a package, along
+// with everything under it, to a new path in a source tree.
+// Nothing refers to the package by its new path until
+// rewritePackageMoves updates the code.
+func movePackageDir(srcDir, from, to string) error {
+	newDir := filepath.Join(srcDir, filepath.FromSlash(to))
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(srcDir, filepath.FromSlash(from)), newDir)
+}
+
+// rewritePackageMoves updates the Go files of a GOPATH
+// after package directories were moved with movePackageDir,
+// so that the code refers to every package by its new path.
+// It rewrites every file once, for all of the moves.
+//
+// Import paths and the import comments of package clauses
+// are changed, including those which a vendor directory
+// resolves. A package whose name was the last component of
+// its path is renamed after the new one, unless it is a main
+// package or the new component is not a Go identifier, and
+// the files which import it refer to it by the new name, or
+// by the old one in an explicit import name if the new one
+// would conflict with another name in the file.
+//
+// origPkgs are the import paths of the packages before they
+// were moved.
+func rewritePackageMoves(gopath string, origPkgs []string, moves PackageMoves) error {
+	if len(moves) == 0 {
+		return nil
+	}
+	srcDir := filepath.Join(gopath, "src")
+	orig := map[string]bool{}
+	for _, pkg := range origPkgs {
+		orig[pkg] = true
+	}
+
+	ctx := gopathContext(gopath)
+	var lock sync.Mutex
+	newNames := map[string]string{}
+	runJobs(len(origPkgs), func(i int) error {
+		oldPath := origPkgs[i]
+		newPath := moves.Obfuscated(oldPath)
+		newName := path.Base(newPath)
+		if newPath == oldPath || !token.IsIdentifier(newName) || newName == path.Base(oldPath) {
+			return nil
+		}
+		bp, err := ctx.ImportDir(filepath.Join(srcDir, filepath.FromSlash(newPath)), 0)
+		if err != nil || bp.Name != path.Base(oldPath) || bp.Name == "main" {
+			return nil
+		}
+		lock.Lock()
+		newNames[oldPath] = newName
+		lock.Unlock()
+		return nil
+	})
+
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return err
+	}
+	return forEachFile(files, func(f sourceFile) error {
+		edits := packageMoveEdits(f, orig, moves, newNames)
+		if len(edits) == 0 {
+			return nil
+		}
+		return applyFileEdits(f.Path, edits)
+	})
+}
+
+// packageMoveEdits finds the edits which make a file refer
+// to moved packages by their new paths and names.
+func packageMoveEdits(f sourceFile, orig map[string]bool, moves PackageMoves,
+	newNames map[string]string) []fileEdit {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, f.Path, nil, parser.ParseComments)
+	if err != nil {
+		// Files which do not parse are not part of any build.
+		return nil
+	}
+	offset := func(pos token.Pos) int {
+		return set.Position(pos).Offset
+	}
+	var edits []fileEdit
+	replace := func(node ast.Node, text string) {
+		edits = append(edits, fileEdit{Start: offset(node.Pos()), End: offset(node.End()), Text: text})
+	}
+
+	pkgPath := moves.Original(f.PkgPath)
+	if newName, ok := newNames[pkgPath]; ok {
+		oldName := path.Base(pkgPath)
+		if file.Name.Name == oldName {
+			replace(file.Name, newName)
+		} else if file.Name.Name == oldName+"_test" {
+			replace(file.Name, newName+"_test")
+		}
+	}
+	// The go command checks the import comments of package
+	// clauses, like // import "path", in GOPATH mode.
+	line := set.Position(file.Name.End()).Line
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if comment.Pos() < file.Name.End() || set.Position(comment.Pos()).Line != line {
+				continue
+			}
+			text := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(comment.Text, "//"), "/*"), "*/")
+			fields := strings.Fields(text)
+			if len(fields) != 2 || fields[0] != "import" {
+				continue
+			}
+			importPath, err := strconv.Unquote(fields[1])
+			if newPath := moves.Obfuscated(importPath); err == nil && newPath != importPath {
+				replace(comment, strings.Replace(comment.Text, fields[1], strconv.Quote(newPath), 1))
+			}
+		}
+	}
+
+	// The names which a renamed import must not take.
+	taken := map[string]bool{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok && ident != file.Name {
+			taken[ident.Name] = true
+		}
+		return true
+	})
+	renamed := map[string]string{}
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		fullPath := resolveVendored(orig, pkgPath, importPath)
+		newPath := moves.Obfuscated(fullPath)
+		if newPath == fullPath {
+			continue
+		}
+		if fullPath != importPath {
+			if idx := strings.LastIndex(newPath, "/vendor/"); idx >= 0 {
+				newPath = newPath[idx+len("/vendor/"):]
+			}
+		}
+		text := strconv.Quote(newPath)
+		if newName, ok := newNames[fullPath]; ok && spec.Name == nil {
+			oldName := path.Base(fullPath)
+			if taken[newName] {
+				text = oldName + " " + text
+			} else {
+				renamed[oldName] = newName
+				taken[newName] = true
+			}
+		}
+		replace(spec.Path, text)
+	}
+	if len(renamed) > 0 {
+		ast.Inspect(file, func(node ast.Node) bool {
+			sel, ok := node.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			// Identifiers which the parser resolves are local, and
+			// those it does not are imports or package-level names,
+			// which cannot share the name of an import.
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				if newName, ok := renamed[ident.Name]; ok {
+					replace(ident, newName)
+				}
+			}
+			return true
+		})
+	}
+	return edits
+}
+
+// resolveVendored finds the import path of the package
+// which an import of a package resolves to, given the
+// original import paths of the packages in the GOPATH, and
+// that of the importing package: the innermost vendored
+// copy of the package which is visible to it, if any.
+func resolveVendored(orig map[string]bool, importer, importPath string) string {
+	for dir := importer; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		if vendored := dir + "/vendor/" + importPath; orig[vendored] {
+			return vendored
+		}
+	}
+	return importPath
+}
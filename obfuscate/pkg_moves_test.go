@@ -0,0 +1,88 @@
+package obfuscate
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewritePackageMoves(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"app/main.go": "package main\n\nimport (\n\t\"app/util\"\n\t\"dep\"\n)\n\n" +
+			"func main() {\n\tprintln(util.Name(), dep.Value)\n}\n",
+		"app/conflict.go": "package main\n\nimport \"app/util\"\n\n" +
+			"func conflict() string {\n\thelpers := util.Name()\n\treturn helpers\n}\n",
+		"app/util/util.go":      "package util // import \"app/util\"\n\nfunc Name() string {\n\treturn \"util\"\n}\n",
+		"app/util/util_test.go": "package util_test\n\nimport \"app/util\"\n\nvar _ = util.Name\n",
+		"app/vendor/dep/dep.go": "package dep\n\nconst Value = 1\n",
+	})
+	srcDir := filepath.Join(gopath, "src")
+	origPkgs, err := packageDirs(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves := PackageMoves{
+		{"app/util", "app/helpers"},
+		{"app/vendor", "app/vendored"},
+		{"app", "moved"},
+	}
+	for _, move := range moves {
+		if err := movePackageDir(srcDir, move.From, move.To); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rewritePackageMoves(gopath, origPkgs, moves); err != nil {
+		t.Fatal(err)
+	}
+	checkTypes(t, gopath)
+
+	for path, expected := range map[string][]string{
+		"moved/main.go":              {"\"moved/helpers\"", "\"moved/vendored/dep\"", "helpers.Name()"},
+		"moved/conflict.go":          {"util \"moved/helpers\"", "util.Name()"},
+		"moved/helpers/util.go":      {"package helpers // import \"moved/helpers\""},
+		"moved/helpers/util_test.go": {"package helpers_test", "helpers.Name"},
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(srcDir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range expected {
+			if !strings.Contains(string(contents), s) {
+				t.Errorf("%s does not contain %s:\n%s", path, s, contents)
+			}
+		}
+	}
+}
+
+func TestObfuscatePackageNames(t *testing.T) {
+	gopath := syntheticGOPATH(t, 3, 2)
+	moves, err := ObfuscatePackageNames(gopath, NameHasher("padding"), func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTypes(t, gopath)
+	pkgs, err := packageDirs(filepath.Join(gopath, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range pkgs {
+		if strings.Contains(pkg, "synth") {
+			t.Errorf("package %s was not moved", pkg)
+		}
+		if moves.Original(pkg) == pkg {
+			t.Errorf("no move for %s", pkg)
+		}
+	}
+}
+
+func BenchmarkObfuscatePackageNames(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		gopath := syntheticGOPATH(b, 20, 10)
+		b.StartTimer()
+		if _, err := ObfuscatePackageNames(gopath, NameHasher("padding"), func(string) bool { return true }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
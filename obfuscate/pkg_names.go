@@ -0,0 +1,162 @@
+package obfuscate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// PackageMoves records the package moves performed by
+// ObfuscatePackageNames, in order.
+// Moving a package also moves all of its sub-packages.
+type PackageMoves []packageMove
+
+type packageMove struct {
+	From string
+	To   string
+}
+
+// Obfuscated finds the new import path of a package.
+func (p PackageMoves) Obfuscated(importPath string) string {
+	for _, move := range p {
+		importPath = replacePathPrefix(importPath, move.From, move.To)
+	}
+	return importPath
+}
+
+// Original finds the original import path of a package
+// from its new import path.
+func (p PackageMoves) Original(importPath string) string {
+	for i := len(p) - 1; i >= 0; i-- {
+		importPath = replacePathPrefix(importPath, p[i].To, p[i].From)
+	}
+	return importPath
+}
+
+func replacePathPrefix(importPath, oldPrefix, newPrefix string) string {
+	if importPath == oldPrefix {
+		return newPrefix
+	} else if strings.HasPrefix(importPath, oldPrefix+"/") {
+		return newPrefix + importPath[len(oldPrefix):]
+	}
+	return importPath
+}
+
+// ObfuscatePackageNames hashes every component of the
+// import paths in a GOPATH, one level at a time.
+// Directories for which include returns false, given their
+// import path before this pass, are not moved (though
+// moving a directory above them still changes their path).
+// The directories are moved first, and the code is then
+// updated for all of the moves at once.
+func ObfuscatePackageNames(gopath string, n NameHasher, include func(string) bool) (PackageMoves, error) {
+	var moves PackageMoves
+
+	level := 1
+	srcDir := filepath.Join(gopath, "src")
+	size := componentSize(srcDir)
+	origPkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	doneChan := make(chan struct{})
+	defer close(doneChan)
+
+	for {
+		resChan := make(chan string)
+		go func() {
+			scanLevel(srcDir, level, resChan, doneChan)
+			close(resChan)
+		}()
+		var dirs []string
+		for dirPath := range resChan {
+			dirs = append(dirs, dirPath)
+		}
+		if len(dirs) == 0 {
+			break
+		}
+
+		// Checking a directory parses its files, so the
+		// directories of a level are checked in parallel.
+		// Directories without Go files below them hold
+		// embedded files, whose paths must stay the same.
+		skip := make([]bool, len(dirs))
+		runJobs(len(dirs), func(i int) error {
+			skip[i] = containsCGO(dirs[i]) || !treeHasGoFiles(dirs[i])
+			return nil
+		})
+		for i, dirPath := range dirs {
+			if skip[i] {
+				continue
+			}
+			srcPkg, err := filepath.Rel(srcDir, dirPath)
+			if err != nil {
+				return nil, err
+			}
+			if !include(moves.Original(filepath.ToSlash(srcPkg))) {
+				continue
+			}
+			encPath := encryptPackageName(dirPath, level, size, n)
+			dstPkg, err := filepath.Rel(srcDir, encPath)
+			if err != nil {
+				return nil, err
+			}
+			if err := movePackageDir(srcDir, srcPkg, dstPkg); err != nil {
+				return nil, fmt.Errorf("package move: %s", err)
+			}
+			moves = append(moves, packageMove{filepath.ToSlash(srcPkg), filepath.ToSlash(dstPkg)})
+		}
+		level++
+	}
+
+	if err := rewritePackageMoves(gopath, origPkgs, moves); err != nil {
+		return nil, fmt.Errorf("package moves: %s", err)
+	}
+	return moves, nil
+}
+
+func scanLevel(dir string, depth int, res chan<- string, done <-chan struct{}) {
+	if depth == 0 {
+		select {
+		case res <- dir:
+		case <-done:
+			return
+		}
+		return
+	}
+	listing, _ := ioutil.ReadDir(dir)
+	for _, item := range listing {
+		if item.IsDir() && !isCgoDir(item.Name()) {
+			scanLevel(filepath.Join(dir, item.Name()), depth-1, res, done)
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+// encryptPackageName finds the new path of a directory at
+// a level of the source tree, whose hashed name is at most
+// size characters long.
+// Names which are already taken by a sibling, even with a
+// different case, or which are reserved on some OS are
+// replaced with other ones.
+func encryptPackageName(dir string, level, size int, p NameHasher) string {
+	subDir, base := filepath.Split(dir)
+	isPackage := pathStyle == "mimic" && containsGoFiles(dir)
+	for attempt := 0; ; attempt++ {
+		var name string
+		if pathStyle == "mimic" {
+			name = mimicComponent(p, base, level, attempt, isPackage)
+		} else {
+			name = hashComponent(p, base, attempt, size)
+		}
+		if portableComponent(name) && !takenComponent(subDir, name) {
+			return filepath.Join(subDir, name)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package obfuscate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxPortablePath is the longest path, relative to the
+// GOPATH (like "src/a/b/file.go"), which an obfuscated tree
+// may hold. Windows limits paths to 260 characters unless
+// long paths are enabled, and this leaves room for the
+// directory the tree is put in.
+const maxPortablePath = 200
+
+// minComponentSize is how short hashed path components may
+// be made to keep paths under maxPortablePath.
+const minComponentSize = 8
+
+// windowsReserved are the device names which Windows does
+// not allow as file names, even with an extension.
+var windowsReserved = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com0": true, "com1": true, "com2": true, "com3": true, "com4": true,
+	"com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt0": true, "lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true,
+	"lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// portableComponent checks if a file or directory name can
+// be created on every host OS.
+func portableComponent(name string) bool {
+	if name == "" || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return false
+	}
+	for _, c := range name {
+		if c < ' ' || strings.ContainsRune(`<>:"/\|?*`, c) {
+			return false
+		}
+	}
+	stem := name
+	if dot := strings.Index(stem, "."); dot >= 0 {
+		stem = stem[:dot]
+	}
+	return !windowsReserved[strings.ToLower(strings.TrimRight(stem, " "))]
+}
+
+// takenComponent checks if a directory holds an entry whose
+// name only differs from name by case, which is the same
+// name on case-insensitive file systems like those of
+// macOS and Windows.
+func takenComponent(dir, name string) bool {
+	listing, _ := ioutil.ReadDir(dir)
+	for _, item := range listing {
+		if strings.EqualFold(item.Name(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashComponent hashes a path component, making a new name
+// for each attempt, and cuts hex names down to size.
+func hashComponent(n NameHasher, base string, attempt, size int) string {
+	token := base
+	if attempt > 0 {
+		token += "#" + strconv.Itoa(attempt)
+	}
+	name := n.Hash(token)
+	if namingMode == "hash" && len(name) > size {
+		name = name[:size]
+	}
+	return name
+}
+
+// componentSize finds how long the hashed components of
+// the directories in a source tree may be, so that no path
+// in the tree gets longer than maxPortablePath. It assumes
+// that every directory is renamed, and that those which
+// are not keep names at least as long.
+// The size is between minComponentSize and the size of
+// full hex names.
+func componentSize(srcDir string) int {
+	size := hashedSymbolSize * 2
+	filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return nil
+		}
+		comps := strings.Split(filepath.ToSlash(rel), "/")
+		for size > minComponentSize {
+			length := len("src/") + len(comps[len(comps)-1])
+			for _, comp := range comps[:len(comps)-1] {
+				if len(comp) > size {
+					length += len(comp) + 1
+				} else {
+					length += size + 1
+				}
+			}
+			if length <= maxPortablePath {
+				break
+			}
+			size--
+		}
+		return nil
+	})
+	return size
+}
+
+// checkPortablePaths checks that every path in the source
+// tree of a GOPATH can be created on every host OS: no two
+// names in a directory may differ only by case, names must
+// not be reserved on Windows, and paths must not be longer
+// than maxPortablePath.
+//
+// It returns descriptions of the problems, split into the
+// ones which obfuscation caused and the ones which paths
+// that were not moved already had.
+func checkPortablePaths(gopath string, moves PackageMoves) (problems, original []string, err error) {
+	srcDir := filepath.Join(gopath, "src")
+	moved := func(path string) bool {
+		rel, _ := filepath.Rel(srcDir, path)
+		rel = filepath.ToSlash(rel)
+		return moves.Original(rel) != rel
+	}
+	report := func(msg string, paths ...string) {
+		for _, path := range paths {
+			if moved(path) {
+				problems = append(problems, msg)
+				return
+			}
+		}
+		original = append(original, msg)
+	}
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(gopath, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !info.IsDir() {
+			if len(rel) > maxPortablePath {
+				report(fmt.Sprintf("%s is longer than %d characters", rel, maxPortablePath), path)
+			}
+			return nil
+		}
+		listing, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		folded := map[string]string{}
+		for _, item := range listing {
+			name := item.Name()
+			if !portableComponent(name) {
+				report(rel+"/"+name+" is not a valid name on windows", filepath.Join(path, name))
+			}
+			key := strings.ToLower(name)
+			if other, ok := folded[key]; ok {
+				report(fmt.Sprintf("%s/%s and %s/%s only differ by case", rel, other, rel, name),
+					filepath.Join(path, other), filepath.Join(path, name))
+			}
+			folded[key] = name
+		}
+		return nil
+	})
+	sort.Strings(problems)
+	sort.Strings(original)
+	return problems, original, err
+}
@@ -0,0 +1,75 @@
+package obfuscate
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A listFlag is a flag which can be passed multiple times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// prepareEnv gets the environment variables to set while
+// the workspace is prepared, from the config file and the
+// -prepenv flags (which take precedence).
+//
+// Only Go toolchain variables (GO*) may be set.
+func prepareEnv() (map[string]string, error) {
+	res := map[string]string{}
+	if config != nil {
+		for key, value := range config.PrepareEnv {
+			res[key] = value
+		}
+	}
+	for _, item := range prepEnvFlags {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid environment variable: %s", item)
+		}
+		res[parts[0]] = parts[1]
+	}
+	for key := range res {
+		if !strings.HasPrefix(key, "GO") {
+			return nil, fmt.Errorf("not a Go environment variable: %s", key)
+		}
+	}
+	return res, nil
+}
+
+// withEnv runs f with extra variables in the process
+// environment, which is restored afterwards.
+// This affects the go commands run by go/build, as well as
+// any we run ourselves with the default environment.
+func withEnv(vars map[string]string, f func() error) error {
+	var keys []string
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		oldValue, wasSet := os.LookupEnv(key)
+		if err := os.Setenv(key, vars[key]); err != nil {
+			return err
+		}
+		if wasSet {
+			defer os.Setenv(key, oldValue)
+		} else {
+			defer os.Unsetenv(key)
+		}
+		if verbose {
+			fmt.Println("[Verbose] Preparation environment:", key+"="+vars[key])
+		}
+	}
+	return f()
+}
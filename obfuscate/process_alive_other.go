@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !solaris && !illumos && !aix && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!solaris,!illumos,!aix,!windows
+
+package obfuscate
+
+// processAlive assumes that every process is running where
+// processes cannot be checked.
+func processAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !solaris && !illumos && !aix
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!solaris,!illumos,!aix
+
+package obfuscate
+
+import "os/exec"
+
+// newProcessGroup does nothing where process groups are
+// not supported.
+func newProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kills a command. Where process groups
+// are not supported, the processes which it started are
+// left to exit on their own.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
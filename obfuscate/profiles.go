@@ -0,0 +1,100 @@
+package obfuscate
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profiles are named presets of command-line flags.
+// Each profile includes everything from the ones before it.
+var profileOrder = []string{"light", "standard", "paranoid"}
+
+var profileFlags = map[string]map[string]string{
+	"light": {
+		"keepbuildinfo": "true",
+	},
+	"standard": {
+		"keepbuildinfo": "false",
+		"flatten":       "3",
+	},
+	"paranoid": {
+		"inlineconsts": "true",
+		"junk":         "true",
+		"literals":     "true",
+		"prunetypes":   "true",
+		"randomroot":   "true",
+		"secrets":      "fail",
+		"stripnotes":   "true",
+	},
+}
+
+// perPackageFlags are the flags which enable passes that
+// a config file may turn on or off for single packages.
+var perPackageFlags = map[string]bool{
+	"flatten":      true,
+	"inlineconsts": true,
+	"merge":        true,
+	"prunetypes":   true,
+}
+
+// profileValues gets the flag values of a profile,
+// including the values of the profiles it builds on.
+func profileValues(profile string) (map[string]string, error) {
+	res := map[string]string{}
+	for _, name := range profileOrder {
+		for key, value := range profileFlags[name] {
+			res[key] = value
+		}
+		if name == profile {
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown profile %q (expected one of: %s)", profile,
+		strings.Join(profileOrder, ", "))
+}
+
+// applyProfile sets every flag of a profile which was not
+// explicitly passed on the command line.
+func applyProfile(profile string, explicit map[string]bool) error {
+	values, err := profileValues(profile)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		if err := runFlags.Set(name, values[name]); err != nil {
+			return fmt.Errorf("set %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// profileEnables checks if a profile turns on the pass
+// controlled by a flag.
+func profileEnables(profile, flagName string) bool {
+	values, err := profileValues(profile)
+	if err != nil {
+		return false
+	}
+	value := values[flagName]
+	return value != "" && value != "false" && value != "0"
+}
+
+// explicitFlags finds the flags which were set on the
+// command line.
+func explicitFlags() map[string]bool {
+	res := map[string]bool{}
+	runFlags.Visit(func(f *flag.Flag) {
+		res[f.Name] = true
+	})
+	return res
+}
@@ -0,0 +1,55 @@
+package obfuscate
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestProfileValues(t *testing.T) {
+	light := map[string]string{"keepbuildinfo": "true"}
+	standard := map[string]string{"keepbuildinfo": "false", "flatten": "3"}
+	paranoid := map[string]string{
+		"keepbuildinfo": "false",
+		"flatten":       "3",
+		"inlineconsts":  "true",
+		"junk":          "true",
+		"literals":      "true",
+		"prunetypes":    "true",
+		"randomroot":    "true",
+		"secrets":       "fail",
+		"stripnotes":    "true",
+	}
+	for profile, expected := range map[string]map[string]string{
+		"light":    light,
+		"standard": standard,
+		"paranoid": paranoid,
+	} {
+		actual, err := profileValues(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %v but got %v", profile, expected, actual)
+		}
+	}
+	if _, err := profileValues("stndard"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	// Later tests expect the flags to have their defaults.
+	defer registerFlags(flag.NewFlagSet("defaults", flag.ContinueOnError))
+	err := Run(Options{PkgName: "example.com/missing", OutPath: "out", Modules: true, Profile: "paranoid",
+		Secrets: "sometimes"})
+	if _, ok := err.(*FlagError); !ok {
+		t.Fatalf("expected a *FlagError, got %#v", err)
+	}
+	if !junkCode || flattenDepth != 3 || !randomRoot || keepBuildInfo {
+		t.Error("the paranoid profile did not set its flags")
+	}
+	if secretsMode != "sometimes" {
+		t.Error("the profile overrode an explicit flag")
+	}
+}
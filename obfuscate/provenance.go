@@ -0,0 +1,333 @@
+package obfuscate
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// provenancePath, if set, is where a signed in-toto
+	// attestation of the SLSA provenance of the binaries is
+	// written.
+	provenancePath string
+
+	// provenanceBuilder is the ID of the build platform
+	// which the provenance names, like the URI of a CI
+	// runner, or else that of gobfuscate itself.
+	provenanceBuilder string
+)
+
+const (
+	provenanceBuildType   = "https://github.com/unixpickle/gobfuscate/provenance/v1"
+	provenancePayloadType = "application/vnd.in-toto+json"
+)
+
+// provenancePackage and provenanceDir are the package
+// argument and the working directory of the run, as they
+// were before a remote package or a -src archive was
+// checked out, and provenanceArchive is the checksum of the
+// -src archive.
+var provenancePackage, provenanceDir, provenanceArchive string
+
+// An inTotoStatement is an in-toto attestation, which
+// states a predicate about its subjects, the binaries.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []slsaResource  `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     *slsaProvenance `json:"predicate"`
+}
+
+// slsaProvenance is a SLSA v1 provenance predicate: what
+// was built, from which sources, and by what.
+type slsaProvenance struct {
+	BuildDefinition struct {
+		BuildType            string                 `json:"buildType"`
+		ExternalParameters   map[string]interface{} `json:"externalParameters"`
+		InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+		ResolvedDependencies []slsaResource         `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  string `json:"startedOn"`
+			FinishedOn string `json:"finishedOn"`
+		} `json:"metadata"`
+		Byproducts []slsaResource `json:"byproducts,omitempty"`
+	} `json:"runDetails"`
+}
+
+// An slsaResource is a file or source which an attestation
+// refers to, by URI or name, and digest.
+type slsaResource struct {
+	URI         string                 `json:"uri,omitempty"`
+	Name        string                 `json:"name,omitempty"`
+	Digest      map[string]string      `json:"digest,omitempty"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// A dsseEnvelope is a signed attestation, in the DSSE
+// format which in-toto tools read. The payload and the
+// signatures are encoded in base64.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// checkProvenanceFlags reports -provenance flags which
+// cannot be used, and loads the signing key to catch
+// problems with it before building.
+func checkProvenanceFlags() error {
+	if provenancePath == "" {
+		if provenanceBuilder != "" {
+			return flagError("The -provenancebuilder flag requires -provenance.")
+		}
+		return nil
+	}
+	if outputGopath || numVariants > 1 {
+		return flagError("The -provenance flag cannot be combined with -outdir or -variants.")
+	}
+	if _, err := loadReleaseKey(); err != nil {
+		return stepError("load provenance signing key", err)
+	}
+	return nil
+}
+
+// startProvenance records where the sources of a run which
+// writes a provenance come from, before they are checked
+// out.
+func startProvenance(pkgName string) error {
+	if provenancePath == "" {
+		return nil
+	}
+	var err error
+	if provenanceDir, err = currentDir(); err != nil {
+		return stepError("get working directory", err)
+	}
+	provenancePackage = pkgName
+	provenanceArchive = ""
+	if sourceArchive != "" && sourceArchive != "-" {
+		file, err := releaseFile(sourceArchive)
+		if err != nil {
+			return stepError("hash source archive", err)
+		}
+		provenanceArchive = file.SHA256
+	}
+	return nil
+}
+
+// WriteProvenance writes the signed provenance of the
+// workspace's binaries, with the mapping file at mapFile,
+// if there is one.
+func (w *Workspace) WriteProvenance(mapFile string) error {
+	key, err := loadReleaseKey()
+	if err != nil {
+		return stepError("load provenance signing key", err)
+	}
+	statement := &inTotoStatement{
+		Type:          "https://in-toto.io/Statement/v1",
+		Subject:       []slsaResource{},
+		PredicateType: "https://slsa.dev/provenance/v1",
+		Predicate:     &slsaProvenance{},
+	}
+	for _, artifact := range w.Artifacts {
+		file, err := releaseFile(artifact.Path)
+		if err != nil {
+			return stepError("hash binary", err)
+		}
+		statement.Subject = append(statement.Subject, slsaResource{
+			Name:   filepath.ToSlash(artifact.Path),
+			Digest: map[string]string{"sha256": file.SHA256},
+		})
+	}
+
+	predicate := statement.Predicate
+	def := &predicate.BuildDefinition
+	def.BuildType = provenanceBuildType
+	var targets []string
+	for _, target := range buildTargets() {
+		targets = append(targets, target.String())
+	}
+	def.ExternalParameters = map[string]interface{}{
+		"package": provenancePackage,
+		"flags":   releaseFlags(),
+		"targets": targets,
+	}
+	if len(w.ExtraPkgs) > 0 {
+		def.ExternalParameters["extra_packages"] = w.ExtraPkgs
+	}
+	version, err := goVersion()
+	if err != nil {
+		return stepError("get Go version", err)
+	}
+	def.InternalParameters = map[string]interface{}{"modules": useModules}
+	if seed != "" {
+		def.InternalParameters["seed_id"] = seedHex("id")[:16]
+	}
+	if def.ResolvedDependencies, err = provenanceSources(w.PkgName); err != nil {
+		return stepError("fingerprint sources", err)
+	}
+	tool := currentTool()
+	toolResource := slsaResource{URI: "pkg:golang/github.com/unixpickle/gobfuscate@" + tool.Version}
+	if tool.Commit != "" {
+		toolResource.Digest = map[string]string{"gitCommit": strings.TrimSuffix(tool.Commit, "-dirty")}
+	}
+	def.ResolvedDependencies = append(def.ResolvedDependencies, toolResource,
+		slsaResource{URI: "pkg:golang/stdlib@" + version})
+
+	run := &predicate.RunDetails
+	run.Builder.ID = provenanceBuilder
+	if run.Builder.ID == "" {
+		run.Builder.ID = "https://github.com/unixpickle/gobfuscate@" + tool.Version
+	}
+	run.Builder.Version = map[string]string{"gobfuscate": tool.Version, "go": version}
+	run.Metadata.StartedOn = runMetrics.start.UTC().Format(time.RFC3339)
+	run.Metadata.FinishedOn = time.Now().UTC().Format(time.RFC3339)
+	var byproducts []string
+	if mapFile != "" {
+		byproducts = append(byproducts, mapFile)
+	}
+	if sbomFormat != "" {
+		for _, artifact := range w.Artifacts {
+			byproducts = append(byproducts, sbomPath(artifact.Path))
+		}
+	}
+	for _, path := range byproducts {
+		file, err := releaseFile(path)
+		if err != nil {
+			return stepError("hash "+path, err)
+		}
+		run.Byproducts = append(run.Byproducts, slsaResource{
+			Name:   filepath.ToSlash(path),
+			Digest: map[string]string{"sha256": file.SHA256},
+		})
+	}
+
+	envelope, err := signStatement(statement, key)
+	if err != nil {
+		return stepError("sign provenance", err)
+	}
+	if err := ioutil.WriteFile(provenancePath, envelope, 0644); err != nil {
+		return stepError("write provenance", err)
+	}
+	return nil
+}
+
+// provenanceSources describes the sources of a run: where
+// they were checked out from, at which revision, and a
+// digest of the files of every package which was built.
+func provenanceSources(pkgName string) ([]slsaResource, error) {
+	var res []slsaResource
+	switch {
+	case sourceArchive != "":
+		archive := slsaResource{Name: filepath.Base(sourceArchive)}
+		if provenanceArchive != "" {
+			archive.Digest = map[string]string{"sha256": provenanceArchive}
+		}
+		res = append(res, archive)
+	case isRemoteRef(provenancePackage) && isGitRef(provenancePackage):
+		url := provenancePackage[:strings.LastIndex(provenancePackage, "#")]
+		res = append(res, slsaResource{URI: "git+" + url, Digest: map[string]string{"gitCommit": remoteRevision}})
+	case isRemoteRef(provenancePackage):
+		modPath := provenancePackage[:strings.LastIndex(provenancePackage, "@")]
+		res = append(res, slsaResource{URI: "pkg:golang/" + modPath + "@" + remoteRevision})
+	default:
+		if commit, dirty := sourceCommit(provenanceDir); commit != "" {
+			source := slsaResource{URI: "file://" + filepath.ToSlash(provenanceDir)}
+			if remote := gitRemoteURL(provenanceDir); remote != "" {
+				source.URI = "git+" + remote
+			}
+			source.Digest = map[string]string{"gitCommit": commit}
+			if dirty {
+				source.Annotations = map[string]interface{}{"dirty": true}
+			}
+			res = append(res, source)
+		}
+	}
+
+	// The digest of the sources holds whether or not there
+	// is a revision, and whatever was changed since.
+	sources, err := buildSources(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintln(hash, name, sources[name])
+	}
+	res = append(res, slsaResource{
+		Name:        "sources",
+		Digest:      map[string]string{"sha256": hex.EncodeToString(hash.Sum(nil))},
+		Annotations: map[string]interface{}{"packages": len(names)},
+	})
+	return res, nil
+}
+
+// gitRemoteURL gets the URL of the origin remote of the
+// repository which contains dir, or "".
+func gitRemoteURL(dir string) string {
+	output, err := exec.Command("git", "-C", dir, "config", "--get", "remote.origin.url").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// signStatement encodes a statement in a DSSE envelope,
+// signed with an Ed25519 key, as a line of JSON.
+func signStatement(statement *inTotoStatement, key ed25519.PrivateKey) ([]byte, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	keyID := sha256.Sum256(publicDER)
+	envelope := &dsseEnvelope{
+		PayloadType: provenancePayloadType,
+		Payload:     payload,
+		Signatures: []dsseSignature{{
+			KeyID: hex.EncodeToString(keyID[:]),
+			Sig:   ed25519.Sign(key, dssePAE(provenancePayloadType, payload)),
+		}},
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// dssePAE is the pre-authentication encoding of a DSSE
+// payload, which is what is signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	header := "DSSEv1 " + strconv.Itoa(len(payloadType)) + " " + payloadType + " " + strconv.Itoa(len(payload)) + " "
+	return append([]byte(header), payload...)
+}
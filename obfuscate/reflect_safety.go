@@ -0,0 +1,359 @@
+package obfuscate
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// reflectSafety decides what happens to the names which
+// code looks up at run time, like the methods called by
+// templates: with "keep", they keep their original names,
+// with "warn", they are only reported, and with "off",
+// they are not looked for.
+var reflectSafety string
+
+// A reflectiveUse is a place where code looks up names at
+// run time.
+type reflectiveUse struct {
+	// Pos is the position of the use, as
+	// "original/import/path/file.go:line".
+	Pos string
+
+	// Types are the types whose names are used, and
+	// Members are the names of methods and fields, which
+	// may belong to any type.
+	Types   []keepEntry
+	Members []string
+
+	// Problem tells why the names which are used cannot be
+	// known, if they cannot.
+	Problem string
+}
+
+// Names lists the names of a use, for messages.
+func (r *reflectiveUse) Names() string {
+	var names []string
+	for _, entry := range r.Types {
+		names = append(names, entry.String())
+	}
+	return strings.Join(append(names, r.Members...), ", ")
+}
+
+// findReflectiveUses looks for names which the packages of
+// a GOPATH for which include returns true look up at run
+// time:
+//
+//   - the methods and fields named by constant templates
+//     of text/template and html/template;
+//   - the names passed to MethodByName and FieldByName;
+//   - the types given to xml.Marshal and gob.Register,
+//     whose names are encoded;
+//   - embedded types which are not structs, whose names
+//     become JSON and XML keys.
+//
+// Packages are type-checked without their imports, so only
+// the types declared by the package which makes a call are
+// found.
+func findReflectiveUses(gopath string, moves PackageMoves, include func(string) bool) ([]reflectiveUse, error) {
+	srcDir := filepath.Join(gopath, "src")
+	pkgs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	var res []reflectiveUse
+	for _, pkg := range pkgs {
+		if !include(pkg) {
+			continue
+		}
+		set := token.NewFileSet()
+		files, err := parsePackageFiles(set, filepath.Join(srcDir, filepath.FromSlash(pkg)))
+		if err != nil {
+			return nil, err
+		}
+		info := &types.Info{
+			Types: map[ast.Expr]types.TypeAndValue{},
+			Uses:  map[*ast.Ident]types.Object{},
+		}
+		conf := types.Config{Importer: noImporter{}, Error: func(error) {}}
+		conf.Check(pkg, set, files, info)
+
+		original := moves.Original(pkg)
+		for _, file := range files {
+			finder := &reflectionFinder{
+				pkg:      pkg,
+				original: original,
+				set:      set,
+				info:     info,
+				file:     file,
+			}
+			ast.Inspect(file, func(node ast.Node) bool {
+				if call, ok := node.(*ast.CallExpr); ok {
+					if use := finder.Call(call); use != nil {
+						res = append(res, *use)
+					}
+				}
+				return true
+			})
+		}
+	}
+	return res, nil
+}
+
+// A reflectionFinder finds the reflective uses in the
+// calls of a file.
+type reflectionFinder struct {
+	pkg      string
+	original string
+	set      *token.FileSet
+	info     *types.Info
+	file     *ast.File
+}
+
+// Call checks if a call looks up names at run time.
+func (r *reflectionFinder) Call(call *ast.CallExpr) *reflectiveUse {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	use := &reflectiveUse{Pos: r.position(call.Pos())}
+	method := sel.Sel.Name
+	var imported string
+	if x, ok := sel.X.(*ast.Ident); ok {
+		if pkgName, ok := r.info.Uses[x].(*types.PkgName); ok {
+			imported = pkgName.Imported().Path()
+		}
+	}
+	usesTemplates := importsPath(r.file, "text/template") || importsPath(r.file, "html/template")
+
+	switch {
+	case imported == "" && (method == "MethodByName" || method == "FieldByName") && len(call.Args) == 1:
+		name, ok := r.constantString(call.Args[0])
+		if !ok {
+			use.Problem = "the name passed to " + method + " is not a constant, so the member it names may be renamed"
+			return use
+		}
+		use.Members = []string{name}
+	case imported == "" && method == "Parse" && len(call.Args) == 1 && usesTemplates:
+		text, ok := r.constantString(call.Args[0])
+		if !ok {
+			if !r.refersToTemplates(sel.X) {
+				return nil
+			}
+			use.Problem = "the template text is not a constant, so the methods it calls may be renamed"
+			return use
+		}
+		members, err := templateMembers(text)
+		if err != nil {
+			use.Problem = "the template cannot be parsed, so the methods it calls may be renamed: " + err.Error()
+			return use
+		}
+		use.Members = members
+	case (imported == "" || imported == "text/template" || imported == "html/template") &&
+		(method == "ParseFiles" || method == "ParseGlob" || method == "ParseFS") && usesTemplates:
+		use.Problem = "templates from files are not checked, so the methods they call may be renamed"
+		return use
+	case imported == "encoding/xml" && (method == "Marshal" || method == "MarshalIndent"),
+		imported == "" && (method == "Encode" || method == "EncodeElement") && importsPath(r.file, "encoding/xml"):
+		r.encodedTypes(use, call.Args, 0, true)
+	case imported == "encoding/xml" && method == "Unmarshal",
+		imported == "" && (method == "Decode" || method == "DecodeElement") && importsPath(r.file, "encoding/xml"):
+		r.encodedTypes(use, call.Args, len(call.Args)-1, true)
+	case imported == "encoding/json" && (method == "Marshal" || method == "MarshalIndent"),
+		imported == "" && method == "Encode" && importsPath(r.file, "encoding/json"):
+		r.encodedTypes(use, call.Args, 0, false)
+	case imported == "encoding/json" && method == "Unmarshal",
+		imported == "" && method == "Decode" && importsPath(r.file, "encoding/json"):
+		r.encodedTypes(use, call.Args, len(call.Args)-1, false)
+	case imported == "encoding/gob" && method == "Register" && len(call.Args) == 1:
+		if named := r.localType(r.info.TypeOf(call.Args[0])); named != nil {
+			use.Types = []keepEntry{r.entry(named)}
+		}
+	}
+	if len(use.Types) == 0 && len(use.Members) == 0 {
+		return nil
+	}
+	return use
+}
+
+func (r *reflectionFinder) position(pos token.Pos) string {
+	p := r.set.Position(pos)
+	return r.original + "/" + filepath.Base(p.Filename) + ":" + strconv.Itoa(p.Line)
+}
+
+func (r *reflectionFinder) constantString(expr ast.Expr) (string, bool) {
+	value := r.info.Types[expr].Value
+	if value == nil || value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(value), true
+}
+
+// refersToTemplates checks if an expression mentions the
+// template packages, like template.New("x").
+func (r *reflectionFinder) refersToTemplates(expr ast.Expr) bool {
+	var res bool
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok {
+			if pkgName, ok := r.info.Uses[ident].(*types.PkgName); ok {
+				path := pkgName.Imported().Path()
+				res = res || path == "text/template" || path == "html/template"
+			}
+		}
+		return !res
+	})
+	return res
+}
+
+// encodedTypes adds the names which encoding the argument
+// at index uses: its type name, for XML elements without
+// an XMLName field, and the names of embedded types which
+// are not structs, which become keys.
+func (r *reflectionFinder) encodedTypes(use *reflectiveUse, args []ast.Expr, index int, xml bool) {
+	if index < 0 || index >= len(args) {
+		return
+	}
+	t := r.info.TypeOf(args[index])
+	if named := r.localType(t); named != nil && xml {
+		if st, ok := named.Underlying().(*types.Struct); ok && !hasField(st, "XMLName") {
+			use.Types = append(use.Types, r.entry(named))
+		}
+	}
+	seen := map[*types.Named]bool{}
+	var visit func(t types.Type)
+	visit = func(t types.Type) {
+		named := r.localType(t)
+		if named == nil || seen[named] {
+			return
+		}
+		seen[named] = true
+		st, ok := named.Underlying().(*types.Struct)
+		if !ok {
+			return
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			field := st.Field(i)
+			if field.Embedded() && st.Tag(i) == "" {
+				embedded := r.localType(field.Type())
+				if _, isStruct := field.Type().Underlying().(*types.Struct); embedded != nil && !isStruct {
+					use.Types = append(use.Types, r.entry(embedded))
+				}
+			}
+			visit(field.Type())
+		}
+	}
+	visit(t)
+}
+
+// localType finds the named type declared by the package
+// which a type refers to, through pointers, slices, arrays,
+// and maps.
+func (r *reflectionFinder) localType(t types.Type) *types.Named {
+	for {
+		switch u := t.(type) {
+		case *types.Pointer:
+			t = u.Elem()
+		case *types.Slice:
+			t = u.Elem()
+		case *types.Array:
+			t = u.Elem()
+		case *types.Map:
+			t = u.Elem()
+		case *types.Named:
+			if obj := u.Obj(); obj.Pkg() != nil && obj.Pkg().Path() == r.pkg && obj.Parent() == obj.Pkg().Scope() {
+				return u
+			}
+			return nil
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *reflectionFinder) entry(named *types.Named) keepEntry {
+	return keepEntry{Pkg: r.original, ObfPkg: r.pkg, Type: named.Obj().Name()}
+}
+
+func hasField(st *types.Struct, name string) bool {
+	for i := 0; i < st.NumFields(); i++ {
+		if st.Field(i).Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// templateMembers lists the names of the fields and
+// methods which a template refers to, like Name in
+// {{.User.Name}}.
+func templateMembers(text string) ([]string, error) {
+	tree := parse.New("template")
+	tree.Mode = parse.SkipFuncCheck
+	trees := map[string]*parse.Tree{}
+	if _, err := tree.Parse(text, "", "", trees); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	var visit func(node parse.Node)
+	visitList := func(list *parse.ListNode) {
+		if list != nil {
+			for _, node := range list.Nodes {
+				visit(node)
+			}
+		}
+	}
+	visitPipe := func(pipe *parse.PipeNode) {
+		if pipe != nil {
+			for _, cmd := range pipe.Cmds {
+				for _, arg := range cmd.Args {
+					visit(arg)
+				}
+			}
+		}
+	}
+	visitBranch := func(branch *parse.BranchNode) {
+		visitPipe(branch.Pipe)
+		visitList(branch.List)
+		visitList(branch.ElseList)
+	}
+	visit = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			visitList(node)
+		case *parse.ActionNode:
+			visitPipe(node.Pipe)
+		case *parse.PipeNode:
+			visitPipe(node)
+		case *parse.FieldNode:
+			for _, name := range node.Ident {
+				names[name] = true
+			}
+		case *parse.ChainNode:
+			visit(node.Node)
+			for _, name := range node.Field {
+				names[name] = true
+			}
+		case *parse.VariableNode:
+			for _, name := range node.Ident[1:] {
+				names[name] = true
+			}
+		case *parse.IfNode:
+			visitBranch(&node.BranchNode)
+		case *parse.RangeNode:
+			visitBranch(&node.BranchNode)
+		case *parse.WithNode:
+			visitBranch(&node.BranchNode)
+		case *parse.TemplateNode:
+			visitPipe(node.Pipe)
+		}
+	}
+	for _, tree := range trees {
+		visitList(tree.Root)
+	}
+	return sortedKeys(names), nil
+}
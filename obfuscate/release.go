@@ -0,0 +1,198 @@
+package obfuscate
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// releasePath, if set, is where a release descriptor of
+// the build is written, and releaseSignPath is the key it
+// is signed with, or else the -mapsign key.
+var (
+	releasePath     string
+	releaseSignPath string
+)
+
+const releaseFormat = "gobfuscate-release-1"
+
+// A Release describes how the binaries of a build were
+// produced, for the people who ship them. It is signed
+// with a detached Ed25519 signature, which is written next
+// to it with a ".sig" extension.
+type Release struct {
+	Format string `json:"format"`
+
+	// Tool is the gobfuscate which made the build, and
+	// GoVersion is the go command it built with.
+	Tool      ToolInfo `json:"gobfuscate"`
+	GoVersion string   `json:"go_version"`
+
+	// Packages are the original import paths of the main
+	// packages which were built.
+	Packages []string `json:"packages"`
+
+	// SeedID identifies the -seed of a reproducible build,
+	// without revealing it.
+	SeedID string `json:"seed_id,omitempty"`
+
+	// Flags are the flags which were passed explicitly,
+	// other than the -seed and -padding.
+	Flags map[string]string `json:"flags"`
+
+	// Mapping is the mapping file of the build, if one was
+	// written.
+	Mapping *ReleaseFile `json:"mapping,omitempty"`
+
+	Artifacts []ReleaseArtifact `json:"artifacts"`
+
+	// PublicKey is the key which the descriptor can be
+	// verified with.
+	PublicKey []byte `json:"public_key"`
+}
+
+// A ReleaseFile is a file of a release, with its
+// checksum.
+type ReleaseFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// A ReleaseArtifact is a binary of a release.
+type ReleaseArtifact struct {
+	Package string `json:"package"`
+	GOOS    string `json:"goos"`
+	GOARCH  string `json:"goarch"`
+	ReleaseFile
+
+	// SBOM is the -sbom file of the binary, if one was
+	// written.
+	SBOM *ReleaseFile `json:"sbom,omitempty"`
+}
+
+// checkReleaseFlags reports -release flags which cannot be
+// used, and loads the signing key to catch problems with
+// it before building.
+func checkReleaseFlags() error {
+	if releasePath == "" {
+		return nil
+	}
+	if outputGopath || numVariants > 1 {
+		return flagError("The -release flag cannot be combined with -outdir or -variants.")
+	}
+	if _, err := loadReleaseKey(); err != nil {
+		return stepError("load release signing key", err)
+	}
+	return nil
+}
+
+// loadReleaseKey reads the key of -releasesign, or else
+// of -mapsign.
+func loadReleaseKey() (ed25519.PrivateKey, error) {
+	path := releaseSignPath
+	if path == "" {
+		path = mapSignPath
+	}
+	if path == "" {
+		return nil, errors.New("-release requires -releasesign or -mapsign")
+	}
+	return readSigningKey(path)
+}
+
+// WriteRelease writes the signed release descriptor of the
+// workspace's binaries, with the mapping file at mapFile,
+// if there is one.
+func (w *Workspace) WriteRelease(mapFile string) error {
+	key, err := loadReleaseKey()
+	if err != nil {
+		return stepError("load release signing key", err)
+	}
+	release := &Release{
+		Format:    releaseFormat,
+		Tool:      currentTool(),
+		Packages:  append([]string{w.PkgName}, w.ExtraPkgs...),
+		Flags:     releaseFlags(),
+		Artifacts: []ReleaseArtifact{},
+		PublicKey: key.Public().(ed25519.PublicKey),
+	}
+	if release.GoVersion, err = goVersion(); err != nil {
+		return stepError("get Go version", err)
+	}
+	if seed != "" {
+		release.SeedID = seedHex("id")[:16]
+	}
+	if mapFile != "" {
+		if release.Mapping, err = releaseFile(mapFile); err != nil {
+			return stepError("hash mapping file", err)
+		}
+	} else {
+		log.Println("Warning: the release has no mapping file (use -map to write one)")
+	}
+	for _, artifact := range w.Artifacts {
+		file, err := releaseFile(artifact.Path)
+		if err != nil {
+			return stepError("hash binary", err)
+		}
+		releaseArtifact := ReleaseArtifact{
+			Package:     artifact.Package,
+			GOOS:        artifact.GOOS,
+			GOARCH:      artifact.GOARCH,
+			ReleaseFile: *file,
+		}
+		if sbomFormat != "" {
+			if releaseArtifact.SBOM, err = releaseFile(sbomPath(artifact.Path)); err != nil {
+				return stepError("hash SBOM", err)
+			}
+		}
+		release.Artifacts = append(release.Artifacts, releaseArtifact)
+	}
+
+	data, err := json.MarshalIndent(release, "", "  ")
+	if err != nil {
+		return stepError("encode release descriptor", err)
+	}
+	data = append(data, '\n')
+	if err := ioutil.WriteFile(releasePath, data, 0644); err != nil {
+		return stepError("write release descriptor", err)
+	}
+	if err := ioutil.WriteFile(releasePath+".sig", ed25519.Sign(key, data), 0644); err != nil {
+		return stepError("write release signature", err)
+	}
+	return nil
+}
+
+// releaseFlags lists the flags which were passed
+// explicitly. The seed and padding are left out, since
+// they are enough to undo the renaming.
+func releaseFlags() map[string]string {
+	res := map[string]string{}
+	runFlags.Visit(func(f *flag.Flag) {
+		if f.Name != "seed" && f.Name != "padding" {
+			res[f.Name] = f.Value.String()
+		}
+	})
+	return res
+}
+
+// releaseFile hashes a file of a release.
+func releaseFile(path string) (*ReleaseFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	return &ReleaseFile{Path: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
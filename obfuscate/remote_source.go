@@ -0,0 +1,297 @@
+package obfuscate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A sourceCheckout is source code which did not exist on
+// the machine before the run: a package fetched from a
+// remote reference given as the package argument, either a
+// module query like "github.com/org/repo/cmd/tool@v1.4.2"
+// or a git URL and commit like
+// "https://github.com/org/repo.git//cmd/tool#4f1c2e9", or a
+// module unpacked from a -src archive.
+//
+// It is checked out into a temporary GOPATH, which is
+// added to the GOPATH for the run, and the run finds its
+// packages in the checkout's directory, so that it is
+// obfuscated like a package of a local checkout.
+type sourceCheckout struct {
+	PkgName string
+
+	gopath    string
+	oldDir    string
+	oldGopath string
+}
+
+// checkoutGopath is the GOPATH of a sourceCheckout, which
+// sourceContext puts at the front of the GOPATH.
+var checkoutGopath string
+
+// remoteRevision is the commit of the git reference, or
+// the version of the module query, which the remote package
+// was fetched at.
+var remoteRevision string
+
+// isRemoteRef checks if a package argument is a remote
+// reference. Import paths never contain "@" or "#".
+func isRemoteRef(arg string) bool {
+	return strings.ContainsAny(arg, "@#") || strings.Contains(arg, "://")
+}
+
+// isGitRef checks if a remote reference is a git URL,
+// like "https://host/repo.git#commit" or
+// "git@host:repo.git#commit", rather than a module query.
+func isGitRef(arg string) bool {
+	return strings.ContainsAny(arg, "#:")
+}
+
+// fetchRemote fetches the package of a remote reference,
+// with the -prepenv variables, which may set GOPROXY or
+// GOPRIVATE.
+func fetchRemote(ref string) (*sourceCheckout, error) {
+	prepEnv, err := prepareEnv()
+	if err != nil {
+		return nil, flagError("Invalid preparation environment: " + err.Error())
+	}
+	gopath, err := newTempDir("remote")
+	if err != nil {
+		return nil, stepError("create temp dir", err)
+	}
+	var pkgName, dir string
+	err = withEnv(prepEnv, func() error {
+		var err error
+		if isGitRef(ref) {
+			pkgName, dir, err = fetchGit(ref, gopath)
+		} else {
+			pkgName, dir, err = fetchModule(ref, gopath)
+		}
+		return err
+	})
+	if err != nil {
+		os.RemoveAll(gopath)
+		return nil, stepError("fetch remote package", err)
+	}
+	return enterCheckout(gopath, dir, pkgName)
+}
+
+// enterCheckout makes the run find its packages in a
+// checkout at dir, in a temporary GOPATH.
+// Checkouts with a go.mod file are built with -modules.
+func enterCheckout(gopath, dir, pkgName string) (*sourceCheckout, error) {
+	res := &sourceCheckout{PkgName: pkgName, gopath: gopath, oldDir: sourceDir, oldGopath: checkoutGopath}
+	sourceDir, checkoutGopath = dir, gopath
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !useModules {
+		log.Println("Using modules for", pkgName)
+		useModules = true
+	}
+	return res, nil
+}
+
+// Close leaves and removes the checkout.
+func (r *sourceCheckout) Close() {
+	sourceDir, checkoutGopath = r.oldDir, r.oldGopath
+	os.RemoveAll(r.gopath)
+}
+
+// fetchGit clones a repository into a GOPATH at the path
+// which the URL implies, or that of the module it holds,
+// and checks out a commit, tag, or branch.
+// It returns the import path of the package and the root
+// of the checkout.
+func fetchGit(ref, gopath string) (string, string, error) {
+	hash := strings.LastIndex(ref, "#")
+	if hash < 0 || hash == len(ref)-1 {
+		return "", "", errors.New("git references need a commit, as url#commit")
+	}
+	url, rev := ref[:hash], ref[hash+1:]
+	// git would take a revision like "--upload-pack=..." for
+	// an option, and unlike URLs, revisions cannot be put
+	// after "--" for checkout.
+	if strings.HasPrefix(rev, "-") {
+		return "", "", errors.New("invalid git revision: " + rev)
+	}
+	// A package below the root of the repository is given
+	// after a double slash.
+	var subdir string
+	var schemeEnd int
+	if idx := strings.Index(url, "://"); idx >= 0 {
+		schemeEnd = idx + 3
+	}
+	if idx := strings.Index(url[schemeEnd:], "//"); idx >= 0 {
+		url, subdir = url[:schemeEnd+idx], strings.Trim(url[schemeEnd+idx+2:], "/")
+		if err := checkModulePath(subdir); err != nil {
+			return "", "", errors.New("invalid package directory: " + subdir)
+		}
+	}
+
+	tmpDir := filepath.Join(gopath, "clone")
+	log.Println("Cloning", url+"...")
+	if err := runGit("", "clone", "--quiet", "--no-checkout", "--", url, tmpDir); err != nil {
+		return "", "", err
+	}
+	if err := runGit(tmpDir, "checkout", "--quiet", rev); err != nil {
+		return "", "", err
+	}
+	commit, err := exec.Command("git", "-C", tmpDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("git rev-parse: %s", err)
+	}
+	remoteRevision = strings.TrimSpace(string(commit))
+	log.Println("Checked out", remoteRevision)
+	os.RemoveAll(filepath.Join(tmpDir, ".git"))
+
+	root := gitImportPath(url)
+	if _, err := os.Stat(filepath.Join(tmpDir, "go.mod")); err == nil {
+		if root, err = readModulePath(filepath.Join(tmpDir, "go.mod")); err != nil {
+			return "", "", err
+		}
+	}
+	if err := checkModulePath(root); err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(root))
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return "", "", err
+	}
+	return path.Join(root, subdir), dir, nil
+}
+
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %s", args[0], err)
+	}
+	return nil
+}
+
+// gitImportPath derives an import path from a git URL,
+// like "github.com/org/repo" from
+// "git@github.com:org/repo.git".
+func gitImportPath(url string) string {
+	if idx := strings.Index(url, "://"); idx >= 0 {
+		url = url[idx+3:]
+	} else {
+		url = strings.Replace(url, ":", "/", 1)
+	}
+	if idx := strings.Index(url, "@"); idx >= 0 && idx < strings.Index(url, "/") {
+		url = url[idx+1:]
+	}
+	if slash := strings.Index(url, "/"); slash >= 0 {
+		// A port is not part of the import path.
+		if colon := strings.Index(url[:slash], ":"); colon >= 0 {
+			url = url[:colon] + url[slash:]
+		}
+	}
+	return strings.TrimSuffix(strings.Trim(url, "/"), ".git")
+}
+
+// fetchModule downloads the module of a query like
+// "github.com/org/repo/cmd/tool@v1.4.2" with the go command,
+// and copies it into a GOPATH.
+// It returns the import path of the package and the root
+// of the copy.
+func fetchModule(ref, gopath string) (string, string, error) {
+	at := strings.LastIndex(ref, "@")
+	pkgName := ref[:at]
+	if strings.HasPrefix(ref, "-") {
+		return "", "", errors.New("invalid module query: " + ref)
+	}
+	log.Println("Downloading", ref+"...")
+
+	tmpModule, err := newFetchModule(gopath)
+	if err != nil {
+		return "", "", err
+	}
+	if err := goGet(tmpModule, ref); err != nil {
+		return "", "", err
+	}
+	modPath, version, modDir, err := listModule(tmpModule, pkgName, stderr)
+	if err != nil {
+		return "", "", err
+	}
+	remoteRevision = version
+	log.Println("Using", modPath, version)
+
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(modPath))
+	if err := copyTree(modDir, dir); err != nil {
+		return "", "", err
+	}
+	// Modules from before go.mod files are downloaded
+	// without one.
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); os.IsNotExist(err) {
+		goMod := []byte("module " + modPath + "\n")
+		if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), goMod, 0644); err != nil {
+			return "", "", err
+		}
+	}
+	return pkgName, dir, nil
+}
+
+// newFetchModule creates an empty module in a GOPATH, in
+// which goGet and listModule resolve packages, since the
+// go command only resolves packages to modules from within
+// a module.
+func newFetchModule(gopath string) (string, error) {
+	tmpModule := filepath.Join(gopath, "fetch")
+	if err := os.Mkdir(tmpModule, 0755); err != nil {
+		return "", err
+	}
+	goMod := []byte("module gobfuscate-fetch\n")
+	if err := ioutil.WriteFile(filepath.Join(tmpModule, "go.mod"), goMod, 0644); err != nil {
+		return "", err
+	}
+	return tmpModule, nil
+}
+
+func fetchEnv() []string {
+	return append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod", "GOWORK=off")
+}
+
+// goGet adds the module of a query like "path@version" to
+// a module made by newFetchModule, downloading it.
+func goGet(tmpModule, ref string) error {
+	get := exec.Command("go", "get", ref)
+	get.Dir = tmpModule
+	get.Env = fetchEnv()
+	get.Stdout = os.Stdout
+	get.Stderr = stderr
+	if err := get.Run(); err != nil {
+		return fmt.Errorf("go get: %s", err)
+	}
+	return nil
+}
+
+// listModule finds the path, version, and directory of the
+// module which provides a package to a module made by
+// newFetchModule, writing the errors of go list to errOut.
+func listModule(tmpModule, pkgName string, errOut io.Writer) (string, string, string, error) {
+	list := exec.Command("go", "list", "-f", "{{.Module.Path}}\n{{.Module.Version}}\n{{.Module.Dir}}", pkgName)
+	list.Dir = tmpModule
+	list.Env = fetchEnv()
+	list.Stderr = errOut
+	output, err := list.Output()
+	if err != nil {
+		return "", "", "", fmt.Errorf("go list: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 3 {
+		return "", "", "", fmt.Errorf("unexpected go list output: %s", output)
+	}
+	return lines[0], lines[1], lines[2], nil
+}
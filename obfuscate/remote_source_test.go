@@ -0,0 +1,122 @@
+package obfuscate
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitImportPath(t *testing.T) {
+	for url, expected := range map[string]string{
+		"https://github.com/org/repo.git":        "github.com/org/repo",
+		"https://github.com/org/repo":            "github.com/org/repo",
+		"git@github.com:org/repo.git":            "github.com/org/repo",
+		"ssh://git@git.example.com:2222/a/b.git": "git.example.com/a/b",
+		"https://user@example.com/repo/":         "example.com/repo",
+	} {
+		if actual := gitImportPath(url); actual != expected {
+			t.Errorf("%s: expected %s, got %s", url, expected, actual)
+		}
+	}
+}
+
+func TestFetchRejectsOptions(t *testing.T) {
+	gopath := t.TempDir()
+	for _, ref := range []string{
+		"https://github.com/org/repo.git#--upload-pack=touch /tmp/pwned",
+		"https://github.com/org/repo.git#-b",
+	} {
+		_, _, err := fetchGit(ref, gopath)
+		if err == nil || !strings.Contains(err.Error(), "invalid git revision") {
+			t.Errorf("%s: expected invalid revision, got %v", ref, err)
+		}
+	}
+	if _, _, err := fetchModule("-modfile=x@v1.0.0", gopath); err == nil ||
+		!strings.Contains(err.Error(), "invalid module query") {
+		t.Errorf("expected invalid module query, got %v", err)
+	}
+}
+
+func TestFetchGitRejectsPaths(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git command not found")
+	}
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	if err := os.Mkdir(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "go.mod"), []byte("module ../../escaped\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "go.mod"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s\n%s", args[0], err, output)
+		}
+	}
+
+	gopath := filepath.Join(dir, "a", "gopath")
+	if err := os.MkdirAll(gopath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"file://" + repo + "#HEAD", "file://" + repo + "//../..#HEAD"} {
+		_, _, err := fetchGit(ref, gopath)
+		if err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("%s: expected an invalid path, got %v", ref, err)
+		}
+		os.RemoveAll(filepath.Join(gopath, "clone"))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Error("the checkout was moved out of the GOPATH")
+	}
+}
+
+func TestEnterCheckout(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/tool/main.go": "package main\n\nfunc main() {}\n",
+	})
+	dir := filepath.Join(gopath, "src", "example.com", "tool")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldGopath := build.Default.GOPATH
+
+	checkout, err := enterCheckout(gopath, dir, "example.com/tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newWd, _ := os.Getwd(); newWd != wd {
+		t.Errorf("the working directory changed to %s", newWd)
+	}
+	if build.Default.GOPATH != oldGopath {
+		t.Errorf("build.Default.GOPATH changed to %s", build.Default.GOPATH)
+	}
+	if dir, _ := currentDir(); dir != filepath.Join(gopath, "src", "example.com", "tool") {
+		t.Errorf("the run works in %s", dir)
+	}
+	ctx := sourceContext()
+	if pkg, err := ctx.Import("example.com/tool", "", build.FindOnly); err != nil {
+		t.Error(err)
+	} else if pkg.Dir != dir {
+		t.Errorf("the package was found in %s", pkg.Dir)
+	}
+
+	checkout.Close()
+	if sourceDir != "" || checkoutGopath != "" {
+		t.Errorf("the checkout was not left: %q, %q", sourceDir, checkoutGopath)
+	}
+	if _, err := os.Stat(gopath); !os.IsNotExist(err) {
+		t.Error("the checkout was not removed")
+	}
+}
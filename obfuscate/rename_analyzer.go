@@ -0,0 +1,200 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// RenameAnalyzer reports the identifiers of a package which
+// gobfuscate cannot safely rename, and why, without
+// obfuscating anything. Since it is an analysis.Analyzer,
+// it runs in drivers like multichecker and gopls, next to
+// other analyzers, which load the packages, and report and
+// suppress its diagnostics.
+//
+// Every diagnostic has a category, which tells what keeps
+// the names: "assembly", "cgo", "interface", "linkname", or
+// "reflection".
+var RenameAnalyzer = &analysis.Analyzer{
+	Name: "obfuscaterename",
+	Doc: "report identifiers which gobfuscate cannot safely rename\n\n" +
+		"Names which assembly or C code refers to, methods which implement interfaces of other packages, " +
+		"and names which are looked up by reflection keep their original names in obfuscated builds.",
+	Run: runRenameAnalyzer,
+}
+
+func runRenameAnalyzer(pass *analysis.Pass) (interface{}, error) {
+	if len(pass.Files) == 0 {
+		return nil, nil
+	}
+	for _, name := range pass.OtherFiles {
+		if filepath.Ext(name) == ".s" || isSwigFile(name) {
+			category := "assembly"
+			if isSwigFile(name) {
+				category = "cgo"
+			}
+			pass.Report(analysis.Diagnostic{
+				Pos:      pass.Files[0].Name.Pos(),
+				Category: category,
+				Message: fmt.Sprintf("the names of package %s cannot be safely renamed, since %s may refer to them",
+					pass.Pkg.Name(), filepath.Base(name)),
+			})
+			return nil, nil
+		}
+	}
+	usesCgo := false
+	for _, file := range pass.Files {
+		usesCgo = usesCgo || importsPath(file, "C")
+	}
+
+	errorType := types.Universe.Lookup("error").(*types.TypeName)
+	interfaces := map[string][]*types.TypeName{"Error": {errorType}}
+	seen := map[*types.Package]bool{pass.Pkg: true}
+	for _, imported := range pass.Pkg.Imports() {
+		addInterfaceMethods(imported, interfaces, seen)
+	}
+	for _, file := range pass.Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || IgnoreMethods[fn.Name.Name] {
+				continue
+			}
+			if fn.Recv == nil {
+				if usesCgo && isExportedToC(fn) {
+					pass.Report(analysis.Diagnostic{
+						Pos:      fn.Name.Pos(),
+						Category: "cgo",
+						Message:  fn.Name.Name + " cannot be safely renamed, since C code calls it by name (//export)",
+					})
+				}
+				continue
+			}
+			method := receiverTypeName(fn.Recv.List[0]) + "." + fn.Name.Name
+			if iface := implementedInterface(pass, fn, interfaces[fn.Name.Name]); iface != nil {
+				ifaceName := iface.Name()
+				if iface.Pkg() != nil {
+					ifaceName = iface.Pkg().Path() + "." + ifaceName
+				}
+				pass.Report(analysis.Diagnostic{
+					Pos:      fn.Name.Pos(),
+					Category: "interface",
+					Message:  fmt.Sprintf("%s cannot be safely renamed, since it implements %s", method, ifaceName),
+				})
+			} else if usesCgo && fn.Name.IsExported() {
+				pass.Report(analysis.Diagnostic{
+					Pos:      fn.Name.Pos(),
+					Category: "cgo",
+					Message: method + " cannot be safely renamed, since its package uses cgo, " +
+						"and it may implement an interface of another package",
+				})
+			}
+		}
+		reportLinknames(pass, file)
+		reportReflectiveUses(pass, file)
+	}
+	return nil, nil
+}
+
+// addInterfaceMethods maps the names of the methods of the
+// interfaces declared by a package and its dependencies to
+// the interfaces which have them, like io.Writer.
+func addInterfaceMethods(pkg *types.Package, res map[string][]*types.TypeName, seen map[*types.Package]bool) {
+	if seen[pkg] {
+		return
+	}
+	seen[pkg] = true
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		iface, ok := obj.Type().Underlying().(*types.Interface)
+		if named, isNamed := obj.Type().(*types.Named); !ok || (isNamed && named.TypeParams().Len() > 0) {
+			continue
+		}
+		for i := 0; i < iface.NumMethods(); i++ {
+			res[iface.Method(i).Name()] = append(res[iface.Method(i).Name()], obj)
+		}
+	}
+	for _, imported := range pkg.Imports() {
+		addInterfaceMethods(imported, res, seen)
+	}
+}
+
+// implementedInterface finds the interface, among those of
+// other packages which have a method by the name of fn,
+// which fn's receiver type implements, if there is one.
+// Methods which implement an interface of their own package
+// are renamed along with it.
+func implementedInterface(pass *analysis.Pass, fn *ast.FuncDecl, ifaces []*types.TypeName) *types.TypeName {
+	method, ok := pass.TypesInfo.Defs[fn.Name].(*types.Func)
+	if !ok {
+		return nil
+	}
+	recv := embeddedTypeName(method.Type().(*types.Signature).Recv().Type())
+	if recv == nil {
+		return nil
+	}
+	t := types.NewPointer(recv.Type())
+	for _, iface := range ifaces {
+		if types.Implements(t, iface.Type().Underlying().(*types.Interface)) {
+			return iface
+		}
+	}
+	return nil
+}
+
+// reportLinknames reports the names which //go:linkname
+// directives refer to.
+func reportLinknames(pass *analysis.Pass, file *ast.File) {
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if !strings.HasPrefix(comment.Text, linknameDirective) {
+				continue
+			}
+			fields := strings.Fields(comment.Text[len(linknameDirective):])
+			if len(fields) == 0 {
+				continue
+			}
+			pass.Report(analysis.Diagnostic{
+				Pos:      comment.Pos(),
+				Category: "linkname",
+				Message:  fields[0] + " cannot be safely renamed, since a //go:linkname directive refers to it by name",
+			})
+		}
+	}
+}
+
+// reportReflectiveUses reports the names which the calls of
+// a file look up at run time, like findReflectiveUses.
+func reportReflectiveUses(pass *analysis.Pass, file *ast.File) {
+	finder := &reflectionFinder{
+		pkg:      pass.Pkg.Path(),
+		original: pass.Pkg.Path(),
+		set:      pass.Fset,
+		info:     pass.TypesInfo,
+		file:     file,
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		use := finder.Call(call)
+		if use == nil {
+			return true
+		}
+		message := use.Problem
+		if message == "" {
+			message = use.Names() + " cannot be safely renamed, since the names are looked up at run time"
+		}
+		pass.Report(analysis.Diagnostic{Pos: call.Pos(), Category: "reflection", Message: message})
+		return true
+	})
+}
@@ -0,0 +1,85 @@
+package obfuscate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// A Report describes what an obfuscation run deliberately
+// left readable in its output.
+type Report struct {
+	// Tool is the gobfuscate which made the run.
+	Tool ToolInfo `json:"gobfuscate"`
+
+	// ReflectiveSurface lists the symbols which kept their
+	// names so that they can be found with reflection.
+	ReflectiveSurface []ReflectiveSymbol `json:"reflective_surface"`
+
+	// Strings tells which string literals were left in
+	// plaintext, and why.
+	Strings *StringCoverage `json:"strings"`
+
+	// Secrets lists the possible hardcoded secrets which
+	// were found before obfuscating.
+	Secrets []SecretFinding `json:"secrets"`
+
+	// Bridges lists the references between symbols which
+	// kept their names and renamed ones.
+	Bridges []Bridge `json:"bridges"`
+
+	// Artifacts lists the binaries which were built.
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// An Artifact is a built binary.
+type Artifact struct {
+	// Package is the original import path of the main
+	// package.
+	Package string `json:"package"`
+
+	GOOS   string `json:"goos"`
+	GOARCH string `json:"goarch"`
+	Path   string `json:"path"`
+
+	// FIPS describes the verified FIPS 140 cryptography of
+	// the binary, if it was built with -fips.
+	FIPS string `json:"fips,omitempty"`
+
+	// SymbolLeaks are the function symbols of the binary,
+	// including compiler-generated wrappers, which still
+	// contain original names.
+	SymbolLeaks []string `json:"symbol_leaks,omitempty"`
+
+	// PathLeaks are the directories of the build machine,
+	// like the home directory or GOROOT, whose paths are
+	// still in the binary despite -trimpath.
+	PathLeaks []string `json:"path_leaks,omitempty"`
+
+	// UnpackedSize is the size of the binary before it was
+	// packed with -pack.
+	UnpackedSize int64 `json:"unpacked_size,omitempty"`
+}
+
+// A ReflectiveSymbol is a symbol which kept its original
+// name.
+type ReflectiveSymbol struct {
+	// Symbol is the original name, e.g. "pkg/path.Type.Method".
+	Symbol string `json:"symbol"`
+
+	// Package is the import path of the symbol's package
+	// in the obfuscated code.
+	Package string `json:"package"`
+
+	// Kind is "type", "method", "field", or "missing" if
+	// the symbol was not found.
+	Kind string `json:"kind"`
+}
+
+// Write saves the report as JSON.
+func (r *Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,80 @@
+package obfuscate
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// bufferPool holds the buffers used to rewrite files, so
+// that every file does not need fresh allocations.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// writerPool holds the buffered writers used to flush
+// rewritten files to disk.
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, 64<<10)
+	},
+}
+
+// getBuffer gets an empty buffer from bufferPool.
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns a buffer to bufferPool.
+// Very large buffers are dropped, so that one huge file
+// does not keep its memory alive for the rest of the run.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= 4<<20 {
+		bufferPool.Put(buf)
+	}
+}
+
+// rewriteFile replaces a file with the output of write,
+// which is streamed to a temporary file in the same
+// directory and then moved over the original.
+// The file keeps its permissions.
+func rewriteFile(path string, write func(w *bufio.Writer) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// The go command ignores files starting with ".", so
+	// the temporary file cannot affect other passes.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".rewrite-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := writerPool.Get().(*bufio.Writer)
+	w.Reset(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	w.Reset(nil)
+	writerPool.Put(w)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,336 @@
+package obfuscate
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stderr is where the command writes errors and usage
+// messages.
+var stderr io.Writer = os.Stderr
+
+// Options configure Run. Each field is the command line
+// flag named in its comment, which is documented in the
+// README. Fields left at their zero values leave the flags
+// at their defaults.
+type Options struct {
+	// Dir is the directory in which packages are found,
+	// like the current directory of the command: the module
+	// of Modules is the one in Dir, and package patterns
+	// like "./cmd/tool" are relative to it. It defaults to
+	// the current directory. Relative paths of files, like
+	// OutPath, are still relative to the current directory.
+	Dir string
+
+	// PkgName is the import path of the package to build,
+	// and OutPath is the path of the binary (or of the
+	// GOPATH, with OutputGopath). PkgName may list several
+	// main packages, separated by commas, which are built
+	// into the OutPath directory.
+	PkgName string
+	OutPath string
+
+	// Settings and randomness.
+	Padding    string // -padding
+	Seed       string // -seed
+	Profile    string // -profile
+	ConfigPath string // -config
+
+	// Sources and workspace.
+	SourceArchive string        // -src
+	Modules       bool          // -modules
+	PrepEnv       []string      // -prepenv
+	KeepTests     bool          // -keeptests
+	Exclude       []string      // -exclude
+	OutputGopath  bool          // -outdir
+	DryRun        bool          // -dryrun
+	DiffPath      string        // -diff
+	WorkDir       string        // -workdir
+	KeepWork      bool          // -keepwork
+	NoDiskCheck   bool          // -nodiskcheck
+	CopyTimeout   time.Duration // -copytimeout
+	DepCache      string        // -depcache
+	BuildCache    string        // -buildcache
+	Resume        string        // -resume
+	Incremental   string        // -incremental
+
+	// Names and packages.
+	Naming       string   // -naming
+	Dictionary   string   // -dictionary
+	PathStyle    string   // -pathstyle
+	NoEncrypt    bool     // -noencrypt
+	RandomRoot   bool     // -randomroot
+	Flatten      int      // -flatten
+	Internal     string   // -internal
+	Merge        bool     // -merge
+	RenameFiles  bool     // -renamefiles
+	Keep         []string // -keep
+	ReflectNames string   // -reflectnames
+	ExportNames  []string // -exportname
+
+	// Code and strings.
+	InlineConsts  bool     // -inlineconsts
+	Literals      bool     // -literals
+	LazyStrings   string   // -lazystrings
+	Strings       string   // -strings
+	CheckStrings  bool     // -checkstrings
+	HTTPStrings   bool     // -httpstrings
+	Embeds        bool     // -embeds
+	ErrorCodes    bool     // -errorcodes
+	Junk          bool     // -junk
+	StripLog      []string // -striplog
+	FlagKeys      []string // -flagkeys
+	PruneTypes    bool     // -prunetypes
+	ReflectSafety string   // -reflectsafety
+	Secrets       string   // -secrets
+
+	// Building. GOOS and GOARCH list the targets to build,
+	// which default to the host.
+	GOOS            []string // -goos
+	GOARCH          []string // -goarch
+	Tags            string   // -tags
+	StaticTags      string   // -statictags
+	BuildMode       string   // -buildmode
+	NoStatic        bool     // -nostatic
+	NoTrimPath      bool     // -trimpath=false
+	WinHide         bool     // -winhide
+	PGO             string   // -pgo
+	FIPS            string   // -fips
+	Variants        int      // -variants
+	Jobs            int      // -jobs
+	BuildJobs       int      // -buildjobs
+	KeepBuildInfo   bool     // -keepbuildinfo
+	StripNotes      bool     // -stripnotes
+	StripGNUVersion bool     // -stripgnuversion
+	Pack            string   // -pack
+	PackFlags       string   // -packflags
+
+	// Checks of the binaries.
+	Blocklist string   // -blocklist
+	YARA      []string // -yara
+	ScanCmd   []string // -scancmd
+
+	// Outputs besides the binaries.
+	MapPath           string // -map
+	MapKey            string // -mapkey
+	MapSign           string // -mapsign
+	ReportPath        string // -report
+	FuncList          string // -funcs
+	ManifestPath      string // -manifest
+	ReleasePath       string // -release
+	ReleaseSign       string // -releasesign
+	ProvenancePath    string // -provenance
+	ProvenanceBuilder string // -provenancebuilder
+	SBOM              string // -sbom
+	MetricsPath       string // -metrics
+	CPUProfile        string // -cpuprofile
+	Verbose           bool   // -verbose
+}
+
+// sourceDir is the directory in which packages are found,
+// and the go commands which find them are run: the Dir of
+// Options, or the checkout of a remote package or source
+// archive. It is the current directory if it is empty.
+var sourceDir string
+
+// currentDir gets sourceDir, or else the current
+// directory.
+func currentDir() (string, error) {
+	if sourceDir != "" {
+		return sourceDir, nil
+	}
+	return os.Getwd()
+}
+
+// sourceCommand is like exec.Command, but runs the command
+// in sourceDir.
+func sourceCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = sourceDir
+	return cmd
+}
+
+// runLock serializes runs, since flags and passes keep
+// their state in package variables.
+var runLock sync.Mutex
+
+// Run obfuscates and builds a package, like the gobfuscate
+// command without a subcommand. Progress is logged with
+// the log package.
+//
+// Invalid options are reported as a *FlagError, failed
+// steps as a *StepError, the problems found by checks as
+// a *CheckError, and the failure of a build for one
+// target as a *TargetError wrapping one of these.
+//
+// Runs are serialized, so concurrent calls wait for one
+// another.
+func Run(opts Options) error {
+	runLock.Lock()
+	defer runLock.Unlock()
+
+	resetState()
+	if opts.Dir != "" {
+		dir, err := filepath.Abs(opts.Dir)
+		if err != nil {
+			return flagError("Invalid directory: " + err.Error())
+		}
+		sourceDir = dir
+	}
+	fs := flag.NewFlagSet("gobfuscate", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	registerFlags(fs)
+	runFlags = fs
+	defer func() {
+		runFlags = flag.CommandLine
+	}()
+
+	if err := fs.Parse(append(opts.args(), opts.PkgName, opts.OutPath)); err != nil {
+		return flagError(err.Error())
+	} else if fs.NArg() != 2 {
+		return flagError("Unexpected arguments: " + strings.Join(fs.Args(), " "))
+	}
+	return runCommand(fs.Arg(0), fs.Arg(1), false)
+}
+
+// args lists the flags which Options set.
+func (o *Options) args() []string {
+	var res []string
+	addString := func(name, value string) {
+		if value != "" {
+			res = append(res, "-"+name+"="+value)
+		}
+	}
+	addBool := func(name string, value bool) {
+		if value {
+			res = append(res, "-"+name)
+		}
+	}
+	addInt := func(name string, value int) {
+		if value != 0 {
+			res = append(res, "-"+name+"="+strconv.Itoa(value))
+		}
+	}
+	addList := func(name string, values []string) {
+		for _, value := range values {
+			res = append(res, "-"+name+"="+value)
+		}
+	}
+
+	addString("padding", o.Padding)
+	addString("seed", o.Seed)
+	addString("profile", o.Profile)
+	addString("config", o.ConfigPath)
+
+	addString("src", o.SourceArchive)
+	addBool("modules", o.Modules)
+	addList("prepenv", o.PrepEnv)
+	addBool("keeptests", o.KeepTests)
+	addList("exclude", o.Exclude)
+	addBool("outdir", o.OutputGopath)
+	addBool("dryrun", o.DryRun)
+	addString("diff", o.DiffPath)
+	addString("workdir", o.WorkDir)
+	addBool("keepwork", o.KeepWork)
+	addBool("nodiskcheck", o.NoDiskCheck)
+	if o.CopyTimeout != 0 {
+		res = append(res, "-copytimeout="+o.CopyTimeout.String())
+	}
+	addString("depcache", o.DepCache)
+	addString("buildcache", o.BuildCache)
+	addString("resume", o.Resume)
+	addString("incremental", o.Incremental)
+
+	addString("naming", o.Naming)
+	addString("dictionary", o.Dictionary)
+	addString("pathstyle", o.PathStyle)
+	addBool("noencrypt", o.NoEncrypt)
+	addBool("randomroot", o.RandomRoot)
+	addInt("flatten", o.Flatten)
+	addString("internal", o.Internal)
+	addBool("merge", o.Merge)
+	addBool("renamefiles", o.RenameFiles)
+	addList("keep", o.Keep)
+	addString("reflectnames", o.ReflectNames)
+	addList("exportname", o.ExportNames)
+
+	addBool("inlineconsts", o.InlineConsts)
+	addBool("literals", o.Literals)
+	addString("lazystrings", o.LazyStrings)
+	addString("strings", o.Strings)
+	addBool("checkstrings", o.CheckStrings)
+	addBool("httpstrings", o.HTTPStrings)
+	addBool("embeds", o.Embeds)
+	addBool("errorcodes", o.ErrorCodes)
+	addBool("junk", o.Junk)
+	addList("striplog", o.StripLog)
+	addList("flagkeys", o.FlagKeys)
+	addBool("prunetypes", o.PruneTypes)
+	addString("reflectsafety", o.ReflectSafety)
+	addString("secrets", o.Secrets)
+
+	addString("goos", strings.Join(o.GOOS, " "))
+	addString("goarch", strings.Join(o.GOARCH, " "))
+	addString("tags", o.Tags)
+	addString("statictags", o.StaticTags)
+	addString("buildmode", o.BuildMode)
+	addBool("nostatic", o.NoStatic)
+	if o.NoTrimPath {
+		res = append(res, "-trimpath=false")
+	}
+	addBool("winhide", o.WinHide)
+	addString("pgo", o.PGO)
+	addString("fips", o.FIPS)
+	addInt("variants", o.Variants)
+	addInt("jobs", o.Jobs)
+	addInt("buildjobs", o.BuildJobs)
+	addBool("keepbuildinfo", o.KeepBuildInfo)
+	addBool("stripnotes", o.StripNotes)
+	addBool("stripgnuversion", o.StripGNUVersion)
+	addString("pack", o.Pack)
+	addString("packflags", o.PackFlags)
+
+	addString("blocklist", o.Blocklist)
+	addList("yara", o.YARA)
+	addList("scancmd", o.ScanCmd)
+
+	addString("map", o.MapPath)
+	addString("mapkey", o.MapKey)
+	addString("mapsign", o.MapSign)
+	addString("report", o.ReportPath)
+	addString("funcs", o.FuncList)
+	addString("manifest", o.ManifestPath)
+	addString("release", o.ReleasePath)
+	addString("releasesign", o.ReleaseSign)
+	addString("provenance", o.ProvenancePath)
+	addString("provenancebuilder", o.ProvenanceBuilder)
+	addString("sbom", o.SBOM)
+	addString("metrics", o.MetricsPath)
+	addString("cpuprofile", o.CPUProfile)
+	addBool("verbose", o.Verbose)
+	return res
+}
+
+// resetState forgets what an earlier run left in package
+// variables besides the flags themselves, which
+// registerFlags resets. Lists of repeated flags are not
+// reset by defining them again.
+func resetState() {
+	stripLogs, excludeFlags, exportNameFlags, keepFlags = nil, nil, nil, nil
+	yaraRules, scanCommands, prepEnvFlags, flagKeyFuncs = nil, nil, nil, nil
+
+	config, cmdLineFlags = nil, nil
+	blocklist, logPatterns, excludedPaths, keepPatterns = nil, nil, nil, nil
+	dictionary, exportNames, extraPackages = nil, nil, nil
+	mainModulePath, remoteRevision, sourceDir, checkoutGopath = "", "", "", ""
+	moduleGoVersions = map[string]string{}
+	runMetrics = newMetrics()
+}
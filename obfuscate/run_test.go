@@ -0,0 +1,103 @@
+package obfuscate
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunModules(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"go.mod": "module example.com/greeter\n\ngo 1.16\n",
+		"loud/loud.go": "package loud\n\ntype GreetingMachine struct {\n\tName string\n}\n\n" +
+			"func (g GreetingMachine) ShoutLoudly() string {\n\treturn \"HELLO, \" + g.Name\n}\n",
+		"cmd/greet/main.go": "package main\n\nimport \"example.com/greeter/loud\"\n\n" +
+			"func main() {\n\tprintln(loud.GreetingMachine{Name: \"gopher\"}.ShoutLoudly())\n}\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "greet")
+	opts := Options{Dir: dir, PkgName: "./cmd/greet", OutPath: out, Modules: true, Padding: "test"}
+	if err := Run(opts); err != nil {
+		t.Fatal(err)
+	}
+	if newWd, err := os.Getwd(); err != nil || newWd != wd {
+		t.Errorf("Run changed the working directory to %s", newWd)
+	}
+	output, err := exec.Command(out).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s\n%s", err, output)
+	} else if string(output) != "HELLO, gopher\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"example.com/greeter", "GreetingMachine", "ShoutLoudly"} {
+		if bytes.Contains(data, []byte(name)) {
+			t.Errorf("binary contains %s", name)
+		}
+	}
+}
+
+func TestRunFlagErrors(t *testing.T) {
+	// Later tests expect the flags to have their defaults.
+	defer registerFlags(flag.NewFlagSet("defaults", flag.ContinueOnError))
+	out := filepath.Join(t.TempDir(), "out")
+	for _, opts := range []Options{
+		{Internal: "flatten"},
+		{Strings: "ephemeral", LazyStrings: "cache"},
+		{Merge: true, KeepTests: true},
+	} {
+		opts.PkgName = "example.com/missing"
+		opts.OutPath = out
+		opts.Modules = true
+		err := Run(opts)
+		if _, ok := err.(*FlagError); !ok {
+			t.Errorf("%v: expected a *FlagError, got %#v", opts.args(), err)
+		}
+	}
+	if flag.Lookup("padding") != nil {
+		t.Error("Run defined its flags on flag.CommandLine")
+	}
+	if runFlags != flag.CommandLine {
+		t.Error("Run did not restore the flag set of the command")
+	}
+}
+
+func TestOptionsArgs(t *testing.T) {
+	opts := Options{
+		Padding:     "pad",
+		Flatten:     3,
+		NoTrimPath:  true,
+		StripLog:    []string{"log.Printf", "corp.com/trace"},
+		GOOS:        []string{"linux", "windows"},
+		CopyTimeout: 90e9,
+	}
+	expected := "-padding=pad -copytimeout=1m30s -flatten=3 -striplog=log.Printf " +
+		"-striplog=corp.com/trace -goos=linux windows -trimpath=false"
+	if actual := strings.Join(opts.args(), " "); actual != expected {
+		t.Errorf("expected %q but got %q", expected, actual)
+	}
+}
@@ -0,0 +1,611 @@
+package obfuscate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The ways a stringHelper can encode strings.
+const (
+	schemeXOR = iota
+	schemeAdd
+	schemeStream
+	schemeRotate
+	numSchemes
+)
+
+// A stringHelper is the package which obfuscated strings
+// are decoded by at runtime.
+//
+// It is generated for every build, with a random import
+// path, and with names, an encoding, and a structure which
+// are picked from the padding, so that the decoding code
+// is not the same in every binary.
+type stringHelper struct {
+	// Path is the import path of the package, and Alias
+	// is the name it is imported as.
+	Path  string `json:"path"`
+	Alias string `json:"alias"`
+
+	// Decode is the exported function which decodes a
+	// string, and Step decodes a single byte, unless the
+	// loop does it inline.
+	Decode string   `json:"decode"`
+	Step   string   `json:"step"`
+	Locals []string `json:"locals"`
+
+	stringScheme
+
+	KeyFirst bool `json:"key_first"`
+	Reverse  bool `json:"reverse"`
+	Inline   bool `json:"inline"`
+
+	// Open is the exported function which decrypts the
+	// strings marked with //gobfuscate:encrypt, and Sealed
+	// is set once any string needs it, so that other
+	// binaries do not link the AES code.
+	Open   string `json:"open"`
+	Sealed bool   `json:"sealed"`
+
+	// DecodeBytes and OpenBytes are like Decode and Open,
+	// but return the bytes, which Wipe clears once cgo code
+	// has copied them. CStrings is set once any literal
+	// passed to C.CString needs them.
+	DecodeBytes string `json:"decode_bytes"`
+	OpenBytes   string `json:"open_bytes"`
+	Wipe        string `json:"wipe"`
+	CStrings    bool   `json:"cstrings"`
+
+	// Keys are the exported variables which numeric
+	// constants are masked with, and KeyValues are their
+	// values. Float32 and Float64 turn bits into floating
+	// point numbers. Numbers is set once any constant needs
+	// them.
+	Keys      []string `json:"keys"`
+	KeyValues []uint64 `json:"key_values"`
+	Float32   string   `json:"float32"`
+	Float64   string   `json:"float64"`
+	Numbers   bool     `json:"numbers"`
+
+	// Once is the type which lazily decoded strings are
+	// cached with. Lazy is set once any string needs it.
+	Once string `json:"once"`
+	Lazy bool   `json:"lazy"`
+
+	// OpenEmbed decrypts an embedded file, and EmbedFS is the
+	// type which embed.FS variables become, made with
+	// NewEmbedFS, whose files are EmbedFile. Embeds is set
+	// by -embeds.
+	OpenEmbed  string `json:"open_embed"`
+	EmbedFS    string `json:"embed_fs"`
+	NewEmbedFS string `json:"new_embed_fs"`
+	EmbedFile  string `json:"embed_file"`
+	Embeds     bool   `json:"embeds"`
+
+	// FlagKey is the exported function which decodes the
+	// key at an index of FlagTable, a table of FlagKeys, the
+	// feature flag and config keys of -flagkeys.
+	FlagKey   string   `json:"flag_key"`
+	FlagTable string   `json:"flag_table"`
+	FlagKeys  []string `json:"flag_keys"`
+
+	lock sync.Mutex
+}
+
+// A stringScheme is a way of encoding strings.
+type stringScheme struct {
+	Scheme int `json:"scheme"`
+
+	// Every byte is also masked with its index times Mul,
+	// plus Add, and Shift picks a byte of the stream in the
+	// stream scheme.
+	Mul   byte `json:"mul"`
+	Add   byte `json:"add"`
+	Shift uint `json:"shift"`
+}
+
+// newStringHelper picks the path and shape of the string
+// helper package of a GOPATH.
+// The same padding and sources give the same helper, so
+// that it matches cached dependencies.
+func newStringHelper(gopath string, n NameHasher) *stringHelper {
+	res := &stringHelper{
+		Decode: n.Hash("Helper#decode"),
+		Step:   n.Hash("helper#step"),
+		Alias:  n.Hash("helper#alias"),
+		stringScheme: stringScheme{
+			Scheme: n.Intn("helper#scheme", numSchemes),
+			Mul:    byte(n.Intn("helper#mul", 128)*2 + 1),
+			Add:    byte(n.Intn("helper#add", 256)),
+			Shift:  uint(n.Intn("helper#shift", 4) * 8),
+		},
+		KeyFirst: n.Intn("helper#keyfirst", 2) == 1,
+		Reverse:  n.Intn("helper#reverse", 2) == 1,
+		Inline:   n.Intn("helper#inline", 2) == 1,
+		Open:     n.Hash("Helper#open"),
+		Once:     n.Hash("Helper#once"),
+
+		DecodeBytes: n.Hash("Helper#decodebytes"),
+		OpenBytes:   n.Hash("Helper#openbytes"),
+		Wipe:        n.Hash("Helper#wipe"),
+
+		Float32: n.Hash("Helper#float32"),
+		Float64: n.Hash("Helper#float64"),
+
+		OpenEmbed:  n.Hash("Helper#openembed"),
+		EmbedFS:    n.Hash("Helper#embedfs"),
+		NewEmbedFS: n.Hash("Helper#newembedfs"),
+		EmbedFile:  n.Hash("helper#embedfile"),
+
+		FlagKey:   n.Hash("Helper#flagkey"),
+		FlagTable: n.Hash("helper#flagtable"),
+	}
+	if res.Scheme == schemeStream {
+		// The stream can only be generated forwards.
+		res.Reverse = false
+	}
+	for i := 0; i < 6; i++ {
+		res.Locals = append(res.Locals, n.Hash("helper#local"+strconv.Itoa(i)))
+	}
+	for i := 0; i < 3; i++ {
+		res.Keys = append(res.Keys, n.Hash("Helper#key"+strconv.Itoa(i)))
+		res.KeyValues = append(res.KeyValues, n.Uint64("helper#keyvalue"+strconv.Itoa(i)))
+	}
+
+	srcDir := filepath.Join(gopath, "src")
+	for attempt := 0; ; attempt++ {
+		var components []string
+		for level := 1; level <= 3; level++ {
+			token := "helper#path" + strconv.Itoa(level)
+			if pathStyle == "mimic" {
+				components = append(components, mimicComponent(n, token, level, attempt, level == 3))
+			} else {
+				components = append(components, n.Hash(token+"#"+strconv.Itoa(attempt)))
+			}
+		}
+		res.Path = path.Join(components...)
+		if _, err := os.Stat(filepath.Join(srcDir, components[0])); os.IsNotExist(err) {
+			return res
+		}
+	}
+}
+
+// Write generates the helper package in a GOPATH.
+func (s *stringHelper) Write(gopath string) error {
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(s.Path))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	source, err := format.Source(s.source())
+	if err != nil {
+		return fmt.Errorf("format helper: %s", err)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, path.Base(s.Path)+".go"), source, 0644)
+}
+
+func (s *stringHelper) source() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n\n", path.Base(s.Path))
+	s.lock.Lock()
+	sealed, cStrings, numbers, lazy, embeds := s.Sealed, s.CStrings, s.Numbers, s.Lazy, s.Embeds
+	flagKeys := s.FlagKeys
+	s.lock.Unlock()
+	if sealed || numbers || lazy || embeds {
+		buf.WriteString("import (\n")
+		if embeds {
+			buf.WriteString("\"bytes\"\n\"crypto/sha256\"\n\"embed\"\n\"io/fs\"\n")
+		}
+		if sealed || embeds {
+			buf.WriteString("\"crypto/aes\"\n\"crypto/cipher\"\n")
+		}
+		if numbers {
+			buf.WriteString("\"math\"\n")
+		}
+		if lazy {
+			buf.WriteString("\"sync\"\n")
+		}
+		buf.WriteString(")\n\n")
+	}
+
+	s.writeDecoder(&buf, s.Decode, true)
+	if !s.Inline {
+		c, k, i := s.Locals[4], s.Locals[5], s.Locals[3]
+		fmt.Fprintf(&buf, "\nfunc %s(%s, %s byte, %s int) byte {\n", s.Step, c, k, i)
+		fmt.Fprintf(&buf, "return %s\n}\n", s.decodeExpr(c, k, i))
+	}
+	if sealed {
+		s.writeOpener(&buf, s.Open, true)
+	}
+
+	if cStrings {
+		buf.WriteString("\n")
+		s.writeDecoder(&buf, s.DecodeBytes, false)
+		if sealed {
+			s.writeOpener(&buf, s.OpenBytes, false)
+		}
+		b, i := s.Locals[0], s.Locals[3]
+		fmt.Fprintf(&buf, "\nfunc %s(%s []byte) {\n", s.Wipe, b)
+		fmt.Fprintf(&buf, "for %s := range %s {\n%s[%s] = 0\n}\n}\n", i, b, b, i)
+	}
+
+	if numbers {
+		buf.WriteString("\nvar (\n")
+		for i, key := range s.Keys {
+			fmt.Fprintf(&buf, "%s uint64 = %#x\n", key, s.KeyValues[i])
+		}
+		buf.WriteString(")\n")
+		b := s.Locals[0]
+		fmt.Fprintf(&buf, "\nfunc %s(%s uint64) float32 {\nreturn math.Float32frombits(uint32(%s))\n}\n",
+			s.Float32, b, b)
+		fmt.Fprintf(&buf, "\nfunc %s(%s uint64) float64 {\nreturn math.Float64frombits(%s)\n}\n",
+			s.Float64, b, b)
+	}
+
+	if lazy {
+		fmt.Fprintf(&buf, "\ntype %s = sync.Once\n", s.Once)
+	}
+
+	if embeds {
+		s.writeEmbeds(&buf)
+	}
+
+	if len(flagKeys) > 0 {
+		s.writeFlagKeys(&buf, flagKeys)
+	}
+	return buf.Bytes()
+}
+
+// SetFlagKeys sets the keys of the table of -flagkeys.
+func (s *stringHelper) SetFlagKeys(keys []string) {
+	s.lock.Lock()
+	s.FlagKeys = keys
+	s.lock.Unlock()
+}
+
+// writeFlagKeys writes the table of feature flag and config
+// keys, whose entries decode one key each, and the function
+// which looks them up.
+func (s *stringHelper) writeFlagKeys(buf *bytes.Buffer, keys []string) {
+	r := newKeyRand("flagkeys", []byte(strings.Join(keys, "\x00")))
+	w := bufio.NewWriter(buf)
+	fmt.Fprintf(w, "\nvar %s = [...]func() string{\n", s.FlagTable)
+	for _, key := range keys {
+		data, k := s.encode(r, key)
+		if s.KeyFirst {
+			data, k = k, data
+		}
+		w.WriteString("func() string {\nreturn " + s.Decode + "(")
+		writeByteSlice(w, data)
+		w.WriteString(", ")
+		writeByteSlice(w, k)
+		w.WriteString(")\n},\n")
+	}
+	w.WriteString("}\n")
+	i := s.Locals[3]
+	fmt.Fprintf(w, "\nfunc %s(%s int) string {\nreturn %s[%s]()\n}\n", s.FlagKey, i, s.FlagTable, i)
+	w.Flush()
+}
+
+// writeEmbeds writes the code which decrypts embedded
+// files: a function like embedCrypt, and a wrapper of
+// embed.FS with the same methods, which decrypts the files
+// as they are read or opened.
+func (s *stringHelper) writeEmbeds(buf *bytes.Buffer) {
+	l := s.Locals
+	fmt.Fprintf(buf, "\nfunc %s(%s, %s []byte, %s string) []byte {\n", s.OpenEmbed, l[0], l[1], l[2])
+	fmt.Fprintf(buf, "%s := sha256.Sum256([]byte(%s))\n", l[3], l[2])
+	fmt.Fprintf(buf, "%s, _ := aes.NewCipher(%s)\n", l[4], l[1])
+	fmt.Fprintf(buf, "%s := make([]byte, len(%s))\n", l[5], l[0])
+	fmt.Fprintf(buf, "cipher.NewCTR(%s, %s[:aes.BlockSize]).XORKeyStream(%s, %s)\n", l[4], l[3], l[5], l[0])
+	fmt.Fprintf(buf, "return %s\n}\n", l[5])
+
+	fmt.Fprintf(buf, "\ntype %s struct {\n%s embed.FS\n%s []byte\n}\n", s.EmbedFS, l[0], l[1])
+	fmt.Fprintf(buf, "\nfunc %s(%s embed.FS, %s []byte) %s {\nreturn %s{%s, %s}\n}\n",
+		s.NewEmbedFS, l[0], l[1], s.EmbedFS, s.EmbedFS, l[0], l[1])
+	fmt.Fprintf(buf, "\nfunc (%s %s) ReadFile(%s string) ([]byte, error) {\n", l[2], s.EmbedFS, l[3])
+	fmt.Fprintf(buf, "%s, %s := %s.%s.ReadFile(%s)\n", l[4], l[5], l[2], l[0], l[3])
+	fmt.Fprintf(buf, "if %s != nil {\nreturn nil, %s\n}\n", l[5], l[5])
+	fmt.Fprintf(buf, "return %s(%s, %s.%s, %s), nil\n}\n", s.OpenEmbed, l[4], l[2], l[1], l[3])
+	fmt.Fprintf(buf, "\nfunc (%s %s) ReadDir(%s string) ([]fs.DirEntry, error) {\n", l[2], s.EmbedFS, l[3])
+	fmt.Fprintf(buf, "return %s.%s.ReadDir(%s)\n}\n", l[2], l[0], l[3])
+
+	// Directories are opened as they are, and files are
+	// decrypted into a reader which can seek, like the files
+	// of an embed.FS.
+	fmt.Fprintf(buf, "\nfunc (%s %s) Open(%s string) (fs.File, error) {\n", l[2], s.EmbedFS, l[3])
+	fmt.Fprintf(buf, "%s, %s := %s.%s.Open(%s)\n", l[4], l[5], l[2], l[0], l[3])
+	fmt.Fprintf(buf, "if %s != nil {\nreturn nil, %s\n}\n", l[5], l[5])
+	fmt.Fprintf(buf, "%s, %s := %s.Stat()\n", l[0], l[5], l[4])
+	fmt.Fprintf(buf, "if %s != nil || %s.IsDir() {\nreturn %s, %s\n}\n", l[5], l[0], l[4], l[5])
+	fmt.Fprintf(buf, "%s.Close()\n", l[4])
+	fmt.Fprintf(buf, "%s, %s := %s.ReadFile(%s)\n", l[1], l[5], l[2], l[3])
+	fmt.Fprintf(buf, "if %s != nil {\nreturn nil, %s\n}\n", l[5], l[5])
+	fmt.Fprintf(buf, "return &%s{bytes.NewReader(%s), %s}, nil\n}\n", s.EmbedFile, l[1], l[0])
+
+	fmt.Fprintf(buf, "\ntype %s struct {\n*bytes.Reader\n%s fs.FileInfo\n}\n", s.EmbedFile, l[0])
+	fmt.Fprintf(buf, "\nfunc (%s *%s) Stat() (fs.FileInfo, error) {\nreturn %s.%s, nil\n}\n",
+		l[2], s.EmbedFile, l[2], l[0])
+	fmt.Fprintf(buf, "\nfunc (%s *%s) Close() error {\nreturn nil\n}\n", l[2], s.EmbedFile)
+}
+
+// writeDecoder writes a function which decodes a string,
+// returning it as a string or as bytes.
+func (s *stringHelper) writeDecoder(buf *bytes.Buffer, name string, asString bool) {
+	data, key, res, i, c, k := s.Locals[0], s.Locals[1], s.Locals[2], s.Locals[3], s.Locals[4], s.Locals[5]
+	params := data + ", " + key + " []byte"
+	if s.KeyFirst {
+		params = key + ", " + data + " []byte"
+	}
+	result := "[]byte"
+	if asString {
+		result = "string"
+	}
+	fmt.Fprintf(buf, "func %s(%s) %s {\n", name, params, result)
+	fmt.Fprintf(buf, "%s := make([]byte, len(%s))\n", res, data)
+	keyByte := key + "[" + i + "]"
+	if s.Scheme == schemeStream {
+		keyByte = "byte(" + k + ">>" + strconv.Itoa(int(s.Shift)) + ")"
+		fmt.Fprintf(buf, "%s := uint32(%s[0]) | uint32(%s[1])<<8 | uint32(%s[2])<<16 | uint32(%s[3])<<24\n",
+			k, key, key, key, key)
+	}
+	if s.Reverse {
+		fmt.Fprintf(buf, "for %s := len(%s) - 1; %s >= 0; %s-- {\n", i, data, i, i)
+	} else {
+		fmt.Fprintf(buf, "for %s := range %s {\n", i, data)
+	}
+	if s.Scheme == schemeStream {
+		fmt.Fprintf(buf, "%s ^= %s << 13\n%s ^= %s >> 17\n%s ^= %s << 5\n", k, k, k, k, k, k)
+	}
+	if s.Inline {
+		fmt.Fprintf(buf, "%s := %s[%s]\n", c, data, i)
+		fmt.Fprintf(buf, "%s[%s] = %s\n", res, i, s.decodeExpr(c, keyByte, i))
+	} else {
+		fmt.Fprintf(buf, "%s[%s] = %s(%s[%s], %s, %s)\n", res, i, s.Step, data, i, keyByte, i)
+	}
+	if asString {
+		fmt.Fprintf(buf, "}\nreturn string(%s)\n}\n", res)
+	} else {
+		fmt.Fprintf(buf, "}\nreturn %s\n}\n", res)
+	}
+}
+
+// writeOpener writes a function which decrypts a string
+// with AES, returning it as a string or as bytes.
+// The plaintext is only kept in the result, and the other
+// buffers are wiped.
+func (s *stringHelper) writeOpener(buf *bytes.Buffer, name string, asString bool) {
+	data, key, res, i, block, k := s.Locals[0], s.Locals[1], s.Locals[2], s.Locals[3], s.Locals[4], s.Locals[5]
+	result := "[]byte"
+	if asString {
+		result = "string"
+	}
+	fmt.Fprintf(buf, "\nfunc %s(%s, %s []byte) %s {\n", name, data, key, result)
+	fmt.Fprintf(buf, "%s, _ := aes.NewCipher(%s[:32])\n", block, key)
+	fmt.Fprintf(buf, "%s := make([]byte, len(%s))\n", res, data)
+	fmt.Fprintf(buf, "cipher.NewCTR(%s, %s[32:]).XORKeyStream(%s, %s)\n", block, key, res, data)
+	wiped := []string{data, key}
+	if asString {
+		fmt.Fprintf(buf, "%s := string(%s)\n", k, res)
+		wiped = []string{res, data, key}
+	}
+	for _, w := range wiped {
+		fmt.Fprintf(buf, "for %s := range %s {\n%s[%s] = 0\n}\n", i, w, w, i)
+	}
+	if asString {
+		fmt.Fprintf(buf, "return %s\n}\n", k)
+	} else {
+		fmt.Fprintf(buf, "return %s\n}\n", res)
+	}
+}
+
+// decodeExpr is the expression which decodes the byte c
+// at index i with the key byte k.
+func (s stringScheme) decodeExpr(c, k, i string) string {
+	mask := fmt.Sprintf("(byte(%s)*%d + %d)", i, s.Mul, s.Add)
+	switch s.Scheme {
+	case schemeAdd:
+		return fmt.Sprintf("(%s ^ %s) - %s", c, mask, k)
+	case schemeRotate:
+		return fmt.Sprintf("(%s>>(%s&7) | %s<<(8-%s&7)) ^ %s", c, k, c, k, mask)
+	}
+	return fmt.Sprintf("%s ^ %s ^ %s", c, k, mask)
+}
+
+// encode encodes a string with the scheme, returning the
+// data and the key.
+func (s stringScheme) encode(r *rand.Rand, str string) ([]byte, []byte) {
+	data := []byte(str)
+	if s.Scheme == schemeStream {
+		key := randomBytes(r, 4)
+		key[0] |= 1
+		state := uint32(key[0]) | uint32(key[1])<<8 | uint32(key[2])<<16 | uint32(key[3])<<24
+		for i := range data {
+			state ^= state << 13
+			state ^= state >> 17
+			state ^= state << 5
+			data[i] ^= byte(state>>s.Shift) ^ (byte(i)*s.Mul + s.Add)
+		}
+		return data, key
+	}
+	key := randomBytes(r, len(data))
+	for i, c := range data {
+		mask := byte(i)*s.Mul + s.Add
+		switch s.Scheme {
+		case schemeAdd:
+			data[i] = (c + key[i]) ^ mask
+		case schemeRotate:
+			r := key[i] & 7
+			c ^= mask
+			data[i] = c<<r | c>>(8-r)
+		default:
+			data[i] = c ^ key[i] ^ mask
+		}
+	}
+	return data, key
+}
+
+// WriteCall writes an expression which decodes str at
+// runtime, with a key from r.
+func (s *stringHelper) WriteCall(w *bufio.Writer, r *rand.Rand, str string) {
+	s.writeArgs(w, r, s.Decode, str)
+}
+
+// writeArgs writes a call to a decoding function of the
+// helper with the encoded string.
+func (s *stringHelper) writeArgs(w *bufio.Writer, r *rand.Rand, name, str string) {
+	data, key := s.encode(r, str)
+	w.WriteString(s.Alias + "." + name + "(")
+	if s.KeyFirst {
+		data, key = key, data
+	}
+	writeByteSlice(w, data)
+	w.WriteString(", ")
+	writeByteSlice(w, key)
+	w.WriteString(")")
+}
+
+// WriteSealedCall writes an expression which decrypts str
+// at runtime with AES, from a ciphertext and a key which
+// are built byte by byte by the code of the call site
+// (like stack strings), rather than stored as data.
+func (s *stringHelper) WriteSealedCall(w *bufio.Writer, r *rand.Rand, str string) {
+	s.writeSealedArgs(w, r, s.Open, str)
+}
+
+// writeSealedArgs writes a call to a decrypting function of
+// the helper with the encrypted string.
+func (s *stringHelper) writeSealedArgs(w *bufio.Writer, r *rand.Rand, name, str string) {
+	s.lock.Lock()
+	s.Sealed = true
+	s.lock.Unlock()
+
+	key := randomBytes(r, 32+aes.BlockSize)
+	block, _ := aes.NewCipher(key[:32])
+	data := make([]byte, len(str))
+	cipher.NewCTR(block, key[32:]).XORKeyStream(data, []byte(str))
+	w.WriteString(s.Alias + "." + name + "(")
+	writeStackBytes(w, data)
+	w.WriteString(", ")
+	writeStackBytes(w, key)
+	w.WriteString(")")
+}
+
+// WriteCStringCall writes an expression which replaces a
+// call to C.CString with a literal, in a file which imports
+// "C". The string is decoded (or decrypted, if sealed) into
+// bytes with a terminating NUL, which are copied to C
+// memory and wiped, so that the plaintext is never kept in
+// a Go string. Like C.CString, the result must be freed.
+func (s *stringHelper) WriteCStringCall(w *bufio.Writer, r *rand.Rand, str string, sealed bool) {
+	s.lock.Lock()
+	s.CStrings = true
+	s.lock.Unlock()
+
+	w.WriteString("func() *C.char { b := ")
+	str += "\x00"
+	if sealed {
+		s.writeSealedArgs(w, r, s.OpenBytes, str)
+	} else {
+		s.writeArgs(w, r, s.DecodeBytes, str)
+	}
+	w.WriteString("; p := C.CBytes(b); " + s.Alias + "." + s.Wipe + "(b); return (*C.char)(p) }()")
+}
+
+// NumberExpr is an expression of the type typeName which
+// computes the bits of a numeric constant at runtime, from
+// one of the keys. Integers take the low bits, as with any
+// conversion, and floats are made from their IEEE 754 bits
+// with Float32 or Float64.
+func (s *stringHelper) NumberExpr(r *rand.Rand, typeName string, bits uint64, kind numberKind) string {
+	s.lock.Lock()
+	s.Numbers = true
+	s.lock.Unlock()
+
+	// The key is picked by chance, along with one of a few
+	// ways of combining it with the masked bits.
+	i, j := r.Intn(len(s.Keys)), r.Intn(len(s.Keys))
+	ki, kj := s.KeyValues[i], s.KeyValues[j]
+	key, other := s.Alias+"."+s.Keys[i], s.Alias+"."+s.Keys[j]
+	var expr string
+	switch r.Intn(3) {
+	case 0:
+		expr = fmt.Sprintf("%s ^ %#x", key, bits^ki)
+	case 1:
+		expr = fmt.Sprintf("%s + %#x", key, bits-ki)
+	default:
+		expr = fmt.Sprintf("(%s ^ %#x) - %s", key, (bits+kj)^ki, other)
+	}
+	switch kind {
+	case numberFloat32:
+		expr = s.Alias + "." + s.Float32 + "(" + expr + ")"
+	case numberFloat64:
+		expr = s.Alias + "." + s.Float64 + "(" + expr + ")"
+	}
+	return typeName + "(" + expr + ")"
+}
+
+// EmbedExpr is an expression which decrypts the embedded
+// variable hidden, of the given kind, with key. The name of
+// the file of a string or []byte derives the IV, while an
+// embed.FS derives it from the names of its files.
+func (s *stringHelper) EmbedExpr(kind embedKind, hidden string, key []byte, name string) string {
+	s.lock.Lock()
+	s.Embeds = true
+	s.lock.Unlock()
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeStackBytes(w, key)
+	w.Flush()
+	switch kind {
+	case embedString:
+		return fmt.Sprintf("string(%s.%s([]byte(%s), %s, %q))", s.Alias, s.OpenEmbed, hidden, buf.String(), name)
+	case embedBytes:
+		return fmt.Sprintf("%s.%s(%s, %s, %q)", s.Alias, s.OpenEmbed, hidden, buf.String(), name)
+	default:
+		return fmt.Sprintf("%s.%s(%s, %s)", s.Alias, s.NewEmbedFS, hidden, buf.String())
+	}
+}
+
+// writeStackBytes writes an expression which builds a
+// byte slice with a store for every byte.
+func writeStackBytes(w *bufio.Writer, data []byte) {
+	fmt.Fprintf(w, "func() []byte { b := make([]byte, %d)", len(data))
+	for i, b := range data {
+		fmt.Fprintf(w, "; b[%d] = %d", i, b)
+	}
+	w.WriteString("; return b }()")
+}
+
+func randomBytes(r *rand.Rand, size int) []byte {
+	res := make([]byte, size)
+	for i := range res {
+		res[i] = byte(r.Intn(256))
+	}
+	return res
+}
+
+func writeByteSlice(w *bufio.Writer, data []byte) {
+	w.WriteString("[]byte(\"")
+	for _, b := range data {
+		writeHexByte(w, b)
+	}
+	w.WriteString("\")")
+}
+
+// ImportDecl is the import declaration of the helper.
+func (s *stringHelper) ImportDecl() string {
+	return "import " + s.Alias + " " + strconv.Quote(s.Path)
+}
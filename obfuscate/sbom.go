@@ -0,0 +1,321 @@
+package obfuscate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sbomFormat, if set, is the format of the software bill of
+// materials written next to every binary: "cyclonedx" or
+// "spdx".
+var sbomFormat string
+
+// sbomExtensions are the extensions which are added to the
+// paths of binaries to name their SBOMs.
+var sbomExtensions = map[string]string{
+	"cyclonedx": ".cdx.json",
+	"spdx":      ".spdx.json",
+}
+
+// An sbomComponent is a module (or, in GOPATH mode, a
+// package) which a binary was built from.
+type sbomComponent struct {
+	Path    string
+	Version string
+}
+
+func (c sbomComponent) purl() string {
+	if c.Version == "" {
+		return "pkg:golang/" + c.Path
+	}
+	return "pkg:golang/" + c.Path + "@" + c.Version
+}
+
+// An sbom is the dependency set of a binary, as it was
+// before it was obfuscated: the module paths and versions
+// which stripping the build info, and renaming, remove from
+// the binary itself.
+type sbom struct {
+	Artifact Artifact
+	SHA256   string
+	Main     sbomComponent
+
+	// Components are the modules of the dependencies, and
+	// the standard library, sorted by path.
+	Components []sbomComponent
+}
+
+// checkSBOMFlags reports an unknown -sbom format, and
+// flags which build no binaries to write SBOMs for.
+func checkSBOMFlags() error {
+	if sbomFormat == "" {
+		return nil
+	} else if sbomExtensions[sbomFormat] == "" {
+		return flagError("The -sbom flag must be cyclonedx or spdx.")
+	} else if outputGopath || numVariants > 1 {
+		return flagError("The -sbom flag cannot be combined with -outdir or -variants.")
+	}
+	return nil
+}
+
+// sbomPath is where the SBOM of a binary is written.
+func sbomPath(binPath string) string {
+	return binPath + sbomExtensions[sbomFormat]
+}
+
+// WriteSBOMs writes the SBOM of each of the workspace's
+// binaries next to it. The dependencies are listed again
+// from the original sources, for the platform and tags of
+// each binary.
+func (w *Workspace) WriteSBOMs() error {
+	prepEnv, err := prepareEnv()
+	if err != nil {
+		return flagError("Invalid preparation environment: " + err.Error())
+	}
+	for _, artifact := range w.Artifacts {
+		var bom *sbom
+		err := withEnv(prepEnv, func() error {
+			var err error
+			bom, err = listSBOM(artifact)
+			return err
+		})
+		if err != nil {
+			return stepError("list the dependencies of "+artifact.Path, err)
+		}
+		file, err := releaseFile(artifact.Path)
+		if err != nil {
+			return stepError("hash binary", err)
+		}
+		bom.SHA256 = file.SHA256
+		var doc interface{}
+		if sbomFormat == "spdx" {
+			doc = bom.SPDX()
+		} else {
+			doc = bom.CycloneDX()
+		}
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return stepError("encode SBOM", err)
+		}
+		path := sbomPath(artifact.Path)
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return stepError("write SBOM", err)
+		}
+		log.Println("Wrote SBOM", path, "with", len(bom.Components), "components")
+	}
+	return nil
+}
+
+// listSBOM lists the original dependencies of a binary with
+// `go list -deps`, for its platform.
+func listSBOM(artifact Artifact) (*sbom, error) {
+	target := buildTarget{artifact.GOOS, artifact.GOARCH}
+	var goflags string
+	if tags := targetTags(target); tags != "" {
+		goflags = "-tags=" + strings.Replace(tags, " ", ",", -1)
+	}
+	env, err := originalListEnv(goflags)
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, "GOOS="+target.GOOS, "GOARCH="+target.GOARCH, "CGO_ENABLED="+targetCGO(target))
+	env = append(env, targetEnv(config.TargetConfig(target))...)
+	pkgs, err := listPackages(artifact.Package, env, false)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := goVersion()
+	if err != nil {
+		return nil, err
+	}
+	res := &sbom{Artifact: artifact, Main: sbomComponent{Path: artifact.Package}}
+	components := map[sbomComponent]bool{}
+	for _, pkg := range pkgs {
+		switch {
+		case pkg.Standard:
+			components[sbomComponent{Path: "stdlib", Version: version}] = true
+		case pkg.Module == nil:
+			// GOPATH packages have no versions.
+			if pkg.ImportPath != artifact.Package {
+				components[sbomComponent{Path: pkg.ImportPath}] = true
+			}
+		case pkg.Module.Main:
+			res.Main = sbomComponent{Path: pkg.Module.Path, Version: pkg.Module.Version}
+		case pkg.Module.Replace != nil && pkg.Module.Replace.Version != "":
+			components[sbomComponent{Path: pkg.Module.Replace.Path, Version: pkg.Module.Replace.Version}] = true
+		case pkg.Module.Replace != nil:
+			// Modules replaced by directories have no
+			// version.
+			components[sbomComponent{Path: pkg.Module.Path}] = true
+		default:
+			components[sbomComponent{Path: pkg.Module.Path, Version: pkg.Module.Version}] = true
+		}
+	}
+	for component := range components {
+		res.Components = append(res.Components, component)
+	}
+	sort.Slice(res.Components, func(i, j int) bool {
+		return res.Components[i].purl() < res.Components[j].purl()
+	})
+	return res, nil
+}
+
+// serialNumber derives a UUID for the SBOM from the hash of
+// the binary, so that the SBOMs of reproducible builds are
+// identical too.
+func (s *sbom) serialNumber() string {
+	id := s.SHA256[:32]
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+// CycloneDX encodes the SBOM as a CycloneDX 1.5 document.
+func (s *sbom) CycloneDX() interface{} {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type component struct {
+		Type       string     `json:"type"`
+		Ref        string     `json:"bom-ref"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version,omitempty"`
+		PURL       string     `json:"purl"`
+		Hashes     []hash     `json:"hashes,omitempty"`
+		Properties []property `json:"properties,omitempty"`
+	}
+	type dependency struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	}
+	tool := currentTool()
+	app := component{
+		Type:    "application",
+		Ref:     s.Main.purl(),
+		Name:    s.Main.Path,
+		Version: s.Main.Version,
+		PURL:    s.Main.purl(),
+		Hashes:  []hash{{"SHA-256", s.SHA256}},
+		Properties: []property{
+			{"gobfuscate:package", s.Artifact.Package},
+			{"gobfuscate:file", filepath.Base(s.Artifact.Path)},
+			{"gobfuscate:goos", s.Artifact.GOOS},
+			{"gobfuscate:goarch", s.Artifact.GOARCH},
+		},
+	}
+	components := []component{}
+	dependsOn := []string{}
+	for _, c := range s.Components {
+		components = append(components, component{
+			Type:    "library",
+			Ref:     c.purl(),
+			Name:    c.Path,
+			Version: c.Version,
+			PURL:    c.purl(),
+		})
+		dependsOn = append(dependsOn, c.purl())
+	}
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + s.serialNumber(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"tools": map[string]interface{}{
+				"components": []component{{
+					Type:    "application",
+					Ref:     "gobfuscate",
+					Name:    "gobfuscate",
+					Version: tool.Version,
+					PURL:    "pkg:golang/github.com/unixpickle/gobfuscate@" + tool.Version,
+				}},
+			},
+			"component": app,
+		},
+		"components":   components,
+		"dependencies": []dependency{{Ref: app.Ref, DependsOn: dependsOn}},
+	}
+}
+
+// SPDX encodes the SBOM as an SPDX 2.3 document.
+func (s *sbom) SPDX() interface{} {
+	type checksum struct {
+		Algorithm string `json:"algorithm"`
+		Value     string `json:"checksumValue"`
+	}
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+	type spdxPackage struct {
+		Name             string        `json:"name"`
+		ID               string        `json:"SPDXID"`
+		Version          string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		Checksums        []checksum    `json:"checksums,omitempty"`
+		ExternalRefs     []externalRef `json:"externalRefs"`
+		Comment          string        `json:"comment,omitempty"`
+	}
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+	newPackage := func(id string, c sbomComponent) spdxPackage {
+		return spdxPackage{
+			Name:             c.Path,
+			ID:               id,
+			Version:          c.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs:     []externalRef{{"PACKAGE-MANAGER", "purl", c.purl()}},
+		}
+	}
+	app := newPackage("SPDXRef-Package-0", s.Main)
+	app.Checksums = []checksum{{"SHA256", s.SHA256}}
+	app.Comment = fmt.Sprintf("%s, built for %s/%s from %s", filepath.Base(s.Artifact.Path),
+		s.Artifact.GOOS, s.Artifact.GOARCH, s.Artifact.Package)
+	packages := []spdxPackage{app}
+	relationships := []relationship{{"SPDXRef-DOCUMENT", "DESCRIBES", app.ID}}
+	for i, c := range s.Components {
+		pkg := newPackage("SPDXRef-Package-"+strconv.Itoa(i+1), c)
+		packages = append(packages, pkg)
+		relationships = append(relationships, relationship{app.ID, "DEPENDS_ON", pkg.ID})
+	}
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              filepath.Base(s.Artifact.Path),
+		"documentNamespace": "https://spdx.org/spdxdocs/gobfuscate/" + s.serialNumber(),
+		"creationInfo": map[string]interface{}{
+			"created":  sbomTime().Format(time.RFC3339),
+			"creators": []string{"Tool: gobfuscate-" + currentTool().Version},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// sbomTime is the creation time of SPDX documents, which
+// they require: SOURCE_DATE_EPOCH, if it is set, for
+// reproducible builds, or else the current time.
+func sbomTime() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now().UTC().Truncate(time.Second)
+}
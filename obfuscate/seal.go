@@ -0,0 +1,311 @@
+package obfuscate
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Files which record how code was renamed (such as cached
+// dependency records and incremental state) are keys to
+// undo the obfuscation, so they can be encrypted and
+// signed.
+var (
+	mapKeyPath    string
+	mapSignPath   string
+	mapVerifyPath string
+)
+
+// mapPassphraseEnv may hold a passphrase to encrypt
+// mapping files with, instead of -mapkey.
+const mapPassphraseEnv = "GOBFUSCATE_MAP_PASSPHRASE"
+
+const (
+	sealedFormat     = "gobfuscate-sealed-1"
+	sealedIterations = 600000
+)
+
+// A sealedFile is the envelope of an encrypted or signed
+// mapping file.
+type sealedFile struct {
+	Format string `json:"format"`
+
+	// KDF is "pbkdf2-sha256" if the key was derived from a
+	// passphrase, "raw" for a key file with a raw key, or
+	// "" if the data is not encrypted.
+	KDF        string `json:"kdf,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       []byte `json:"salt,omitempty"`
+	Nonce      []byte `json:"nonce,omitempty"`
+
+	Data []byte `json:"data"`
+
+	PublicKey []byte `json:"public_key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// writeMapFile writes a mapping file, encrypting and
+// signing it if a key was configured.
+// Mapping files are only readable by their owner either
+// way.
+func writeMapFile(path string, data []byte) error {
+	sealed, err := sealMapData(data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, sealed, 0600)
+}
+
+// readMapFile reads a mapping file written by writeMapFile,
+// verifying and decrypting it as needed.
+func readMapFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = unsealMapData(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return data, nil
+}
+
+func sealMapData(data []byte) ([]byte, error) {
+	key, kdf, err := loadMapKey()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := loadSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if kdf == "" && signer == nil {
+		return data, nil
+	}
+
+	sealed := &sealedFile{Format: sealedFormat, Data: data}
+	if kdf != "" {
+		sealed.KDF = kdf
+		if kdf == "pbkdf2-sha256" {
+			sealed.Iterations = sealedIterations
+			sealed.Salt = make([]byte, 16)
+			if _, err := rand.Read(sealed.Salt); err != nil {
+				return nil, err
+			}
+		}
+		aead, err := sealedCipher(sealed, key)
+		if err != nil {
+			return nil, err
+		}
+		sealed.Nonce = make([]byte, aead.NonceSize())
+		if _, err := rand.Read(sealed.Nonce); err != nil {
+			return nil, err
+		}
+		sealed.Data = aead.Seal(nil, sealed.Nonce, data, []byte(sealedFormat))
+	}
+	if signer != nil {
+		sealed.PublicKey = signer.Public().(ed25519.PublicKey)
+		sealed.Signature = ed25519.Sign(signer, sealed.signedData())
+	}
+	return json.MarshalIndent(sealed, "", "  ")
+}
+
+func unsealMapData(data []byte) ([]byte, error) {
+	var header struct {
+		Format string `json:"format"`
+	}
+	if json.Unmarshal(data, &header) != nil || header.Format != sealedFormat {
+		if mapVerifyPath != "" {
+			return nil, errors.New("file is not signed")
+		}
+		return data, nil
+	}
+	var sealed sealedFile
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("corrupted file: %s", err)
+	}
+
+	if sealed.Signature != nil {
+		// ed25519.Verify panics on a public key of the wrong
+		// size, which a corrupted file may have.
+		if len(sealed.PublicKey) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid public key")
+		}
+		if !ed25519.Verify(sealed.PublicKey, sealed.signedData(), sealed.Signature) {
+			return nil, errors.New("invalid signature")
+		}
+	}
+	if mapVerifyPath != "" {
+		trusted, err := loadVerifyKey()
+		if err != nil {
+			return nil, err
+		}
+		if sealed.Signature == nil {
+			return nil, errors.New("file is not signed")
+		} else if !bytes.Equal(trusted, sealed.PublicKey) {
+			return nil, errors.New("file is signed by an untrusted key")
+		}
+	}
+
+	if sealed.KDF == "" {
+		return sealed.Data, nil
+	}
+	key, kdf, err := loadMapKey()
+	if err != nil {
+		return nil, err
+	} else if kdf == "" {
+		return nil, errors.New("file is encrypted, but no -mapkey or " + mapPassphraseEnv + " was given")
+	} else if (kdf == "raw") != (sealed.KDF == "raw") {
+		return nil, fmt.Errorf("file is encrypted with a %s key", sealed.KDF)
+	}
+	aead, err := sealedCipher(&sealed, key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, sealed.Nonce, sealed.Data, []byte(sealedFormat))
+	if err != nil {
+		return nil, errors.New("wrong key or corrupted file")
+	}
+	return plain, nil
+}
+
+// signedData encodes every field of the envelope other
+// than the signature.
+func (s *sealedFile) signedData() []byte {
+	var buf bytes.Buffer
+	for _, field := range [][]byte{[]byte(s.Format), []byte(s.KDF), s.Salt, s.Nonce, s.Data, s.PublicKey} {
+		binary.Write(&buf, binary.BigEndian, uint64(len(field)))
+		buf.Write(field)
+	}
+	binary.Write(&buf, binary.BigEndian, uint64(s.Iterations))
+	return buf.Bytes()
+}
+
+// sealedCipher creates the cipher for an envelope from a
+// raw key or a passphrase.
+func sealedCipher(s *sealedFile, key []byte) (cipher.AEAD, error) {
+	if s.KDF == "pbkdf2-sha256" {
+		var err error
+		key, err = pbkdf2.Key(sha256.New, string(key), s.Salt, s.Iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+	} else if s.KDF != "raw" {
+		return nil, fmt.Errorf("unknown key derivation: %s", s.KDF)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadMapKey reads the encryption key or passphrase.
+// A key file with 64 hex digits holds a raw AES-256 key,
+// and any other key file holds a passphrase.
+// The kdf is "" if no key was configured.
+func loadMapKey() (key []byte, kdf string, err error) {
+	if mapKeyPath == "" {
+		if pass := os.Getenv(mapPassphraseEnv); pass != "" {
+			return []byte(pass), "pbkdf2-sha256", nil
+		}
+		return nil, "", nil
+	}
+	data, err := ioutil.ReadFile(mapKeyPath)
+	if err != nil {
+		return nil, "", err
+	}
+	text := strings.TrimSpace(string(data))
+	if len(text) == 64 {
+		if raw, err := hex.DecodeString(text); err == nil {
+			return raw, "raw", nil
+		}
+	}
+	if text == "" {
+		return nil, "", errors.New("empty key file: " + mapKeyPath)
+	}
+	return []byte(text), "pbkdf2-sha256", nil
+}
+
+// loadSigningKey reads the Ed25519 private key given by
+// -mapsign, in PKCS #8 PEM form.
+func loadSigningKey() (ed25519.PrivateKey, error) {
+	if mapSignPath == "" {
+		return nil, nil
+	}
+	return readSigningKey(mapSignPath)
+}
+
+// readSigningKey reads an Ed25519 private key in PKCS #8
+// PEM form.
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an Ed25519 key")
+	}
+	return edKey, nil
+}
+
+// loadVerifyKey reads the Ed25519 public key given by
+// -mapverify, in PKIX PEM form.
+func loadVerifyKey() (ed25519.PublicKey, error) {
+	der, err := readPEM(mapVerifyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("verification key is not an Ed25519 key")
+	}
+	return edKey, nil
+}
+
+func readPEM(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data in " + path)
+	}
+	return block.Bytes, nil
+}
+
+// unmap prints the contents of a mapping file, verifying
+// and decrypting it as needed.
+func unmap(path string) bool {
+	data, err := readMapFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to read mapping file:", err)
+		return false
+	}
+	os.Stdout.Write(data)
+	return true
+}
@@ -0,0 +1,208 @@
+package obfuscate
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sealKeys are the key files which the seal tests use.
+type sealKeys struct {
+	Raw, Passphrase, Sign, Verify, OtherVerify string
+}
+
+func writeSealKeys(t *testing.T) sealKeys {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	writePEM := func(name, kind string, der []byte) string {
+		return write(name, string(pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})))
+	}
+	keys := sealKeys{
+		Raw:        write("raw.key", strings.Repeat("ab", 32)+"\n"),
+		Passphrase: write("pass.key", "correct horse battery staple\n"),
+	}
+	for i, path := range []*string{&keys.Verify, &keys.OtherVerify} {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubDER, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("key%d", i)
+		*path = writePEM(name+".pub", "PUBLIC KEY", pubDER)
+		if i == 0 {
+			keys.Sign = writePEM(name+".pem", "PRIVATE KEY", privDER)
+		}
+	}
+	return keys
+}
+
+// setSealFlags sets the mapping file flags for a test, and
+// restores them when it is done.
+func setSealFlags(t *testing.T, key, sign, verify string) {
+	oldKey, oldSign, oldVerify := mapKeyPath, mapSignPath, mapVerifyPath
+	t.Cleanup(func() {
+		mapKeyPath, mapSignPath, mapVerifyPath = oldKey, oldSign, oldVerify
+	})
+	mapKeyPath, mapSignPath, mapVerifyPath = key, sign, verify
+}
+
+func TestSealMapData(t *testing.T) {
+	t.Setenv(mapPassphraseEnv, "")
+	keys := writeSealKeys(t)
+	plain := []byte(`{"renames":{"a":"b"}}`)
+	tests := []struct {
+		name string
+
+		sealKey, sign   string
+		openKey, verify string
+
+		// tamper modifies the envelope before it is opened.
+		tamper func(s *sealedFile)
+
+		err string
+	}{
+		{name: "plain"},
+		{name: "raw key", sealKey: keys.Raw, openKey: keys.Raw},
+		{name: "passphrase", sealKey: keys.Passphrase, openKey: keys.Passphrase},
+		{name: "signed", sign: keys.Sign},
+		{name: "signed and verified", sign: keys.Sign, verify: keys.Verify},
+		{name: "encrypted and signed", sealKey: keys.Raw, sign: keys.Sign, openKey: keys.Raw, verify: keys.Verify},
+		{name: "plain but verified", verify: keys.Verify, err: "file is not signed"},
+		{name: "untrusted key", sign: keys.Sign, verify: keys.OtherVerify, err: "untrusted key"},
+		{name: "missing key", sealKey: keys.Raw, err: "file is encrypted"},
+		{name: "wrong kind of key", sealKey: keys.Raw, openKey: keys.Passphrase, err: "encrypted with a raw key"},
+		{
+			name: "tampered data", sealKey: keys.Raw, openKey: keys.Raw,
+			tamper: func(s *sealedFile) { s.Data[0] ^= 1 },
+			err:    "wrong key or corrupted file",
+		},
+		{
+			name: "tampered signed data", sign: keys.Sign,
+			tamper: func(s *sealedFile) { s.Data[0] ^= 1 },
+			err:    "invalid signature",
+		},
+		{
+			name: "short public key", sign: keys.Sign,
+			tamper: func(s *sealedFile) { s.PublicKey = s.PublicKey[:5] },
+			err:    "invalid public key",
+		},
+		{
+			name: "missing public key", sign: keys.Sign,
+			tamper: func(s *sealedFile) { s.PublicKey = nil },
+			err:    "invalid public key",
+		},
+		{
+			name: "unknown kdf", sealKey: keys.Raw, openKey: keys.Raw,
+			tamper: func(s *sealedFile) { s.KDF = "scrypt" },
+			err:    "encrypted with a scrypt key",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setSealFlags(t, test.sealKey, test.sign, "")
+			data, err := sealMapData(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.tamper != nil {
+				var sealed sealedFile
+				if err := json.Unmarshal(data, &sealed); err != nil {
+					t.Fatal(err)
+				}
+				test.tamper(&sealed)
+				if data, err = json.Marshal(&sealed); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			setSealFlags(t, test.openKey, "", test.verify)
+			opened, err := unsealMapData(data)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(opened) != string(plain) {
+				t.Errorf("expected %s, got %s", plain, opened)
+			}
+		})
+	}
+}
+
+func TestSealedMappingRoundTrip(t *testing.T) {
+	t.Setenv(mapPassphraseEnv, "")
+	keys := writeSealKeys(t)
+	moves := PackageMoves{{From: "example.com/app", To: "abcdef"}}
+	record := newRenameRecord([]string{"example.com/app"}, moves, []symbolRenameReq{
+		{`"abcdef".Server`, "Ghijkl"},
+		{`"abcdef".Ghijkl.Handle`, "Mnopqr"},
+	}, func(string) bool {
+		return true
+	})
+	record.addHoists(moves, []symbolRenameReq{{`"abcdef".main`, "Stuvwx"}}, func(string) bool {
+		return true
+	})
+
+	path := filepath.Join(t.TempDir(), "map.json")
+	setSealFlags(t, keys.Raw, keys.Sign, "")
+	if err := writeMapping(path, record); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(data), "example.com/app") {
+		t.Error("the mapping file is not encrypted")
+	}
+
+	setSealFlags(t, keys.Raw, "", keys.Verify)
+	data, err = readMapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opened renameRecord
+	if err := json.Unmarshal(data, &opened); err != nil {
+		t.Fatal(err)
+	}
+	if opened.Tool == nil {
+		t.Error("the mapping file does not name its tool")
+	}
+	u := newUnmangler(&opened)
+	for obfuscated, expected := range map[string]string{
+		"abcdef.(*Ghijkl).Mnopqr(0xc000010000)\n": "example.com/app.(*Server).Handle(0xc000010000)\n",
+		"main.Stuvwx.func1()\n":                   "main.main.func1()\n",
+	} {
+		if actual := u.Line(obfuscated); actual != expected {
+			t.Errorf("expected %q but got %q", expected, actual)
+		}
+	}
+
+	setSealFlags(t, keys.Raw, "", keys.OtherVerify)
+	if _, err := readMapFile(path); err == nil {
+		t.Error("a mapping file signed by an untrusted key was read")
+	}
+}
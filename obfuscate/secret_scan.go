@@ -0,0 +1,203 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// secretsMode is "warn", "fail", or "off", and decides
+// what happens when hardcoded secrets are found.
+var secretsMode string
+
+// allowSecretComment marks a line whose secret is known to
+// be harmless, such as a test key.
+const allowSecretComment = "gobfuscate:allowsecret"
+
+var (
+	credentialNameExpr = regexp.MustCompile(`(?i)(passw(or)?d|passwd|secret|token|api_?key|credential|private_?key)$`)
+	urlCredentialsExpr = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@"]+:[^/\s@"]+@`)
+)
+
+// A SecretFinding is a string literal which looks like a
+// hardcoded credential.
+type SecretFinding struct {
+	// Package is the original import path of the package.
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+
+	// Kind is "private-key", "api-key", "url-credentials",
+	// or "credential" for a value assigned to a name like
+	// "password" or "apiKey".
+	Kind string `json:"kind"`
+
+	// Preview is the start of the value, which is enough to
+	// find it without repeating the secret.
+	Preview string `json:"preview"`
+}
+
+func (s SecretFinding) String() string {
+	return fmt.Sprintf("%s/%s:%d: possible %s (%s)", s.Package, s.File, s.Line, s.Kind, s.Preview)
+}
+
+// ScanSecrets looks for hardcoded credentials in the
+// packages of a GOPATH for which include returns true.
+// It should be run before strings are obfuscated.
+func ScanSecrets(gopath string, moves PackageMoves, include func(string) bool) ([]SecretFinding, error) {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return nil, err
+	}
+	var resLock sync.Mutex
+	var res []SecretFinding
+	err = forEachFile(files, func(f sourceFile) error {
+		findings, err := scanFileSecrets(f.Path)
+		if err != nil {
+			return err
+		}
+		resLock.Lock()
+		defer resLock.Unlock()
+		for _, finding := range findings {
+			finding.Package = moves.Original(f.PkgPath)
+			res = append(res, finding)
+		}
+		return nil
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return positionLess(res[i].Package, res[i].File, res[i].Line, res[j].Package, res[j].File, res[j].Line)
+	})
+	return res, err
+}
+
+func scanFileSecrets(path string) ([]SecretFinding, error) {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, nil, parser.ParseComments)
+	if err != nil {
+		// The file will be left alone and fail to build.
+		return nil, nil
+	}
+	allowed := map[int]bool{}
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if strings.Contains(comment.Text, allowSecretComment) {
+				allowed[set.Position(comment.Pos()).Line] = true
+			}
+		}
+	}
+
+	var res []SecretFinding
+	add := func(lit *ast.BasicLit, named bool) {
+		if lit.Kind != token.STRING {
+			return
+		}
+		value, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return
+		}
+		kind := secretKind(value, named)
+		pos := set.Position(lit.Pos())
+		if kind == "" || allowed[pos.Line] {
+			return
+		}
+		res = append(res, SecretFinding{
+			File:    filepath.Base(pos.Filename),
+			Line:    pos.Line,
+			Kind:    kind,
+			Preview: secretPreview(value),
+		})
+	}
+
+	seen := map[*ast.BasicLit]bool{}
+	addNamed := func(name ast.Expr, value ast.Expr) {
+		lit, ok := value.(*ast.BasicLit)
+		if !ok {
+			return
+		}
+		seen[lit] = true
+		add(lit, credentialNameExpr.MatchString(exprName(name)))
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ImportSpec:
+			return false
+		case *ast.Field:
+			// Struct tags are never credentials.
+			return false
+		case *ast.ValueSpec:
+			if len(n.Names) == len(n.Values) {
+				for i, name := range n.Names {
+					addNamed(name, n.Values[i])
+				}
+			}
+		case *ast.AssignStmt:
+			if len(n.Lhs) == len(n.Rhs) {
+				for i, lhs := range n.Lhs {
+					addNamed(lhs, n.Rhs[i])
+				}
+			}
+		case *ast.KeyValueExpr:
+			addNamed(n.Key, n.Value)
+		case *ast.BasicLit:
+			if !seen[n] {
+				add(n, false)
+			}
+		}
+		return true
+	})
+	return res, nil
+}
+
+// secretKind decides if a string looks like a credential.
+// Named is set if the string is assigned to a name like
+// "password".
+func secretKind(s string, named bool) string {
+	switch kind := sensitiveKind(s); {
+	case kind == "private-key":
+		return kind
+	case apiKeyExpr.MatchString(s):
+		return "api-key"
+	case urlCredentialsExpr.MatchString(s):
+		return "url-credentials"
+	case named && len(s) >= 8 && !strings.ContainsAny(s, " \t\n"):
+		return "credential"
+	}
+	return ""
+}
+
+// exprName gets the name being assigned to by an
+// expression, such as "Password" for "cfg.Password" or
+// "api_key" for the key "api_key" of a map literal.
+func exprName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.BasicLit:
+		if name, err := strconv.Unquote(e.Value); err == nil {
+			return name
+		}
+	case *ast.IndexExpr:
+		return exprName(e.Index)
+	}
+	return ""
+}
+
+func secretPreview(s string) string {
+	if strings.Contains(s, "-----BEGIN") {
+		return strings.SplitN(strings.TrimSpace(s), "\n", 2)[0]
+	}
+	const previewLen = 4
+	if len(s) <= previewLen*2 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:previewLen] + strings.Repeat("*", 4)
+}
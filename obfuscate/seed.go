@@ -0,0 +1,54 @@
+package obfuscate
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+)
+
+// seed, if set, is what every random choice is derived
+// from, so that runs with the same seed on the same
+// sources produce the same output.
+var seed string
+
+// setupSeed derives the padding from the -seed, unless
+// -padding is passed. Builds with a seed are always
+// trimmed, since the path of the temporary workspace
+// would otherwise end up in the binaries.
+func setupSeed() error {
+	if seed == "" {
+		return nil
+	}
+	if customPadding == "" {
+		customPadding = seedHex("padding")
+	}
+	trimPath = true
+	return nil
+}
+
+// seedHex derives a hex string from the seed and a token.
+func seedHex(token string) string {
+	hash := sha256.Sum256([]byte(seed + "\x00" + token))
+	return hex.EncodeToString(hash[:])
+}
+
+// newKeyRand creates the source of the keys which a pass
+// picks for the code of a file.
+// With a seed, it is derived from the seed and the file's
+// contents, so that it does not depend on the order in
+// which files are processed, nor on where the workspace
+// is. Otherwise, it is seeded randomly.
+func newKeyRand(pass string, contents []byte) *rand.Rand {
+	var seedBytes [8]byte
+	if seed == "" {
+		cryptorand.Read(seedBytes[:])
+	} else {
+		hash := sha256.New()
+		hash.Write([]byte(seed + "\x00" + pass + "\x00"))
+		hash.Write(contents)
+		copy(seedBytes[:], hash.Sum(nil))
+	}
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seedBytes[:]))))
+}
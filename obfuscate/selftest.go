@@ -0,0 +1,546 @@
+package obfuscate
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A selftestSample is a program which is obfuscated by the
+// selftest subcommand.
+type selftestSample struct {
+	Name string
+
+	// Files maps paths in the sample's module to their
+	// contents.
+	Files map[string]string
+
+	// CGO is set for samples which need a C compiler.
+	CGO bool
+
+	// Flags are added to the flags of the obfuscation.
+	Flags []string
+}
+
+// unforwardedFlags are the flags which are not passed on to
+// the runs of the selftest and verify subcommands, since
+// they change what is produced, and the binaries must run
+// on this machine.
+var unforwardedFlags = map[string]bool{
+	"goos":        true,
+	"goarch":      true,
+	"outdir":      true,
+	"variants":    true,
+	"incremental": true,
+	"dryrun":      true,
+	"diff":        true,
+}
+
+// pathFlags are the flags whose values are paths, which are
+// made absolute when they are passed on, since the runs
+// happen in other directories.
+var pathFlags = map[string]bool{
+	"config":     true,
+	"dictionary": true,
+	"depcache":   true,
+	"metrics":    true,
+	"cpuprofile": true,
+	"mapkey":     true,
+	"mapsign":    true,
+	"mapverify":  true,
+	"pgo":        true,
+	"report":     true,
+	"map":        true,
+	"blocklist":  true,
+	"yara":       true,
+	"resume":     true,
+	"workdir":    true,
+	"buildcache": true,
+}
+
+// selftest obfuscates every sample program with the flags
+// of the command line, runs both the original and the
+// obfuscated binary, and compares their output and exit
+// status.
+func selftest() bool {
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to find executable:", err)
+		return false
+	}
+	goVersion, err := goLanguageVersion()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to get Go version:", err)
+		return false
+	}
+	dir, err := newTempDir("selftest")
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to create temp dir:", err)
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	flags := forwardedFlags()
+	var failed []string
+	for _, sample := range selftestSamples {
+		if sample.CGO && !haveCCompiler() {
+			log.Println("Skipping sample", sample.Name+": no C compiler")
+			continue
+		}
+		log.Println("Checking sample", sample.Name+"...")
+		if err := runSample(self, filepath.Join(dir, sample.Name), goVersion, sample, flags); err != nil {
+			fmt.Fprintln(stderr, "Sample "+sample.Name+" failed:", err)
+			failed = append(failed, sample.Name)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(stderr, "Failed samples:", strings.Join(failed, ", "))
+		return false
+	}
+	log.Println("All samples behave the same when obfuscated")
+	return true
+}
+
+// forwardedFlags lists the flags of the command line, so
+// that they apply to the runs of a subcommand.
+func forwardedFlags() []string {
+	var res []string
+	add := func(name, value string) {
+		if pathFlags[name] && value != "" {
+			if abs, err := filepath.Abs(value); err == nil {
+				value = abs
+			}
+		}
+		res = append(res, "-"+name+"="+value)
+	}
+	runFlags.Visit(func(f *flag.Flag) {
+		if unforwardedFlags[f.Name] {
+			return
+		}
+		if list, ok := f.Value.(*listFlag); ok {
+			for _, value := range *list {
+				add(f.Name, value)
+			}
+			return
+		}
+		add(f.Name, f.Value.String())
+	})
+	return res
+}
+
+// haveCCompiler checks if the C compiler of the go command
+// is installed.
+func haveCCompiler() bool {
+	output, err := exec.Command("go", "env", "CC").Output()
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return false
+	}
+	_, err = exec.LookPath(fields[0])
+	return err == nil
+}
+
+// runSample writes a sample's module to dir, builds it with
+// and without obfuscation, and compares the binaries'
+// behavior.
+func runSample(self, dir, goVersion string, sample selftestSample, flags []string) error {
+	files := map[string]string{"go.mod": "module selftest/" + sample.Name + "\n\ngo " + goVersion + "\n"}
+	for name, contents := range sample.Files {
+		files[name] = contents
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+
+	cgo := "0"
+	if sample.CGO {
+		cgo = "1"
+	}
+	env := append(os.Environ(), "GO111MODULE=on", "GOWORK=off", "GOFLAGS=")
+	original := filepath.Join(dir, "original")
+	obfuscated := filepath.Join(dir, "obfuscated")
+
+	build := exec.Command("go", "build", "-o", original, ".")
+	build.Dir = dir
+	build.Env = append(env, "CGO_ENABLED="+cgo)
+	if output, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("build original: %s\n%s", err, output)
+	}
+
+	args := append(append(append([]string{}, flags...), sample.Flags...), "-modules", ".", obfuscated)
+	obfuscate := exec.Command(self, args...)
+	obfuscate.Dir = dir
+	obfuscate.Env = append(env, "CGO_ENABLED_"+runtime.GOOS+"_"+runtime.GOARCH+"="+cgo)
+	if output, err := obfuscate.CombinedOutput(); err != nil {
+		return fmt.Errorf("obfuscate: %s\n%s", err, output)
+	}
+	if runtime.GOOS == "windows" {
+		original += ".exe"
+		obfuscated += ".exe"
+	}
+
+	wantOutput, wantStatus, err := runSampleBinary(original)
+	if err != nil {
+		return err
+	}
+	gotOutput, gotStatus, err := runSampleBinary(obfuscated)
+	if err != nil {
+		return err
+	}
+	if diff := firstDifference(wantOutput, gotOutput); diff != "" {
+		return fmt.Errorf("output differs: %s", diff)
+	} else if wantStatus != gotStatus {
+		return fmt.Errorf("exit status %d, want %d", gotStatus, wantStatus)
+	}
+	return nil
+}
+
+// runSampleBinary runs a binary and gets its output and
+// exit status.
+func runSampleBinary(path string) ([]byte, int, error) {
+	output, err := exec.Command(path).CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return output, exitErr.ExitCode(), nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("run %s: %s", filepath.Base(path), err)
+	}
+	return output, 0, nil
+}
+
+// firstDifference describes the first line in which two
+// outputs differ, or returns "" if they are the same.
+func firstDifference(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	wantLines := strings.Split(string(want), "\n")
+	gotLines := strings.Split(string(got), "\n")
+	for i := 0; ; i++ {
+		if i >= len(wantLines) || i >= len(gotLines) {
+			return fmt.Sprintf("got %d lines, want %d", len(gotLines), len(wantLines))
+		}
+		if wantLines[i] != gotLines[i] {
+			return fmt.Sprintf("line %d is %q, want %q", i+1, gotLines[i], wantLines[i])
+		}
+	}
+}
+
+// selftestSamples are the programs which the selftest
+// subcommand checks, each covering code which renaming can
+// break.
+var selftestSamples = []selftestSample{
+	{
+		Name: "reflection",
+		Files: map[string]string{
+			"main.go": `package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+type Config struct {
+	Name   string         ` + "`json:\"name\"`" + `
+	Port   int            ` + "`json:\"port\"`" + `
+	Tags   []string       ` + "`json:\"tags,omitempty\"`" + `
+	Limits map[string]int ` + "`json:\"limits\"`" + `
+	secret string
+}
+
+type Greeter struct {
+	Greeting string ` + "`json:\"greeting\"`" + `
+}
+
+// Hello is called through reflection.
+//
+//gobfuscate:keep
+func (g Greeter) Hello(name string) string {
+	return g.Greeting + ", " + name
+}
+
+const input = ` + "`" + `{"name":"server","port":8080,"tags":["a","b"],"limits":{"conn":10,"rate":5}}` + "`" + `
+
+func main() {
+	var cfg Config
+	if err := json.Unmarshal([]byte(input), &cfg); err != nil {
+		fmt.Println("unmarshal:", err)
+		os.Exit(1)
+	}
+	cfg.secret = "hidden"
+	fmt.Println(cfg.Name, cfg.Port, cfg.Tags, cfg.Limits["conn"], cfg.Limits["rate"], len(cfg.secret))
+
+	out, err := json.Marshal(cfg)
+	if err != nil {
+		fmt.Println("marshal:", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+	var copied Config
+	if err := json.Unmarshal(out, &copied); err != nil {
+		fmt.Println("unmarshal copy:", err)
+		os.Exit(1)
+	}
+	fmt.Println(reflect.DeepEqual(copied.Limits, cfg.Limits), reflect.DeepEqual(copied.Tags, cfg.Tags))
+
+	t := reflect.TypeOf(cfg)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fmt.Println(i, field.Type.Kind(), field.Tag.Get("json"), field.IsExported())
+	}
+
+	method := reflect.ValueOf(Greeter{Greeting: "hello"}).MethodByName("Hello")
+	if !method.IsValid() {
+		fmt.Println("method not found")
+		os.Exit(1)
+	}
+	fmt.Println(method.Call([]reflect.Value{reflect.ValueOf("world")})[0].String())
+}
+`,
+		},
+	},
+	{
+		Name: "embedding",
+		Files: map[string]string{
+			"greeting.txt": "Hello from an embedded file.\n",
+			"main.go": `package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"selftest/embedding/shapes"
+)
+
+//go:embed greeting.txt
+var greeting string
+
+// service embeds both a struct and an interface, so that
+// it gets their methods.
+type service struct {
+	*shapes.Logger
+	shapes.Named
+}
+
+func main() {
+	fmt.Print(greeting)
+	all := []shapes.Named{
+		shapes.Rect{Base: shapes.Base{Label: "rect"}, W: 2, H: 3},
+		shapes.Circle{Base: &shapes.Base{Label: "circle"}, R: 1},
+	}
+	total := 0.0
+	for _, s := range all {
+		svc := service{Logger: &shapes.Logger{}, Named: s}
+		svc.Logf("%s area=%.3f perimeter=%.3f", svc.Name(), svc.Area(), svc.Perimeter())
+		fmt.Println(strings.Join(svc.Lines(), "\n"))
+		total += s.Area()
+	}
+	fmt.Printf("total %.3f\n", total)
+	os.Exit(3)
+}
+`,
+			"shapes/shapes.go": `package shapes
+
+import (
+	"fmt"
+	"math"
+)
+
+type Shape interface {
+	Area() float64
+	Perimeter() float64
+}
+
+type Named interface {
+	Shape
+	Name() string
+}
+
+type Base struct {
+	Label string
+}
+
+func (b Base) Name() string {
+	return b.Label
+}
+
+type Rect struct {
+	Base
+	W, H float64
+}
+
+func (r Rect) Area() float64 {
+	return r.W * r.H
+}
+
+func (r Rect) Perimeter() float64 {
+	return 2 * (r.W + r.H)
+}
+
+type Circle struct {
+	*Base
+	R float64
+}
+
+func (c Circle) Area() float64 {
+	return math.Pi * c.R * c.R
+}
+
+func (c Circle) Perimeter() float64 {
+	return 2 * math.Pi * c.R
+}
+
+type Logger struct {
+	lines []string
+}
+
+func (l *Logger) Logf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Lines() []string {
+	return l.lines
+}
+`,
+		},
+	},
+	{
+		Name: "generics",
+		Files: map[string]string{
+			"main.go": `package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+func Sum[T Number](values []T) T {
+	var total T
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func Map[T, U any](values []T, f func(T) U) []U {
+	res := make([]U, 0, len(values))
+	for _, v := range values {
+		res = append(res, f(v))
+	}
+	return res
+}
+
+type Stack[T any] struct {
+	items []T
+}
+
+func (s *Stack[T]) Push(v T) {
+	s.items = append(s.items, v)
+}
+
+func (s *Stack[T]) Pop() (T, bool) {
+	var zero T
+	if len(s.items) == 0 {
+		return zero, false
+	}
+	v := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return v, true
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+func SortedPairs[K ~string, V any](m map[K]V) []Pair[K, V] {
+	var res []Pair[K, V]
+	for k, v := range m {
+		res = append(res, Pair[K, V]{k, v})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+type Celsius float64
+
+func (c Celsius) String() string {
+	return fmt.Sprintf("%.1fC", float64(c))
+}
+
+func main() {
+	fmt.Println(Sum([]int{1, 2, 3}), Sum([]Celsius{20.5, 1.25}))
+	fmt.Println(Map([]string{"a", "bc"}, strings.ToUpper), Map([]int{1, 2}, func(i int) Celsius { return Celsius(i) }))
+
+	var s Stack[string]
+	s.Push("first")
+	s.Push("second")
+	for {
+		v, ok := s.Pop()
+		if !ok {
+			break
+		}
+		fmt.Println(v)
+	}
+	fmt.Println(SortedPairs(map[string]int{"b": 2, "a": 1, "c": 3}))
+}
+`,
+		},
+	},
+	{
+		Name: "cgo",
+		CGO:  true,
+		// Static linking needs the static C libraries,
+		// which the sample is not about.
+		Flags: []string{"-nostatic"},
+		Files: map[string]string{
+			"main.go": `package main
+
+/*
+#include <stdlib.h>
+
+static int add(int a, int b) { return a + b; }
+static const char *greeting(void) { return "hello from C"; }
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func main() {
+	fmt.Println(C.add(2, 3))
+	fmt.Println(C.GoString(C.greeting()))
+	cs := C.CString("round trip")
+	defer C.free(unsafe.Pointer(cs))
+	fmt.Println(C.GoString(cs))
+}
+`,
+		},
+	},
+}
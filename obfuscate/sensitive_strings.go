@@ -0,0 +1,93 @@
+package obfuscate
+
+import (
+	"math"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// A SensitiveString is a string literal which looks like
+// it holds valuable information, such as a URL or a
+// credential.
+type SensitiveString struct {
+	// Package is the original import path of the package.
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Value   string `json:"value"`
+
+	// Kind is the kind of information, as given by
+	// sensitiveKind.
+	Kind string `json:"kind"`
+
+	Obfuscated bool `json:"obfuscated"`
+}
+
+var (
+	urlExpr         = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"]+`)
+	ipv4Expr        = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	registryKeyExpr = regexp.MustCompile(`(?i)^(HKEY_[A-Z_]+|HKLM|HKCU|HKCR|HKU|HKCC)(\\|$)`)
+	unixPathExpr    = regexp.MustCompile(`^~?/[\w.-]+(/[\w.-]*)+$`)
+	windowsPathExpr = regexp.MustCompile(`^([a-zA-Z]:\\|\\\\[\w.-]+\\)`)
+	apiKeyExpr      = regexp.MustCompile(`\b(AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_-]{35}|gh[pousr]_[0-9A-Za-z]{36}|` +
+		`github_pat_\w{22,}|xox[abprs]-[0-9A-Za-z-]{10,}|sk_live_[0-9A-Za-z]{20,}|sk-[0-9A-Za-z_-]{20,})`)
+	tokenExpr = regexp.MustCompile(`^[A-Za-z0-9_\-+/=.]{20,}$`)
+)
+
+// sensitiveKind guesses what kind of valuable information
+// a string holds: "private-key", "api-key", "registry-key",
+// "url", "ip", or "path".
+// It returns "" for strings which look harmless.
+func sensitiveKind(s string) string {
+	switch {
+	case strings.Contains(s, "-----BEGIN") && strings.Contains(s, "PRIVATE KEY"):
+		return "private-key"
+	case apiKeyExpr.MatchString(s) || looksLikeToken(s):
+		return "api-key"
+	case registryKeyExpr.MatchString(s):
+		return "registry-key"
+	case urlExpr.MatchString(s):
+		return "url"
+	case containsIP(s):
+		return "ip"
+	case unixPathExpr.MatchString(s) || windowsPathExpr.MatchString(s):
+		return "path"
+	}
+	return ""
+}
+
+func containsIP(s string) bool {
+	for _, match := range ipv4Expr.FindAllString(s, -1) {
+		if net.ParseIP(match) != nil {
+			return true
+		}
+	}
+	if strings.Count(s, ":") >= 2 {
+		host := strings.Trim(s, "[]")
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeToken checks if a string looks like a random
+// secret: a long word of mixed letters and digits with
+// high entropy.
+func looksLikeToken(s string) bool {
+	if !tokenExpr.MatchString(s) || !strings.ContainsAny(s, "0123456789") ||
+		strings.ToLower(s) == s || strings.ToUpper(s) == s {
+		return false
+	}
+	counts := map[rune]int{}
+	for _, ch := range s {
+		counts[ch]++
+	}
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= 4
+}
@@ -0,0 +1,232 @@
+package obfuscate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sourceArchive, if set, is a tar or zip archive of a
+// module which is obfuscated instead of code from the
+// GOPATH, or "-" to read the archive from stdin.
+var sourceArchive string
+
+// unpackSource unpacks the -src archive into a temporary
+// GOPATH, at the path of the module it holds, and enters
+// it like a remote checkout.
+// Package arguments like "./cmd/tool" are resolved against
+// the module path, and it returns the import path of the
+// first package.
+func unpackSource(pkgName string) (*sourceCheckout, error) {
+	gopath, err := newTempDir("source")
+	if err != nil {
+		return nil, stepError("create temp dir", err)
+	}
+	modPath, dir, err := unpackModule(sourceArchive, gopath)
+	if err != nil {
+		os.RemoveAll(gopath)
+		return nil, stepError("unpack source archive", err)
+	}
+	log.Println("Unpacked module", modPath)
+	for i, pkg := range extraPackages {
+		extraPackages[i] = archivePackage(modPath, pkg)
+	}
+	return enterCheckout(gopath, dir, archivePackage(modPath, pkgName))
+}
+
+func archivePackage(modPath, pkgName string) string {
+	if build.IsLocalImport(pkgName) {
+		return path.Join(modPath, pkgName)
+	}
+	return pkgName
+}
+
+// unpackModule extracts an archive into a GOPATH and moves
+// the module in it to its path in the GOPATH. The go.mod
+// file may be at the root of the archive, or in its only
+// directory, as with "git archive --prefix".
+// It returns the module path and the root of the module.
+func unpackModule(archive, gopath string) (string, string, error) {
+	var r io.Reader = os.Stdin
+	if archive != "-" {
+		f, err := os.Open(archive)
+		if err != nil {
+			return "", "", err
+		}
+		defer f.Close()
+		r = f
+	}
+	log.Println("Unpacking source archive...")
+	tmpDir := filepath.Join(gopath, "unpack")
+	if err := extractArchive(r, tmpDir); err != nil {
+		return "", "", err
+	}
+
+	root := tmpDir
+	if _, err := os.Stat(filepath.Join(root, "go.mod")); os.IsNotExist(err) {
+		listing, err := ioutil.ReadDir(root)
+		if err != nil {
+			return "", "", err
+		}
+		if len(listing) != 1 || !listing[0].IsDir() {
+			return "", "", errors.New("no go.mod file at the root of the archive")
+		}
+		root = filepath.Join(root, listing[0].Name())
+	}
+	modPath, err := readModulePath(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", "", err
+	}
+	if err := checkModulePath(modPath); err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(modPath))
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(root, dir); err != nil {
+		return "", "", err
+	}
+	return modPath, dir, nil
+}
+
+// checkModulePath makes sure that a module path read from
+// an untrusted go.mod file names a directory under the
+// GOPATH's src directory, and not one elsewhere, like
+// "../../etc" or "/tmp/x".
+// Like module paths in general, each element may only have
+// ASCII letters, digits, and the characters "-._~+".
+func checkModulePath(modPath string) error {
+	if modPath == "" || path.IsAbs(modPath) || filepath.IsAbs(modPath) || filepath.VolumeName(modPath) != "" {
+		return fmt.Errorf("invalid module path %q", modPath)
+	}
+	for _, elem := range strings.Split(modPath, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return fmt.Errorf("invalid module path %q", modPath)
+		}
+		for _, c := range elem {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+				strings.ContainsRune("-._~+", c)) {
+				return fmt.Errorf("invalid module path %q", modPath)
+			}
+		}
+	}
+	return nil
+}
+
+// extractArchive extracts a zip file, a tar file, or a
+// gzipped tar file, which is told apart by its first bytes,
+// into a new directory.
+// Only regular files and directories are extracted, and
+// entries whose paths leave the directory are rejected.
+func extractArchive(r io.Reader, dir string) error {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		// Zip files are read from their end, so they are read
+		// into memory first, which also works for stdin.
+		data, err := ioutil.ReadAll(buffered)
+		if err != nil {
+			return err
+		}
+		return extractZip(data, dir)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTar(gz, dir)
+	default:
+		return extractTar(buffered, dir)
+	}
+}
+
+func extractTar(r io.Reader, dir string) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read tar: %s", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = extractEntry(dir, header.Name, true, nil)
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractEntry(dir, header.Name, false, archive)
+		case tar.TypeXGlobalHeader:
+			// git archive stores the commit in a global header.
+		default:
+			log.Println("Warning: skipping", header.Name, "in source archive (not a regular file)")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(data []byte, dir string) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("read zip: %s", err)
+	}
+	for _, file := range archive.File {
+		mode := file.Mode()
+		if mode.IsDir() {
+			err = extractEntry(dir, file.Name, true, nil)
+		} else if mode.IsRegular() {
+			var contents io.ReadCloser
+			if contents, err = file.Open(); err == nil {
+				err = extractEntry(dir, file.Name, false, contents)
+				contents.Close()
+			}
+		} else {
+			log.Println("Warning: skipping", file.Name, "in source archive (not a regular file)")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractEntry writes a file or directory of an archive
+// below dir.
+func extractEntry(dir, name string, isDir bool, contents io.Reader) error {
+	clean := path.Clean(strings.Replace(name, "\\", "/", -1))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || filepath.VolumeName(clean) != "" {
+		return fmt.Errorf("unsafe path in archive: %s", name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(clean))
+	if isDir {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, contents)
+	return err
+}
@@ -0,0 +1,52 @@
+package obfuscate
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckModulePath(t *testing.T) {
+	for _, modPath := range []string{"example.com/tool", "github.com/org/repo/v2", "corp_tool", "x.io/a~b+c"} {
+		if err := checkModulePath(modPath); err != nil {
+			t.Errorf("%s: %s", modPath, err)
+		}
+	}
+	for _, modPath := range []string{"", "/tmp/x", "../x", "example.com/../../x", "example.com//x",
+		"example.com/./x", "example.com/", `example.com\..\x`, "C:/x", "example.com/a b"} {
+		if err := checkModulePath(modPath); err == nil {
+			t.Errorf("%q should be rejected", modPath)
+		}
+	}
+}
+
+func TestUnpackModuleRejectsPath(t *testing.T) {
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	contents := []byte("module ../../escaped\n")
+	if err := archive.WriteHeader(&tar.Header{Name: "go.mod", Mode: 0644, Size: int64(len(contents)),
+		Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	archive.Write(contents)
+	archive.Close()
+
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "src.tar")
+	if err := ioutil.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(dir, "a", "gopath")
+	if err := os.MkdirAll(gopath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := unpackModule(archivePath, gopath); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Error("the module was moved out of the GOPATH")
+	}
+}
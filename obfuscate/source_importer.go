@@ -0,0 +1,138 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// A sourceImporter type-checks packages from the sources in
+// a GOPATH and GOROOT, as the pure Go versions which are
+// built without cgo.
+type sourceImporter struct {
+	// Visit is called with every package, and its files,
+	// once it has been type-checked.
+	Visit func(pkg *types.Package, files []*ast.File, info *types.Info)
+
+	// Cgo makes the GOPATH packages be checked as they are
+	// built with cgo, including the files which import "C",
+	// against a fake "C" package. The standard library is
+	// still checked without cgo, since its cgo files do not
+	// type-check against the fake package.
+	Cgo bool
+
+	ctx  build.Context
+	set  *token.FileSet
+	pkgs map[string]*types.Package
+
+	// broken are the packages with type errors, in their
+	// own files or those of their dependencies.
+	broken map[string]bool
+}
+
+// gopathContext is build.Default with another GOPATH, in
+// which imports are resolved in GOPATH mode, whatever
+// GO111MODULE is set to.
+// go/build asks the go command to resolve imports, in
+// module mode by default, unless one of the file system
+// hooks of the context is set; the go command cannot find
+// the packages of a workspace's GOPATH in module mode.
+func gopathContext(gopath string) build.Context {
+	ctx := build.Default
+	ctx.GOPATH = gopath
+	ctx.JoinPath = filepath.Join
+	return ctx
+}
+
+func newSourceImporter(gopath string) *sourceImporter {
+	ctx := gopathContext(gopath)
+	ctx.CgoEnabled = false
+	return &sourceImporter{
+		ctx:  ctx,
+		set:  token.NewFileSet(),
+		pkgs: map[string]*types.Package{},
+
+		broken: map[string]bool{},
+	}
+}
+
+// TypeChecks checks if a package which was imported, and
+// its dependencies, type-checked without errors.
+func (s *sourceImporter) TypeChecks(path string) bool {
+	pkg, ok := s.pkgs[path]
+	return ok && pkg != nil && !s.broken[path]
+}
+
+func (s *sourceImporter) Import(path string) (*types.Package, error) {
+	return s.ImportFrom(path, filepath.Join(s.ctx.GOPATH, "src"), 0)
+}
+
+func (s *sourceImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	bp, err := s.findPackage(path, dir)
+	if err != nil {
+		return nil, err
+	}
+	if pkg, ok := s.pkgs[bp.ImportPath]; ok {
+		if pkg == nil && s.broken[bp.ImportPath] {
+			return nil, fmt.Errorf("cannot parse %s", bp.ImportPath)
+		} else if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", bp.ImportPath)
+		}
+		return pkg, nil
+	}
+	s.pkgs[bp.ImportPath] = nil
+
+	var files []*ast.File
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		file, err := parser.ParseFile(s.set, filepath.Join(bp.Dir, name), nil, parser.ParseComments)
+		if err != nil {
+			s.broken[bp.ImportPath] = true
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	info := newTypesInfo()
+	conf := types.Config{Importer: s, FakeImportC: s.Cgo, Error: func(error) {
+		s.broken[bp.ImportPath] = true
+	}}
+	pkg, _ := conf.Check(bp.ImportPath, s.set, files, info)
+	s.pkgs[bp.ImportPath] = pkg
+	for _, imported := range pkg.Imports() {
+		if s.broken[imported.Path()] {
+			s.broken[bp.ImportPath] = true
+		}
+	}
+	if s.Visit != nil {
+		s.Visit(pkg, files, info)
+	}
+	return pkg, nil
+}
+
+// findPackage finds the files of a package which are
+// type-checked.
+func (s *sourceImporter) findPackage(path, dir string) (*build.Package, error) {
+	bp, err := s.ctx.Import(path, dir, 0)
+	if err != nil || !s.Cgo || bp.Goroot {
+		return bp, err
+	}
+	ctx := s.ctx
+	ctx.CgoEnabled = true
+	return ctx.Import(path, dir, 0)
+}
+
+func newTypesInfo() *types.Info {
+	return &types.Info{
+		Types:     map[ast.Expr]types.TypeAndValue{},
+		Defs:      map[*ast.Ident]types.Object{},
+		Uses:      map[*ast.Ident]types.Object{},
+		Implicits: map[ast.Node]types.Object{},
+		Scopes:    map[ast.Node]*types.Scope{},
+	}
+}
@@ -0,0 +1,120 @@
+package obfuscate
+
+import (
+	"debug/elf"
+	"os/exec"
+	"strings"
+)
+
+// staticTags are build tags added to statically linked
+// linux builds, so that the net and os/user packages do
+// not need the C library at run time.
+var staticTags string
+
+// staticLinux checks if a target is linked statically
+// against its C library, which only applies to linux.
+func staticLinux(target buildTarget) bool {
+	return !noStaticLink && target.GOOS == "linux" && !sharedBuild()
+}
+
+// compilerLibc finds which C library a C compiler links
+// against: "glibc", "musl", or "" if it is unknown.
+// An empty compiler name means the go tool's default.
+func compilerLibc(cc string) string {
+	if cc == "" {
+		output, err := exec.Command("go", "env", "CC").Output()
+		if err != nil {
+			return ""
+		}
+		cc = strings.TrimSpace(string(output))
+	}
+	fields := strings.Fields(cc)
+	if len(fields) == 0 {
+		return ""
+	}
+	output, err := exec.Command(fields[0], append(fields[1:], "-dumpmachine")...).Output()
+	if err != nil {
+		return ""
+	}
+	machine := string(output)
+	if strings.Contains(machine, "musl") {
+		return "musl"
+	} else if strings.Contains(machine, "gnu") {
+		return "glibc"
+	}
+	return ""
+}
+
+// dynamicDependencies lists what a linux binary needs at
+// run time to be loaded: its interpreter, and its shared
+// libraries.
+// Static binaries need nothing.
+func dynamicDependencies(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []string
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			data := make([]byte, prog.Filesz)
+			if _, err := prog.ReadAt(data, 0); err != nil {
+				return nil, err
+			}
+			res = append(res, "interpreter "+strings.TrimRight(string(data), "\x00"))
+		}
+	}
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+	for _, lib := range libs {
+		res = append(res, "library "+lib)
+	}
+	return res, nil
+}
+
+// checkStaticBinary makes sure that a binary which should
+// be statically linked is, explaining the likely causes if
+// it is not.
+func checkStaticBinary(path string, target buildTarget, cgo bool, libc string) error {
+	deps, err := dynamicDependencies(path)
+	if err != nil {
+		return stepError("check static linking", err)
+	} else if len(deps) == 0 {
+		return nil
+	}
+	lines := []string{"binary for " + target.String() + " needs:"}
+	for _, dep := range deps {
+		lines = append(lines, "  "+dep)
+	}
+	lines = append(lines, "Likely causes:")
+	tagSet := strings.Split(targetTags(target), ",")
+	if !cgo {
+		lines = append(lines, "  none known: cgo is disabled, so the Go linker should have "+
+			"produced a static binary")
+	} else if !containsString(tagSet, "netgo") || !containsString(tagSet, "osusergo") {
+		lines = append(lines, "  the net and os/user packages use the C library without the "+
+			"netgo and osusergo tags (see -statictags)")
+	}
+	if cgo && libc == "glibc" {
+		lines = append(lines, "  cgo code is linked against glibc, which does not fully support static "+
+			"linking; use a musl compiler (e.g. CC_"+target.GOOS+"_"+target.GOARCH+"=musl-gcc)")
+	}
+	if cgo {
+		lines = append(lines, "  a C library used through cgo is only installed as a shared library; "+
+			"install its static (.a) version")
+	}
+	lines = append(lines, "Use -nostatic to allow dynamic linking.")
+	return &CheckError{Kind: "Dynamically linked", Problems: []string{strings.Join(lines, "\n")}}
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
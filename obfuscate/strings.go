@@ -0,0 +1,549 @@
+package obfuscate
+
+import (
+	"bufio"
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A StringCoverage describes how many string literals the
+// string pass obfuscated, and which ones it left alone.
+type StringCoverage struct {
+	Obfuscated int               `json:"obfuscated"`
+	Plaintext  []PlaintextString `json:"plaintext"`
+
+	// Numbers counts the numeric constants replaced with
+	// -literals.
+	Numbers int `json:"numbers,omitempty"`
+
+	// Sensitive lists the literals which look like they hold
+	// valuable information, whether they were obfuscated or
+	// not.
+	Sensitive []SensitiveString `json:"sensitive"`
+
+	// Literals lists the obfuscated literals, for mapping
+	// files.
+	Literals []ObfuscatedString `json:"-"`
+}
+
+// An ObfuscatedString is a string literal which was
+// obfuscated.
+type ObfuscatedString struct {
+	// Package is the original import path of the package.
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Value   string `json:"value"`
+}
+
+// A PlaintextString is a string literal which was left in
+// plaintext.
+type PlaintextString struct {
+	// Package is the original import path of the package.
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Value   string `json:"value"`
+
+	// Reason is "const" for literals in constant
+	// declarations which cannot become variables,
+	// "struct-tag" for struct tags, "cgo" for C string
+	// literals in cgo preambles, "skip" for literals in
+	// code marked with //gobfuscate:skip, or "unparsed"
+	// for literals in files which could not be parsed.
+	Reason string `json:"reason"`
+}
+
+// ObfuscateStrings replaces the string literals in a
+// GOPATH with calls to the helper package, which decode
+// them at runtime, and generates the helper package.
+// Packages for which include returns false are skipped.
+func ObfuscateStrings(gopath string, moves PackageMoves, include func(string) bool,
+	helper *stringHelper) (*StringCoverage, error) {
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return nil, err
+	}
+	res := &StringCoverage{}
+	if numericLiterals {
+		res.Numbers, err = ObfuscateNumbers(files, helper)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var resLock sync.Mutex
+	err = forEachFile(files, func(f sourceFile) error {
+		coverage, err := obfuscateFileStrings(f.Path, helper)
+		if err != nil {
+			return err
+		}
+		resLock.Lock()
+		defer resLock.Unlock()
+		res.Obfuscated += coverage.Obfuscated
+		for _, plain := range coverage.Plaintext {
+			plain.Package = moves.Original(f.PkgPath)
+			res.Plaintext = append(res.Plaintext, plain)
+		}
+		for _, sensitive := range coverage.Sensitive {
+			sensitive.Package = moves.Original(f.PkgPath)
+			res.Sensitive = append(res.Sensitive, sensitive)
+		}
+		for _, literal := range coverage.Literals {
+			literal.Package = moves.Original(f.PkgPath)
+			res.Literals = append(res.Literals, literal)
+		}
+		return nil
+	})
+	sort.Slice(res.Plaintext, func(i, j int) bool {
+		p1, p2 := res.Plaintext[i], res.Plaintext[j]
+		return positionLess(p1.Package, p1.File, p1.Line, p2.Package, p2.File, p2.Line)
+	})
+	sort.Slice(res.Sensitive, func(i, j int) bool {
+		s1, s2 := res.Sensitive[i], res.Sensitive[j]
+		return positionLess(s1.Package, s1.File, s1.Line, s2.Package, s2.File, s2.Line)
+	})
+	sort.SliceStable(res.Literals, func(i, j int) bool {
+		l1, l2 := res.Literals[i], res.Literals[j]
+		return positionLess(l1.Package, l1.File, l1.Line, l2.Package, l2.File, l2.Line)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, helper.Write(gopath)
+}
+
+// PlaintextSensitive counts the sensitive literals which
+// were left in plaintext.
+func (s *StringCoverage) PlaintextSensitive() int {
+	var res int
+	for _, sensitive := range s.Sensitive {
+		if !sensitive.Obfuscated {
+			res++
+		}
+	}
+	return res
+}
+
+func positionLess(pkg1, file1 string, line1 int, pkg2, file2 string, line2 int) bool {
+	if pkg1 != pkg2 {
+		return pkg1 < pkg2
+	} else if file1 != file2 {
+		return file1 < file2
+	}
+	return line1 < line2
+}
+
+// obfuscateFileStrings replaces the string literals in a
+// single file, importing the helper package if there are
+// any.
+func obfuscateFileStrings(path string, helper *stringHelper) (*StringCoverage, error) {
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	stringConstsToVar(path, original, buf)
+	contents := buf.Bytes()
+
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, contents, parser.ParseComments)
+	if err != nil {
+		coverage := unparsedStrings(path, original)
+		coverage.Sensitive = sensitiveStrings(nil, nil, coverage.Plaintext)
+		return coverage, nil
+	}
+
+	obfuscator := &stringObfuscator{
+		Contents:  contents,
+		Set:       set,
+		Helper:    helper,
+		Rand:      newKeyRand("strings", original),
+		ImportPos: set.Position(file.Name.End()).Offset,
+		Imported:  importsPath(file, helper.Path),
+		Encrypted: findEncryptedCode(set, file, contents),
+	}
+	if importsC(file) {
+		obfuscator.CStrings = map[*ast.BasicLit]*ast.CallExpr{}
+	}
+	skipped := findSkippedCode(file)
+	for _, decl := range file.Decls {
+		if skipped.Contains(decl) {
+			obfuscator.skip(decl, "skip")
+			continue
+		}
+		ast.Walk(obfuscator, decl)
+	}
+	obfuscator.cgoStrings(file)
+	coverage := &StringCoverage{
+		Obfuscated: len(obfuscator.Nodes),
+		Plaintext:  obfuscator.Plain,
+		Sensitive:  sensitiveStrings(set, obfuscator.Nodes, obfuscator.Plain),
+	}
+	for _, lit := range obfuscator.Nodes {
+		value, _ := strconv.Unquote(lit.Value)
+		pos := set.Position(lit.Pos())
+		coverage.Literals = append(coverage.Literals, ObfuscatedString{
+			File:  filepath.Base(pos.Filename),
+			Line:  pos.Line,
+			Value: value,
+		})
+	}
+	if len(obfuscator.Nodes) == 0 && bytes.Equal(contents, original) {
+		return coverage, nil
+	}
+	return coverage, rewriteFile(path, obfuscator.Write)
+}
+
+type stringObfuscator struct {
+	Contents []byte
+	Set      *token.FileSet
+	Nodes    []*ast.BasicLit
+	Plain    []PlaintextString
+	Helper   *stringHelper
+
+	// Rand picks the keys of the literals.
+	Rand *rand.Rand
+
+	// ImportPos is the offset after the package clause,
+	// where the helper package is imported, unless Imported
+	// is set because the numbers pass already imported it.
+	ImportPos int
+	Imported  bool
+
+	// Hoisted are the literals in switch cases and map
+	// literal keys, which are decoded once into variables
+	// rather than every time the switch or literal is
+	// evaluated.
+	Hoisted map[*ast.BasicLit]bool
+
+	// Encrypted finds the literals which are encrypted
+	// with AES rather than encoded.
+	Encrypted *encryptedCode
+
+	// CStrings maps the literals which are passed to
+	// C.CString to their calls, which are replaced as a
+	// whole. It is only set for files which import "C".
+	CStrings map[*ast.BasicLit]*ast.CallExpr
+}
+
+func (s *stringObfuscator) Visit(n ast.Node) ast.Visitor {
+	if lit, ok := n.(*ast.BasicLit); ok {
+		if lit.Kind == token.STRING {
+			s.Nodes = append(s.Nodes, lit)
+		}
+		return nil
+	} else if decl, ok := n.(*ast.GenDecl); ok {
+		if decl.Tok == token.IMPORT {
+			return nil
+		} else if decl.Tok == token.CONST {
+			s.skip(decl, "const")
+			return nil
+		}
+	} else if st, ok := n.(*ast.StructType); ok {
+		// Avoid messing with annotation strings.
+		s.skip(st, "struct-tag")
+		return nil
+	} else if clause, ok := n.(*ast.CaseClause); ok {
+		for _, expr := range clause.List {
+			s.hoist(expr)
+		}
+	} else if lit, ok := n.(*ast.CompositeLit); ok {
+		for _, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				s.hoist(kv.Key)
+			}
+		}
+	} else if call, ok := n.(*ast.CallExpr); ok && s.CStrings != nil {
+		if lit := cStringLiteral(call); lit != nil {
+			s.CStrings[lit] = call
+		}
+	}
+	return s
+}
+
+// cStringLiteral finds the string literal which a call
+// passes to C.CString, if it is one.
+func cStringLiteral(call *ast.CallExpr) *ast.BasicLit {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "CString" || len(call.Args) != 1 {
+		return nil
+	}
+	if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "C" || x.Obj != nil {
+		return nil
+	}
+	arg := call.Args[0]
+	for {
+		paren, ok := arg.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		arg = paren.X
+	}
+	if lit, ok := arg.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+		return lit
+	}
+	return nil
+}
+
+// importsC checks if a file uses cgo.
+func importsC(file *ast.File) bool {
+	for _, spec := range file.Imports {
+		if spec.Path.Value == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stringObfuscator) hoist(expr ast.Expr) {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+	if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+		if s.Hoisted == nil {
+			s.Hoisted = map[*ast.BasicLit]bool{}
+		}
+		s.Hoisted[lit] = true
+	}
+}
+
+// skip records the string literals in a node as being
+// left in plaintext.
+func (s *stringObfuscator) skip(n ast.Node, reason string) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			value, _ := strconv.Unquote(lit.Value)
+			pos := s.Set.Position(lit.Pos())
+			s.Plain = append(s.Plain, PlaintextString{
+				File:   filepath.Base(pos.Filename),
+				Line:   pos.Line,
+				Value:  value,
+				Reason: reason,
+			})
+		}
+		return true
+	})
+}
+
+// cLiteralExpr matches string literals in C code.
+var cLiteralExpr = regexp.MustCompile(`"(\\.|[^"\\\n])*"`)
+
+// cgoStrings records the string literals in the cgo
+// preamble of a file, which is compiled as C code.
+func (s *stringObfuscator) cgoStrings(file *ast.File) {
+	for _, decl := range file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT || d.Doc == nil || len(d.Specs) != 1 ||
+			d.Specs[0].(*ast.ImportSpec).Path.Value != `"C"` {
+			continue
+		}
+		for _, comment := range d.Doc.List {
+			pos := s.Set.Position(comment.Pos())
+			for i, line := range strings.Split(comment.Text, "\n") {
+				// Included file names do not end up in the binary.
+				if strings.Contains(line, "#include") {
+					continue
+				}
+				for _, lit := range cLiteralExpr.FindAllString(line, -1) {
+					s.Plain = append(s.Plain, PlaintextString{
+						File:   filepath.Base(pos.Filename),
+						Line:   pos.Line + i,
+						Value:  lit[1 : len(lit)-1],
+						Reason: "cgo",
+					})
+				}
+			}
+		}
+	}
+}
+
+// sensitiveStrings finds the sensitive literals among the
+// obfuscated and plaintext literals of a file.
+func sensitiveStrings(set *token.FileSet, obfuscated []*ast.BasicLit,
+	plain []PlaintextString) []SensitiveString {
+	var res []SensitiveString
+	for _, lit := range obfuscated {
+		value, _ := strconv.Unquote(lit.Value)
+		if kind := sensitiveKind(value); kind != "" {
+			pos := set.Position(lit.Pos())
+			res = append(res, SensitiveString{
+				File:       filepath.Base(pos.Filename),
+				Line:       pos.Line,
+				Value:      value,
+				Kind:       kind,
+				Obfuscated: true,
+			})
+		}
+	}
+	for _, p := range plain {
+		if kind := sensitiveKind(p.Value); kind != "" {
+			res = append(res, SensitiveString{
+				File:  p.File,
+				Line:  p.Line,
+				Value: p.Value,
+				Kind:  kind,
+			})
+		}
+	}
+	return res
+}
+
+// unparsedStrings lists the string literals in a file
+// which could not be parsed, and so was left unchanged.
+func unparsedStrings(path string, contents []byte) *StringCoverage {
+	var scan scanner.Scanner
+	set := token.NewFileSet()
+	file := set.AddFile(path, -1, len(contents))
+	scan.Init(file, contents, nil, 0)
+	res := &StringCoverage{}
+	for {
+		pos, tok, lit := scan.Scan()
+		if tok == token.EOF {
+			break
+		} else if tok != token.STRING {
+			continue
+		}
+		value, _ := strconv.Unquote(lit)
+		res.Plaintext = append(res.Plaintext, PlaintextString{
+			File:   filepath.Base(path),
+			Line:   set.Position(pos).Line,
+			Value:  value,
+			Reason: "unparsed",
+		})
+	}
+	return res
+}
+
+// Write writes the file's contents with the string
+// literals replaced.
+func (s *stringObfuscator) Write(w *bufio.Writer) error {
+	sort.Sort(s)
+
+	parsed := make([]string, s.Len())
+	for i, n := range s.Nodes {
+		var err error
+		parsed[i], err = strconv.Unquote(n.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	data := s.Contents
+	lastIndex := s.ImportPos
+	w.Write(data[:lastIndex])
+	if len(s.Nodes) > 0 && !s.Imported && s.usesHelper() {
+		w.WriteString("\n" + s.Helper.ImportDecl())
+	}
+	// Hoisted literals are decoded by package-level
+	// variables, one for each value.
+	hoistedVars := map[string]string{}
+	hoistedSealed := map[string]bool{}
+	var hoistedValues []string
+	var lazy []lazyString
+	for i, node := range s.Nodes {
+		strVal := parsed[i]
+		startIdx := node.Pos() - 1
+		endIdx := node.End() - 1
+		if call, ok := s.CStrings[node]; ok {
+			// The literal is only decoded as part of the
+			// call.
+			startIdx, endIdx = call.Pos()-1, call.End()-1
+			w.Write(data[lastIndex:startIdx])
+			s.Helper.WriteCStringCall(w, s.Rand, strVal, s.Encrypted.Contains(node))
+			lastIndex = int(endIdx)
+			continue
+		}
+		w.Write(data[lastIndex:startIdx])
+		encrypted := s.Encrypted.Contains(node)
+		if decodesLazily() && !encrypted {
+			// Every literal is decoded by a function of its
+			// own, even in switch cases and map literal keys.
+			name := hexName(randomBytes(s.Rand, hashedSymbolSize))
+			lazy = append(lazy, lazyString{Name: name, Value: strVal})
+			w.WriteString(name + "()")
+		} else if s.Hoisted[node] && !ephemeralStrings() {
+			if _, ok := hoistedVars[strVal]; !ok {
+				hoistedVars[strVal] = hexName(randomBytes(s.Rand, hashedSymbolSize))
+				hoistedValues = append(hoistedValues, strVal)
+			}
+			hoistedSealed[strVal] = hoistedSealed[strVal] || encrypted
+			w.WriteString(hoistedVars[strVal])
+		} else if encrypted {
+			s.Helper.WriteSealedCall(w, s.Rand, strVal)
+		} else {
+			s.Helper.WriteCall(w, s.Rand, strVal)
+		}
+		lastIndex = int(endIdx)
+	}
+	if _, err := w.Write(data[lastIndex:]); err != nil {
+		return err
+	}
+	if len(hoistedValues) > 0 {
+		w.WriteString("\nvar (\n")
+		for _, value := range hoistedValues {
+			w.WriteString("\t" + hoistedVars[value] + " = ")
+			if hoistedSealed[value] {
+				s.Helper.WriteSealedCall(w, s.Rand, value)
+			} else {
+				s.Helper.WriteCall(w, s.Rand, value)
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(")\n")
+	}
+	for _, l := range lazy {
+		s.Helper.WriteLazyFunc(w, s.Rand, l.Name, l.Value)
+	}
+	return nil
+}
+
+// usesHelper checks if the replaced literals refer to the
+// helper package, which the functions of lazily decoded
+// strings only do to cache them.
+func (s *stringObfuscator) usesHelper() bool {
+	if !decodesLazily() || lazyStrings == "cache" {
+		return true
+	}
+	for _, node := range s.Nodes {
+		if _, ok := s.CStrings[node]; ok || s.Encrypted.Contains(node) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stringObfuscator) Len() int {
+	return len(s.Nodes)
+}
+
+func (s *stringObfuscator) Swap(i, j int) {
+	s.Nodes[i], s.Nodes[j] = s.Nodes[j], s.Nodes[i]
+}
+
+func (s *stringObfuscator) Less(i, j int) bool {
+	return s.Nodes[i].Pos() < s.Nodes[j].Pos()
+}
+
+// writeHexByte writes a byte as a \x escape sequence.
+func writeHexByte(w *bufio.Writer, b byte) {
+	const digits = "0123456789abcdef"
+	w.WriteString("\\x")
+	w.WriteByte(digits[b>>4])
+	w.WriteByte(digits[b&0xf])
+}
@@ -0,0 +1,321 @@
+package obfuscate
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stripLogs lists the logging calls to remove from the
+// obfuscated sources, like the patterns of logPattern.
+var stripLogs listFlag
+
+// logPatterns are the parsed -striplog patterns.
+var logPatterns []logPattern
+
+// A logPattern selects logging calls.
+//
+// It is written as "import/path" for every function of a
+// package, "import/path.Func" for one function, or
+// "*.Method" for every method (or package function) with a
+// name. A "=Name" suffix downgrades the calls to another
+// function of the same package (or method of the same
+// value) instead of removing them.
+type logPattern struct {
+	Package     string
+	Func        string
+	Replacement string
+}
+
+func parseLogPattern(pattern string) (logPattern, error) {
+	var res logPattern
+	name := pattern
+	if idx := strings.Index(name, "="); idx >= 0 {
+		name, res.Replacement = name[:idx], name[idx+1:]
+		if !token.IsIdentifier(res.Replacement) {
+			return res, errors.New("invalid replacement in " + pattern)
+		}
+	}
+	if strings.HasPrefix(name, "*.") {
+		res.Func = name[2:]
+		if !token.IsIdentifier(res.Func) {
+			return res, errors.New("invalid method name in " + pattern)
+		}
+		return res, nil
+	}
+	res.Package = name
+	base := name[strings.LastIndex(name, "/")+1:]
+	if dot := strings.LastIndex(base, "."); dot >= 0 && token.IsExported(base[dot+1:]) {
+		// Package paths like gopkg.in/yaml.v2 also contain
+		// dots, but no exported names.
+		res.Package = name[:len(name)-len(base)+dot]
+		res.Func = base[dot+1:]
+	}
+	if res.Package == "" {
+		return res, errors.New("invalid package in " + pattern)
+	}
+	if res.Func == "" && res.Replacement != "" {
+		return res, errors.New("cannot downgrade every function of a package: " + pattern)
+	}
+	return res, nil
+}
+
+// setupStripLogs parses the -striplog patterns.
+func setupStripLogs() error {
+	for _, pattern := range stripLogs {
+		p, err := parseLogPattern(pattern)
+		if err != nil {
+			return flagError("Invalid -striplog pattern: " + err.Error())
+		}
+		logPatterns = append(logPatterns, p)
+	}
+	return nil
+}
+
+// StripLogCalls removes (or downgrades) the logging calls
+// which match patterns in the packages of a GOPATH for
+// which include returns true.
+//
+// Only calls which are statements of their own are
+// removed, along with all of their arguments. The local
+// variables which the arguments use are kept used, and
+// imports which only the arguments used become blank
+// imports, so that the sources still compile.
+func StripLogCalls(gopath string, patterns []logPattern, moves PackageMoves, include func(string) bool) error {
+	var moved []logPattern
+	for _, p := range patterns {
+		if p.Package != "" {
+			p.Package = moves.Obfuscated(p.Package)
+		}
+		moved = append(moved, p)
+	}
+	files, err := listGoFiles(gopath, nil, include)
+	if err != nil {
+		return err
+	}
+	names := newImportNames(gopath)
+	return forEachFile(files, func(f sourceFile) error {
+		return stripFileLogCalls(f.Path, moved, names)
+	})
+}
+
+// importNames finds the names which packages are imported
+// by when imports have no explicit name.
+type importNames struct {
+	gopath string
+	lock   sync.Mutex
+	names  map[string]string
+}
+
+func newImportNames(gopath string) *importNames {
+	return &importNames{gopath: gopath, names: map[string]string{}}
+}
+
+func (i *importNames) Name(importPath string) string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if name, ok := i.names[importPath]; ok {
+		return name
+	}
+	name := path.Base(importPath)
+	ctx := gopathContext(i.gopath)
+	if pkg, err := ctx.Import(importPath, "", 0); err == nil {
+		name = pkg.Name
+	}
+	i.names[importPath] = name
+	return name
+}
+
+type fileImport struct {
+	Spec *ast.ImportSpec
+	Path string
+	Name string
+}
+
+func fileImports(file *ast.File, names *importNames) []fileImport {
+	var res []fileImport
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		imp := fileImport{Spec: spec, Path: importPath}
+		if spec.Name != nil {
+			imp.Name = spec.Name.Name
+		} else {
+			imp.Name = names.Name(importPath)
+		}
+		res = append(res, imp)
+	}
+	return res
+}
+
+func stripFileLogCalls(path string, patterns []logPattern, names *importNames) error {
+	set := token.NewFileSet()
+	file, err := parser.ParseFile(set, path, nil, 0)
+	if err != nil {
+		return err
+	}
+	imports := fileImports(file, names)
+	match := func(call *ast.CallExpr) *logPattern {
+		return matchCall(call, imports, patterns)
+	}
+
+	var edits []fileEdit
+	removed := map[ast.Node]bool{}
+	offset := func(pos token.Pos) int {
+		return set.Position(pos).Offset
+	}
+	removeStmts := func(stmts []ast.Stmt) {
+		for _, stmt := range stmts {
+			expr, ok := stmt.(*ast.ExprStmt)
+			if !ok {
+				continue
+			}
+			call, ok := expr.X.(*ast.CallExpr)
+			if !ok {
+				continue
+			}
+			if p := match(call); p != nil && p.Replacement == "" {
+				removed[stmt] = true
+				edits = append(edits, fileEdit{
+					Start: offset(stmt.Pos()),
+					End:   offset(stmt.End()),
+					Text:  keepUsedText(stmt),
+				})
+			}
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			removeStmts(n.List)
+		case *ast.CaseClause:
+			removeStmts(n.Body)
+		case *ast.CommClause:
+			removeStmts(n.Body)
+		case ast.Stmt:
+			return !removed[n]
+		case *ast.CallExpr:
+			if p := match(n); p != nil && p.Replacement != "" {
+				sel := n.Fun.(*ast.SelectorExpr)
+				edits = append(edits, fileEdit{
+					Start: offset(sel.Sel.Pos()),
+					End:   offset(sel.Sel.End()),
+					Text:  p.Replacement,
+				})
+			}
+		}
+		return true
+	})
+	if len(edits) == 0 {
+		return nil
+	}
+
+	edits = append(edits, blankUnusedImports(set, file, imports, removed)...)
+	return applyFileEdits(path, edits)
+}
+
+// matchCall finds the pattern of a call in a file with
+// some imports, if any.
+func matchCall(call *ast.CallExpr, imports []fileImport, patterns []logPattern) *logPattern {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	importPath := ""
+	if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil {
+		for _, imp := range imports {
+			if imp.Name == x.Name {
+				importPath = imp.Path
+			}
+		}
+	}
+	for i, p := range patterns {
+		if p.Func != "" && p.Func != sel.Sel.Name {
+			continue
+		}
+		if p.Package == "" || (importPath != "" && p.Package == importPath) {
+			return &patterns[i]
+		}
+	}
+	return nil
+}
+
+// blankUnusedImports turns the imports which only some
+// removed nodes used into blank imports, since the
+// packages may still need to be initialized.
+func blankUnusedImports(set *token.FileSet, file *ast.File, imports []fileImport,
+	removed map[ast.Node]bool) []fileEdit {
+	if len(removed) == 0 {
+		return nil
+	}
+	used := selectorNames(file, removed)
+	usedByRemoved := map[string]bool{}
+	for node := range removed {
+		for name := range selectorNames(node, nil) {
+			usedByRemoved[name] = true
+		}
+	}
+	var res []fileEdit
+	for _, imp := range imports {
+		if !usedByRemoved[imp.Name] || used[imp.Name] || imp.Path == "C" {
+			continue
+		}
+		start := imp.Spec.Path.Pos()
+		if imp.Spec.Name != nil {
+			start = imp.Spec.Name.Pos()
+		}
+		res = append(res, fileEdit{
+			Start: set.Position(start).Offset,
+			End:   set.Position(imp.Spec.Path.Pos()).Offset,
+			Text:  "_ ",
+		})
+	}
+	return res
+}
+
+// selectorNames collects the unresolved names which
+// selectors are used on in a node, like the names of
+// imports, skipping some nodes.
+func selectorNames(node ast.Node, skip map[ast.Node]bool) map[string]bool {
+	res := map[string]bool{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if skip[n] {
+			return false
+		}
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil {
+				res[x.Name] = true
+			}
+		}
+		return true
+	})
+	return res
+}
+
+// keepUsedText is a statement which uses the variables
+// that a removed statement used, or nothing if it used
+// none.
+func keepUsedText(stmt ast.Stmt) string {
+	var vars []string
+	seen := map[string]bool{}
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok || ident.Obj == nil || ident.Obj.Kind != ast.Var || seen[ident.Name] {
+			return true
+		}
+		// Variables declared by the statement itself, like
+		// closure parameters, go away with it.
+		if decl, ok := ident.Obj.Decl.(ast.Node); ok &&
+			decl.Pos() >= stmt.Pos() && decl.Pos() < stmt.End() {
+			return true
+		}
+		seen[ident.Name] = true
+		vars = append(vars, "_ = "+ident.Name)
+		return true
+	})
+	return strings.Join(vars, "; ")
+}
@@ -0,0 +1,210 @@
+package obfuscate
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A symbolAudit finds original names in the function
+// symbols of a binary, including those of the wrappers
+// which the compiler generates, like method values
+// (pkg.T.Method-fm), closures (pkg.Func.func1), and
+// pointer receiver wrappers (pkg.(*T).Method).
+type symbolAudit struct {
+	// Names are the original names of renamed symbols
+	// which are not declared anywhere in the workspace any
+	// more.
+	Names map[string]bool
+
+	// Packages are the import paths of the packages in
+	// the workspace, whose symbols are checked.
+	Packages map[string]bool
+}
+
+// newSymbolAudit collects the names to look for in the
+// binaries built from a workspace.
+func newSymbolAudit(gopath string, renames []symbolRenameReq, depRecord *renameRecord) (*symbolAudit, error) {
+	res := &symbolAudit{Names: map[string]bool{}, Packages: map[string]bool{}}
+	for _, r := range renames {
+		_, _, name := parseRenameQuery(r.OldName)
+		res.Names[name] = true
+	}
+	if depRecord != nil {
+		for symbol := range depRecord.Symbols {
+			res.Names[symbol[strings.LastIndex(symbol, ".")+1:]] = true
+		}
+	}
+
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resLock sync.Mutex
+	err = forEachFile(files, func(f sourceFile) error {
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, 0)
+		if err != nil {
+			// Files which cannot be parsed are not
+			// built either.
+			return nil
+		}
+		resLock.Lock()
+		defer resLock.Unlock()
+		res.Packages[f.PkgPath] = true
+		if file.Name.Name == "main" {
+			// The symbols of main packages are named
+			// after the package, not its path.
+			res.Packages["main"] = true
+		}
+		// Names which some code still declares (like
+		// methods of interfaces) may appear legitimately.
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				delete(res.Names, decl.Name.Name)
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if spec, ok := spec.(*ast.TypeSpec); ok {
+						delete(res.Names, spec.Name.Name)
+					}
+				}
+			}
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if iface, ok := n.(*ast.InterfaceType); ok {
+				for _, method := range iface.Methods.List {
+					for _, name := range method.Names {
+						delete(res.Names, name.Name)
+					}
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	return res, err
+}
+
+// Leaks finds the function symbols of the workspace's
+// packages in a binary which contain an original name.
+func (s *symbolAudit) Leaks(binPath string) ([]string, error) {
+	names, err := binaryFuncNames(binPath)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, name := range names {
+		pkg, rest := splitFuncSymbol(name)
+		if !s.Packages[pkg] {
+			continue
+		}
+		for _, part := range strings.Split(rest, ".") {
+			part = strings.TrimSuffix(strings.Trim(part, "(*)"), "-fm")
+			if idx := strings.Index(part, "["); idx >= 0 {
+				part = part[:idx]
+			}
+			if s.Names[part] {
+				res = append(res, name)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// splitFuncSymbol splits a function symbol, like
+// "a/b.(*T).M-fm", into its package path and the rest.
+func splitFuncSymbol(name string) (pkg, rest string) {
+	// Type arguments may contain dots and slashes.
+	base := name
+	if idx := strings.Index(base, "["); idx >= 0 {
+		base = base[:idx]
+	}
+	start := strings.LastIndex(base, "/") + 1
+	dot := strings.Index(base[start:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:start+dot], name[start+dot+1:]
+}
+
+// pclntabMagics start the function tables of the Go
+// releases which the audit can read.
+var pclntabMagics = [][]byte{
+	{0xf1, 0xff, 0xff, 0xff, 0, 0},
+	{0xf0, 0xff, 0xff, 0xff, 0, 0},
+	{0xfa, 0xff, 0xff, 0xff, 0, 0},
+	{0xfb, 0xff, 0xff, 0xff, 0, 0},
+}
+
+// binaryFuncNames reads the names of the functions in a
+// Go binary from its function table, which is kept when
+// the symbol table is stripped.
+func binaryFuncNames(path string) ([]string, error) {
+	data, err := pclntabData(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, 0))
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, fn := range table.Funcs {
+		res = append(res, fn.Name)
+	}
+	return res, nil
+}
+
+func pclntabData(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if file, err := elf.NewFile(f); err == nil {
+		if section := file.Section(".gopclntab"); section != nil {
+			return section.Data()
+		}
+		// Position independent binaries keep the table
+		// in the read-only data.
+		if section := file.Section(".data.rel.ro"); section != nil {
+			return findPclntab(section.Data())
+		}
+	} else if file, err := macho.NewFile(f); err == nil {
+		if section := file.Section("__gopclntab"); section != nil {
+			return section.Data()
+		}
+	} else if file, err := pe.NewFile(f); err == nil {
+		if section := file.Section(".rdata"); section != nil {
+			return findPclntab(section.Data())
+		}
+	}
+	return nil, errors.New("no Go function table found")
+}
+
+// findPclntab finds the function table in a section which
+// contains other data too.
+func findPclntab(data []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, magic := range pclntabMagics {
+		if idx := bytes.Index(data, magic); idx >= 0 {
+			return data[idx:], nil
+		}
+	}
+	return nil, errors.New("no Go function table found")
+}
@@ -0,0 +1,351 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var IgnoreMethods = map[string]bool{"main": true, "init": true}
+
+type symbolRenameReq struct {
+	OldName string
+	NewName string
+}
+
+// ObfuscateSymbols renames the top-level symbols, methods,
+// statement labels, and local names declared in the
+// packages of a GOPATH for which include returns true,
+// and hoists closures out of functions which keep their
+// names. Packages which use cgo are renamed by
+// ObfuscateCgoSymbols.
+// The top-level symbols and methods are renamed together,
+// by renameSymbols.
+// It returns the renames which succeeded, and the hoists
+// of HoistClosures.
+func ObfuscateSymbols(gopath string, n NameHasher, keep *KeepList,
+	include func(string) bool) ([]symbolRenameReq, []symbolRenameReq, error) {
+	removeDoNotEdit(gopath)
+	renames, err := topLevelRenames(gopath, n, keep, include)
+	if err != nil {
+		return nil, nil, fmt.Errorf("top-level renames: %s", err)
+	}
+	methods, err := methodRenames(gopath, n, keep, include)
+	if err != nil {
+		return nil, nil, fmt.Errorf("method renames: %s", err)
+	}
+	applied, err := renameSymbols(gopath, append(renames, methods...), include)
+	if err != nil {
+		return nil, nil, fmt.Errorf("symbol renaming: %s", err)
+	}
+	cgoApplied, err := ObfuscateCgoSymbols(gopath, n, keep, include)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cgo renaming: %s", err)
+	}
+	if err := ObfuscateLabels(gopath, n, include); err != nil {
+		return nil, nil, fmt.Errorf("label renaming: %s", err)
+	}
+	if err := ObfuscateLocals(gopath, n, include); err != nil {
+		return nil, nil, fmt.Errorf("local renaming: %s", err)
+	}
+	applied = append(applied, cgoApplied...)
+	hoists, err := HoistClosures(gopath, n, renamedNames(applied), include)
+	if err != nil {
+		return nil, nil, fmt.Errorf("closure hoisting: %s", err)
+	}
+	return applied, hoists, nil
+}
+
+func topLevelRenames(gopath string, n NameHasher, keep *KeepList,
+	include func(string) bool) ([]symbolRenameReq, error) {
+	files, err := listGoFiles(gopath, containsUnsupportedCode, include)
+	if err != nil {
+		return nil, err
+	}
+	var resLock sync.Mutex
+	res := map[symbolRenameReq]int{}
+	addRes := func(pkgPath, name string) {
+		prefix := "\"" + pkgPath + "\"."
+		oldName := prefix + name
+		newName := n.Hash(name)
+		resLock.Lock()
+		res[symbolRenameReq{oldName, newName}]++
+		resLock.Unlock()
+	}
+	err = forEachFile(files, func(f sourceFile) error {
+		pkgPath := f.PkgPath
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		// The declarations of external test packages are not
+		// named by the queries, and only used by their tests.
+		if strings.HasSuffix(file.Name.Name, "_test") {
+			return nil
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if strings.HasSuffix(f.Path, "_test.go") && isTestFunc(d.Name.Name) {
+					continue
+				}
+				if !IgnoreMethods[d.Name.Name] && d.Recv == nil && !keep.KeepsName(pkgPath, d.Name.Name) &&
+					!keepsName(file, d.Doc) {
+					addRes(pkgPath, d.Name.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if !keep.KeepsName(pkgPath, spec.Name.Name) && !keepsName(file, d.Doc, spec.Doc, spec.Comment) {
+							addRes(pkgPath, spec.Name.Name)
+						}
+					case *ast.ValueSpec:
+						if keepsName(file, d.Doc, spec.Doc, spec.Comment) {
+							continue
+						}
+						for _, name := range spec.Names {
+							if !keep.KeepsName(pkgPath, name.Name) {
+								addRes(pkgPath, name.Name)
+							}
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return singleRenames(res), err
+}
+
+// methodRenames lists the methods of the types declared
+// in included packages, and the methods declared by their
+// named interfaces, which do not keep their names.
+// Whether a method can really be renamed depends on the
+// interfaces it implements, which renameSymbols checks.
+func methodRenames(gopath string, n NameHasher, keep *KeepList,
+	include func(string) bool) ([]symbolRenameReq, error) {
+	files, err := listGoFiles(gopath, containsUnsupportedCode, include)
+	if err != nil {
+		return nil, err
+	}
+	var resLock sync.Mutex
+	res := map[symbolRenameReq]int{}
+	addRes := func(oldName, name string) {
+		resLock.Lock()
+		res[symbolRenameReq{oldName, n.Hash(name)}]++
+		resLock.Unlock()
+	}
+	err = forEachFile(files, func(f sourceFile) error {
+		pkgPath := f.PkgPath
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		// The declarations of external test packages are not
+		// named by the queries, and only used by their tests.
+		if strings.HasSuffix(file.Name.Name, "_test") {
+			return nil
+		}
+		prefix := "\"" + pkgPath + "\"."
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil || keepsName(file, d.Doc) {
+					continue
+				}
+				for _, rec := range d.Recv.List {
+					receiver := receiverString(prefix, rec)
+					if receiver == "" || keep.KeepsMember(pkgPath, receiverTypeName(rec), d.Name.Name, n) {
+						continue
+					}
+					addRes(receiver+"."+d.Name.Name, d.Name.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					spec, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					t, ok := spec.Type.(*ast.InterfaceType)
+					if !ok || keepsName(file, d.Doc, spec.Doc, spec.Comment) {
+						continue
+					}
+					for _, field := range t.Methods.List {
+						if keepsName(file, field.Doc, field.Comment) {
+							continue
+						}
+						for _, name := range field.Names {
+							if !keep.KeepsMember(pkgPath, spec.Name.Name, name.Name, n) {
+								addRes(prefix+spec.Name.Name+"."+name.Name, name.Name)
+							}
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return singleRenames(res), err
+}
+
+// isTestFunc checks if a function of a test file is run by
+// go test, which finds it by name.
+func isTestFunc(name string) bool {
+	for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// singleRenames removes any rename requests which appear
+// more than one time.
+// This is necessary because of build constraints, which
+// the refactoring API doesn't seem to properly support.
+func singleRenames(multiset map[symbolRenameReq]int) []symbolRenameReq {
+	var res []symbolRenameReq
+	for x, count := range multiset {
+		if count == 1 {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// containsUnsupportedCode checks if a source directory
+// contains assembly or CGO code, neither of which are
+// supported by the refactoring API.
+func containsUnsupportedCode(dir string) bool {
+	return containsAssembly(dir) || containsCGO(dir)
+}
+
+// containsAssembly checks if a source directory contains
+// any assembly files.
+// We cannot rename symbols in assembly-filled directories
+// because of limitations of the refactoring API.
+func containsAssembly(dir string) bool {
+	contents, _ := ioutil.ReadDir(dir)
+	for _, item := range contents {
+		if filepath.Ext(item.Name()) == ".s" {
+			return true
+		}
+	}
+	return false
+}
+
+// containsCGO checks if a package relies on CGO.
+// We cannot rename symbols in packages that use CGO due
+// to limitations of the refactoring API.
+// Packages with SWIG files count too, since the go tool
+// generates cgo code for them.
+func containsCGO(dir string) bool {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, item := range listing {
+		if isSwigFile(item.Name()) {
+			return true
+		}
+		if isGoFile(item.Name()) {
+			path := filepath.Join(dir, item.Name())
+			set := token.NewFileSet()
+			file, err := parser.ParseFile(set, path, nil, 0)
+			if err != nil {
+				return false
+			}
+			for _, spec := range file.Imports {
+				if spec.Path.Value == `"C"` {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func isSwigFile(path string) bool {
+	return filepath.Ext(path) == ".swig" || filepath.Ext(path) == ".swigcxx"
+}
+
+// removeDoNotEdit removes comments that prevent gorename
+// from working properly.
+func removeDoNotEdit(dir string) error {
+	srcDir := filepath.Join(dir, "src")
+	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isGoFile(path) {
+			return nil
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0755)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		content, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, path, content, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+
+		for _, comment := range file.Comments {
+			data := make([]byte, comment.End()-comment.Pos())
+			start := int(comment.Pos()) - 1
+			end := start + len(data)
+			data = content[start:end]
+			commentStr := string(data)
+			if strings.Contains(commentStr, "DO NOT EDIT") {
+				commentStr = strings.Replace(commentStr, "DO NOT EDIT", "XXXXXXXXXXX", -1)
+				if _, err := f.WriteAt([]byte(commentStr), int64(comment.Pos()-1)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// receiverString gets the string representation of a
+// method receiver so that the method can be renamed.
+func receiverString(prefix string, rec *ast.Field) string {
+	if stringer, ok := rec.Type.(fmt.Stringer); ok {
+		return prefix + stringer.String()
+	} else if star, ok := rec.Type.(*ast.StarExpr); ok {
+		if stringer, ok := star.X.(fmt.Stringer); ok {
+			return "(*" + prefix + stringer.String() + ")"
+		}
+	}
+	return ""
+}
+
+// receiverTypeName gets the name of a method receiver's
+// type, without any pointer indirection.
+func receiverTypeName(rec *ast.Field) string {
+	t := rec.Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if ident, ok := t.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
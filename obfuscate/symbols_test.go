@@ -0,0 +1,200 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeGOPATH writes the files of a GOPATH, by their paths
+// relative to its src directory.
+func writeGOPATH(t testing.TB, files map[string]string) string {
+	gopath := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(gopath, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return gopath
+}
+
+// syntheticGOPATH generates a GOPATH with a chain of
+// packages under "synth", each of which uses the one before
+// it, and a main package "synth/cmd" which uses the last.
+// Every package has types with methods, an interface they
+// implement, and tests.
+func syntheticGOPATH(t testing.TB, numPkgs, numFiles int) string {
+	files := map[string]string{}
+	for i := 0; i < numPkgs; i++ {
+		dir := fmt.Sprintf("synth/p%d/", i)
+		for j := 0; j < numFiles; j++ {
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "package p%d\n\n", i)
+			if i > 0 {
+				fmt.Fprintf(&buf, "import prev \"synth/p%d\"\n\n", i-1)
+			}
+			fmt.Fprintf(&buf, "type Item%d struct {\n\tvalue int\n\tName  string\n}\n\n", j)
+			fmt.Fprintf(&buf, "func NewItem%d(value int) *Item%d {\n\treturn &Item%d{value: value}\n}\n\n", j, j, j)
+			fmt.Fprintf(&buf, "func (i *Item%d) Value() int {\n\treturn i.value\n}\n\n", j)
+			fmt.Fprintf(&buf, "func (i Item%d) Describe() string {\n\treturn i.Name\n}\n\n", j)
+			fmt.Fprintf(&buf, "func (i *Item%d) Error() string {\n\treturn i.Name\n}\n\n", j)
+			fmt.Fprintf(&buf, "var Default%d = NewItem%d(%d)\n\n", j, j, j)
+			if i > 0 {
+				fmt.Fprintf(&buf, "func Use%d() int {\n\tx := prev.NewItem%d(%d)\n\treturn x.Value() + prev.Total() + len(x.Describe())\n}\n",
+					j, j, j)
+			} else {
+				fmt.Fprintf(&buf, "func Use%d() int {\n\treturn Default%d.Value()\n}\n", j, j)
+			}
+			files[dir+fmt.Sprintf("item%d.go", j)] = buf.String()
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "package p%d\n\n", i)
+		buf.WriteString("type Valuer interface {\n\tValue() int\n}\n\n")
+		buf.WriteString("type wrapper struct {\n\t*Item0\n}\n\n")
+		buf.WriteString("type counter int\n\nfunc (c counter) Value(x int) int {\n\treturn int(c) + x\n}\n\n")
+		buf.WriteString("func Total() int {\n\tvar sum int\n\tfor _, v := range []Valuer{")
+		for j := 0; j < numFiles; j++ {
+			fmt.Fprintf(&buf, "NewItem%d(%d), ", j, j)
+		}
+		buf.WriteString("wrapper{NewItem0(1)}} {\n\t\tsum += v.Value()\n\t}\n\treturn sum\n}\n")
+		files[dir+"total.go"] = buf.String()
+		files[dir+"total_test.go"] = fmt.Sprintf("package p%d\n\nimport \"testing\"\n\n"+
+			"func TestTotal(t *testing.T) {\n\tif Total() < Default0.Value()+counter(1).Value(2) {\n\t\tt.Fatal(\"bad total\")\n\t}\n}\n\n"+
+			"func testItem() *Item0 {\n\treturn NewItem0(1)\n}\n", i)
+		files[dir+"example_test.go"] = fmt.Sprintf("package p%d_test\n\nimport \"synth/p%d\"\n\n"+
+			"func ExampleTotal() {\n\tp%d.Total()\n\t_ = p%d.NewItem0(1).Describe()\n}\n", i, i, i, i)
+	}
+	files["synth/cmd/main.go"] = fmt.Sprintf("package main\n\nimport \"synth/p%d\"\n\n"+
+		"func main() {\n\tprintln(p%d.Total(), p%d.Use0())\n}\n", numPkgs-1, numPkgs-1, numPkgs-1)
+	return writeGOPATH(t, files)
+}
+
+func includeSynth(pkg string) bool {
+	return strings.HasPrefix(pkg, "synth/")
+}
+
+// checkTypes type-checks every package of a GOPATH, with
+// its tests, and fails if any name is not resolved.
+func checkTypes(t *testing.T, gopath string) *typedProgram {
+	prog, err := loadTypedProgram(gopath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := prog.UnresolvedNames(); len(names) > 0 {
+		t.Fatalf("unresolved names after renaming: %v", sortedKeys(names))
+	}
+	return prog
+}
+
+func TestObfuscateSymbols(t *testing.T) {
+	gopath := syntheticGOPATH(t, 3, 2)
+	n := NameHasher("padding")
+	applied, _, err := ObfuscateSymbols(gopath, n, nil, includeSynth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTypes(t, gopath)
+
+	renamed := map[string]string{}
+	for _, r := range applied {
+		renamed[r.OldName] = r.NewName
+	}
+	for _, name := range []string{`"synth/p1".NewItem0`, `"synth/p2".Total`, `"synth/p0".Default1`} {
+		if renamed[name] == "" {
+			t.Errorf("%s was not renamed", name)
+		}
+	}
+	// Methods are named by the new names of their types.
+	// Value is renamed along with Valuer.Value, which it
+	// implements, and counter.Value on its own, but Error
+	// implements the predeclared error.
+	newType := n.Hash("Item0")
+	for _, name := range []string{
+		`"synth/p1".` + newType + ".Describe",
+		`(*"synth/p1".` + newType + ").Value",
+		`"synth/p1".` + n.Hash("Valuer") + ".Value",
+		`"synth/p1".` + n.Hash("counter") + ".Value",
+		`"synth/p1".testItem`,
+	} {
+		if renamed[name] == "" {
+			t.Errorf("%s was not renamed", name)
+		}
+	}
+	if renamed[`(*"synth/p1".`+newType+").Error"] != "" {
+		t.Error("Error was renamed")
+	}
+	if renamed[`"synth/p1".TestTotal`] != "" {
+		t.Error("TestTotal was renamed")
+	}
+
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		contents, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"NewItem", "Describe", "Default", "Value", "testItem"} {
+			if strings.Contains(string(contents), name) {
+				t.Errorf("%s still contains %s", f.Path, name)
+			}
+		}
+	}
+}
+
+func BenchmarkObfuscateSymbols(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		gopath := syntheticGOPATH(b, 20, 10)
+		b.StartTimer()
+		if _, _, err := ObfuscateSymbols(gopath, NameHasher("padding"), nil, includeSynth); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenameSymbols(b *testing.B) {
+	n := NameHasher("padding")
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		gopath := syntheticGOPATH(b, 20, 10)
+		renames, err := topLevelRenames(gopath, n, nil, includeSynth)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if _, err := renameSymbols(gopath, renames, includeSynth); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadTypedProgram(b *testing.B) {
+	gopath := syntheticGOPATH(b, 20, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := loadTypedProgram(gopath, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListGoFiles(b *testing.B) {
+	gopath := syntheticGOPATH(b, 20, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := listGoFiles(gopath, containsUnsupportedCode, includeSynth); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
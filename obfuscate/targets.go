@@ -0,0 +1,160 @@
+package obfuscate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// A buildTarget is a platform to build binaries for.
+type buildTarget struct {
+	GOOS   string
+	GOARCH string
+}
+
+func (b buildTarget) String() string {
+	return b.GOOS + "/" + b.GOARCH
+}
+
+// buildEnvVars are the environment variables which Build
+// sets itself.
+var buildEnvVars = []string{
+	"GOROOT", "GOARCH", "GOOS", "GOPATH", "GOCACHE", "CGO_ENABLED", "GO111MODULE", "GOWORK", "GOPROXY",
+}
+
+// toolchainEnvVars are the toolchain settings which are
+// passed on from the environment to the go tool.
+var toolchainEnvVars = []string{
+	"GOEXPERIMENT", "GOAMD64", "GOARM", "GOARM64", "GO386", "GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM",
+}
+
+// targetEnv gets the toolchain settings for a target, as
+// environment variables: those from the environment,
+// overridden by those from the config file.
+func targetEnv(targetConfig TargetConfig) []string {
+	values := map[string]string{}
+	for _, name := range toolchainEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			values[name] = value
+		}
+	}
+	for name, value := range targetConfig.Env {
+		values[name] = value
+	}
+	var res []string
+	for name, value := range values {
+		res = append(res, name+"="+value)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// buildTargets gets every combination of the -goos and
+// -goarch flags, in the order they are built, or the
+// platforms of the config file if neither flag is passed.
+func buildTargets() []buildTarget {
+	var res []buildTarget
+	if config != nil && len(config.Platforms) > 0 && !cmdLineFlags["goos"] && !cmdLineFlags["goarch"] {
+		for _, platform := range config.Platforms {
+			parts := strings.Split(platform, "/")
+			res = append(res, buildTarget{parts[0], parts[1]})
+		}
+		return res
+	}
+	for _, operatingSystem := range strings.Fields(goos) {
+		for _, arch := range strings.Fields(goarch) {
+			res = append(res, buildTarget{operatingSystem, arch})
+		}
+	}
+	return res
+}
+
+// targetCGO gets the CGO_ENABLED setting of a target:
+// CGO_ENABLED_goos_goarch from the environment, or "0"
+// unless cgo is needed.
+func targetCGO(target buildTarget) string {
+	cgo := os.Getenv("CGO_ENABLED_" + target.GOOS + "_" + target.GOARCH)
+	if cgo == "" && sharedBuild() {
+		// Libraries for C programs need cgo.
+		cgo = "1"
+	} else if cgo == "" {
+		cgo = "0"
+	}
+	return fipsCGO(cgo)
+}
+
+// targetTags gets the build tags for a target: those of
+// -tags, those for the target in the config file, and the
+// static linking tags.
+func targetTags(target buildTarget) string {
+	res := mergeTags(tags, config.TargetConfig(target).Tags)
+	if staticLinux(target) {
+		return mergeTags(res, staticTags)
+	}
+	return res
+}
+
+// mergeTags joins lists of build tags, which may be
+// separated by commas or spaces, without repeating tags.
+func mergeTags(lists ...string) string {
+	var res []string
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, tag := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if !seen[tag] {
+				seen[tag] = true
+				res = append(res, tag)
+			}
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+// supportedTargets lists the platforms which the Go
+// toolchain can build for.
+func supportedTargets() (map[buildTarget]bool, error) {
+	output, err := exec.Command("go", "tool", "dist", "list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("go tool dist list: %s", err)
+	}
+	res := map[buildTarget]bool{}
+	for _, line := range strings.Fields(string(output)) {
+		parts := strings.Split(line, "/")
+		if len(parts) == 2 {
+			res[buildTarget{parts[0], parts[1]}] = true
+		}
+	}
+	return res, nil
+}
+
+// checkTargets verifies that the toolchain supports every
+// target platform before any work is done, listing the
+// supported platforms if it does not.
+func checkTargets() error {
+	targets := buildTargets()
+	if len(targets) == 0 {
+		return flagError("The -goos and -goarch flags must name at least one target.")
+	}
+	supported, err := supportedTargets()
+	if err != nil {
+		return stepError("list supported targets", err)
+	}
+	var unsupported []string
+	for _, target := range targets {
+		if !supported[target] {
+			unsupported = append(unsupported, target.String())
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	var valid []string
+	for target := range supported {
+		valid = append(valid, "  "+target.String())
+	}
+	sort.Strings(valid)
+	return flagError("Unsupported target(s): " + strings.Join(unsupported, " ") +
+		"\nValid targets are:\n" + strings.Join(valid, "\n"))
+}
@@ -0,0 +1,349 @@
+package obfuscate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// reflectivePackages are imports which suggest that a
+// package inspects its own types by name at runtime.
+var reflectivePackages = map[string]bool{
+	"reflect":       true,
+	"encoding/json": true,
+	"encoding/xml":  true,
+	"encoding/gob":  true,
+	"encoding/asn1": true,
+	"text/template": true,
+	"html/template": true,
+}
+
+// A typeName identifies a type by the name it will have
+// in the binary's reflect metadata, i.e. "pkg.Type".
+type typeName struct {
+	Pkg  string
+	Name string
+}
+
+func (t typeName) String() string {
+	return t.Pkg + "." + t.Name
+}
+
+// PruneTypeMetadata renames identifiers which end up in
+// reflect type metadata but are not covered by
+// ObfuscateSymbols: types declared inside functions and
+// unexported struct fields.
+//
+// Struct fields are only renamed in packages which do not
+// import any reflectivePackages, and never when the field
+// has a tag.
+//
+// Only packages for which include returns true are changed.
+func PruneTypeMetadata(gopath string, n NameHasher, keep *KeepList, include func(string) bool) error {
+	renames, err := fieldRenames(gopath, n, keep, include)
+	if err != nil {
+		return fmt.Errorf("field renames: %s", err)
+	}
+	prog, err := loadTypedProgram(gopath, true)
+	if err != nil {
+		return err
+	}
+	newNames, _ := prog.resolveRenames(renames, nil)
+	localNames, err := prog.localTypeRenames(n, include)
+	if err != nil {
+		return fmt.Errorf("local type renames: %s", err)
+	}
+	for key, name := range localNames {
+		newNames[key] = name
+	}
+	if err := prog.applyRenames(newNames); err != nil {
+		return fmt.Errorf("renaming: %s", err)
+	}
+	return nil
+}
+
+// localTypeRenames finds the new names of the types
+// declared within function bodies, keyed like the objects
+// of the program.
+//
+// Local types cannot be named by a rename query, so they
+// are found by their declarations, and renamed along with
+// the fields in one pass over the program.
+func (p *typedProgram) localTypeRenames(n NameHasher, include func(string) bool) (map[token.Pos]string, error) {
+	unresolved := p.UnresolvedNames()
+	res := map[token.Pos]string{}
+	for _, unit := range p.Units {
+		if !unit.Local || unit.Pkg == nil || !include(unit.Pkg.Path()) {
+			continue
+		}
+		for _, file := range unit.Owned {
+			if containsUnsupportedCode(filepath.Dir(p.Filename(file.Package))) {
+				continue
+			}
+			var specs []*ast.TypeSpec
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+					ast.Inspect(fn.Body, func(node ast.Node) bool {
+						if spec, ok := node.(*ast.TypeSpec); ok && spec.Name.Name != "_" {
+							specs = append(specs, spec)
+						}
+						return true
+					})
+				}
+			}
+			for _, spec := range specs {
+				obj, ok := unit.Info.Defs[spec.Name].(*types.TypeName)
+				if !ok || obj.Parent() == nil {
+					continue
+				}
+				if unresolved[obj.Name()] {
+					log.Printf("Warning: not renaming local type %s at %s: it is not resolved in every package",
+						obj.Name(), p.Set.Position(obj.Pos()))
+					continue
+				}
+				newName := n.Hash(obj.Name())
+				if _, other := obj.Parent().LookupParent(newName, token.NoPos); other != nil ||
+					scopeDeclares(obj.Parent(), newName) {
+					return nil, fmt.Errorf("%s: cannot rename %s: %s is already declared",
+						p.Set.Position(obj.Pos()), obj.Name(), newName)
+				}
+				res[p.Key(obj)] = newName
+			}
+		}
+	}
+	return res, nil
+}
+
+// scopeDeclares checks if a scope or any scope within it
+// declares a name.
+func scopeDeclares(scope *types.Scope, name string) bool {
+	if scope.Lookup(name) != nil {
+		return true
+	}
+	for i := 0; i < scope.NumChildren(); i++ {
+		if scopeDeclares(scope.Child(i), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldRenames(gopath string, n NameHasher, keep *KeepList, include func(string) bool) ([]symbolRenameReq, error) {
+	skipDir := func(dir string) bool {
+		return containsUnsupportedCode(dir) || importsReflection(dir)
+	}
+	files, err := listGoFiles(gopath, skipDir, include)
+	if err != nil {
+		return nil, err
+	}
+	var resLock sync.Mutex
+	res := map[symbolRenameReq]int{}
+	err = forEachFile(files, func(f sourceFile) error {
+		pkgPath := f.PkgPath
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		skipped := findSkippedCode(file)
+		resLock.Lock()
+		defer resLock.Unlock()
+		prefix := "\"" + pkgPath + "\"."
+		for _, decl := range file.Decls {
+			d, ok := decl.(*ast.GenDecl)
+			if !ok || d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				spec := spec.(*ast.TypeSpec)
+				st, ok := spec.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				for _, field := range st.Fields.List {
+					if field.Tag != nil || keepsName(file, field.Doc, field.Comment) || skipped.Contains(field) {
+						continue
+					}
+					for _, name := range field.Names {
+						if name.IsExported() || name.Name == "_" {
+							continue
+						}
+						if keep.KeepsMember(pkgPath, spec.Name.Name, name.Name, n) {
+							continue
+						}
+						oldName := prefix + spec.Name.Name + "." + name.Name
+						res[symbolRenameReq{oldName, n.Hash(name.Name)}]++
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return singleRenames(res), err
+}
+
+// importsReflection checks if any file in a directory
+// imports one of the reflectivePackages.
+func importsReflection(dir string) bool {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, item := range listing {
+		if !isGoFile(item.Name()) {
+			continue
+		}
+		set := token.NewFileSet()
+		path := filepath.Join(dir, item.Name())
+		file, err := parser.ParseFile(set, path, nil, parser.ImportsOnly)
+		if err != nil {
+			return true
+		}
+		for _, spec := range file.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			if reflectivePackages[importPath] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectTypeNames lists every type declared in a GOPATH,
+// as it would be named in reflect metadata if it were not
+// renamed.
+// It should be run after package names are obfuscated.
+func collectTypeNames(gopath string) ([]typeName, error) {
+	files, err := listGoFiles(gopath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resLock sync.Mutex
+	seen := map[typeName]bool{}
+	var res []typeName
+	err = forEachFile(files, func(f sourceFile) error {
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, f.Path, nil, 0)
+		if err != nil {
+			return err
+		}
+		resLock.Lock()
+		defer resLock.Unlock()
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok && spec.Name.Name != "_" {
+				name := typeName{file.Name.Name, spec.Name.Name}
+				if !seen[name] {
+					seen[name] = true
+					res = append(res, name)
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].String() < res[j].String()
+	})
+	return res, err
+}
+
+// typeNameLeaks finds the type names which still appear
+// in a built binary.
+func typeNameLeaks(binPath string, names []typeName) ([]typeName, error) {
+	// Chunks must be able to contain any name and the byte
+	// after it.
+	var maxLen int
+	for _, name := range names {
+		if l := len(name.String()) + 1; l > maxLen {
+			maxLen = l
+		}
+	}
+	found := make([]bool, len(names))
+	err := scanChunks(binPath, maxLen, func(chunk []byte, atEOF bool) {
+		for i, name := range names {
+			if !found[i] && containsIdentifier(chunk, name.String(), atEOF) {
+				found[i] = true
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []typeName
+	for i, name := range names {
+		if found[i] {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// leakChunkSize is the amount of a binary which
+// scanChunks reads at once.
+const leakChunkSize = 4 << 20
+
+// scanChunks calls f with each chunk of a file.
+// Each chunk overlaps the previous one by overlap bytes,
+// so that anything up to that long is found whole in at
+// least one chunk.
+func scanChunks(path string, overlap int, f func(chunk []byte, atEOF bool)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, overlap+leakChunkSize)
+	var filled int
+	for {
+		n, err := io.ReadFull(file, buf[filled:])
+		filled += n
+		atEOF := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !atEOF {
+			return err
+		}
+		f(buf[:filled], atEOF)
+		if atEOF {
+			return nil
+		}
+		filled = copy(buf, buf[filled-overlap:filled])
+	}
+}
+
+// containsIdentifier checks if data contains ident, not
+// directly followed by another identifier character.
+// Unless atEOF is set, data may continue, so a match at the
+// very end of data does not count.
+func containsIdentifier(data []byte, ident string, atEOF bool) bool {
+	needle := []byte(ident)
+	for offset := 0; offset < len(data); {
+		idx := bytes.Index(data[offset:], needle)
+		if idx < 0 {
+			return false
+		}
+		end := offset + idx + len(needle)
+		if end == len(data) {
+			return atEOF
+		} else if !isIdentByte(data[end]) {
+			return true
+		}
+		offset = end
+	}
+	return false
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
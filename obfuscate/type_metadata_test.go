@@ -0,0 +1,61 @@
+package obfuscate
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPruneTypeMetadata(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/app/main.go": "package main\n\n" +
+			"type config struct {\n\tname string\n}\n\n" +
+			"func pair() int {\n\ttype point struct{ X, Y int }\n" +
+			"\tf := func() point {\n\t\treturn point{1, 2}\n\t}\n\treturn f().X + f().Y\n}\n\n" +
+			"func other() int {\n\ttype point int\n\tvar p point = 3\n\treturn int(p)\n}\n\n" +
+			"func main() {\n\tc := config{name: \"app\"}\n\tprintln(c.name, pair(), other())\n}\n",
+		"example.com/app/main_test.go": "package main\n\nimport \"testing\"\n\n" +
+			"func TestPair(t *testing.T) {\n\ttype want int\n\tif pair() != int(want(3)) {\n\t\tt.Fail()\n\t}\n}\n",
+	})
+	n := NameHasher("metadata")
+	err := PruneTypeMetadata(gopath, n, nil, func(pkg string) bool {
+		return pkg == "example.com/app"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTypes(t, gopath)
+
+	dir := filepath.Join(gopath, "src", "example.com", "app")
+	for name, snippets := range map[string][]string{
+		"main.go": {
+			"type " + n.Hash("point") + " struct{ X, Y int }",
+			"func() " + n.Hash("point") + " {",
+			"type " + n.Hash("point") + " int",
+			"config{" + n.Hash("name") + ": \"app\"}",
+		},
+		"main_test.go": {"int(" + n.Hash("want") + "(3))"},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, snippet := range snippets {
+			if !strings.Contains(string(data), snippet) {
+				t.Errorf("%s does not contain %q:\n%s", name, snippet, data)
+			}
+		}
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	cmd := exec.Command("go", "vet", "example.com/app")
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("pruned program does not build: %s\n%s", err, output)
+	}
+}
@@ -0,0 +1,234 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// A typedProgram is every package of a GOPATH, along with
+// the standard library packages they import, type-checked
+// from source with one FileSet.
+// Passes which rename or move many symbols load it once,
+// and find the references to all of them in one walk over
+// the program, instead of loading the program again for
+// every symbol.
+type typedProgram struct {
+	Set *token.FileSet
+
+	// Units are the type-checked packages, and the test
+	// variants of the GOPATH packages.
+	Units []*typedUnit
+
+	// Packages are the units of the GOPATH packages, without
+	// their tests, by import path.
+	Packages map[string]*typedUnit
+
+	// Tests are the units of the GOPATH packages with their
+	// in-package tests, by import path.
+	Tests map[string]*typedUnit
+}
+
+// A typedUnit is a type-checked package, or a variant of a
+// package with its tests.
+type typedUnit struct {
+	Pkg   *types.Package
+	Files []*ast.File
+	Info  *types.Info
+
+	// Local is set for the units of GOPATH packages.
+	Local bool
+
+	// Owned are the files which belong to this unit, and not
+	// to the package which it is a test variant of.
+	Owned []*ast.File
+}
+
+// loadTypedProgram type-checks the packages of a GOPATH.
+// The files which import "C" are checked against a fake "C"
+// package, and the tests of each package are checked too
+// if tests is set.
+// Packages which fail to type-check are checked as far as
+// they can be, so a caller should find the names which are
+// not resolved with UnresolvedNames before renaming, but a
+// package which cannot be found or parsed fails the load,
+// since none of its names could be renamed.
+func loadTypedProgram(gopath string, tests bool) (*typedProgram, error) {
+	srcDir := filepath.Join(gopath, "src")
+	dirs, err := packageDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	local := map[string]bool{}
+	for _, dir := range dirs {
+		if !isIgnoredPath(dir) {
+			local[dir] = true
+		}
+	}
+
+	imp := newSourceImporter(gopath)
+	imp.Cgo = true
+	p := &typedProgram{Set: imp.set, Packages: map[string]*typedUnit{}, Tests: map[string]*typedUnit{}}
+	imp.Visit = func(pkg *types.Package, files []*ast.File, info *types.Info) {
+		unit := &typedUnit{Pkg: pkg, Files: files, Info: info, Local: local[pkg.Path()], Owned: files}
+		p.Units = append(p.Units, unit)
+		if unit.Local {
+			p.Packages[pkg.Path()] = unit
+		}
+	}
+	for _, path := range sortedKeys(local) {
+		if _, err := imp.Import(path); err != nil {
+			if _, ok := err.(*build.NoGoError); ok {
+				// Directories of tests, or of files which are
+				// never built, have nothing to rename.
+				continue
+			}
+			return nil, fmt.Errorf("load package %s: %s", path, err)
+		}
+	}
+	if tests {
+		for _, path := range sortedKeys(local) {
+			if _, ok := p.Packages[path]; ok {
+				p.addTests(imp, path)
+			}
+		}
+	}
+	return p, nil
+}
+
+// addTests type-checks the tests of a GOPATH package: the
+// package with its in-package tests, and the external test
+// package, which imports that variant.
+// Tests which do not parse are skipped.
+func (p *typedProgram) addTests(imp *sourceImporter, path string) {
+	base := p.Packages[path]
+	bp, err := imp.findPackage(path, filepath.Join(imp.ctx.GOPATH, "src"))
+	if err != nil {
+		return
+	}
+	check := func(path string, files, owned []*ast.File, importer types.Importer) *typedUnit {
+		info := newTypesInfo()
+		conf := types.Config{Importer: importer, FakeImportC: true, Error: func(error) {}}
+		pkg, _ := conf.Check(path, p.Set, files, info)
+		unit := &typedUnit{Pkg: pkg, Files: files, Info: info, Local: true, Owned: owned}
+		p.Units = append(p.Units, unit)
+		return unit
+	}
+
+	testPkg := base.Pkg
+	if testFiles, err := p.parseFiles(bp.Dir, bp.TestGoFiles); err == nil && len(testFiles) > 0 {
+		files := append(append([]*ast.File{}, base.Files...), testFiles...)
+		p.Tests[path] = check(path, files, testFiles, imp)
+		testPkg = p.Tests[path].Pkg
+	}
+	if xtestFiles, err := p.parseFiles(bp.Dir, bp.XTestGoFiles); err == nil && len(xtestFiles) > 0 {
+		check(path+"_test", xtestFiles, xtestFiles, testImporter{imp, path, testPkg})
+	}
+}
+
+func (p *typedProgram) parseFiles(dir string, names []string) ([]*ast.File, error) {
+	var res []*ast.File
+	for _, name := range names {
+		file, err := parser.ParseFile(p.Set, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, file)
+	}
+	return res, nil
+}
+
+// Scope finds the unit which declares the most symbols of
+// a GOPATH package: the variant with its in-package tests,
+// if it has one.
+func (p *typedProgram) Scope(path string) (*typedUnit, bool) {
+	if unit, ok := p.Tests[path]; ok {
+		return unit, true
+	}
+	unit, ok := p.Packages[path]
+	return unit, ok
+}
+
+// Key identifies an object across the units of the
+// program, by the position of its declaration, since the
+// test variant of a package declares its objects anew.
+// Instantiated methods and fields are keyed by their
+// generic declarations, and embedded fields by the types
+// they are named after.
+// Objects without a position, like those of the universe,
+// have the zero key.
+func (p *typedProgram) Key(obj types.Object) token.Pos {
+	switch o := obj.(type) {
+	case nil:
+		return token.NoPos
+	case *types.Func:
+		obj = o.Origin()
+	case *types.Var:
+		if o.Embedded() {
+			if name := embeddedTypeName(o.Type()); name != nil {
+				return p.Key(name)
+			}
+		}
+		obj = o.Origin()
+	}
+	return obj.Pos()
+}
+
+// Filename finds the name of the file which contains a
+// position.
+func (p *typedProgram) Filename(pos token.Pos) string {
+	return p.Set.Position(pos).Filename
+}
+
+// UnresolvedNames finds the names used in the files of
+// GOPATH packages which the type-checker could not resolve,
+// because the packages have errors. Renaming a symbol with
+// one of these names may miss some of its references.
+func (p *typedProgram) UnresolvedNames() map[string]bool {
+	res := map[string]bool{}
+	for _, unit := range p.Units {
+		if !unit.Local {
+			continue
+		}
+		for _, file := range unit.Owned {
+			ast.Inspect(file, func(node ast.Node) bool {
+				ident, ok := node.(*ast.Ident)
+				if !ok || ident == file.Name || ident.Name == "_" {
+					return true
+				}
+				if _, ok := unit.Info.Defs[ident]; ok {
+					return true
+				}
+				if _, ok := unit.Info.Uses[ident]; !ok {
+					res[ident.Name] = true
+				}
+				return true
+			})
+		}
+	}
+	return res
+}
+
+// A testImporter imports the test variant of a package for
+// its external tests.
+type testImporter struct {
+	types.ImporterFrom
+
+	path string
+	pkg  *types.Package
+}
+
+func (t testImporter) Import(path string) (*types.Package, error) {
+	return t.ImportFrom(path, "", 0)
+}
+
+func (t testImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == t.path {
+		return t.pkg, nil
+	}
+	return t.ImporterFrom.ImportFrom(path, dir, mode)
+}
@@ -0,0 +1,453 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// renameSymbols applies renames of top-level symbols,
+// methods, and struct fields, named by queries like those
+// of gorename, to the packages of a GOPATH. The program is
+// loaded once, and every file is rewritten at most once,
+// with all of the renames which touch it.
+//
+// A method is renamed along with the methods which must
+// keep the same name for the program to compile: those of
+// the interfaces its type implements, and of the other
+// types which implement them. Such a group is renamed only
+// if every method in it is renamed to the same name, apart
+// from the methods of anonymous interfaces in packages for
+// which include returns true, which follow the rest.
+// Renames which would conflict with another name, or might
+// miss references which did not type-check, are skipped.
+//
+// It returns the renames which were applied, with the
+// receiver types of methods by their new names.
+func renameSymbols(gopath string, renames []symbolRenameReq,
+	include func(string) bool) ([]symbolRenameReq, error) {
+	prog, err := loadTypedProgram(gopath, true)
+	if err != nil {
+		return nil, err
+	}
+	newNames, applied := prog.resolveRenames(renames, include)
+	if err := prog.applyRenames(newNames); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// resolveRenames finds the objects which renameSymbols
+// renames, keyed like the objects of the program, along
+// with their new names and the renames which are applied.
+func (p *typedProgram) resolveRenames(renames []symbolRenameReq,
+	include func(string) bool) (map[token.Pos]string, []symbolRenameReq) {
+	unresolved := p.UnresolvedNames()
+	groups := newMethodGroups(p)
+
+	newNames := map[token.Pos]string{}
+	requests := map[token.Pos]symbolRenameReq{}
+	var methods []token.Pos
+	var applied []symbolRenameReq
+	for _, r := range renames {
+		obj, owner, err := p.lookupQuery(r.OldName)
+		if err == nil && unresolved[obj.Name()] {
+			err = fmt.Errorf("%s is not resolved in every package", obj.Name())
+		}
+		if err != nil {
+			log.Println("Warning: not renaming", r.OldName+":", err)
+			continue
+		}
+		key := p.Key(obj)
+		if _, ok := obj.(*types.Func); ok && obj.Parent() == nil {
+			requests[key] = r
+			methods = append(methods, key)
+			continue
+		}
+		if err := p.checkRename(obj, owner, r.NewName); err != nil {
+			log.Println("Warning: not renaming", r.OldName+":", err)
+			continue
+		}
+		newNames[key] = r.NewName
+		applied = append(applied, r)
+	}
+	for _, key := range methods {
+		if _, ok := newNames[key]; ok {
+			continue
+		}
+		r := requests[key]
+		members, err := groups.Renamable(key, r.NewName, requests, include)
+		if err != nil {
+			log.Println("Warning: not renaming", r.OldName+":", err)
+			continue
+		}
+		for _, member := range members {
+			newNames[member] = r.NewName
+		}
+	}
+	for _, key := range methods {
+		if _, ok := newNames[key]; ok {
+			applied = append(applied, renamedReceiver(requests[key], applied))
+		}
+	}
+	return newNames, applied
+}
+
+// applyRenames rewrites the files which refer to the
+// objects with the keys of newNames, to give them their
+// new names.
+func (p *typedProgram) applyRenames(newNames map[token.Pos]string) error {
+	edits := p.renameEdits(newNames)
+	paths := make([]string, 0, len(edits))
+	for path := range edits {
+		paths = append(paths, path)
+	}
+	return runJobs(len(paths), func(i int) error {
+		return applyFileEdits(paths[i], edits[paths[i]])
+	})
+}
+
+// lookupQuery finds the object named by a query like
+// "pkg".Name, "pkg".Type.Method, or (*"pkg".Type).Method,
+// where the method may also be a struct field, or a method
+// of an interface.
+// Methods and fields must be declared by the type itself.
+// The type which declares a method or field is returned
+// along with it.
+func (p *typedProgram) lookupQuery(query string) (types.Object, types.Type, error) {
+	pkgPath, typeName, name := parseRenameQuery(query)
+	unit, ok := p.Scope(pkgPath)
+	if !ok {
+		return nil, nil, fmt.Errorf("package %s is not loaded", pkgPath)
+	}
+	scope := unit.Pkg.Scope()
+	if typeName == "" {
+		if obj := scope.Lookup(name); obj != nil {
+			return obj, nil, nil
+		}
+		return nil, nil, fmt.Errorf("%s is not declared", name)
+	}
+	obj, ok := scope.Lookup(typeName).(*types.TypeName)
+	if !ok || obj.IsAlias() {
+		return nil, nil, fmt.Errorf("%s is not a defined type", typeName)
+	}
+	if named, ok := obj.Type().(*types.Named); ok {
+		for i := 0; i < named.NumMethods(); i++ {
+			if named.Method(i).Name() == name {
+				return named.Method(i), named, nil
+			}
+		}
+		if iface, ok := named.Underlying().(*types.Interface); ok {
+			for i := 0; i < iface.NumExplicitMethods(); i++ {
+				if iface.ExplicitMethod(i).Name() == name {
+					return iface.ExplicitMethod(i), named, nil
+				}
+			}
+		}
+		if st, ok := named.Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				if field := st.Field(i); field.Name() == name && !field.Embedded() {
+					return field, named, nil
+				}
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%s has no method or field %s", typeName, name)
+}
+
+// checkRename checks that a top-level symbol, or a field
+// of a struct type, can take a new name without conflicting
+// with the symbols or imports of its package, or the fields
+// and methods of the type.
+func (p *typedProgram) checkRename(obj types.Object, owner types.Type, newName string) error {
+	if owner != nil {
+		if other, _, _ := types.LookupFieldOrMethod(types.NewPointer(owner), true, obj.Pkg(), newName); other != nil {
+			return fmt.Errorf("%s already has a field or method %s", owner, newName)
+		}
+		return nil
+	}
+	unit, _ := p.Scope(obj.Pkg().Path())
+	if unit.Pkg.Scope().Lookup(newName) != nil {
+		return fmt.Errorf("%s is already declared", newName)
+	}
+	for _, file := range unit.Files {
+		if scope := unit.Info.Scopes[file]; scope != nil && scope.Lookup(newName) != nil {
+			return fmt.Errorf("%s is already imported", newName)
+		}
+	}
+	return nil
+}
+
+// renamedReceiver names the receiver type of an applied
+// method rename by the type's new name, if it was renamed
+// as well.
+func renamedReceiver(r symbolRenameReq, applied []symbolRenameReq) symbolRenameReq {
+	pkg, typeName, _ := parseRenameQuery(r.OldName)
+	oldType := "\"" + pkg + "\"." + typeName
+	for _, other := range applied {
+		if other.OldName == oldType {
+			r.OldName = strings.Replace(r.OldName, oldType, "\""+pkg+"\"."+other.NewName, 1)
+			break
+		}
+	}
+	return r
+}
+
+// renameEdits finds the edits which give the objects with
+// the keys of newNames their new names, throughout the
+// GOPATH packages and their tests.
+// The edits are grouped by file name.
+func (p *typedProgram) renameEdits(newNames map[token.Pos]string) map[string][]fileEdit {
+	var lock sync.Mutex
+	edits := map[string][]fileEdit{}
+	runJobs(len(p.Units), func(i int) error {
+		unit := p.Units[i]
+		if !unit.Local {
+			return nil
+		}
+		for _, file := range unit.Owned {
+			var fileEdits []fileEdit
+			ast.Inspect(file, func(node ast.Node) bool {
+				ident, ok := node.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				// The name of an embedded field is both a use of its
+				// type and a definition of the field, and the use
+				// is the one which is renamed.
+				obj, ok := unit.Info.Uses[ident]
+				if !ok {
+					obj = unit.Info.Defs[ident]
+				}
+				if newName, ok := newNames[p.Key(obj)]; ok && obj != nil {
+					fileEdits = append(fileEdits, fileEdit{
+						Start: p.Set.Position(ident.Pos()).Offset,
+						End:   p.Set.Position(ident.End()).Offset,
+						Text:  newName,
+					})
+				}
+				return true
+			})
+			if len(fileEdits) > 0 {
+				lock.Lock()
+				edits[p.Filename(file.Package)] = fileEdits
+				lock.Unlock()
+			}
+		}
+		return nil
+	})
+	return edits
+}
+
+// methodGroups are the sets of methods, keyed like objects
+// of a typedProgram, which must keep the same name: the
+// methods of an interface, and those which implement them,
+// of every type and interface in the program.
+type methodGroups struct {
+	prog   *typedProgram
+	parent map[token.Pos]token.Pos
+
+	// methods are the methods of each key, as declared.
+	methods map[token.Pos]*types.Func
+
+	// owners are the types which have each method, through
+	// which a new name might conflict.
+	owners map[token.Pos][]types.Type
+}
+
+func newMethodGroups(prog *typedProgram) *methodGroups {
+	g := &methodGroups{
+		prog:    prog,
+		parent:  map[token.Pos]token.Pos{},
+		methods: map[token.Pos]*types.Func{},
+		owners:  map[token.Pos][]types.Type{},
+	}
+
+	seen := map[types.Type]bool{}
+	var concrete []types.Type
+	ifaces := map[string][]types.Type{}
+	add := func(t types.Type) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		// Generic types implement interfaces through their
+		// instances, which are added on their own.
+		if named, ok := t.(*types.Named); ok && named.TypeParams().Len() > named.TypeArgs().Len() {
+			if !types.IsInterface(named) {
+				for i := 0; i < named.NumMethods(); i++ {
+					g.addMethod(named.Method(i), t)
+				}
+			}
+			return
+		}
+		if iface, ok := t.Underlying().(*types.Interface); ok {
+			for i := 0; i < iface.NumMethods(); i++ {
+				ifaces[iface.Method(i).Name()] = append(ifaces[iface.Method(i).Name()], t)
+				g.addMethod(iface.Method(i), t)
+			}
+		} else if _, ok := t.(*types.Named); ok {
+			if _, ok := t.Underlying().(*types.Pointer); !ok {
+				concrete = append(concrete, t)
+			}
+		}
+	}
+	for _, unit := range prog.Units {
+		for _, obj := range unit.Info.Defs {
+			if obj, ok := obj.(*types.TypeName); ok && !obj.IsAlias() {
+				add(obj.Type())
+			}
+		}
+		for _, tv := range unit.Info.Types {
+			if tv.IsType() {
+				add(types.Unalias(tv.Type))
+			}
+		}
+	}
+
+	for _, t := range concrete {
+		ptr := types.NewPointer(t)
+		mset := types.NewMethodSet(ptr)
+		checked := map[types.Type]bool{}
+		for i := 0; i < mset.Len(); i++ {
+			sel := mset.At(i)
+			if len(sel.Index()) == 1 {
+				g.addMethod(sel.Obj().(*types.Func), t)
+			}
+			for _, iface := range ifaces[sel.Obj().Name()] {
+				if checked[iface] {
+					continue
+				}
+				checked[iface] = true
+				if types.Implements(ptr, iface.Underlying().(*types.Interface)) {
+					g.unionMethods(iface, ptr)
+				}
+			}
+		}
+	}
+	for _, list := range ifaces {
+		for _, iface := range list {
+			for _, other := range list {
+				if iface != other && types.Implements(iface, other.Underlying().(*types.Interface)) {
+					g.unionMethods(other, iface)
+				}
+			}
+		}
+	}
+	return g
+}
+
+func (g *methodGroups) addMethod(fn *types.Func, owner types.Type) {
+	key := g.prog.Key(fn)
+	if _, ok := g.methods[key]; !ok {
+		g.methods[key] = fn.Origin()
+	}
+	g.owners[key] = append(g.owners[key], owner)
+}
+
+// unionMethods joins the groups of the methods of an
+// interface with those of a type which implements it.
+func (g *methodGroups) unionMethods(iface, t types.Type) {
+	methods := iface.Underlying().(*types.Interface)
+	for i := 0; i < methods.NumMethods(); i++ {
+		m := methods.Method(i)
+		obj, _, _ := types.LookupFieldOrMethod(t, false, m.Pkg(), m.Name())
+		if obj != nil {
+			g.union(g.prog.Key(m), g.prog.Key(obj))
+		}
+	}
+}
+
+func (g *methodGroups) find(key token.Pos) token.Pos {
+	for {
+		parent, ok := g.parent[key]
+		if !ok || parent == key {
+			return key
+		}
+		if grand, ok := g.parent[parent]; ok {
+			g.parent[key] = grand
+		}
+		key = parent
+	}
+}
+
+func (g *methodGroups) union(a, b token.Pos) {
+	a, b = g.find(a), g.find(b)
+	if a != b {
+		g.parent[a] = b
+	}
+}
+
+// Renamable finds the methods which are renamed along with
+// a method, or the reason they cannot be: a method in the
+// group is not requested to take the same new name, unless
+// it belongs to an anonymous interface of an included
+// package, or has an owner with a field or method by that
+// name already.
+func (g *methodGroups) Renamable(key token.Pos, newName string,
+	requests map[token.Pos]symbolRenameReq, include func(string) bool) ([]token.Pos, error) {
+	root := g.find(key)
+	members := []token.Pos{key}
+	for member := range g.methods {
+		if member != key && g.find(member) == root {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i] < members[j]
+	})
+	for _, member := range members {
+		fn := g.methods[member]
+		if member == token.NoPos || fn == nil {
+			return nil, fmt.Errorf("it implements a predeclared interface")
+		}
+		if r, ok := requests[member]; ok && r.NewName != newName {
+			return nil, fmt.Errorf("it must have the same name as %s", g.describe(member))
+		} else if !ok && !g.anonymous(member, include) {
+			return nil, fmt.Errorf("it must be renamed along with %s", g.describe(member))
+		}
+		for _, owner := range g.owners[member] {
+			if _, ok := owner.Underlying().(*types.Interface); !ok {
+				owner = types.NewPointer(owner)
+			}
+			if obj, _, _ := types.LookupFieldOrMethod(owner, true, fn.Pkg(), newName); obj != nil {
+				return nil, fmt.Errorf("%s already has a field or method %s", owner, newName)
+			}
+		}
+	}
+	return members, nil
+}
+
+// anonymous checks if a method belongs only to interfaces
+// without names, of an included package which has no
+// unsupported code.
+func (g *methodGroups) anonymous(key token.Pos, include func(string) bool) bool {
+	fn := g.methods[key]
+	if !types.IsInterface(fn.Type().(*types.Signature).Recv().Type()) {
+		return false
+	}
+	for _, owner := range g.owners[key] {
+		if _, ok := owner.(*types.Named); ok {
+			return false
+		}
+	}
+	if fn.Pkg() == nil || include == nil || !include(fn.Pkg().Path()) {
+		return false
+	}
+	if _, ok := g.prog.Packages[fn.Pkg().Path()]; !ok {
+		return false
+	}
+	return !containsUnsupportedCode(filepath.Dir(g.prog.Filename(key)))
+}
+
+func (g *methodGroups) describe(key token.Pos) string {
+	fn := g.methods[key]
+	recv := fn.Type().(*types.Signature).Recv().Type()
+	return types.TypeString(recv, nil) + "." + fn.Name() + " (" + g.prog.Set.Position(key).String() + ")"
+}
@@ -0,0 +1,92 @@
+package obfuscate
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameSymbols(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/shapes/shapes.go": "package shapes\n\n" +
+			"type Shape interface {\n\tArea() float64\n}\n\n" +
+			"type Square struct {\n\tSide float64\n}\n\n" +
+			"func (s Square) Area() float64 {\n\treturn s.Side * s.Side\n}\n\n" +
+			"func (s *Square) Grow(by float64) {\n\ts.Side += by\n}\n\n" +
+			"func Total(shapes []Shape) float64 {\n\tvar res float64\n" +
+			"\tfor _, s := range shapes {\n\t\tres += s.Area()\n\t}\n\treturn res\n}\n\n" +
+			"func helper() int {\n\treturn 1\n}\n\n" +
+			"func taken() int {\n\treturn helper()\n}\n",
+		"example.com/shapes/cmd/main.go": "package main\n\n" +
+			"import \"example.com/shapes\"\n\n" +
+			"func main() {\n\tsq := &shapes.Square{Side: 2}\n\tsq.Grow(1)\n" +
+			"\tprintln(shapes.Total([]shapes.Shape{sq}))\n}\n",
+	})
+	applied, err := renameSymbols(gopath, []symbolRenameReq{
+		{`"example.com/shapes".Square`, "Box"},
+		{`"example.com/shapes".Total`, "Sum"},
+		{`"example.com/shapes".Square.Side`, "Edge"},
+		{`"example.com/shapes".Square.Grow`, "Expand"},
+		{`"example.com/shapes".Square.Area`, "Measure"},
+		{`"example.com/shapes".Shape.Area`, "Measure"},
+		// taken is already declared.
+		{`"example.com/shapes".helper`, "taken"},
+	}, func(string) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTypes(t, gopath)
+
+	actual := map[string]string{}
+	for _, r := range applied {
+		actual[r.OldName] = r.NewName
+	}
+	// Methods are named by the new names of their types,
+	// and fields are not.
+	expected := map[string]string{
+		`"example.com/shapes".Square`:      "Box",
+		`"example.com/shapes".Total`:       "Sum",
+		`"example.com/shapes".Square.Side`: "Edge",
+		`"example.com/shapes".Box.Grow`:    "Expand",
+		`"example.com/shapes".Box.Area`:    "Measure",
+		`"example.com/shapes".Shape.Area`:  "Measure",
+	}
+	for name, newName := range expected {
+		if actual[name] != newName {
+			t.Errorf("expected %s to be renamed to %s, got %q", name, newName, actual[name])
+		}
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("unexpected renames: %v", actual)
+	}
+
+	srcDir := filepath.Join(gopath, "src", "example.com", "shapes")
+	for name, snippets := range map[string][]string{
+		"shapes.go":   {"func (s Box) Measure() float64", "func (s *Box) Expand(by float64)", "s.Edge * s.Edge", "func helper()"},
+		"cmd/main.go": {"&shapes.Box{Edge: 2}", "sq.Expand(1)", "shapes.Sum("},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(srcDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, snippet := range snippets {
+			if !strings.Contains(string(data), snippet) {
+				t.Errorf("%s does not contain %q:\n%s", name, snippet, data)
+			}
+		}
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		return
+	}
+	cmd := exec.Command("go", "build", "-o", os.DevNull, "example.com/shapes/cmd")
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("renamed program does not build: %s\n%s", err, output)
+	}
+}
@@ -0,0 +1,232 @@
+package obfuscate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// unmangle reads panic output or stack traces from stdin,
+// and writes them to stdout with the obfuscated package
+// paths and symbol names of the -map file replaced by the
+// original ones.
+func unmangle() bool {
+	data, err := readMapFile(mapPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to read mapping file:", err)
+		return false
+	}
+	var record renameRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		fmt.Fprintln(stderr, "Failed to parse mapping file:", err)
+		return false
+	}
+	if err := newUnmangler(&record).Copy(os.Stdout, os.Stdin); err != nil {
+		fmt.Fprintln(stderr, "Failed to unmangle:", err)
+		return false
+	}
+	return true
+}
+
+// An unmangler translates obfuscated names in text back to
+// their original names.
+type unmangler struct {
+	// packages are the obfuscated import paths with their
+	// original paths, the longest first.
+	packages []packageMove
+
+	// symbols maps the obfuscated names of symbols to their
+	// original names, by the original import paths of their
+	// packages, and names does the same for the names which
+	// only one original name was renamed to.
+	symbols map[string]map[string]string
+	names   map[string]string
+
+	// files maps the new names of renamed files to their
+	// original names, by the original import paths of their
+	// packages.
+	files map[string]map[string]string
+}
+
+func newUnmangler(record *renameRecord) *unmangler {
+	res := &unmangler{
+		symbols: map[string]map[string]string{},
+		names:   map[string]string{},
+		files:   map[string]map[string]string{},
+	}
+	for key, newName := range record.Files {
+		pkg := path.Dir(key)
+		if res.files[pkg] == nil {
+			res.files[pkg] = map[string]string{}
+		}
+		res.files[pkg][newName] = path.Base(key)
+	}
+	for orig, obfuscated := range record.Packages {
+		// Packages which kept their paths are still matched
+		// for the names of their files.
+		if orig != obfuscated || res.files[orig] != nil {
+			res.packages = append(res.packages, packageMove{From: obfuscated, To: orig})
+		}
+	}
+	sort.Slice(res.packages, func(i, j int) bool {
+		p1, p2 := res.packages[i].From, res.packages[j].From
+		if len(p1) != len(p2) {
+			return len(p1) > len(p2)
+		}
+		return p1 < p2
+	})
+
+	// Hoisted functions are named after the functions
+	// whose bodies they hold.
+	ambiguous := map[string]bool{}
+	for _, symbols := range []map[string]string{record.Symbols, record.Hoisted} {
+		for key, newName := range symbols {
+			pkg := symbolPackage(key, record.Packages)
+			parts := strings.Split(key[len(pkg)+1:], ".")
+			origName := parts[len(parts)-1]
+			if res.symbols[pkg] == nil {
+				res.symbols[pkg] = map[string]string{}
+			}
+			res.symbols[pkg][newName] = origName
+			if name, ok := res.names[newName]; ok && name != origName {
+				ambiguous[newName] = true
+			}
+			res.names[newName] = origName
+		}
+	}
+	for name := range ambiguous {
+		delete(res.names, name)
+	}
+	return res
+}
+
+// symbolPackage finds the import path in a symbol's key
+// like "import/path.Name", which may have dots of its own.
+func symbolPackage(key string, packages map[string]string) string {
+	var res string
+	for pkg := range packages {
+		if len(pkg) > len(res) && strings.HasPrefix(key, pkg+".") {
+			res = pkg
+		}
+	}
+	if res != "" {
+		return res
+	}
+	slash := strings.LastIndex(key, "/") + 1
+	return key[:slash+strings.Index(key[slash:], ".")]
+}
+
+// Copy translates the text of r line by line into w.
+func (u *unmangler) Copy(w io.Writer, r io.Reader) error {
+	reader := bufio.NewReader(r)
+	writer := bufio.NewWriter(w)
+	for {
+		line, err := reader.ReadString('\n')
+		writer.WriteString(u.Line(line))
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// Line translates a line of text.
+//
+// Package paths are replaced wherever they start and end
+// like a path, such as in "pkg/path.Func" and in file
+// names, along with the names of renamed files after
+// them. Symbol names are only replaced after a dot, like
+// in "pkg.Func" and "pkg.(*Type).Method", using the names
+// of the package before them if there is one, so that
+// words in messages stay the same.
+func (u *unmangler) Line(line string) string {
+	var res strings.Builder
+	var pkg string
+	for i := 0; i < len(line); {
+		c := line[i]
+		if c == ' ' || c == '\t' {
+			pkg = ""
+		} else if i == 0 || !isPathByte(line[i-1]) {
+			if move, ok := u.matchPackage(line[i:]); ok {
+				res.WriteString(move.To)
+				pkg = move.To
+				i += len(move.From)
+				if file, n := u.file(pkg, line[i:]); n > 0 {
+					res.WriteString("/" + file)
+					i += n
+				}
+				continue
+			}
+		}
+		if isIdentByte(c) && i > 0 && (line[i-1] == '.' || line[i-1] == '*') {
+			end := i
+			for end < len(line) && isIdentByte(line[end]) {
+				end++
+			}
+			res.WriteString(u.name(pkg, line[i:end]))
+			i = end
+			continue
+		}
+		res.WriteByte(c)
+		i++
+	}
+	return res.String()
+}
+
+// matchPackage finds the longest obfuscated import path at
+// the start of text, which must not go on like a path
+// component.
+func (u *unmangler) matchPackage(text string) (packageMove, bool) {
+	for _, move := range u.packages {
+		if strings.HasPrefix(text, move.From) &&
+			(len(text) == len(move.From) || !isComponentByte(text[len(move.From)])) {
+			return move, true
+		}
+	}
+	return packageMove{}, false
+}
+
+// file finds the original name of a renamed file of a
+// package at the start of text, like "/name.go", returning
+// it with the length of the text it replaces.
+func (u *unmangler) file(pkg, text string) (string, int) {
+	if !strings.HasPrefix(text, "/") {
+		return "", 0
+	}
+	end := 1
+	for end < len(text) && isPathByte(text[end]) {
+		end++
+	}
+	if orig, ok := u.files[pkg][text[1:end]]; ok {
+		return orig, end
+	}
+	return "", 0
+}
+
+// name finds the original name of a symbol, preferably
+// among the names of a package.
+func (u *unmangler) name(pkg, name string) string {
+	if orig, ok := u.symbols[pkg][name]; ok {
+		return orig
+	} else if orig, ok := u.names[name]; ok {
+		return orig
+	}
+	return name
+}
+
+// isComponentByte checks if a byte may be part of a path
+// component, other than a dot.
+func isComponentByte(c byte) bool {
+	return isIdentByte(c) || c == '-' || c == '~'
+}
+
+func isPathByte(c byte) bool {
+	return isComponentByte(c) || c == '.'
+}
@@ -0,0 +1,51 @@
+package obfuscate
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+func isGoFile(path string) bool {
+	return filepath.Ext(path) == ".go"
+}
+
+// These are the GOOS and GOARCH values that go/build
+// recognizes in file name suffixes.
+var (
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true,
+		"freebsd": true, "hurd": true, "illumos": true, "ios": true,
+		"js": true, "linux": true, "nacl": true, "netbsd": true,
+		"openbsd": true, "plan9": true, "solaris": true, "wasip1": true,
+		"windows": true, "zos": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "amd64p32": true, "arm": true,
+		"armbe": true, "arm64": true, "arm64be": true, "loong64": true,
+		"mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+		"mips64p32": true, "mips64p32le": true, "ppc": true, "ppc64": true,
+		"ppc64le": true, "riscv": true, "riscv64": true, "s390": true,
+		"s390x": true, "sparc": true, "sparc64": true, "wasm": true,
+	}
+)
+
+// goFileSuffix gets the part of a Go file name which the
+// go tool interprets, such as "_test.go" or "_linux_amd64.go".
+// Renamed files must keep this suffix.
+func goFileSuffix(name string) string {
+	name = strings.TrimSuffix(name, ".go")
+	ext := ".go"
+	if strings.HasSuffix(name, "_test") {
+		name = strings.TrimSuffix(name, "_test")
+		ext = "_test.go"
+	}
+	parts := strings.Split(name, "_")
+	n := len(parts)
+	if n >= 3 && knownOS[parts[n-2]] && knownArch[parts[n-1]] {
+		return "_" + parts[n-2] + "_" + parts[n-1] + ext
+	}
+	if n >= 2 && (knownOS[parts[n-1]] || knownArch[parts[n-1]]) {
+		return "_" + parts[n-1] + ext
+	}
+	return ext
+}
@@ -0,0 +1,175 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/refactor/importgraph"
+)
+
+// vanityGopath, if set, is the temporary GOPATH into which
+// fetchMissingImports downloaded the dependencies which the
+// GOPATH lacks. sourceContext puts it at the end of the
+// GOPATH.
+var vanityGopath string
+
+// sourceContext is the context in which the packages of a
+// GOPATH run are found: build.Default, with the GOPATHs of
+// a remote checkout and of fetched dependencies, if any,
+// resolving imports in GOPATH mode from sourceDir.
+func sourceContext() build.Context {
+	gopath := build.Default.GOPATH
+	if checkoutGopath != "" {
+		gopath = checkoutGopath + string(filepath.ListSeparator) + gopath
+	}
+	if vanityGopath != "" {
+		gopath += string(filepath.ListSeparator) + vanityGopath
+	}
+	ctx := gopathContext(gopath)
+	ctx.Dir = sourceDir
+	return ctx
+}
+
+// A vanityFetch is a temporary GOPATH of dependencies which
+// were missing from the GOPATH, mostly those with vanity
+// import paths like "gopkg.in/yaml.v2" or
+// "golang.org/x/net/context", whose code lives in a
+// repository at another path, and which were checked out
+// at that path, or never fetched at all.
+//
+// Their modules are downloaded through the module proxy,
+// with the -prepenv variables, and each is put in the
+// directory of its import path, so that the packages are
+// found, copied, and renamed like any other dependency.
+type vanityFetch struct {
+	gopath string
+}
+
+// fetchMissingImports downloads the missing dependencies
+// of GOPATH packages, if there are any. The returned fetch
+// is nil if nothing was missing.
+func fetchMissingImports(pkgNames []string) (*vanityFetch, error) {
+	prepEnv, err := prepareEnv()
+	if err != nil {
+		return nil, flagError("Invalid preparation environment: " + err.Error())
+	}
+	var res *vanityFetch
+	var tmpModule string
+	tried := map[string]bool{}
+	err = withEnv(prepEnv, func() error {
+		// Downloaded packages may import more missing
+		// packages, which are only found in the next round.
+		for {
+			missing, err := missingImports(pkgNames)
+			if err != nil || len(missing) == 0 {
+				return err
+			}
+			for _, importPath := range missing {
+				if tried[importPath] || !isRemoteImport(importPath) {
+					return fmt.Errorf("cannot find package %s in the GOPATH", importPath)
+				}
+				tried[importPath] = true
+				if res == nil {
+					if res, err = startVanityFetch(); err != nil {
+						return err
+					}
+					if tmpModule, err = newFetchModule(res.gopath); err != nil {
+						return err
+					}
+				}
+				if err := res.fetch(tmpModule, importPath); err != nil {
+					return err
+				}
+			}
+		}
+	})
+	if err != nil {
+		if res != nil {
+			res.Close()
+		}
+		return nil, stepError("fetch missing imports", err)
+	}
+	return res, nil
+}
+
+// missingImports lists the dependencies of packages which
+// are not in the GOPATH.
+func missingImports(pkgNames []string) ([]string, error) {
+	ctx := sourceContext()
+	forward, _, errs := importgraph.Build(&ctx)
+	missing := map[string]bool{}
+	for _, pkgName := range pkgNames {
+		if _, ok := forward[pkgName]; !ok {
+			if err, ok := errs[pkgName]; ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("package %s not found", pkgName)
+		}
+		root, err := ctx.Import(pkgName, "", build.FindOnly)
+		if err != nil {
+			return nil, err
+		}
+		for dep := range forward.Search(pkgName) {
+			if dep == "C" || missing[dep] {
+				continue
+			}
+			if _, err := ctx.Import(dep, root.Dir, build.FindOnly); err != nil {
+				missing[dep] = true
+			}
+		}
+	}
+	return sortedKeys(missing), nil
+}
+
+// isRemoteImport checks if an import path can be fetched,
+// which, like for the go command, means that its first
+// element is a domain.
+func isRemoteImport(importPath string) bool {
+	return strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".")
+}
+
+func startVanityFetch() (*vanityFetch, error) {
+	gopath, err := newTempDir("vanity")
+	if err != nil {
+		return nil, err
+	}
+	vanityGopath = gopath
+	return &vanityFetch{gopath: gopath}, nil
+}
+
+// fetch downloads the module of a package into the GOPATH,
+// at the version which the modules fetched before require,
+// or else the latest one.
+func (v *vanityFetch) fetch(tmpModule, importPath string) error {
+	modPath, version, modDir, err := listModule(tmpModule, importPath, ioutil.Discard)
+	if err != nil {
+		log.Println("Downloading", importPath+"...")
+		if err := goGet(tmpModule, importPath+"@latest"); err != nil {
+			return err
+		}
+		modPath, version, modDir, err = listModule(tmpModule, importPath, stderr)
+		if err != nil {
+			return err
+		}
+	}
+	dir := filepath.Join(v.gopath, "src", filepath.FromSlash(modPath))
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	log.Println("Using", modPath, version, "for", importPath)
+	return copyTree(modDir, dir)
+}
+
+// Close removes the downloaded packages from the GOPATH.
+func (v *vanityFetch) Close() {
+	if v == nil {
+		return
+	}
+	vanityGopath = ""
+	os.RemoveAll(v.gopath)
+}
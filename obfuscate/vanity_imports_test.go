@@ -0,0 +1,54 @@
+package obfuscate
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMissingImports(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"app/main.go": "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/dep\"\n)\n\n" +
+			"func main() {\n\tfmt.Println(dep.Name)\n}\n",
+	})
+	defer func(old string) {
+		build.Default.GOPATH = old
+	}(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+	// Imports are resolved in GOPATH mode either way.
+	t.Setenv("GO111MODULE", "on")
+
+	missing, err := missingImports([]string{"app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []string{"example.com/dep"}) {
+		t.Fatalf("unexpected missing imports: %v", missing)
+	}
+
+	fetch, err := startVanityFetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fetch.Close()
+	if build.Default.GOPATH != gopath {
+		t.Error("build.Default was changed")
+	}
+	depDir := filepath.Join(fetch.gopath, "src", "example.com", "dep")
+	if err := os.MkdirAll(depDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(depDir, "dep.go"), []byte("package dep\n\nvar Name = \"dep\"\n"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	missing, err = missingImports([]string{"app"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(missing) > 0 {
+		t.Errorf("fetched imports still missing: %v", missing)
+	}
+}
@@ -0,0 +1,114 @@
+package obfuscate
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// numVariants is the number of differently obfuscated
+// builds to make.
+var numVariants int
+
+// obfuscateVariants copies a package once, and then
+// obfuscates and builds a copy of it for each variant.
+//
+// Every variant uses its own padding, so their names
+// differ. With -padding, the padding of each variant is
+// derived from it, so that variants can be rebuilt.
+//
+// Variants are saved next to outPath with their number
+// added to the name, along with their mapping files.
+func obfuscateVariants(pkgName, outPath string) error {
+	baseGopath, err := newTempDir("gopath")
+	if err != nil {
+		return stepError("create temp dir", err)
+	}
+	defer os.RemoveAll(baseGopath)
+
+	var modCache string
+	if useModules {
+		modCache, err = newTempDir("modcache")
+		if err != nil {
+			return stepError("create temp dir", err)
+		}
+		defer cleanModCache(modCache)
+	}
+
+	// Variants share a build cache, so that the standard
+	// library is only compiled once.
+	goCache, err := newTempDir("gocache")
+	if err != nil {
+		return stepError("create temp dir", err)
+	}
+	defer os.RemoveAll(goCache)
+
+	pkgName, _, err = copyWorkspace(pkgName, baseGopath, modCache, nil)
+	if err != nil {
+		return err
+	}
+	pkgs, err := workspacePackages(baseGopath)
+	if err != nil {
+		return stepError("list packages", err)
+	}
+
+	// The report is written by each variant.
+	baseReportPath := reportPath
+	defer func() {
+		reportPath = baseReportPath
+	}()
+
+	for i := 1; i <= numVariants; i++ {
+		log.Printf("Building variant %d of %d...", i, numVariants)
+		variantOut := variantPath(outPath, i)
+		if baseReportPath != "" {
+			reportPath = variantPath(baseReportPath, i)
+		}
+		mapOut := variantOut + ".map.json"
+		if mapPath != "" {
+			mapOut = variantPath(mapPath, i)
+		}
+		if err := buildVariant(pkgName, baseGopath, goCache, variantOut, mapOut, i, pkgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildVariant(pkgName, baseGopath, goCache, outPath, mapOut string, index int, pkgs []string) error {
+	newGopath, err := newTempDir("gopath")
+	if err != nil {
+		return stepError("create temp dir", err)
+	}
+	defer os.RemoveAll(newGopath)
+	runMetrics.Phase("copy")
+	if err := copyTree(baseGopath, newGopath); err != nil {
+		return stepError("copy workspace", err)
+	}
+
+	padding := customPadding
+	if padding != "" {
+		padding += "-" + strconv.Itoa(index)
+	}
+	ws, err := obfuscateWorkspace(pkgName, newGopath, newNameHasher(padding), nil)
+	if err != nil {
+		return err
+	}
+	ws.GoCache = goCache
+	if err := ws.Build(outPath); err != nil {
+		return err
+	}
+	if err := writeMapping(mapOut, ws.Mapping(pkgs)); err != nil {
+		return stepError("write mapping file", err)
+	}
+	return nil
+}
+
+// variantPath adds the number of a variant to a path,
+// before its extension.
+func variantPath(path string, index int) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + strconv.Itoa(index) + ext
+}
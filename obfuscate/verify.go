@@ -0,0 +1,93 @@
+package obfuscate
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// verify obfuscates every project listed in the config
+// file with the flags of the command line, and runs each
+// project's command against its binary.
+func verify() bool {
+	if err := setupProfile(""); err != nil {
+		fmt.Fprintln(stderr, err)
+		return false
+	}
+	if config == nil || len(config.Verify) == 0 {
+		fmt.Fprintln(stderr, `No projects to verify (list them under "verify" in the -config file)`)
+		return false
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to find executable:", err)
+		return false
+	}
+	dir, err := newTempDir("verify")
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to create temp dir:", err)
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	var names []string
+	for name := range config.Verify {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := forwardedFlags()
+	var failed []string
+	for i, name := range names {
+		log.Println("Verifying project", name+"...")
+		outPath := filepath.Join(dir, fmt.Sprint(i), "out")
+		if err := verifyProject(self, config.Verify[name], flags, outPath); err != nil {
+			fmt.Fprintln(stderr, "Project "+name+" failed:", err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(stderr, "Failed projects:", strings.Join(failed, ", "))
+		return false
+	}
+	log.Println("All projects verified")
+	return true
+}
+
+// verifyProject obfuscates a project into outPath and runs
+// its command.
+func verifyProject(self string, project VerifyProject, flags []string, outPath string) error {
+	if project.Package == "" || project.Command == "" {
+		return fmt.Errorf("a package and a command are required")
+	}
+	dir := project.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(configPath), dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+
+	args := append(append(append([]string{}, flags...), project.Flags...), project.Package, outPath)
+	obfuscate := exec.Command(self, args...)
+	obfuscate.Dir = dir
+	if output, err := obfuscate.CombinedOutput(); err != nil {
+		return fmt.Errorf("obfuscate: %s\n%s", err, output)
+	}
+	if runtime.GOOS == "windows" {
+		outPath += ".exe"
+	}
+
+	fields := strings.Fields(project.Command)
+	cmd := exec.Command(fields[0], append(fields[1:], outPath)...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s\n%s", project.Command, err, output)
+	}
+	return nil
+}
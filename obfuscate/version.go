@@ -0,0 +1,123 @@
+package obfuscate
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// toolVersion is the version of gobfuscate, which release
+// builds set with -ldflags "-X github.com/unixpickle/gobfuscate/obfuscate.toolVersion=v1.2.3".
+var toolVersion = "devel"
+
+// minGoMinor is the oldest Go 1.x release whose toolchain
+// can build the obfuscated code.
+const minGoMinor = 19
+
+// ToolInfo identifies the build of gobfuscate which
+// produced an artifact.
+type ToolInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+// currentTool gets the version of gobfuscate, and the
+// commit it was built from if the go command recorded it.
+func currentTool() ToolInfo {
+	res := ToolInfo{Version: toolVersion}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return res
+	}
+	if res.Version == "devel" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		res.Version = info.Main.Version
+	}
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			res.Commit = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if res.Commit != "" && modified {
+		res.Commit += "-dirty"
+	}
+	return res
+}
+
+// goMinorVersion gets the minor version of the Go
+// toolchain, e.g. 24 for Go 1.24.
+func goMinorVersion() (int, error) {
+	version, err := goLanguageVersion()
+	if err != nil {
+		return 0, err
+	}
+	minor, err := strconv.Atoi(strings.TrimPrefix(version, "1."))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected go version: %s", version)
+	}
+	return minor, nil
+}
+
+// A feature is something gobfuscate can only do with the
+// right toolchain or tools.
+type feature struct {
+	Name     string
+	Requires string
+	Enabled  bool
+}
+
+// features lists the features which depend on the
+// toolchain or on other tools, and whether they can be used.
+func features(goMinor int) []feature {
+	hasTool := func(name string) bool {
+		_, err := exec.LookPath(name)
+		return err == nil
+	}
+	return []feature{
+		{"-pgo", "Go 1.21", goMinor >= 21},
+		{"-fips fips140", "Go 1.24", goMinor >= 24},
+		{"-fips boringcrypto", "linux/amd64 or linux/arm64 C toolchain", hasTool("gcc") || hasTool("cc")},
+		{"-stripnotes", "objcopy", hasTool("objcopy")},
+		{"-yara", "yara", hasTool("yara")},
+	}
+}
+
+// printVersion prints the version of gobfuscate, the Go
+// toolchains it supports, and the features which the
+// installed tools allow.
+func printVersion() bool {
+	tool := currentTool()
+	fmt.Println("gobfuscate", tool.Version)
+	if tool.Commit != "" {
+		fmt.Println("commit:", tool.Commit)
+	}
+	fmt.Println("built with:", runtime.Version())
+	fmt.Printf("supported Go toolchains: go1.%d and newer\n", minGoMinor)
+
+	goMinor, err := goMinorVersion()
+	if err != nil {
+		fmt.Println("go toolchain: not found:", err)
+	} else {
+		status := "supported"
+		if goMinor < minGoMinor {
+			status = "not supported"
+		}
+		fmt.Printf("go toolchain: go1.%d (%s)\n", goMinor, status)
+	}
+
+	fmt.Println("features:")
+	for _, f := range features(goMinor) {
+		status := "enabled"
+		if !f.Enabled {
+			status = "disabled (requires " + f.Requires + ")"
+		}
+		fmt.Printf("  %s: %s\n", f.Name, status)
+	}
+	return true
+}
@@ -0,0 +1,405 @@
+package obfuscate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// watchInterval is how often watch mode checks the
+// sources for changes.
+var watchInterval time.Duration
+
+// A watchSession keeps an obfuscated workspace up to date
+// with the sources it was made from.
+type watchSession struct {
+	PkgName  string
+	OutPath  string
+	ModCache string
+
+	ws     *Workspace
+	record *renameRecord
+
+	// pkgs are the packages of the original sources, with
+	// export data to type-check changed packages against.
+	pkgs []*listedPackage
+
+	// watched maps the directories being watched to the
+	// original import paths of their packages.
+	watched map[string]string
+
+	// decls holds the declarations of each watched package
+	// at the time of the last full obfuscation.
+	decls map[string]map[string]bool
+
+	stamps map[string]fileStamp
+}
+
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// watch obfuscates and builds a package, and then rebuilds
+// it whenever its sources change.
+//
+// Changes which only affect function bodies and other
+// code within declarations are applied to the changed
+// files alone, reusing the names from the last full run.
+// Other changes obfuscate everything again.
+func watch(pkgName, outPath string) error {
+	if err := checkFlags(); err != nil {
+		return err
+	}
+	if err := checkTargets(); err != nil {
+		return err
+	}
+	if err := checkIncrementalFlags("Watch mode"); err != nil {
+		return err
+	}
+
+	session := &watchSession{PkgName: pkgName, OutPath: outPath}
+	if useModules {
+		modCache, err := newTempDir("modcache")
+		if err != nil {
+			return stepError("create temp dir", err)
+		}
+		defer cleanModCache(modCache)
+		session.ModCache = modCache
+	}
+	defer session.Close()
+
+	if err := session.Rebuild(); err != nil {
+		return err
+	}
+	err := session.ws.Build(outPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+	}
+	writeMetrics(err == nil)
+
+	log.Println("Watching for changes...")
+	for {
+		time.Sleep(watchInterval)
+		changed, err := session.Changes()
+		if err != nil {
+			fmt.Fprintln(stderr, "Failed to check for changes:", err)
+			continue
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		log.Println("Updating", len(changed), "changed package(s)...")
+		runMetrics = newMetrics()
+		runMetrics.Phase("update")
+		if err := session.Update(changed); err != nil {
+			log.Println("Obfuscating everything again:", err)
+			if err := session.Rebuild(); err != nil {
+				fmt.Fprintln(stderr, err)
+				continue
+			}
+		}
+		err = session.ws.Build(outPath)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+		writeMetrics(err == nil)
+	}
+}
+
+// checkIncrementalFlags reports flags which change code in
+// ways that cannot be repeated on a single package, and so
+// cannot be used when updating a workspace incrementally.
+func checkIncrementalFlags(mode string) error {
+	if outputGopath || passRuns("merge", mergePkgs) || passRuns("inlineconsts", inlineConsts) ||
+		passRuns("prunetypes", pruneTypes) || numVariants > 1 || errorCodes || len(logPatterns) > 0 || renameFiles ||
+		junkCode || releasePath != "" || manifestPath != "" || resumeDir != "" || buildCacheDir != "" || sbomFormat != "" ||
+		provenancePath != "" || encryptEmbeds || funcListPath != "" || len(flagKeyPatterns) > 0 ||
+		httpStrings {
+		return flagError(mode + " cannot be combined with -outdir, -merge, -inlineconsts, " +
+			"-prunetypes, -variants, -errorcodes, -striplog, -renamefiles, -junk, -release, -manifest, -resume, " +
+			"-buildcache, -sbom, -provenance, -embeds, -funcs, -flagkeys, or -httpstrings.")
+	}
+	return nil
+}
+
+// Close removes the session's workspace.
+func (s *watchSession) Close() {
+	if s.ws != nil {
+		os.RemoveAll(s.ws.Gopath)
+		s.ws = nil
+	}
+}
+
+// Rebuild obfuscates the package from scratch.
+func (s *watchSession) Rebuild() error {
+	s.Close()
+	newGopath, err := newTempDir("gopath")
+	if err != nil {
+		return stepError("create temp dir", err)
+	}
+	ws, err := prepareWorkspace(s.PkgName, newGopath, s.ModCache)
+	if err != nil {
+		os.RemoveAll(newGopath)
+		return err
+	}
+	s.ws = ws
+	if mapPath != "" {
+		if err := ws.WriteMapping(mapPath); err != nil {
+			return err
+		}
+	}
+
+	if useModules {
+		s.pkgs, err = ListModulePackages(s.PkgName, s.ModCache, true)
+	} else {
+		env := append(os.Environ(), "GO111MODULE=off")
+		s.pkgs, err = listPackages(s.PkgName, env, true)
+	}
+	if err != nil {
+		return stepError("list packages", err)
+	}
+
+	var origPkgs []string
+	s.watched = map[string]string{}
+	s.decls = map[string]map[string]bool{}
+	for _, pkg := range s.pkgs {
+		if pkg.Standard {
+			continue
+		}
+		origPkgs = append(origPkgs, pkg.ImportPath)
+		// Module dependencies live in a read-only cache,
+		// so only the main module can change.
+		if useModules && (pkg.Module == nil || !pkg.Module.Main) {
+			continue
+		}
+		s.watched[pkg.Dir] = pkg.ImportPath
+		decls, err := packageDecls(pkg.Dir)
+		if err != nil {
+			return stepError("read declarations", err)
+		}
+		s.decls[pkg.ImportPath] = decls
+	}
+	s.record = ws.renameRecord(origPkgs)
+
+	s.stamps = map[string]fileStamp{}
+	_, err = s.Changes()
+	return err
+}
+
+// Changes finds the watched directories with files which
+// were added, removed, or modified since the last call.
+func (s *watchSession) Changes() ([]string, error) {
+	stamps := map[string]fileStamp{}
+	changed := map[string]bool{}
+	for dir := range s.watched {
+		listing, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range listing {
+			if item.IsDir() || !isGoFile(item.Name()) {
+				continue
+			}
+			path := filepath.Join(dir, item.Name())
+			stamp := fileStamp{ModTime: item.ModTime(), Size: item.Size()}
+			stamps[path] = stamp
+			if old, ok := s.stamps[path]; !ok || old != stamp {
+				changed[dir] = true
+			}
+		}
+	}
+	for path := range s.stamps {
+		if _, ok := stamps[path]; !ok {
+			changed[filepath.Dir(path)] = true
+		}
+	}
+	s.stamps = stamps
+	return sortedKeys(changed), nil
+}
+
+// Update applies changes in watched directories to the
+// workspace.
+// It fails if the changes cannot be applied incrementally.
+func (s *watchSession) Update(dirs []string) error {
+	set := token.NewFileSet()
+	imp := exportImporter(set, s.pkgs)
+	for _, dir := range dirs {
+		importPath := s.watched[dir]
+		if isExcluded(importPath) {
+			return fmt.Errorf("excluded package %s changed", importPath)
+		}
+		decls, err := packageDecls(dir)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(decls, s.decls[importPath]) {
+			return fmt.Errorf("declarations changed in %s", importPath)
+		}
+
+		pkg, err := build.Default.ImportDir(dir, 0)
+		if err != nil {
+			return err
+		}
+		var files []*ast.File
+		for _, name := range pkg.GoFiles {
+			file, err := parser.ParseFile(set, filepath.Join(dir, name), nil, 0)
+			if err != nil {
+				return err
+			}
+			files = append(files, file)
+		}
+		if len(pkg.CgoFiles)+len(pkg.SwigFiles)+len(pkg.SwigCXXFiles) > 0 {
+			return fmt.Errorf("cannot update cgo package %s", importPath)
+		}
+		edits, err := renameEdits(set, importPath, files, imp, s.ws.Moves, s.record, true)
+		if err != nil {
+			return err
+		}
+		newDir := filepath.Join(s.ws.Gopath, "src", filepath.FromSlash(s.ws.Moves.Obfuscated(importPath)))
+		if err := s.replacePackageFiles(newDir, files, set, edits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replacePackageFiles replaces the Go files in a workspace
+// directory with renamed and obfuscated copies of a
+// package's current files.
+func (s *watchSession) replacePackageFiles(newDir string, files []*ast.File, set *token.FileSet,
+	edits map[string][]fileEdit) error {
+	// The package may have been renamed when it was moved.
+	pkgName, err := packageName(newDir)
+	if err != nil {
+		return err
+	}
+	listing, err := ioutil.ReadDir(newDir)
+	if err != nil {
+		return err
+	}
+	for _, item := range listing {
+		if !item.IsDir() && isGoFile(item.Name()) && !strings.HasSuffix(item.Name(), "_test.go") {
+			if err := os.Remove(filepath.Join(newDir, item.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	renamed := map[string]bool{}
+	for _, newName := range s.record.Symbols {
+		renamed[newName] = true
+	}
+	names := newImportNames(s.ws.Gopath)
+	for _, file := range files {
+		path := set.Position(file.Package).Filename
+		newPath := filepath.Join(newDir, filepath.Base(path))
+		if err := copyFile(path, newPath); err != nil {
+			return err
+		}
+		fileEdits := edits[path]
+		if file.Name.Name != pkgName {
+			fileEdits = append(fileEdits, fileEdit{
+				Start: set.Position(file.Name.Pos()).Offset,
+				End:   set.Position(file.Name.End()).Offset,
+				Text:  pkgName,
+			})
+		}
+		if len(fileEdits) > 0 {
+			if err := applyFileEdits(newPath, fileEdits); err != nil {
+				return err
+			}
+		}
+		if err := stripFileDebugCode(newPath, names); err != nil {
+			return err
+		} else if _, err := os.Stat(newPath); os.IsNotExist(err) {
+			continue
+		}
+		if err := obfuscateFileLabels(newPath, s.ws.Hasher); err != nil {
+			return err
+		}
+		if err := obfuscateFileLocals(newPath, s.ws.Hasher); err != nil {
+			return err
+		}
+		// The same functions were hoisted, and recorded,
+		// when the workspace was created.
+		if _, err := hoistFileClosures(newPath, "", s.ws.Hasher, renamed); err != nil {
+			return err
+		}
+		if _, err := obfuscateFileStrings(newPath, s.ws.Helper); err != nil {
+			return err
+		}
+	}
+	if numericLiterals {
+		if _, err := obfuscatePackageNumbers(pkgName, newDir, s.ws.Helper); err != nil {
+			return err
+		}
+	}
+	if s.ws.Helper.Sealed || s.ws.Helper.Numbers || s.ws.Helper.Lazy {
+		// The files may be the first to encrypt strings,
+		// use the keys, or cache lazily decoded strings.
+		return s.ws.Helper.Write(s.ws.Gopath)
+	}
+	return nil
+}
+
+// packageDecls lists the names declared at the top level
+// of a package, its methods, and the methods of its
+// interfaces, none of which watch mode can change without
+// obfuscating everything again.
+func packageDecls(dir string) (map[string]bool, error) {
+	pkg, err := build.Default.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]bool{}
+	for _, name := range pkg.GoFiles {
+		set := token.NewFileSet()
+		file, err := parser.ParseFile(set, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					res[d.Name.Name] = true
+				} else {
+					for _, rec := range d.Recv.List {
+						res[receiverTypeName(rec)+"."+d.Name.Name] = true
+					}
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						res[spec.Name.Name] = true
+						ast.Inspect(spec.Type, func(n ast.Node) bool {
+							if iface, ok := n.(*ast.InterfaceType); ok {
+								for _, field := range iface.Methods.List {
+									for _, name := range field.Names {
+										res["interface."+name.Name] = true
+									}
+								}
+							}
+							return true
+						})
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							res[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
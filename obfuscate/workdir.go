@@ -0,0 +1,217 @@
+package obfuscate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// tempDirPrefix starts the names of the temporary
+	// directories made by newTempDir.
+	tempDirPrefix = "gobfuscate-"
+
+	// ownerFileName is the file in a temporary directory
+	// which records the run using it.
+	ownerFileName = ".gobfuscate-owner"
+
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// A dirOwner is the run which uses a temporary directory
+// or holds a lock.
+type dirOwner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+var startTime = time.Now()
+
+// workDir, if set, is where runs make their temporary
+// directories, instead of the system's temp directory, so
+// that they can be put on a RAM disk for speed, or on a big
+// scratch disk.
+var workDir string
+
+// tempRoot is the directory which holds the temporary
+// directories of runs.
+func tempRoot() string {
+	if workDir != "" {
+		return workDir
+	}
+	return os.TempDir()
+}
+
+func currentOwner() *dirOwner {
+	host, _ := os.Hostname()
+	return &dirOwner{PID: os.Getpid(), Host: host, Started: startTime}
+}
+
+func readOwner(path string) (*dirOwner, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res dirOwner
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Same checks if two owners are the same run.
+func (d *dirOwner) Same(other *dirOwner) bool {
+	return d.PID == other.PID && d.Host == other.Host && d.Started.Equal(other.Started)
+}
+
+// Exited checks if the owner is known to have exited.
+// Owners on other hosts, which cannot be checked, are
+// assumed to be running.
+func (d *dirOwner) Exited() bool {
+	host, _ := os.Hostname()
+	return d.Host == host && !processAlive(d.PID)
+}
+
+// newTempDir creates a temporary directory for this run.
+// The kind of directory (like "gopath" or "modcache") and
+// the process ID are part of its name, and the run is
+// recorded in it, so that `gobfuscate clean` can remove it
+// if the run crashes.
+func newTempDir(kind string) (string, error) {
+	if err := os.MkdirAll(tempRoot(), 0755); err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(tempRoot(), fmt.Sprintf("%s%s-%d-", tempDirPrefix, kind, os.Getpid()))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(currentOwner())
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ownerFileName), data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	trackTempDir(dir)
+	return dir, nil
+}
+
+// lockFile takes a lock on something shared by concurrent
+// runs, like a cache directory, with a lock file.
+// It waits for other runs to release the lock, but takes
+// over locks left behind by runs which crashed.
+// The returned function releases the lock.
+func lockFile(path string) (func(), error) {
+	data, err := json.Marshal(currentOwner())
+	if err != nil {
+		return nil, err
+	}
+	var waiting bool
+	for {
+		unlock, err := tryLockFile(path, data)
+		if err != nil {
+			return nil, err
+		} else if unlock != nil {
+			return unlock, nil
+		}
+		if !waiting {
+			log.Println("Waiting for another run to release", path+"...")
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lockedPath checks if a locked file is still the one at
+// its path, since the run which held the lock before may
+// have removed the file as it released it.
+func lockedPath(f *os.File, path string) bool {
+	locked, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(locked, current)
+}
+
+// writeLockOwner records the run which holds a lock in
+// its lock file.
+func writeLockOwner(f *os.File, owner []byte) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(owner, 0)
+	return err
+}
+
+// removeTempDir removes a temporary directory, even if it
+// holds read-only files, like those of a module cache.
+func removeTempDir(dir string) error {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			os.Chmod(path, 0755)
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
+
+// cleanTempDirs removes the temporary directories of runs
+// which exited without removing them.
+func cleanTempDirs() bool {
+	dirs, err := filepath.Glob(filepath.Join(tempRoot(), tempDirPrefix+"*"))
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to list temp dirs:", err)
+		return false
+	}
+	var freed int64
+	for _, dir := range dirs {
+		owner, err := readOwner(filepath.Join(dir, ownerFileName))
+		if err != nil || !owner.Exited() {
+			continue
+		}
+		size := dirSize(dir)
+		if err := removeTempDir(dir); err != nil {
+			fmt.Fprintln(stderr, "Failed to remove temp dir:", err)
+			return false
+		}
+		log.Printf("Removed %s (%s), left by process %d", dir, formatSize(size), owner.PID)
+		freed += size
+	}
+	if freed > 0 {
+		log.Println("Freed", formatSize(freed))
+	}
+	return true
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / unit
+	for _, suffix := range []string{"KiB", "MiB", "GiB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f TiB", value)
+}
@@ -0,0 +1,120 @@
+package obfuscate
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// numJobs is the number of files or packages which are
+// parsed and rewritten at the same time.
+var numJobs = runtime.NumCPU()
+
+// A sourceFile is a Go file found by listGoFiles, along
+// with the import path of its package.
+type sourceFile struct {
+	Path    string
+	PkgPath string
+}
+
+// listGoFiles lists the Go files in a GOPATH's src
+// directory, skipping directories for which skipDir
+// returns true and packages for which include returns
+// false.
+func listGoFiles(gopath string, skipDir func(dir string) bool,
+	include func(pkgPath string) bool) ([]sourceFile, error) {
+	srcDir := filepath.Join(gopath, "src")
+	var res []sourceFile
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if skipDir != nil && skipDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isGoFile(path) {
+			return nil
+		}
+		pkgPath, err := filepath.Rel(srcDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		pkgPath = filepath.ToSlash(pkgPath)
+		if include != nil && !include(pkgPath) {
+			return nil
+		}
+		res = append(res, sourceFile{Path: path, PkgPath: pkgPath})
+		return nil
+	})
+	return res, err
+}
+
+// forEachFile calls f for every file, using numJobs
+// workers.
+// Each worker handles one file at a time, so at most
+// numJobs files are in memory at once.
+// It returns the first error that f returns, after which
+// no more files are started.
+func forEachFile(files []sourceFile, f func(file sourceFile) error) error {
+	return runJobs(len(files), func(i int) error {
+		return f(files[i])
+	})
+}
+
+// forEachPackage calls f for every package, like
+// forEachFile, for passes which work on whole packages.
+func forEachPackage(pkgs []string, f func(pkg string) error) error {
+	return runJobs(len(pkgs), func(i int) error {
+		return f(pkgs[i])
+	})
+}
+
+// runJobs calls job with every index below count, using
+// numJobs workers, and returns the first error.
+func runJobs(count int, job func(i int) error) error {
+	return runWorkers(numJobs, count, job)
+}
+
+// runWorkers is like runJobs, with a given number of
+// workers.
+func runWorkers(jobs, count int, job func(i int) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	indexChan := make(chan int)
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var firstErr error
+	failed := make(chan struct{})
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexChan {
+				if err := job(index); err != nil {
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = err
+						close(failed)
+					}
+					errLock.Unlock()
+				}
+			}
+		}()
+	}
+JobLoop:
+	for i := 0; i < count; i++ {
+		select {
+		case indexChan <- i:
+		case <-failed:
+			break JobLoop
+		}
+	}
+	close(indexChan)
+	wg.Wait()
+	return firstErr
+}